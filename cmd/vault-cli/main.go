@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -15,17 +22,42 @@ const (
 )
 
 type InitResponse struct {
-	RootToken string `json:"root_token"`
-	UnsealKey string `json:"unseal_key"`
+	RootToken       string   `json:"root_token"`
+	UnsealKey       string   `json:"unseal_key"`
+	Keys            []string `json:"keys"`
+	SecretShares    int      `json:"secret_shares"`
+	SecretThreshold int      `json:"secret_threshold"`
 }
 
 type StatusResponse struct {
-	Initialized bool `json:"initialized"`
-	Sealed      bool `json:"sealed"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	T           int    `json:"t"`
+	N           int    `json:"n"`
+	Progress    int    `json:"progress"`
+	Nonce       string `json:"nonce"`
 }
 
 type SecretResponse struct {
-	Data map[string]interface{} `json:"data"`
+	Data          map[string]interface{} `json:"data"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+}
+
+type SecretMetadataResponse struct {
+	CurrentVersion int    `json:"current_version"`
+	OldestVersion  int    `json:"oldest_version"`
+	MaxVersions    int    `json:"max_versions"`
+	CASRequired    bool   `json:"cas_required"`
+	CreatedTime    string `json:"created_time"`
+	UpdatedTime    string `json:"updated_time"`
+	Versions       []struct {
+		Version      int    `json:"version"`
+		CreatedTime  string `json:"created_time"`
+		DeletionTime string `json:"deletion_time"`
+		Destroyed    bool   `json:"destroyed"`
+	} `json:"versions"`
 }
 
 type ErrorResponse struct {
@@ -43,6 +75,52 @@ func getVaultToken() string {
 	return os.Getenv("VAULT_TOKEN")
 }
 
+// loginMFA holds the passcodes given with login -mfa
+var loginMFA []string
+
+// mfaPasscodes returns the "<method>:<passcode>" values to send in
+// X-Vault-MFA headers: those in VAULT_MFA, separated by commas, and any
+// given to login
+func mfaPasscodes() []string {
+	var passcodes []string
+	for _, value := range strings.Split(os.Getenv("VAULT_MFA"), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			passcodes = append(passcodes, value)
+		}
+	}
+	return append(passcodes, loginMFA...)
+}
+
+// httpClient returns a client that trusts VAULT_CACERT, when set, and
+// presents the client certificate in VAULT_CLIENT_CERT and
+// VAULT_CLIENT_KEY
+func httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("VAULT_CLIENT_CERT"), os.Getenv("VAULT_CLIENT_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}, nil
+}
+
 func makeRequest(method, endpoint string, body interface{}, token string) (*http.Response, error) {
 	addr := getVaultAddr()
 	url := addr + endpoint
@@ -64,9 +142,15 @@ func makeRequest(method, endpoint string, body interface{}, token string) (*http
 	if token != "" {
 		req.Header.Set("X-Vault-Token", token)
 	}
+	for _, passcode := range mfaPasscodes() {
+		req.Header.Add("X-Vault-MFA", passcode)
+	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
+	client, err := httpClient()
+	if err != nil {
+		return nil, err
+	}
 	return client.Do(req)
 }
 
@@ -76,20 +160,64 @@ func printUsage() {
 	fmt.Println("  vault-cli <command> [arguments]")
 	fmt.Println("\nCommands:")
 	fmt.Println("  status                           Show vault status")
-	fmt.Println("  init                             Initialize the vault")
-	fmt.Println("  unseal <key>                     Unseal the vault")
+	fmt.Println("  init [-key-shares=N -key-threshold=T]")
+	fmt.Println("                                   Initialize the vault")
+	fmt.Println("  unseal <key> | -reset            Submit an unseal key share")
 	fmt.Println("  seal                             Seal the vault")
+	fmt.Println("  operator rekey -init [-key-shares=N -key-threshold=T]")
+	fmt.Println("                                   Start replacing the unseal keys")
+	fmt.Println("  operator rekey <key>             Submit a current unseal key share")
+	fmt.Println("  operator rekey -status | -cancel Show or cancel the current rekey")
 	fmt.Println("  auth                             Authenticate root token")
-	fmt.Println("  write <path> <key=value>...      Write a secret")
-	fmt.Println("  read <path>                      Read a secret")
-	fmt.Println("  delete <path>                    Delete a secret")
-	fmt.Println("  list [prefix]                    List secrets")
-	fmt.Println("  token-create [ttl]               Create a new token")
+	fmt.Println("  auth enable [-path=p] <type>     Enable an auth method (userpass, approle, jwt, cert, ldap)")
+	fmt.Println("  auth disable <path>              Disable an auth method and revoke its tokens")
+	fmt.Println("  auth list                        List enabled auth methods")
+	fmt.Println("  login [-method=m] [-path=p] [-mfa=method:code] <token | k=v...>")
+	fmt.Println("                                   Log in and print the token issued")
+	fmt.Println("  mfa generate <method>            Enroll in a TOTP method and print its otpauth URL")
+	fmt.Println("  mfa admin-generate <method> <entity>")
+	fmt.Println("                                   Enroll an entity, replacing its secret")
+	fmt.Println("  mfa admin-destroy <method> <entity>")
+	fmt.Println("                                   Remove an entity's secret")
+	fmt.Println("  lockout list                     List users and addresses locked out after failed logins")
+	fmt.Println("  lockout unlock <mount> <alias> | -address=<ip>")
+	fmt.Println("                                   Lift the lockout of a user or client address")
+	fmt.Println("  unwrap [wrapping-token]          Print a wrapped response (default: VAULT_TOKEN) and revoke its token")
+	fmt.Println("  write [-cas=N] <path> <k=v>...   Write a secret (check-and-set against version N)")
+	fmt.Println("  read [-version=N] <path>         Read a secret")
+	fmt.Println("  delete [-versions=1,2] <path>    Soft-delete the current or given versions")
+	fmt.Println("  undelete -versions=1,2 <path>    Restore soft-deleted versions")
+	fmt.Println("  destroy -versions=1,2 <path>     Permanently destroy versions")
+	fmt.Println("  metadata <path>                  Show the version history of a secret")
+	fmt.Println("  list [path]                      List secrets (default: secret/)")
+	fmt.Println("  secrets enable [-path=p] <type>  Mount a secrets engine (kv, transit, totp)")
+	fmt.Println("  secrets disable <path>           Unmount a secrets engine and delete its data")
+	fmt.Println("  secrets list                     List mounted secrets engines")
+	fmt.Println("  secrets move <from> <to>         Move a secrets engine to a new path")
+	fmt.Println("  secrets tune [flags] <path>      Change the description or lease TTLs of a mount")
+	fmt.Println("  token create [-policy=a,b] [-orphan] [-renewable=false]")
+	fmt.Println("               [-explicit-max-ttl=d] [-period=d] [-use-limit=n] [ttl]")
+	fmt.Println("                                   Create a child (or orphan) token")
+	fmt.Println("  token lookup [-accessor] [token]")
+	fmt.Println("                                   Show a token, or the current one")
+	fmt.Println("  token renew [-increment=d] [token]")
+	fmt.Println("                                   Extend a token's TTL, or the current one's")
+	fmt.Println("  token revoke [-orphan] [-accessor] <token> | -self")
+	fmt.Println("                                   Revoke a token and its children")
+	fmt.Println("  token accessors                  List the accessors of all tokens")
+	fmt.Println("  policy write <name> <file|->     Create or update a policy")
+	fmt.Println("  policy read <name>               Show a policy")
+	fmt.Println("  policy list                      List policies")
+	fmt.Println("  policy delete <name>             Delete a policy")
 	fmt.Println("\nEnvironment Variables:")
 	fmt.Println("  VAULT_ADDR      Vault server address (default: http://127.0.0.1:8200)")
 	fmt.Println("  VAULT_TOKEN     Authentication token")
+	fmt.Println("  VAULT_CACERT    CA certificate file to verify an HTTPS server with")
+	fmt.Println("  VAULT_CLIENT_CERT, VAULT_CLIENT_KEY")
+	fmt.Println("                  Client certificate and key to present over HTTPS")
+	fmt.Println("  VAULT_MFA       MFA passcodes to send, as <method>:<passcode>[,...]")
 	fmt.Println("\nExamples:")
-	fmt.Println("  vault-cli init")
+	fmt.Println("  vault-cli init -key-shares=5 -key-threshold=3")
 	fmt.Println("  vault-cli unseal <unseal-key>")
 	fmt.Println("  export VAULT_TOKEN=<root-token>")
 	fmt.Println("  vault-cli auth")
@@ -97,6 +225,13 @@ func printUsage() {
 	fmt.Println("  vault-cli read secret/myapp")
 	fmt.Println("  vault-cli delete secret/myapp")
 	fmt.Println("  vault-cli list")
+	fmt.Println("  vault-cli secrets enable -path=team-a kv")
+	fmt.Println("  vault-cli auth enable userpass")
+	fmt.Println("  vault-cli write auth/userpass/users/alice password=s3cret policies=app-dev")
+	fmt.Println("  vault-cli login -method=userpass username=alice")
+	fmt.Println("  vault-cli login -method=approle role_id=<id> secret_id=<id>")
+	fmt.Println("  vault-cli login -method=jwt role=ci jwt=$CI_JOB_JWT")
+	fmt.Println("  vault-cli login -method=cert name=web")
 }
 
 func handleStatus() error {
@@ -113,11 +248,28 @@ func handleStatus() error {
 
 	fmt.Printf("Initialized: %v\n", status.Initialized)
 	fmt.Printf("Sealed: %v\n", status.Sealed)
+	if status.Initialized {
+		fmt.Printf("Total Shares: %d\n", status.N)
+		fmt.Printf("Threshold: %d\n", status.T)
+	}
+	if status.Sealed && status.Progress > 0 {
+		fmt.Printf("Unseal Progress: %d/%d\n", status.Progress, status.T)
+		fmt.Printf("Unseal Nonce: %s\n", status.Nonce)
+	}
 	return nil
 }
 
-func handleInit() error {
-	resp, err := makeRequest("POST", "/v1/sys/init", nil, "")
+func handleInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	shares := fs.Int("key-shares", 1, "Number of unseal key shares to generate")
+	threshold := fs.Int("key-threshold", 1, "Number of key shares required to unseal")
+	fs.Parse(args)
+
+	body := map[string]int{
+		"secret_shares":    *shares,
+		"secret_threshold": *threshold,
+	}
+	resp, err := makeRequest("POST", "/v1/sys/init", body, "")
 	if err != nil {
 		return err
 	}
@@ -137,16 +289,40 @@ func handleInit() error {
 	fmt.Println("Vault initialized successfully!")
 	fmt.Println("\nIMPORTANT: Save these credentials securely!")
 	fmt.Printf("\nRoot Token: %s\n", initResp.RootToken)
-	fmt.Printf("Unseal Key: %s\n", initResp.UnsealKey)
-	fmt.Println("\nTo unseal the vault, run:")
-	fmt.Printf("  vault-cli unseal %s\n", initResp.UnsealKey)
+	if len(initResp.Keys) == 1 {
+		fmt.Printf("Unseal Key: %s\n", initResp.Keys[0])
+		fmt.Println("\nTo unseal the vault, run:")
+		fmt.Printf("  vault-cli unseal %s\n", initResp.Keys[0])
+	} else {
+		for i, key := range initResp.Keys {
+			fmt.Printf("Unseal Key %d: %s\n", i+1, key)
+		}
+		fmt.Printf("\nThe vault was initialized with %d key shares and a key threshold of %d.\n",
+			initResp.SecretShares, initResp.SecretThreshold)
+		fmt.Println("Distribute the key shares to different operators. To unseal the vault,")
+		fmt.Printf("%d of them must each run:\n", initResp.SecretThreshold)
+		fmt.Println("  vault-cli unseal <unseal-key>")
+	}
 	fmt.Println("\nTo authenticate, set the token:")
 	fmt.Printf("  export VAULT_TOKEN=%s\n", initResp.RootToken)
 	return nil
 }
 
-func handleUnseal(key string) error {
-	body := map[string]string{"key": key}
+func handleUnseal(args []string) error {
+	fs := flag.NewFlagSet("unseal", flag.ExitOnError)
+	reset := fs.Bool("reset", false, "Discard previously submitted unseal key shares")
+	fs.Parse(args)
+
+	body := map[string]interface{}{}
+	if *reset {
+		body["reset"] = true
+	} else {
+		if fs.NArg() < 1 {
+			return fmt.Errorf("unseal key required")
+		}
+		body["key"] = fs.Arg(0)
+	}
+
 	resp, err := makeRequest("POST", "/v1/sys/unseal", body, "")
 	if err != nil {
 		return err
@@ -159,10 +335,211 @@ func handleUnseal(key string) error {
 		return fmt.Errorf("unseal failed: %s", errResp.Error)
 	}
 
-	fmt.Println("Vault unsealed successfully!")
+	var status StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return err
+	}
+
+	switch {
+	case *reset:
+		fmt.Println("Unseal progress reset.")
+	case status.Sealed:
+		fmt.Printf("Unseal key share accepted (%d/%d).\n", status.Progress, status.T)
+	default:
+		fmt.Println("Vault unsealed successfully!")
+	}
 	return handleStatus()
 }
 
+type RekeyStatusResponse struct {
+	Started  bool   `json:"started"`
+	Nonce    string `json:"nonce"`
+	T        int    `json:"t"`
+	N        int    `json:"n"`
+	Progress int    `json:"progress"`
+	Required int    `json:"required"`
+}
+
+type RekeyUpdateResponse struct {
+	Nonce           string   `json:"nonce"`
+	Complete        bool     `json:"complete"`
+	Progress        int      `json:"progress"`
+	Required        int      `json:"required"`
+	Keys            []string `json:"keys"`
+	SecretShares    int      `json:"secret_shares"`
+	SecretThreshold int      `json:"secret_threshold"`
+}
+
+func handleOperator(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("operator subcommand required (rekey)")
+	}
+
+	switch args[0] {
+	case "rekey":
+		return handleRekey(args[1:])
+	default:
+		return fmt.Errorf("unknown operator subcommand: %s", args[0])
+	}
+}
+
+func handleRekey(args []string) error {
+	fs := flag.NewFlagSet("operator rekey", flag.ExitOnError)
+	start := fs.Bool("init", false, "Start a new rekey operation")
+	cancel := fs.Bool("cancel", false, "Cancel the current rekey operation")
+	status := fs.Bool("status", false, "Show the progress of the current rekey operation")
+	shares := fs.Int("key-shares", 0, "Number of new key shares (default: keep current)")
+	threshold := fs.Int("key-threshold", 0, "Number of new key shares required to unseal (default: keep current)")
+	nonce := fs.String("nonce", "", "Nonce of the rekey operation (default: current)")
+	fs.Parse(args)
+
+	switch {
+	case *start:
+		var body interface{}
+		if *shares != 0 || *threshold != 0 {
+			body = map[string]int{
+				"secret_shares":    *shares,
+				"secret_threshold": *threshold,
+			}
+		}
+		resp, err := makeRequest("POST", "/v1/sys/rekey/init", body, "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("rekey init failed: %s", errResp.Error)
+		}
+
+		var rekeyStatus RekeyStatusResponse
+		if err := json.NewDecoder(resp.Body).Decode(&rekeyStatus); err != nil {
+			return err
+		}
+
+		fmt.Println("Rekey started.")
+		printRekeyStatus(&rekeyStatus)
+		fmt.Printf("\n%d holder(s) of the current unseal keys must each run:\n", rekeyStatus.Required)
+		fmt.Println("  vault-cli operator rekey <unseal-key>")
+		return nil
+
+	case *cancel:
+		if *nonce == "" {
+			rekeyStatus, err := getRekeyStatus()
+			if err != nil {
+				return err
+			}
+			if !rekeyStatus.Started {
+				return fmt.Errorf("no rekey in progress")
+			}
+			*nonce = rekeyStatus.Nonce
+		}
+
+		body := map[string]string{"nonce": *nonce}
+		resp, err := makeRequest("POST", "/v1/sys/rekey/cancel", body, "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("rekey cancel failed: %s", errResp.Error)
+		}
+
+		fmt.Println("Rekey cancelled.")
+		return nil
+
+	case *status:
+		rekeyStatus, err := getRekeyStatus()
+		if err != nil {
+			return err
+		}
+		printRekeyStatus(rekeyStatus)
+		return nil
+	}
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("unseal key required")
+	}
+
+	if *nonce == "" {
+		rekeyStatus, err := getRekeyStatus()
+		if err != nil {
+			return err
+		}
+		if !rekeyStatus.Started {
+			return fmt.Errorf("no rekey in progress, start one with: vault-cli operator rekey -init")
+		}
+		*nonce = rekeyStatus.Nonce
+	}
+
+	body := map[string]string{"key": fs.Arg(0), "nonce": *nonce}
+	resp, err := makeRequest("POST", "/v1/sys/rekey/update", body, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("rekey failed: %s", errResp.Error)
+	}
+
+	var result RekeyUpdateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if !result.Complete {
+		fmt.Printf("Key share accepted (%d/%d).\n", result.Progress, result.Required)
+		return nil
+	}
+
+	fmt.Println("Vault rekeyed successfully! The previous unseal keys no longer work.")
+	fmt.Println("\nIMPORTANT: Save the new unseal keys securely! They are only shown once.")
+	for i, key := range result.Keys {
+		fmt.Printf("Unseal Key %d: %s\n", i+1, key)
+	}
+	fmt.Printf("\nKey shares: %d, key threshold: %d\n", result.SecretShares, result.SecretThreshold)
+	return nil
+}
+
+func getRekeyStatus() (*RekeyStatusResponse, error) {
+	resp, err := makeRequest("GET", "/v1/sys/rekey/init", nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return nil, fmt.Errorf("rekey status failed: %s", errResp.Error)
+	}
+
+	var rekeyStatus RekeyStatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rekeyStatus); err != nil {
+		return nil, err
+	}
+	return &rekeyStatus, nil
+}
+
+func printRekeyStatus(status *RekeyStatusResponse) {
+	fmt.Printf("Rekey In Progress: %v\n", status.Started)
+	if !status.Started {
+		return
+	}
+	fmt.Printf("Nonce: %s\n", status.Nonce)
+	fmt.Printf("Progress: %d/%d\n", status.Progress, status.Required)
+	fmt.Printf("New Shares: %d\n", status.N)
+	fmt.Printf("New Threshold: %d\n", status.T)
+}
+
 func handleSeal() error {
 	token := getVaultToken()
 	if token == "" {
@@ -185,7 +562,23 @@ func handleSeal() error {
 	return nil
 }
 
-func handleWrite(path string, kvPairs []string) error {
+func handleWrite(args []string) error {
+	fs := flag.NewFlagSet("write", flag.ExitOnError)
+	cas := fs.Int("cas", -1, "Only write if the current version matches (0 = only if absent)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("path required")
+	}
+	path, kvPairs := fs.Arg(0), fs.Args()[1:]
+
+	// Auth method paths take their fields directly rather than as a
+	// versioned secret, and some of them take no fields at all
+	authPath := strings.HasPrefix(path, "auth/")
+	if !authPath && len(kvPairs) == 0 {
+		return fmt.Errorf("path and at least one key=value pair required")
+	}
+
 	token := getVaultToken()
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN not set")
@@ -200,8 +593,51 @@ func handleWrite(path string, kvPairs []string) error {
 		data[parts[0]] = parts[1]
 	}
 
+	if authPath {
+		return writeAuthPath(path, data, token)
+	}
+
+	endpoint, err := secretEndpoint(token, path, "data")
+	if err != nil {
+		return err
+	}
+
 	body := map[string]interface{}{"data": data}
-	resp, err := makeRequest("POST", "/v1/secret/"+path, body, token)
+	if *cas >= 0 {
+		body["options"] = map[string]interface{}{"cas": *cas}
+	}
+	resp, err := makeRequest("POST", endpoint, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("write failed: %s", errResp.Error)
+	}
+
+	var writeResp struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&writeResp); err != nil {
+		return err
+	}
+
+	// Unversioned engines such as the cubbyhole report no version
+	if writeResp.Version == 0 {
+		fmt.Printf("Secret written successfully to: %s\n", path)
+		return nil
+	}
+	fmt.Printf("Secret written successfully to: %s (version %d)\n", path, writeResp.Version)
+	return nil
+}
+
+// writeAuthPath writes fields to an auth method path and prints any data
+// returned
+func writeAuthPath(path string, data map[string]interface{}, token string) error {
+	resp, err := makeRequest("POST", "/v1/"+path, data, token)
 	if err != nil {
 		return err
 	}
@@ -213,17 +649,57 @@ func handleWrite(path string, kvPairs []string) error {
 		return fmt.Errorf("write failed: %s", errResp.Error)
 	}
 
-	fmt.Printf("Secret written successfully to: %s\n", path)
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	delete(result, "status")
+
+	if len(result) == 0 {
+		fmt.Printf("Success! Data written to: %s\n", path)
+		return nil
+	}
+	printFields(result)
 	return nil
 }
 
-func handleRead(path string) error {
+// printFields prints the fields of a response sorted by name
+func printFields(fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Printf("%-20s %v\n", key, fields[key])
+	}
+}
+
+func handleRead(args []string) error {
+	fs := flag.NewFlagSet("read", flag.ExitOnError)
+	version := fs.Int("version", 0, "Version to read (default: current)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("path required")
+	}
+	path := fs.Arg(0)
+
 	token := getVaultToken()
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN not set")
 	}
 
-	resp, err := makeRequest("GET", "/v1/secret/"+path, nil, token)
+	endpoint, err := secretEndpoint(token, path, "data")
+	if err != nil {
+		return err
+	}
+	if *version > 0 {
+		endpoint += fmt.Sprintf("?version=%d", *version)
+	}
+
+	resp, err := makeRequest("GET", endpoint, nil, token)
 	if err != nil {
 		return err
 	}
@@ -235,25 +711,59 @@ func handleRead(path string) error {
 		return fmt.Errorf("read failed: %s", errResp.Error)
 	}
 
+	if strings.HasPrefix(path, "auth/") {
+		var fields map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&fields); err != nil {
+			return err
+		}
+		printFields(fields)
+		return nil
+	}
+
 	var secretResp SecretResponse
 	if err := json.NewDecoder(resp.Body).Decode(&secretResp); err != nil {
 		return err
 	}
 
-	fmt.Printf("Secret at %s:\n", path)
+	fmt.Printf("Secret at %s", path)
+	if v, ok := secretResp.Metadata["version"]; ok {
+		fmt.Printf(" (version %v)", v)
+	}
+	fmt.Println(":")
 	for key, value := range secretResp.Data {
 		fmt.Printf("  %s: %v\n", key, value)
 	}
+	if secretResp.LeaseID != "" {
+		fmt.Printf("Lease ID: %s (expires in %s)\n", secretResp.LeaseID, time.Duration(secretResp.LeaseDuration)*time.Second)
+	}
 	return nil
 }
 
-func handleDelete(path string) error {
+func handleDelete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	versions := fs.String("versions", "", "Comma-separated versions to delete (default: current)")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("path required")
+	}
+	path := fs.Arg(0)
+
+	if *versions != "" {
+		return handleSecretVersions("delete", path, *versions)
+	}
+
 	token := getVaultToken()
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN not set")
 	}
 
-	resp, err := makeRequest("DELETE", "/v1/secret/"+path, nil, token)
+	endpoint, err := secretEndpoint(token, path, "data")
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeRequest("DELETE", endpoint, nil, token)
 	if err != nil {
 		return err
 	}
@@ -269,18 +779,29 @@ func handleDelete(path string) error {
 	return nil
 }
 
-func handleList(prefix string) error {
+// handleSecretVersions runs delete, undelete or destroy on specific versions
+func handleSecretVersions(action, path, versionList string) error {
 	token := getVaultToken()
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN not set")
 	}
 
-	endpoint := "/v1/secrets/list"
-	if prefix != "" {
-		endpoint += "?prefix=" + prefix
+	var versions []int
+	for _, v := range strings.Split(versionList, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid version: %s", v)
+		}
+		versions = append(versions, n)
 	}
 
-	resp, err := makeRequest("GET", endpoint, nil, token)
+	endpoint, err := kvPath(token, path, action)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{"versions": versions}
+	resp, err := makeRequest("POST", endpoint, body, token)
 	if err != nil {
 		return err
 	}
@@ -289,40 +810,37 @@ func handleList(prefix string) error {
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		json.NewDecoder(resp.Body).Decode(&errResp)
-		return fmt.Errorf("list failed: %s", errResp.Error)
+		return fmt.Errorf("%s failed: %s", action, errResp.Error)
 	}
 
-	var listResp struct {
-		Keys []string `json:"keys"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
-		return err
-	}
+	fmt.Printf("Success: %s of versions %s at %s\n", action, versionList, path)
+	return nil
+}
 
-	if len(listResp.Keys) == 0 {
-		fmt.Println("No secrets found")
-		return nil
-	}
+func handleVersionAction(action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ExitOnError)
+	versions := fs.String("versions", "", "Comma-separated versions")
+	fs.Parse(args)
 
-	fmt.Println("Secrets:")
-	for _, key := range listResp.Keys {
-		fmt.Printf("  %s\n", key)
+	if fs.NArg() < 1 || *versions == "" {
+		return fmt.Errorf("-versions and path required")
 	}
-	return nil
+
+	return handleSecretVersions(action, fs.Arg(0), *versions)
 }
 
-func handleTokenCreate(ttl string) error {
+func handleMetadata(path string) error {
 	token := getVaultToken()
 	if token == "" {
 		return fmt.Errorf("VAULT_TOKEN not set")
 	}
 
-	body := make(map[string]string)
-	if ttl != "" {
-		body["ttl"] = ttl
+	endpoint, err := kvPath(token, path, "metadata")
+	if err != nil {
+		return err
 	}
 
-	resp, err := makeRequest("POST", "/v1/auth/token/create", body, token)
+	resp, err := makeRequest("GET", endpoint, nil, token)
 	if err != nil {
 		return err
 	}
@@ -331,84 +849,1226 @@ func handleTokenCreate(ttl string) error {
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		json.NewDecoder(resp.Body).Decode(&errResp)
-		return fmt.Errorf("token create failed: %s", errResp.Error)
+		return fmt.Errorf("metadata failed: %s", errResp.Error)
 	}
 
-	var tokenResp struct {
-		Token string `json:"token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+	var md SecretMetadataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&md); err != nil {
 		return err
 	}
 
-	fmt.Printf("New token created: %s\n", tokenResp.Token)
+	fmt.Printf("Metadata for %s:\n", path)
+	fmt.Printf("  Current Version: %d\n", md.CurrentVersion)
+	fmt.Printf("  Oldest Version: %d\n", md.OldestVersion)
+	fmt.Printf("  Max Versions: %d\n", md.MaxVersions)
+	fmt.Printf("  CAS Required: %v\n", md.CASRequired)
+	fmt.Printf("  Created: %s\n", md.CreatedTime)
+	fmt.Printf("  Updated: %s\n", md.UpdatedTime)
+	fmt.Println("\nVersions:")
+	for _, v := range md.Versions {
+		state := "active"
+		if v.Destroyed {
+			state = "destroyed"
+		} else if v.DeletionTime != "" {
+			state = "deleted " + v.DeletionTime
+		}
+		fmt.Printf("  %d  %s  %s\n", v.Version, v.CreatedTime, state)
+	}
 	return nil
 }
 
-func handleAuth() error {
-	token := getVaultToken()
-	if token == "" {
-		return fmt.Errorf("VAULT_TOKEN not set")
-	}
+// mountInfo is the mount serving a path
+type mountInfo struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
 
-	resp, err := makeRequest("POST", "/v1/auth/token/authenticate", nil, token)
+// lookupMount returns the mount that serves path
+func lookupMount(token, path string) (*mountInfo, error) {
+	resp, err := makeRequest("GET", "/v1/sys/internal/ui/mounts/"+path, nil, token)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		json.NewDecoder(resp.Body).Decode(&errResp)
-		return fmt.Errorf("authentication failed: %s", errResp.Error)
+		return nil, fmt.Errorf("mount lookup failed: %s", errResp.Error)
 	}
 
-	fmt.Println("Token authenticated successfully!")
-	return nil
+	var mount mountInfo
+	if err := json.NewDecoder(resp.Body).Decode(&mount); err != nil {
+		return nil, err
+	}
+	return &mount, nil
 }
 
-func main() {
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+// kvPath builds the endpoint of a KV engine action such as
+// "<mount>metadata/<key>" by looking up the mount that serves path
+func kvPath(token, path, action string) (string, error) {
+	mount, err := lookupMount(token, path)
+	if err != nil {
+		return "", err
+	}
+	if mount.Type != "kv" {
+		return "", fmt.Errorf("%s is not a kv secrets engine", mount.Path)
 	}
 
-	command := os.Args[1]
-	var err error
+	return "/v1/" + mount.Path + action + "/" + strings.TrimPrefix(path, mount.Path), nil
+}
 
-	switch command {
-	case "status":
-		err = handleStatus()
-	case "init":
-		err = handleInit()
-	case "unseal":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: unseal key required")
-			os.Exit(1)
-		}
-		err = handleUnseal(os.Args[2])
-	case "seal":
-		err = handleSeal()
-	case "auth":
-		err = handleAuth()
+// secretEndpoint returns the endpoint that reads, writes, deletes or
+// lists path. Secrets of KV engines are served under the engine's data/
+// endpoint, so that "secret/app" is written to "secret/data/app"; kvAction
+// picks "metadata" instead for listing. Other engines serve path as is.
+func secretEndpoint(token, path, kvAction string) (string, error) {
+	if strings.HasPrefix(path, "auth/") || strings.HasPrefix(path, "sys/") {
+		return "/v1/" + path, nil
+	}
+
+	mount, err := lookupMount(token, path)
+	if err != nil {
+		return "", err
+	}
+	if mount.Type != "kv" {
+		return "/v1/" + path, nil
+	}
+	return "/v1/" + mount.Path + kvAction + "/" + strings.TrimPrefix(path, mount.Path), nil
+}
+
+func handleList(path string) error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	if path == "" {
+		path = "secret/"
+	}
+	endpoint, err := secretEndpoint(token, path, "metadata")
+	if err != nil {
+		return err
+	}
+
+	resp, err := makeRequest("GET", endpoint+"?list=true", nil, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("list failed: %s", errResp.Error)
+	}
+
+	var listResp struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return err
+	}
+
+	if len(listResp.Keys) == 0 {
+		fmt.Println("No secrets found")
+		return nil
+	}
+
+	fmt.Println("Secrets:")
+	for _, key := range listResp.Keys {
+		fmt.Printf("  %s\n", key)
+	}
+	return nil
+}
+
+func handleTokenCreate(args []string) error {
+	fs := flag.NewFlagSet("token-create", flag.ExitOnError)
+	policies := fs.String("policy", "", "Comma-separated policies to attach to the token")
+	orphan := fs.Bool("orphan", false, "Create a token without a parent")
+	renewable := fs.Bool("renewable", true, "Allow the token to be renewed")
+	explicitMaxTTL := fs.String("explicit-max-ttl", "", "Maximum lifetime of the token across renewals")
+	period := fs.String("period", "", "Create a periodic token renewed by this period")
+	useLimit := fs.Int("use-limit", 0, "Number of requests the token can make (0 for unlimited)")
+	fs.Parse(args)
+
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	body := make(map[string]interface{})
+	if fs.NArg() > 0 {
+		body["ttl"] = fs.Arg(0)
+	}
+	if *policies != "" {
+		body["policies"] = strings.Split(*policies, ",")
+	}
+	body["renewable"] = *renewable
+	if *explicitMaxTTL != "" {
+		body["explicit_max_ttl"] = *explicitMaxTTL
+	}
+	if *period != "" {
+		body["period"] = *period
+	}
+	if *useLimit > 0 {
+		body["num_uses"] = *useLimit
+	}
+
+	endpoint := "/v1/auth/token/create"
+	if *orphan {
+		endpoint = "/v1/auth/token/create-orphan"
+	}
+
+	resp, err := makeRequest("POST", endpoint, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("token create failed: %s", errResp.Error)
+	}
+
+	var tokenResp struct {
+		Token         string `json:"token"`
+		Accessor      string `json:"accessor"`
+		LeaseID       string `json:"lease_id"`
+		LeaseDuration int64  `json:"lease_duration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+
+	fmt.Printf("New token created: %s\n", tokenResp.Token)
+	fmt.Printf("Accessor: %s\n", tokenResp.Accessor)
+	fmt.Printf("Lease ID: %s (expires in %s)\n", tokenResp.LeaseID, time.Duration(tokenResp.LeaseDuration)*time.Second)
+	return nil
+}
+
+func handleToken(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: token <create|lookup|renew|revoke|accessors> [arguments]")
+	}
+
+	switch args[0] {
+	case "create":
+		return handleTokenCreate(args[1:])
+	case "lookup":
+		return handleTokenLookup(args[1:])
+	case "renew":
+		return handleTokenRenew(args[1:])
+	case "revoke":
+		return handleTokenRevoke(args[1:])
+	case "accessors":
+		return handleTokenAccessors()
+	}
+	return fmt.Errorf("unknown token subcommand: %s", args[0])
+}
+
+func handleTokenLookup(args []string) error {
+	fs := flag.NewFlagSet("token-lookup", flag.ExitOnError)
+	accessor := fs.Bool("accessor", false, "Treat the argument as a token accessor")
+	fs.Parse(args)
+
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	var resp *http.Response
+	var err error
+	switch {
+	case *accessor:
+		if fs.NArg() < 1 {
+			return fmt.Errorf("accessor required")
+		}
+		resp, err = makeRequest("POST", "/v1/auth/token/lookup-accessor", map[string]string{"accessor": fs.Arg(0)}, token)
+	case fs.NArg() > 0:
+		resp, err = makeRequest("POST", "/v1/auth/token/lookup", map[string]string{"token": fs.Arg(0)}, token)
+	default:
+		resp, err = makeRequest("GET", "/v1/auth/token/lookup-self", nil, token)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("token lookup failed: %s", errResp.Error)
+	}
+
+	var info struct {
+		Accessor       string    `json:"accessor"`
+		Policies       []string  `json:"policies"`
+		Orphan         bool      `json:"orphan"`
+		CreationTime   time.Time `json:"creation_time"`
+		ExpireTime     time.Time `json:"expire_time"`
+		TTL            int64     `json:"ttl"`
+		Renewable      bool      `json:"renewable"`
+		ExplicitMaxTTL int64     `json:"explicit_max_ttl"`
+		Period         int64     `json:"period"`
+		NumUses        int       `json:"num_uses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+
+	fmt.Printf("Accessor:      %s\n", info.Accessor)
+	fmt.Printf("Policies:      %s\n", strings.Join(info.Policies, ", "))
+	fmt.Printf("Orphan:        %t\n", info.Orphan)
+	fmt.Printf("Created:       %s\n", info.CreationTime.Format(time.RFC3339))
+	fmt.Printf("Expires:       %s\n", info.ExpireTime.Format(time.RFC3339))
+	fmt.Printf("TTL:           %s\n", time.Duration(info.TTL)*time.Second)
+	fmt.Printf("Renewable:     %t\n", info.Renewable)
+	if info.ExplicitMaxTTL > 0 {
+		fmt.Printf("Explicit Max:  %s\n", time.Duration(info.ExplicitMaxTTL)*time.Second)
+	}
+	if info.Period > 0 {
+		fmt.Printf("Period:        %s\n", time.Duration(info.Period)*time.Second)
+	}
+	if info.NumUses > 0 {
+		fmt.Printf("Uses Left:     %d\n", info.NumUses)
+	}
+	return nil
+}
+
+func handleTokenRenew(args []string) error {
+	fs := flag.NewFlagSet("token-renew", flag.ExitOnError)
+	increment := fs.String("increment", "", "Requested extension, e.g. 1h (default: the token's TTL)")
+	fs.Parse(args)
+
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	endpoint := "/v1/auth/token/renew-self"
+	body := make(map[string]interface{})
+	if fs.NArg() > 0 {
+		endpoint = "/v1/auth/token/renew"
+		body["token"] = fs.Arg(0)
+	}
+	if *increment != "" {
+		body["increment"] = *increment
+	}
+
+	resp, err := makeRequest("POST", endpoint, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("token renew failed: %s", errResp.Error)
+	}
+
+	var tokenResp struct {
+		LeaseDuration int64 `json:"lease_duration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+
+	fmt.Printf("Success! Token renewed, expires in %s\n", time.Duration(tokenResp.LeaseDuration)*time.Second)
+	return nil
+}
+
+func handleTokenRevoke(args []string) error {
+	fs := flag.NewFlagSet("token-revoke", flag.ExitOnError)
+	self := fs.Bool("self", false, "Revoke the token in VAULT_TOKEN")
+	orphan := fs.Bool("orphan", false, "Keep the token's children as orphans")
+	accessor := fs.Bool("accessor", false, "Treat the argument as a token accessor")
+	fs.Parse(args)
+
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	endpoint := "/v1/auth/token/revoke"
+	var body interface{}
+	switch {
+	case *self:
+		endpoint = "/v1/auth/token/revoke-self"
+	case fs.NArg() < 1:
+		return fmt.Errorf("token required (or -self)")
+	case *accessor:
+		if *orphan {
+			return fmt.Errorf("-orphan cannot be combined with -accessor")
+		}
+		endpoint = "/v1/auth/token/revoke-accessor"
+		body = map[string]string{"accessor": fs.Arg(0)}
+	default:
+		if *orphan {
+			endpoint = "/v1/auth/token/revoke-orphan"
+		}
+		body = map[string]string{"token": fs.Arg(0)}
+	}
+
+	resp, err := makeRequest("POST", endpoint, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("token revoke failed: %s", errResp.Error)
+	}
+
+	fmt.Println("Success! Token revoked")
+	return nil
+}
+
+func handleTokenAccessors() error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	resp, err := makeRequest("GET", "/v1/auth/token/accessors", nil, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("token accessors failed: %s", errResp.Error)
+	}
+
+	var listResp struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+		return err
+	}
+
+	for _, accessor := range listResp.Keys {
+		fmt.Println(accessor)
+	}
+	return nil
+}
+
+func handlePolicy(args []string) error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	if len(args) < 1 {
+		return fmt.Errorf("policy subcommand required (write, read, list, delete)")
+	}
+
+	switch args[0] {
 	case "write":
-		if len(os.Args) < 4 {
-			fmt.Println("Error: path and at least one key=value pair required")
+		if len(args) < 3 {
+			return fmt.Errorf("policy name and file required")
+		}
+
+		var raw []byte
+		var err error
+		if args[2] == "-" {
+			raw, err = io.ReadAll(os.Stdin)
+		} else {
+			raw, err = os.ReadFile(args[2])
+		}
+		if err != nil {
+			return err
+		}
+
+		body := map[string]string{"policy": string(raw)}
+		resp, err := makeRequest("PUT", "/v1/sys/policy/"+args[1], body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("policy write failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Policy written successfully: %s\n", args[1])
+		return nil
+
+	case "read":
+		if len(args) < 2 {
+			return fmt.Errorf("policy name required")
+		}
+
+		resp, err := makeRequest("GET", "/v1/sys/policy/"+args[1], nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("policy read failed: %s", errResp.Error)
+		}
+
+		var policyResp struct {
+			Policy string `json:"policy"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&policyResp); err != nil {
+			return err
+		}
+
+		fmt.Println(policyResp.Policy)
+		return nil
+
+	case "list":
+		resp, err := makeRequest("GET", "/v1/sys/policy", nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("policy list failed: %s", errResp.Error)
+		}
+
+		var listResp struct {
+			Policies []string `json:"policies"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+			return err
+		}
+
+		for _, name := range listResp.Policies {
+			fmt.Println(name)
+		}
+		return nil
+
+	case "delete":
+		if len(args) < 2 {
+			return fmt.Errorf("policy name required")
+		}
+
+		resp, err := makeRequest("DELETE", "/v1/sys/policy/"+args[1], nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("policy delete failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Policy deleted successfully: %s\n", args[1])
+		return nil
+
+	default:
+		return fmt.Errorf("unknown policy subcommand: %s", args[0])
+	}
+}
+
+func handleSecrets(args []string) error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	if len(args) < 1 {
+		return fmt.Errorf("secrets subcommand required (enable, disable, list, move, tune)")
+	}
+
+	switch args[0] {
+	case "enable":
+		fs := flag.NewFlagSet("secrets enable", flag.ExitOnError)
+		path := fs.String("path", "", "Mount path (default: the engine type)")
+		description := fs.String("description", "", "Human-readable description of the mount")
+		defaultTTL := fs.String("default-lease-ttl", "", "Default lease TTL, e.g. 1h")
+		maxTTL := fs.String("max-lease-ttl", "", "Maximum lease TTL, e.g. 24h")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
+			return fmt.Errorf("engine type required")
+		}
+		engineType := fs.Arg(0)
+		if *path == "" {
+			*path = engineType
+		}
+
+		body := map[string]interface{}{
+			"type":        engineType,
+			"description": *description,
+			"config": map[string]string{
+				"default_lease_ttl": *defaultTTL,
+				"max_lease_ttl":     *maxTTL,
+			},
+		}
+		resp, err := makeRequest("POST", "/v1/sys/mounts/"+*path, body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("enable failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Enabled the %s secrets engine at: %s/\n", engineType, strings.Trim(*path, "/"))
+		return nil
+
+	case "disable":
+		if len(args) < 2 {
+			return fmt.Errorf("mount path required")
+		}
+
+		resp, err := makeRequest("DELETE", "/v1/sys/mounts/"+args[1], nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("disable failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Disabled the secrets engine at: %s\n", args[1])
+		return nil
+
+	case "list":
+		resp, err := makeRequest("GET", "/v1/sys/mounts", nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("secrets list failed: %s", errResp.Error)
+		}
+
+		var listResp struct {
+			Mounts []struct {
+				Path        string `json:"path"`
+				Type        string `json:"type"`
+				Description string `json:"description"`
+				Config      struct {
+					DefaultLeaseTTL int64 `json:"default_lease_ttl"`
+					MaxLeaseTTL     int64 `json:"max_lease_ttl"`
+				} `json:"config"`
+			} `json:"mounts"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+			return err
+		}
+
+		fmt.Printf("%-20s %-10s %-12s %-12s %s\n", "Path", "Type", "Default TTL", "Max TTL", "Description")
+		for _, m := range listResp.Mounts {
+			fmt.Printf("%-20s %-10s %-12s %-12s %s\n", m.Path, m.Type,
+				formatTTL(m.Config.DefaultLeaseTTL), formatTTL(m.Config.MaxLeaseTTL), m.Description)
+		}
+		return nil
+
+	case "move":
+		if len(args) < 3 {
+			return fmt.Errorf("source and destination paths required")
+		}
+
+		body := map[string]string{"from": args[1], "to": args[2]}
+		resp, err := makeRequest("POST", "/v1/sys/remount", body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("move failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Moved secrets engine %s to: %s\n", args[1], args[2])
+		return nil
+
+	case "tune":
+		fs := flag.NewFlagSet("secrets tune", flag.ExitOnError)
+		description := fs.String("description", "", "Human-readable description of the mount")
+		defaultTTL := fs.String("default-lease-ttl", "", "Default lease TTL, e.g. 1h")
+		maxTTL := fs.String("max-lease-ttl", "", "Maximum lease TTL, e.g. 24h")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
+			return fmt.Errorf("mount path required")
+		}
+
+		// Only send the settings given on the command line
+		body := make(map[string]interface{})
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "description":
+				body["description"] = *description
+			case "default-lease-ttl":
+				body["default_lease_ttl"] = *defaultTTL
+			case "max-lease-ttl":
+				body["max_lease_ttl"] = *maxTTL
+			}
+		})
+
+		resp, err := makeRequest("POST", "/v1/sys/mounts/"+strings.Trim(fs.Arg(0), "/")+"/tune", body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("tune failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Tuned the secrets engine at: %s\n", fs.Arg(0))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown secrets subcommand: %s", args[0])
+	}
+}
+
+// formatTTL renders a TTL in seconds, where 0 means the system default
+func formatTTL(seconds int64) string {
+	if seconds == 0 {
+		return "system"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func handleAuth(args []string) error {
+	if len(args) == 0 {
+		return authenticateRootToken()
+	}
+
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	switch args[0] {
+	case "enable":
+		fs := flag.NewFlagSet("auth enable", flag.ExitOnError)
+		path := fs.String("path", "", "Mount path under auth/ (default: the method type)")
+		description := fs.String("description", "", "Human-readable description of the method")
+		defaultTTL := fs.String("default-lease-ttl", "", "Default token TTL, e.g. 1h")
+		maxTTL := fs.String("max-lease-ttl", "", "Maximum token TTL, e.g. 24h")
+		fs.Parse(args[1:])
+
+		if fs.NArg() < 1 {
+			return fmt.Errorf("auth method type required")
+		}
+		methodType := fs.Arg(0)
+		if *path == "" {
+			*path = methodType
+		}
+
+		body := map[string]interface{}{
+			"type":        methodType,
+			"description": *description,
+			"config": map[string]string{
+				"default_lease_ttl": *defaultTTL,
+				"max_lease_ttl":     *maxTTL,
+			},
+		}
+		resp, err := makeRequest("POST", "/v1/sys/auth/"+*path, body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("enable failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Enabled the %s auth method at: auth/%s/\n", methodType, strings.Trim(*path, "/"))
+		return nil
+
+	case "disable":
+		if len(args) < 2 {
+			return fmt.Errorf("auth method path required")
+		}
+
+		resp, err := makeRequest("DELETE", "/v1/sys/auth/"+args[1], nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("disable failed: %s", errResp.Error)
+		}
+
+		fmt.Printf("Disabled the auth method at: %s\n", args[1])
+		return nil
+
+	case "list":
+		resp, err := makeRequest("GET", "/v1/sys/auth", nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("auth list failed: %s", errResp.Error)
+		}
+
+		var listResp struct {
+			Methods []struct {
+				Path        string `json:"path"`
+				Type        string `json:"type"`
+				Description string `json:"description"`
+				Config      struct {
+					DefaultLeaseTTL int64 `json:"default_lease_ttl"`
+					MaxLeaseTTL     int64 `json:"max_lease_ttl"`
+				} `json:"config"`
+			} `json:"methods"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
+			return err
+		}
+
+		fmt.Printf("%-20s %-10s %-12s %-12s %s\n", "Path", "Type", "Default TTL", "Max TTL", "Description")
+		fmt.Printf("%-20s %-10s %-12s %-12s %s\n", "token/", "token", "-", "-", "token based credentials")
+		for _, m := range listResp.Methods {
+			fmt.Printf("%-20s %-10s %-12s %-12s %s\n", m.Path, m.Type,
+				formatTTL(m.Config.DefaultLeaseTTL), formatTTL(m.Config.MaxLeaseTTL), m.Description)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown auth subcommand: %s", args[0])
+	}
+}
+
+func authenticateRootToken() error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	resp, err := makeRequest("POST", "/v1/auth/token/authenticate", nil, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("authentication failed: %s", errResp.Error)
+	}
+
+	fmt.Println("Token authenticated successfully!")
+	return nil
+}
+
+// handleLogin logs in with an auth method and prints the token it issues.
+// The token method only checks the given token.
+func handleLogin(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	method := fs.String("method", "token", "Auth method type (token, userpass, approle, jwt, cert, ldap)")
+	path := fs.String("path", "", "Mount path of the auth method (default: the method type)")
+	mfa := fs.String("mfa", "", "MFA passcodes as <method>:<passcode>, separated by commas")
+	fs.Parse(args)
+
+	for _, passcode := range strings.Split(*mfa, ",") {
+		if passcode = strings.TrimSpace(passcode); passcode != "" {
+			loginMFA = append(loginMFA, passcode)
+		}
+	}
+
+	if *method == "token" {
+		if fs.NArg() < 1 {
+			return fmt.Errorf("token required")
+		}
+		return loginToken(fs.Arg(0))
+	}
+
+	if *path == "" {
+		*path = *method
+	}
+
+	fields := make(map[string]string)
+	for _, pair := range fs.Args() {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid key=value pair: %s", pair)
+		}
+		fields[parts[0]] = parts[1]
+	}
+
+	var endpoint string
+	body := make(map[string]interface{})
+	switch *method {
+	case "userpass", "ldap":
+		username := fields["username"]
+		if username == "" {
+			return fmt.Errorf("username=<name> required")
+		}
+		password, err := promptIfMissing(fields, "password", "Password")
+		if err != nil {
+			return err
+		}
+		endpoint = "/v1/auth/" + strings.Trim(*path, "/") + "/login/" + username
+		body["password"] = password
+	case "approle":
+		roleID := fields["role_id"]
+		if roleID == "" {
+			return fmt.Errorf("role_id=<id> required")
+		}
+		secretID, err := promptIfMissing(fields, "secret_id", "Secret ID")
+		if err != nil {
+			return err
+		}
+		endpoint = "/v1/auth/" + strings.Trim(*path, "/") + "/login"
+		body["role_id"] = roleID
+		if secretID != "" {
+			body["secret_id"] = secretID
+		}
+	case "cert":
+		// The certificate is the one in VAULT_CLIENT_CERT
+		endpoint = "/v1/auth/" + strings.Trim(*path, "/") + "/login"
+		if name := fields["name"]; name != "" {
+			body["name"] = name
+		}
+	case "jwt":
+		token, err := promptIfMissing(fields, "jwt", "JWT")
+		if err != nil {
+			return err
+		}
+		endpoint = "/v1/auth/" + strings.Trim(*path, "/") + "/login"
+		body["jwt"] = token
+		if role := fields["role"]; role != "" {
+			body["role"] = role
+		}
+	default:
+		return fmt.Errorf("unsupported auth method: %s", *method)
+	}
+
+	resp, err := makeRequest("POST", endpoint, body, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("login failed: %s", errResp.Error)
+	}
+
+	var tokenResp struct {
+		Token         string   `json:"token"`
+		Accessor      string   `json:"accessor"`
+		LeaseDuration int64    `json:"lease_duration"`
+		Policies      []string `json:"policies"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return err
+	}
+
+	fmt.Println("Success! You are now authenticated.")
+	fmt.Printf("Token:     %s\n", tokenResp.Token)
+	fmt.Printf("Accessor:  %s\n", tokenResp.Accessor)
+	fmt.Printf("Policies:  %s\n", strings.Join(tokenResp.Policies, ", "))
+	fmt.Printf("Expires:   in %s\n", time.Duration(tokenResp.LeaseDuration)*time.Second)
+	fmt.Println("\nTo use the token, set it:")
+	fmt.Printf("  export VAULT_TOKEN=%s\n", tokenResp.Token)
+	return nil
+}
+
+// loginToken checks that a token is valid
+func loginToken(token string) error {
+	resp, err := makeRequest("GET", "/v1/auth/token/lookup-self", nil, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("login failed: %s", errResp.Error)
+	}
+
+	fmt.Println("Success! The token is valid.")
+	fmt.Println("\nTo use the token, set it:")
+	fmt.Printf("  export VAULT_TOKEN=%s\n", token)
+	return nil
+}
+
+// promptIfMissing returns a login field, reading it from standard input
+// when it was not given on the command line so that it stays out of the
+// shell history
+func promptIfMissing(fields map[string]string, key, label string) (string, error) {
+	if value, ok := fields[key]; ok {
+		return value, nil
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// handleMFA enrolls entities in TOTP methods
+func handleMFA(args []string) error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: mfa generate <method> | mfa admin-generate|admin-destroy <method> <entity>")
+	}
+
+	action, method := args[0], args[1]
+	body := make(map[string]string)
+	switch action {
+	case "generate":
+	case "admin-generate", "admin-destroy":
+		if len(args) < 3 {
+			return fmt.Errorf("entity ID required")
+		}
+		body["entity_id"] = args[2]
+	default:
+		return fmt.Errorf("unknown mfa subcommand: %s", action)
+	}
+
+	resp, err := makeRequest("POST", "/v1/sys/mfa/method/totp/"+method+"/"+action, body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("mfa %s failed: %s", action, errResp.Error)
+	}
+
+	if action == "admin-destroy" {
+		fmt.Printf("Secret for %s removed from MFA method %s.\n", args[2], method)
+		return nil
+	}
+
+	var secret struct {
+		EntityID string `json:"entity_id"`
+		URL      string `json:"url"`
+		Secret   string `json:"secret"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return err
+	}
+
+	fmt.Printf("Entity:  %s\n", secret.EntityID)
+	fmt.Printf("Secret:  %s\n", secret.Secret)
+	fmt.Printf("URL:     %s\n", secret.URL)
+	fmt.Println("\nAdd the secret to an authenticator app, or show the URL as a QR code,")
+	fmt.Println("for example with: qrencode -t ansiutf8 '<url>'")
+	return nil
+}
+
+func handleLockout(args []string) error {
+	token := getVaultToken()
+	if token == "" {
+		return fmt.Errorf("VAULT_TOKEN not set")
+	}
+
+	if len(args) < 1 {
+		return fmt.Errorf("usage: lockout list | lockout unlock <mount> <alias> | lockout unlock -address=<ip>")
+	}
+
+	switch args[0] {
+	case "list":
+		resp, err := makeRequest("GET", "/v1/sys/locked-users", nil, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("failed to list lockouts: %s", errResp.Error)
+		}
+
+		var locked struct {
+			Users []struct {
+				MountPath   string    `json:"mount_path"`
+				Alias       string    `json:"alias"`
+				LockedUntil time.Time `json:"locked_until"`
+			} `json:"users"`
+			Addresses []struct {
+				RemoteAddress string    `json:"remote_address"`
+				LockedUntil   time.Time `json:"locked_until"`
+			} `json:"addresses"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&locked); err != nil {
+			return err
+		}
+
+		if len(locked.Users) == 0 && len(locked.Addresses) == 0 {
+			fmt.Println("No lockouts")
+			return nil
+		}
+		for _, u := range locked.Users {
+			fmt.Printf("user     auth/%s%s  until %s\n", u.MountPath, u.Alias, u.LockedUntil.Local().Format(time.RFC3339))
+		}
+		for _, a := range locked.Addresses {
+			fmt.Printf("address  %s  until %s\n", a.RemoteAddress, a.LockedUntil.Local().Format(time.RFC3339))
+		}
+		return nil
+	case "unlock":
+		fs := flag.NewFlagSet("lockout unlock", flag.ExitOnError)
+		address := fs.String("address", "", "client address to unlock")
+		fs.Parse(args[1:])
+
+		body := map[string]string{}
+		target := *address
+		if *address != "" {
+			body["remote_address"] = *address
+		} else {
+			if fs.NArg() < 2 {
+				return fmt.Errorf("usage: lockout unlock <mount> <alias> | lockout unlock -address=<ip>")
+			}
+			body["mount_path"] = fs.Arg(0)
+			body["alias"] = fs.Arg(1)
+			target = fs.Arg(1)
+		}
+
+		resp, err := makeRequest("POST", "/v1/sys/locked-users/unlock", body, token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			var errResp ErrorResponse
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return fmt.Errorf("failed to unlock %s: %s", target, errResp.Error)
+		}
+
+		fmt.Printf("Unlocked %s\n", target)
+		return nil
+	}
+	return fmt.Errorf("unknown lockout subcommand: %s", args[0])
+}
+
+func handleUnwrap(args []string) error {
+	body := map[string]string{}
+	token := getVaultToken()
+	if len(args) > 0 {
+		body["token"] = args[0]
+	} else if token == "" {
+		return fmt.Errorf("wrapping token required")
+	}
+
+	resp, err := makeRequest("POST", "/v1/sys/wrapping/unwrap", body, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("unwrap failed: %s", errResp.Error)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	command := os.Args[1]
+	var err error
+
+	switch command {
+	case "status":
+		err = handleStatus()
+	case "init":
+		err = handleInit(os.Args[2:])
+	case "unseal":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: unseal key required")
 			os.Exit(1)
 		}
-		err = handleWrite(os.Args[2], os.Args[3:])
+		err = handleUnseal(os.Args[2:])
+	case "seal":
+		err = handleSeal()
+	case "operator":
+		err = handleOperator(os.Args[2:])
+	case "auth":
+		err = handleAuth(os.Args[2:])
+	case "login":
+		err = handleLogin(os.Args[2:])
+	case "write":
+		err = handleWrite(os.Args[2:])
 	case "read":
 		if len(os.Args) < 3 {
 			fmt.Println("Error: path required")
 			os.Exit(1)
 		}
-		err = handleRead(os.Args[2])
+		err = handleRead(os.Args[2:])
 	case "delete":
 		if len(os.Args) < 3 {
 			fmt.Println("Error: path required")
 			os.Exit(1)
 		}
-		err = handleDelete(os.Args[2])
+		err = handleDelete(os.Args[2:])
+	case "undelete", "destroy":
+		err = handleVersionAction(command, os.Args[2:])
+	case "metadata":
+		if len(os.Args) < 3 {
+			fmt.Println("Error: path required")
+			os.Exit(1)
+		}
+		err = handleMetadata(os.Args[2])
 	case "list":
 		prefix := ""
 		if len(os.Args) >= 3 {
@@ -416,11 +2076,19 @@ func main() {
 		}
 		err = handleList(prefix)
 	case "token-create":
-		ttl := ""
-		if len(os.Args) >= 3 {
-			ttl = os.Args[2]
-		}
-		err = handleTokenCreate(ttl)
+		err = handleTokenCreate(os.Args[2:])
+	case "token":
+		err = handleToken(os.Args[2:])
+	case "secrets":
+		err = handleSecrets(os.Args[2:])
+	case "policy":
+		err = handlePolicy(os.Args[2:])
+	case "mfa":
+		err = handleMFA(os.Args[2:])
+	case "lockout":
+		err = handleLockout(os.Args[2:])
+	case "unwrap":
+		err = handleUnwrap(os.Args[2:])
 	case "help", "-h", "--help":
 		printUsage()
 		os.Exit(0)
@@ -1,13 +1,33 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
+	"vault-clone/pkg/approle"
+	"vault-clone/pkg/audit"
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/cert"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/cubbyhole"
+	"vault-clone/pkg/jwt"
+	"vault-clone/pkg/kv"
+	"vault-clone/pkg/ldap"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+	"vault-clone/pkg/totp"
+	"vault-clone/pkg/transit"
+	"vault-clone/pkg/userpass"
 	"vault-clone/pkg/vault"
 )
 
@@ -15,8 +35,13 @@ var (
 	vaultInstance *vault.Vault
 	addr          = flag.String("addr", "127.0.0.1:8200", "HTTP server address")
 	storagePath   = flag.String("storage", "./vault-data", "Storage directory path")
+	tlsCertFile   = flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set")
+	tlsKeyFile    = flag.String("tls-key", "", "TLS private key file")
 )
 
+// maxRequestSize caps request bodies, which are read into memory whole
+const maxRequestSize = 32 << 20
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -26,28 +51,98 @@ type StatusResponse struct {
 	Sealed      bool `json:"sealed"`
 }
 
-type SecretRequest struct {
-	Data map[string]interface{} `json:"data"`
+type InitRequest struct {
+	SecretShares    int `json:"secret_shares"`
+	SecretThreshold int `json:"secret_threshold"`
+}
+
+type UnsealRequest struct {
+	Key   string `json:"key"`
+	Reset bool   `json:"reset"`
+}
+
+type RekeyUpdateRequest struct {
+	Key   string `json:"key"`
+	Nonce string `json:"nonce"`
+}
+
+type RekeyCancelRequest struct {
+	Nonce string `json:"nonce"`
+}
+
+type MountTuneRequest struct {
+	Description     *string     `json:"description"`
+	DefaultLeaseTTL interface{} `json:"default_lease_ttl"`
+	MaxLeaseTTL     interface{} `json:"max_lease_ttl"`
+}
+
+type RemountRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type AuditEnableRequest struct {
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	Options     map[string]string `json:"options"`
 }
 
-type SecretResponse struct {
-	Data map[string]interface{} `json:"data"`
+type AuditHashRequest struct {
+	Input string `json:"input"`
 }
 
 type TokenCreateRequest struct {
-	TTL string `json:"ttl"` // Duration string like "1h", "24h", etc.
+	TTL            string   `json:"ttl"` // Duration string like "1h", "24h", etc.
+	Policies       []string `json:"policies"`
+	Renewable      *bool    `json:"renewable"` // Defaults to true
+	ExplicitMaxTTL string   `json:"explicit_max_ttl"`
+	Period         string   `json:"period"`
+	NumUses        int      `json:"num_uses"`
+}
+
+type TokenTargetRequest struct {
+	Token     string      `json:"token"`
+	Accessor  string      `json:"accessor"`
+	Increment interface{} `json:"increment"`
+}
+
+type MFAEntityRequest struct {
+	EntityID string `json:"entity_id"`
+}
+
+type LockoutConfigRequest struct {
+	Threshold    *int        `json:"lockout_threshold"`
+	IPThreshold  *int        `json:"ip_lockout_threshold"`
+	Duration     interface{} `json:"lockout_duration"`
+	CounterReset interface{} `json:"lockout_counter_reset"`
+	Disabled     *bool       `json:"disable_lockout"`
+}
+
+type UnlockRequest struct {
+	MountPath     string `json:"mount_path"`
+	Alias         string `json:"alias"`
+	RemoteAddress string `json:"remote_address"`
 }
 
-type TokenCreateResponse struct {
+type WrappingTokenRequest struct {
 	Token string `json:"token"`
 }
 
+type PolicyRequest struct {
+	Policy string `json:"policy"`
+}
+
+type LeaseRequest struct {
+	LeaseID   string      `json:"lease_id"`
+	Increment interface{} `json:"increment"`
+}
+
 // CORS middleware
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Vault-Token")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, LIST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Vault-Token, X-Vault-MFA, X-Vault-Wrap-TTL")
 		w.Header().Set("Access-Control-Max-Age", "3600")
 
 		// Handle preflight requests
@@ -60,6 +155,240 @@ func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Body limit middleware: request bodies larger than maxRequestSize fail
+// to read, so that a client cannot make the server buffer without bound
+func limitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		next(w, r)
+	}
+}
+
+// MFA middleware: passcodes sent in X-Vault-MFA headers are validated
+// for the calling token before the request is served, so that paths
+// requiring MFA let it through
+func mfaMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		passcodes, err := mfaPasscodes(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		token := getTokenFromHeader(r)
+		if token != "" && len(passcodes) > 0 {
+			path := strings.TrimPrefix(r.URL.Path, "/v1/")
+			if err := vaultInstance.ValidateMFA(token, path, passcodes); err != nil {
+				writeError(w, errorStatus(err, http.StatusForbidden), err.Error())
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// mfaPasscodes parses the X-Vault-MFA headers, each of the form
+// "<method>:<passcode>"
+func mfaPasscodes(r *http.Request) (map[string]string, error) {
+	values := r.Header.Values("X-Vault-MFA")
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	passcodes := make(map[string]string, len(values))
+	for _, value := range values {
+		method, passcode, ok := strings.Cut(value, ":")
+		method, passcode = strings.TrimSpace(method), strings.TrimSpace(passcode)
+		if !ok || method == "" || passcode == "" {
+			return nil, errors.New("X-Vault-MFA must be of the form <method>:<passcode>")
+		}
+		passcodes[strings.ToLower(method)] = passcode
+	}
+	return passcodes, nil
+}
+
+// Wrap middleware: the response to a request with an X-Vault-Wrap-TTL
+// header is stored under a single-use wrapping token, and the client
+// only gets the wrapping info. Requests whose response could not be
+// wrapped are refused before they are served. Errors are returned as
+// they are.
+func wrapMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl, err := logical.ParseTTL(r.Header.Get("X-Vault-Wrap-TTL"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid X-Vault-Wrap-TTL: "+err.Error())
+			return
+		}
+		// sys/wrapping/wrap reads the header itself
+		if ttl == 0 || r.URL.Path == "/v1/sys/wrapping/wrap" {
+			next(w, r)
+			return
+		}
+		if ttl < 0 {
+			writeError(w, http.StatusBadRequest, "invalid X-Vault-Wrap-TTL: must be positive")
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		if err := vaultInstance.CheckWrap(path); err != nil {
+			writeError(w, errorStatus(err, http.StatusServiceUnavailable), err.Error())
+			return
+		}
+
+		rec := &auditResponseWriter{header: make(http.Header)}
+		next(rec, r)
+		rec.WriteHeader(http.StatusOK)
+
+		if rec.status != http.StatusOK {
+			for key, values := range rec.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+			return
+		}
+
+		info, err := vaultInstance.WrapResponse(path, ttl, rec.body.Bytes())
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"wrap_info": info})
+	}
+}
+
+// auditResponseWriter buffers a response until it has been audited, or
+// wrapped
+type auditResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditResponseWriter) Header() http.Header { return w.header }
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *auditResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// Audit middleware: records each request before it is served and its
+// response before it is sent. If audit devices are enabled and none of
+// them can record an entry, the client gets an error instead.
+func auditMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		id, err := crypto.GenerateUUID()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		token := getTokenFromHeader(r)
+		req := &audit.Request{
+			ID:            id,
+			Operation:     auditOperation(r),
+			Path:          strings.TrimPrefix(r.URL.Path, "/v1/"),
+			RemoteAddress: remoteAddress(r),
+			Data:          auditRequestData(r, body),
+		}
+
+		if err := vaultInstance.AuditRequest(token, req); err != nil {
+			log.Printf("Audit failed for request %s: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "failed to audit request")
+			return
+		}
+
+		rec := &auditResponseWriter{header: make(http.Header)}
+		next(rec, r)
+		rec.WriteHeader(http.StatusOK)
+
+		resp := &audit.Response{StatusCode: rec.status}
+		var respErr string
+		if err := json.Unmarshal(rec.body.Bytes(), &resp.Data); err == nil && rec.status >= http.StatusBadRequest {
+			if data, ok := resp.Data.(map[string]interface{}); ok {
+				respErr, _ = data["error"].(string)
+				resp.Data = nil
+			}
+		}
+
+		if err := vaultInstance.AuditResponse(token, req, resp, respErr); err != nil {
+			log.Printf("Audit failed for response %s: %v", id, err)
+			writeError(w, http.StatusInternalServerError, "failed to audit response")
+			return
+		}
+
+		for key, values := range rec.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// auditOperation names the operation an HTTP method performs
+func auditOperation(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Get("list") == "true" {
+			return string(logical.ListOperation)
+		}
+		return string(logical.ReadOperation)
+	case "LIST":
+		return string(logical.ListOperation)
+	case http.MethodDelete:
+		return string(logical.DeleteOperation)
+	}
+	return string(logical.UpdateOperation)
+}
+
+// auditRequestData returns the query parameters and JSON body fields of
+// a request
+func auditRequestData(r *http.Request, body []byte) map[string]interface{} {
+	data := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		data[key] = values[0]
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		for key, value := range fields {
+			data[key] = value
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	return data
+}
+
+func remoteAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -70,6 +399,58 @@ func writeError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, ErrorResponse{Error: message})
 }
 
+// errorStatus maps well-known vault errors to HTTP status codes
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, vault.ErrPermissionDenied),
+		errors.Is(err, vault.ErrMFARequired),
+		errors.Is(err, vault.ErrMFAFailed):
+		return http.StatusForbidden
+	case errors.Is(err, vault.ErrMFALocked),
+		errors.Is(err, vault.ErrLoginLocked):
+		return http.StatusTooManyRequests
+	case errors.Is(err, vault.ErrMissingToken),
+		errors.Is(err, logical.ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, vault.ErrMountNotFound),
+		errors.Is(err, vault.ErrAuthNotFound),
+		errors.Is(err, vault.ErrAuditNotFound),
+		errors.Is(err, vault.ErrLeaseNotFound),
+		errors.Is(err, vault.ErrMFAMethodNotFound),
+		errors.Is(err, vault.ErrMFAEnforcementNotFound),
+		errors.Is(err, vault.ErrLockoutNotFound),
+		errors.Is(err, auth.ErrAccessorNotFound),
+		errors.Is(err, logical.ErrUnsupportedPath),
+		errors.Is(err, kv.ErrSecretNotFound),
+		errors.Is(err, cubbyhole.ErrSecretNotFound),
+		errors.Is(err, transit.ErrKeyNotFound),
+		errors.Is(err, totp.ErrKeyNotFound),
+		errors.Is(err, userpass.ErrUserNotFound),
+		errors.Is(err, approle.ErrRoleNotFound),
+		errors.Is(err, approle.ErrSecretIDNotFound),
+		errors.Is(err, jwt.ErrRoleNotFound),
+		errors.Is(err, jwt.ErrGroupNotFound),
+		errors.Is(err, cert.ErrRoleNotFound),
+		errors.Is(err, ldap.ErrGroupNotFound),
+		errors.Is(err, ldap.ErrUserNotFound),
+		errors.Is(err, kv.ErrVersionNotFound),
+		errors.Is(err, kv.ErrVersionDeleted),
+		errors.Is(err, kv.ErrVersionDestroyed):
+		return http.StatusNotFound
+	case errors.Is(err, kv.ErrCASMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, kv.ErrCASRequired),
+		errors.Is(err, vault.ErrInvalidWrappingToken),
+		errors.Is(err, logical.ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, logical.ErrUnsupportedOperation):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, storage.ErrRecordTooLarge):
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
 func getTokenFromHeader(r *http.Request) string {
 	return r.Header.Get("X-Vault-Token")
 }
@@ -81,11 +462,7 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 
 // Status endpoint
 func statusHandler(w http.ResponseWriter, r *http.Request) {
-	response := StatusResponse{
-		Initialized: vaultInstance.IsInitialized(),
-		Sealed:      vaultInstance.IsSealed(),
-	}
-	writeJSON(w, http.StatusOK, response)
+	writeJSON(w, http.StatusOK, vaultInstance.SealStatus())
 }
 
 // Initialize endpoint
@@ -100,7 +477,25 @@ func initHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	initResp, err := vaultInstance.Initialize()
+	// An empty body keeps the single unseal key behaviour
+	req := InitRequest{SecretShares: 1, SecretThreshold: 1}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	config := &vault.SealConfig{
+		SecretShares:    req.SecretShares,
+		SecretThreshold: req.SecretThreshold,
+	}
+	if err := config.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	initResp, err := vaultInstance.Initialize(config)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -116,24 +511,34 @@ func unsealHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Key string `json:"key"`
-	}
-
+	var req UnsealRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	if err := vaultInstance.Unseal(req.Key); err != nil {
+	if req.Reset {
+		status, err := vaultInstance.ResetUnseal()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	if req.Key == "" {
+		writeError(w, http.StatusBadRequest, "missing unseal key")
+		return
+	}
+
+	status, err := vaultInstance.Unseal(req.Key)
+	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, StatusResponse{
-		Initialized: vaultInstance.IsInitialized(),
-		Sealed:      vaultInstance.IsSealed(),
-	})
+	writeJSON(w, http.StatusOK, status)
 }
 
 // Seal endpoint
@@ -143,8 +548,14 @@ func sealHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := vaultInstance.Seal(); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	if err := vaultInstance.Seal(token); err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
 		return
 	}
 
@@ -154,42 +565,98 @@ func sealHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Write secret endpoint
-func writeSecretHandler(w http.ResponseWriter, r *http.Request) {
+// Rekey init endpoint: GET reports progress, POST/PUT starts a rekey,
+// DELETE cancels it
+func rekeyInitHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, vaultInstance.RekeyStatus())
+	case http.MethodPost, http.MethodPut:
+		// An empty body keeps the current share and threshold settings
+		var config *vault.SealConfig
+		if r.ContentLength != 0 {
+			var req InitRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			if req.SecretShares != 0 || req.SecretThreshold != 0 {
+				config = &vault.SealConfig{
+					SecretShares:    req.SecretShares,
+					SecretThreshold: req.SecretThreshold,
+				}
+			}
+		}
+
+		status, err := vaultInstance.RekeyInit(config)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodDelete:
+		rekeyCancelHandler(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Rekey update endpoint
+func rekeyUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost && r.Method != http.MethodPut {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	token := getTokenFromHeader(r)
-	if token == "" {
-		writeError(w, http.StatusUnauthorized, "missing token")
+	var req RekeyUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	path := r.URL.Path[len("/v1/secret/"):]
-	if path == "" {
-		writeError(w, http.StatusBadRequest, "missing secret path")
+	if req.Key == "" {
+		writeError(w, http.StatusBadRequest, "missing unseal key")
 		return
 	}
 
-	var req SecretRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	result, err := vaultInstance.RekeyUpdate(req.Key, req.Nonce)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Rekey cancel endpoint
+func rekeyCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req RekeyCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		writeError(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
-	if err := vaultInstance.WriteSecret(token, path, req.Data); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+	if req.Nonce == "" {
+		writeError(w, http.StatusBadRequest, "missing rekey nonce")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	if err := vaultInstance.RekeyCancel(req.Nonce); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "cancelled"})
 }
 
-// Read secret endpoint
-func readSecretHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// Rotate encryption key endpoint
+func rotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
@@ -200,24 +667,18 @@ func readSecretHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	path := r.URL.Path[len("/v1/secret/"):]
-	if path == "" {
-		writeError(w, http.StatusBadRequest, "missing secret path")
-		return
-	}
-
-	secret, err := vaultInstance.ReadSecret(token, path)
+	status, err := vaultInstance.RotateKey(token)
 	if err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+		writeError(w, http.StatusForbidden, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, SecretResponse{Data: secret.Data})
+	writeJSON(w, http.StatusOK, status)
 }
 
-// Delete secret endpoint
-func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
+// Encryption key status endpoint
+func keyStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
@@ -228,18 +689,71 @@ func deleteSecretHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	path := r.URL.Path[len("/v1/secret/"):]
-	if path == "" {
-		writeError(w, http.StatusBadRequest, "missing secret path")
+	status, err := vaultInstance.KeyStatus(token)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Logical endpoint: every path without a dedicated handler is routed to
+// the secrets engine or auth method mounted at its longest matching
+// prefix. Login paths of auth methods are served without a token.
+func logicalHandler(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+
+	req := &logical.Request{
+		Path:       strings.TrimPrefix(r.URL.Path, "/v1/"),
+		Data:       make(map[string]interface{}),
+		RemoteAddr: remoteAddress(r),
+	}
+	if r.TLS != nil {
+		req.PeerCertificates = r.TLS.PeerCertificates
+	}
+	req.MFACredentials, _ = mfaPasscodes(r)
+
+	switch r.Method {
+	case http.MethodGet, "LIST":
+		req.Operation = logical.ReadOperation
+		if r.Method == "LIST" || r.URL.Query().Get("list") == "true" {
+			req.Operation = logical.ListOperation
+		}
+		for key, values := range r.URL.Query() {
+			req.Data[key] = values[0]
+		}
+	case http.MethodPost, http.MethodPut:
+		req.Operation = logical.UpdateOperation
+		if err := json.NewDecoder(r.Body).Decode(&req.Data); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	case http.MethodDelete:
+		req.Operation = logical.DeleteOperation
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	resp, err := vaultInstance.HandleRequest(token, req)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
 		return
 	}
 
-	if err := vaultInstance.DeleteSecret(token, path); err != nil {
-		writeError(w, http.StatusNotFound, err.Error())
+	if resp == nil || resp.Data == nil {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	// Leased secrets carry the lease alongside the engine's fields
+	if data, ok := resp.Data.(map[string]interface{}); ok && resp.Secret != nil {
+		data["lease_id"] = resp.Secret.LeaseID
+		data["lease_duration"] = int64(resp.Secret.TTL / time.Second)
+		data["renewable"] = resp.Secret.Renewable
+	}
+	writeJSON(w, http.StatusOK, resp.Data)
 }
 
 // List secrets endpoint
@@ -255,18 +769,30 @@ func listSecretsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	prefix := r.URL.Query().Get("prefix")
-	secrets, err := vaultInstance.ListSecrets(token, prefix)
+	// Lists the default "secret/" mount
+	resp, err := vaultInstance.HandleRequest(token, &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      "secret/metadata/" + r.URL.Query().Get("prefix"),
+	})
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": secrets})
+	writeJSON(w, http.StatusOK, resp.Data)
 }
 
-// Create token endpoint
+// Create token endpoint: the new token is a child of the calling token
 func createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	createToken(w, r, false)
+}
+
+// Create orphan token endpoint: the new token has no parent
+func createOrphanTokenHandler(w http.ResponseWriter, r *http.Request) {
+	createToken(w, r, true)
+}
+
+func createToken(w http.ResponseWriter, r *http.Request, orphan bool) {
 	if r.Method != http.MethodPost {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
@@ -284,57 +810,1037 @@ func createTokenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ttl := 24 * time.Hour // Default TTL
-	if req.TTL != "" {
-		parsedTTL, err := time.ParseDuration(req.TTL)
+	// Unset durations are left to the vault's defaults
+	var durations [3]time.Duration
+	for i, raw := range []string{req.TTL, req.ExplicitMaxTTL, req.Period} {
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(raw)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, "invalid ttl format")
+			writeError(w, http.StatusBadRequest, "invalid duration format: "+raw)
 			return
 		}
-		ttl = parsedTTL
+		durations[i] = parsed
 	}
 
-	newToken, err := vaultInstance.CreateToken(token, ttl)
+	renewable := true
+	if req.Renewable != nil {
+		renewable = *req.Renewable
+	}
+
+	tokenAuth, err := vaultInstance.CreateToken(token, &vault.TokenRequest{
+		TTL:            durations[0],
+		Policies:       req.Policies,
+		Orphan:         orphan,
+		Renewable:      renewable,
+		ExplicitMaxTTL: durations[1],
+		Period:         durations[2],
+		NumUses:        req.NumUses,
+	})
 	if err != nil {
 		writeError(w, http.StatusForbidden, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, TokenCreateResponse{Token: newToken})
+	writeJSON(w, http.StatusOK, tokenAuth)
 }
 
-// Authenticate root token endpoint
-func authenticateHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
+// Router to handle token lookup, renewal and revocation endpoints. The
+// "-self" variants act on the calling token, the "-accessor" variants
+// take an accessor in the body and the others take a token.
+func tokenRouter(w http.ResponseWriter, r *http.Request) {
 	token := getTokenFromHeader(r)
 	if token == "" {
 		writeError(w, http.StatusUnauthorized, "missing token")
 		return
 	}
 
-	if err := vaultInstance.AuthenticateRootToken(token); err != nil {
-		writeError(w, http.StatusUnauthorized, err.Error())
+	action := r.URL.Path[len("/v1/auth/token/"):]
+	switch action {
+	case "lookup-self":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		info, err := vaultInstance.LookupSelf(token)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusForbidden), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
 		return
-	}
+	case "revoke-self":
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if err := vaultInstance.RevokeSelf(token); err != nil {
+			writeError(w, errorStatus(err, http.StatusForbidden), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+		return
+	case "renew-self":
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var req TokenTargetRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		increment, err := logical.ParseTTL(req.Increment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid increment: "+err.Error())
+			return
+		}
+		tokenAuth, err := vaultInstance.RenewSelf(token, increment)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, tokenAuth)
+		return
+	case "accessors":
+		if r.Method != http.MethodGet && r.Method != "LIST" {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		accessors, err := vaultInstance.ListAccessors(token)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusForbidden), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": accessors})
+		return
+	case "lookup", "renew", "revoke", "revoke-orphan", "lookup-accessor", "revoke-accessor":
+	default:
+		writeError(w, http.StatusNotFound, "unsupported path")
+		return
+	}
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req TokenTargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	byAccessor := strings.HasSuffix(action, "-accessor")
+	if byAccessor && req.Accessor == "" {
+		writeError(w, http.StatusBadRequest, "missing accessor")
+		return
+	}
+	if !byAccessor && req.Token == "" {
+		writeError(w, http.StatusBadRequest, "missing token")
+		return
+	}
+
+	switch action {
+	case "lookup":
+		info, err := vaultInstance.LookupToken(token, req.Token)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case "renew":
+		increment, err := logical.ParseTTL(req.Increment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid increment: "+err.Error())
+			return
+		}
+		tokenAuth, err := vaultInstance.RenewToken(token, req.Token, increment)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, tokenAuth)
+	case "revoke":
+		if err := vaultInstance.RevokeToken(token, req.Token); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case "revoke-orphan":
+		if err := vaultInstance.RevokeOrphan(token, req.Token); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case "lookup-accessor":
+		info, err := vaultInstance.LookupAccessor(token, req.Accessor)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case "revoke-accessor":
+		if err := vaultInstance.RevokeAccessor(token, req.Accessor); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	}
+}
+
+// Authenticate root token endpoint
+func authenticateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	if err := vaultInstance.AuthenticateRootToken(token, remoteAddress(r)); err != nil {
+		writeError(w, errorStatus(err, http.StatusUnauthorized), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "authenticated"})
+}
+
+// List policies endpoint
+func listPoliciesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	policies, err := vaultInstance.ListPolicies(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"policies": policies})
+}
+
+// Router to handle policy endpoints
+func policyRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	name := r.URL.Path[len("/v1/sys/policy/"):]
+	if name == "" {
+		listPoliciesHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p, err := vaultInstance.ReadPolicy(token, name)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusNotFound), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"name": p.Name, "policy": p.Raw})
+	case http.MethodPost, http.MethodPut:
+		var req PolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := vaultInstance.WritePolicy(token, name, req.Policy); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case http.MethodDelete:
+		if err := vaultInstance.DeletePolicy(token, name); err != nil {
+			writeError(w, errorStatus(err, http.StatusNotFound), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// List mounts endpoint
+func listMountsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	mounts, err := vaultInstance.ListMounts(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"mounts": mounts})
+}
+
+// Router to handle mount endpoints: POST/PUT enables an engine, DELETE
+// disables it, and "<path>/tune" reads or changes its settings
+func mountRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	path := r.URL.Path[len("/v1/sys/mounts/"):]
+	if path == "" {
+		listMountsHandler(w, r)
+		return
+	}
+
+	if mountPath, ok := strings.CutSuffix(path, "/tune"); ok {
+		mountTuneHandler(w, r, token, mountPath)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var entry vault.MountEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		entry.Path = path
+		if err := vaultInstance.EnableMount(token, &entry); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case http.MethodDelete:
+		if err := vaultInstance.DisableMount(token, path); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Mount tune endpoint
+func mountTuneHandler(w http.ResponseWriter, r *http.Request, token, path string) {
+	switch r.Method {
+	case http.MethodGet:
+		entry, err := vaultInstance.ReadMountTune(token, path)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"description":       entry.Description,
+			"default_lease_ttl": int64(entry.Config.DefaultLeaseTTL / time.Second),
+			"max_lease_ttl":     int64(entry.Config.MaxLeaseTTL / time.Second),
+		})
+	case http.MethodPost, http.MethodPut:
+		var req MountTuneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		tune := &vault.MountTune{Description: req.Description}
+		for _, field := range []struct {
+			name  string
+			value interface{}
+			dest  **time.Duration
+		}{
+			{"default_lease_ttl", req.DefaultLeaseTTL, &tune.DefaultLeaseTTL},
+			{"max_lease_ttl", req.MaxLeaseTTL, &tune.MaxLeaseTTL},
+		} {
+			if field.value == nil {
+				continue
+			}
+			ttl, err := logical.ParseTTL(field.value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+field.name+": "+err.Error())
+				return
+			}
+			*field.dest = &ttl
+		}
+
+		if err := vaultInstance.TuneMount(token, path, tune); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Remount endpoint: moves a mount and its data to a new path
+func remountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	var req RemountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := vaultInstance.Remount(token, req.From, req.To); err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// Mount lookup endpoint used by clients to find the engine serving a path
+func internalMountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	entry, err := vaultInstance.MountForPath(token, r.URL.Path[len("/v1/sys/internal/ui/mounts/"):])
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"path": entry.Path, "type": entry.Type})
+}
+
+// List auth methods endpoint
+func listAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	methods, err := vaultInstance.ListAuth(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"methods": methods})
+}
+
+// Router to handle auth method endpoints: POST/PUT enables a method and
+// DELETE disables it
+func authRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	path := r.URL.Path[len("/v1/sys/auth/"):]
+	if path == "" {
+		listAuthHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var entry vault.MountEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		entry.Path = path
+		if err := vaultInstance.EnableAuth(token, &entry); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case http.MethodDelete:
+		if err := vaultInstance.DisableAuth(token, path); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// List audit devices endpoint
+func listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	devices, err := vaultInstance.ListAudit(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": devices})
+}
+
+// Router to handle audit device endpoints: POST/PUT enables a device and
+// DELETE disables it
+func auditRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	path := r.URL.Path[len("/v1/sys/audit/"):]
+	if path == "" {
+		listAuditHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		var req AuditEnableRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		entry := &vault.AuditEntry{
+			Path:        path,
+			Type:        req.Type,
+			Description: req.Description,
+			Options:     req.Options,
+		}
+		if err := vaultInstance.EnableAudit(token, entry); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case http.MethodDelete:
+		if err := vaultInstance.DisableAudit(token, path); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Audit hash endpoint: returns the HMAC a device logs for a given value
+func auditHashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	var req AuditHashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	hash, err := vaultInstance.AuditHash(token, r.URL.Path[len("/v1/sys/audit-hash/"):], req.Input)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "authenticated"})
+	writeJSON(w, http.StatusOK, map[string]string{"hash": hash})
+}
+
+// Lease endpoints: lookup, renew and revoke take a lease_id in the body,
+// revoke-prefix takes the prefix in the path
+func leaseRouter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	action := r.URL.Path[len("/v1/sys/leases/"):]
+	if prefix, ok := strings.CutPrefix(action, "revoke-prefix/"); ok {
+		if err := vaultInstance.RevokePrefix(token, prefix); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+		return
+	}
+
+	var req LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.LeaseID == "" {
+		writeError(w, http.StatusBadRequest, "missing lease_id")
+		return
+	}
+
+	switch action {
+	case "lookup":
+		lease, err := vaultInstance.LookupLease(token, req.LeaseID)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, lease)
+	case "renew":
+		increment, err := logical.ParseTTL(req.Increment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid increment: "+err.Error())
+			return
+		}
+		lease, err := vaultInstance.RenewLease(token, req.LeaseID, increment)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"lease_id":       lease.ID,
+			"lease_duration": lease.TTL,
+			"renewable":      lease.Renewable,
+		})
+	case "revoke":
+		if err := vaultInstance.RevokeLease(token, req.LeaseID); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusNotFound, "unsupported path")
+	}
+}
+
+// List MFA methods endpoint
+func listMFAMethodsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != "LIST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	methods, err := vaultInstance.ListMFAMethods(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"methods": methods})
+}
+
+// Router to handle MFA method endpoints under sys/mfa/method/totp/:name:
+// the method itself, and generate, admin-generate and admin-destroy for
+// enrollments
+func mfaMethodRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	path := r.URL.Path[len("/v1/sys/mfa/method/"):]
+	if path == "" {
+		listMFAMethodsHandler(w, r)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(path, "totp/")
+	if !ok || rest == "" {
+		writeError(w, http.StatusNotFound, "unsupported path")
+		return
+	}
+	name, action, _ := strings.Cut(rest, "/")
+
+	if action != "" {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req MFAEntityRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		var secret *vault.MFASecret
+		var err error
+		switch action {
+		case "generate":
+			secret, err = vaultInstance.GenerateMFASecret(token, name)
+		case "admin-generate":
+			secret, err = vaultInstance.AdminGenerateMFASecret(token, name, req.EntityID)
+		case "admin-destroy":
+			err = vaultInstance.AdminDestroyMFASecret(token, name, req.EntityID)
+		default:
+			writeError(w, http.StatusNotFound, "unsupported path")
+			return
+		}
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		if secret == nil {
+			writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+			return
+		}
+		writeJSON(w, http.StatusOK, secret)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		method, err := vaultInstance.ReadMFAMethod(token, name)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, method)
+	case http.MethodPost, http.MethodPut:
+		var method vault.MFAMethod
+		if err := json.NewDecoder(r.Body).Decode(&method); err != nil && err != io.EOF {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		method.Name = name
+		if err := vaultInstance.WriteMFAMethod(token, &method); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	case http.MethodDelete:
+		if err := vaultInstance.DeleteMFAMethod(token, name); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// List MFA login enforcements endpoint
+func listMFAEnforcementsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != "LIST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	names, err := vaultInstance.ListMFALoginEnforcements(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enforcements": names})
 }
 
-// Router to handle secret endpoints
-func secretRouter(w http.ResponseWriter, r *http.Request) {
+// Router to handle MFA login enforcement endpoints
+func mfaEnforcementRouter(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	name := r.URL.Path[len("/v1/sys/mfa/login-enforcement/"):]
+	if name == "" {
+		listMFAEnforcementsHandler(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		readSecretHandler(w, r)
+		e, err := vaultInstance.ReadMFALoginEnforcement(token, name)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
 	case http.MethodPost, http.MethodPut:
-		writeSecretHandler(w, r)
+		var e vault.MFALoginEnforcement
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		e.Name = name
+		if err := vaultInstance.WriteMFALoginEnforcement(token, &e); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 	case http.MethodDelete:
-		deleteSecretHandler(w, r)
+		if err := vaultInstance.DeleteMFALoginEnforcement(token, name); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Login lockout config endpoint
+func lockoutConfigHandler(w http.ResponseWriter, r *http.Request) {
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		config, err := vaultInstance.ReadLockoutConfig(token)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, config)
+	case http.MethodPost, http.MethodPut:
+		var req LockoutConfigRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		tune := &vault.LockoutTune{
+			Threshold:   req.Threshold,
+			IPThreshold: req.IPThreshold,
+			Disabled:    req.Disabled,
+		}
+		for _, field := range []struct {
+			name  string
+			value interface{}
+			dest  **time.Duration
+		}{
+			{"lockout_duration", req.Duration, &tune.Duration},
+			{"lockout_counter_reset", req.CounterReset, &tune.CounterReset},
+		} {
+			if field.value == nil {
+				continue
+			}
+			d, err := logical.ParseTTL(field.value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid "+field.name+": "+err.Error())
+				return
+			}
+			*field.dest = &d
+		}
+
+		if err := vaultInstance.TuneLockoutConfig(token, tune); err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// List locked users and client addresses endpoint
+func lockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != "LIST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	locked, err := vaultInstance.ListLockedLogins(token)
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusInternalServerError), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, locked)
+}
+
+// Unlock endpoint: lifts the lockout of a user, given mount_path and
+// alias, or of a client address, given remote_address
+func unlockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	if token == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	var req UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var err error
+	switch {
+	case req.RemoteAddress != "" && req.Alias == "" && req.MountPath == "":
+		err = vaultInstance.UnlockAddress(token, req.RemoteAddress)
+	case req.RemoteAddress == "" && req.Alias != "" && req.MountPath != "":
+		err = vaultInstance.UnlockUser(token, req.MountPath, req.Alias)
 	default:
+		writeError(w, http.StatusBadRequest, "either mount_path and alias or remote_address is required")
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// Response wrapping endpoints: unwrap, lookup and rewrap take the
+// wrapping token in the body or as the request token; wrap wraps the
+// request body for the TTL in X-Vault-Wrap-TTL
+func wrappingRouter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token := getTokenFromHeader(r)
+	action := r.URL.Path[len("/v1/sys/wrapping/"):]
+
+	if action == "wrap" {
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "missing token")
+			return
+		}
+
+		ttl, err := logical.ParseTTL(r.Header.Get("X-Vault-Wrap-TTL"))
+		if err != nil || ttl == 0 {
+			writeError(w, http.StatusBadRequest, "a valid X-Vault-Wrap-TTL header is required")
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		info, err := vaultInstance.Wrap(token, data, ttl)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"wrap_info": info})
+		return
+	}
+
+	var req WrappingTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	wrappingToken := req.Token
+	if wrappingToken == "" {
+		wrappingToken = token
+	}
+	if wrappingToken == "" {
+		writeError(w, http.StatusUnauthorized, "missing token")
+		return
+	}
+
+	switch action {
+	case "unwrap":
+		data, err := vaultInstance.Unwrap(wrappingToken)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	case "lookup":
+		info, err := vaultInstance.LookupWrapping(wrappingToken)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case "rewrap":
+		info, err := vaultInstance.Rewrap(wrappingToken)
+		if err != nil {
+			writeError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"wrap_info": info})
+	default:
+		writeError(w, http.StatusNotFound, "unsupported path")
 	}
 }
 
@@ -348,20 +1854,72 @@ func main() {
 		log.Fatalf("Failed to create vault: %v", err)
 	}
 
-	// Setup routes with CORS middleware
+	// Setup routes with CORS middleware. Health and status checks are
+	// polled constantly and carry no data, so they are not audited.
+	handle := func(pattern string, handler http.HandlerFunc) {
+		http.HandleFunc(pattern, corsMiddleware(limitMiddleware(auditMiddleware(mfaMiddleware(wrapMiddleware(handler))))))
+	}
+
 	http.HandleFunc("/v1/sys/health", corsMiddleware(healthHandler))
 	http.HandleFunc("/v1/sys/status", corsMiddleware(statusHandler))
-	http.HandleFunc("/v1/sys/init", corsMiddleware(initHandler))
-	http.HandleFunc("/v1/sys/unseal", corsMiddleware(unsealHandler))
-	http.HandleFunc("/v1/sys/seal", corsMiddleware(sealHandler))
-	http.HandleFunc("/v1/secret/", corsMiddleware(secretRouter))
-	http.HandleFunc("/v1/secrets/list", corsMiddleware(listSecretsHandler))
-	http.HandleFunc("/v1/auth/token/create", corsMiddleware(createTokenHandler))
-	http.HandleFunc("/v1/auth/token/authenticate", corsMiddleware(authenticateHandler))
+	handle("/v1/sys/init", initHandler)
+	handle("/v1/sys/unseal", unsealHandler)
+	handle("/v1/sys/seal", sealHandler)
+	handle("/v1/sys/rekey/init", rekeyInitHandler)
+	handle("/v1/sys/rekey/update", rekeyUpdateHandler)
+	handle("/v1/sys/rekey/cancel", rekeyCancelHandler)
+	handle("/v1/sys/rotate", rotateHandler)
+	handle("/v1/sys/key-status", keyStatusHandler)
+	handle("/v1/sys/policy", listPoliciesHandler)
+	handle("/v1/sys/policy/", policyRouter)
+	handle("/v1/sys/mounts", listMountsHandler)
+	handle("/v1/sys/mounts/", mountRouter)
+	handle("/v1/sys/remount", remountHandler)
+	handle("/v1/sys/auth", listAuthHandler)
+	handle("/v1/sys/auth/", authRouter)
+	handle("/v1/sys/leases/", leaseRouter)
+	handle("/v1/sys/audit", listAuditHandler)
+	handle("/v1/sys/audit/", auditRouter)
+	handle("/v1/sys/audit-hash/", auditHashHandler)
+	handle("/v1/sys/mfa/method", listMFAMethodsHandler)
+	handle("/v1/sys/mfa/method/", mfaMethodRouter)
+	handle("/v1/sys/mfa/login-enforcement", listMFAEnforcementsHandler)
+	handle("/v1/sys/mfa/login-enforcement/", mfaEnforcementRouter)
+	handle("/v1/sys/config/lockout", lockoutConfigHandler)
+	handle("/v1/sys/locked-users", lockedUsersHandler)
+	handle("/v1/sys/locked-users/unlock", unlockHandler)
+	handle("/v1/sys/wrapping/", wrappingRouter)
+	handle("/v1/sys/internal/ui/mounts/", internalMountHandler)
+	handle("/v1/secrets/list", listSecretsHandler)
+	handle("/v1/auth/token/create", createTokenHandler)
+	handle("/v1/auth/token/create-orphan", createOrphanTokenHandler)
+	handle("/v1/auth/token/authenticate", authenticateHandler)
+	handle("/v1/auth/token/", tokenRouter)
+	handle("/v1/", logicalHandler)
 
 	fmt.Printf("Vault server starting on %s\n", *addr)
 	fmt.Println("Storage path:", *storagePath)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
+
+	if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+		log.Fatalf("-tls-cert and -tls-key must be given together")
+	}
+	if *tlsCertFile == "" {
+		if err := http.ListenAndServe(*addr, nil); err != nil {
+			log.Fatalf("Server failed: %v", err)
+		}
+		return
+	}
+
+	// Client certificates are requested but not verified here: the cert
+	// auth method checks them against the CAs trusted by its roles
+	server := &http.Server{
+		Addr: *addr,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.RequestClientCert,
+		},
+	}
+	if err := server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
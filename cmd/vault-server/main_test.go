@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vault-clone/pkg/kv"
+	"vault-clone/pkg/vault"
+)
+
+func newTestVault(t *testing.T) {
+	t.Helper()
+	v, err := vault.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	vaultInstance = v
+}
+
+// serve sends a request through the wrap middleware, wrapped for a
+// minute if wrap is set
+func serve(handler http.HandlerFunc, path, token, body string, wrap bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("X-Vault-Token", token)
+	}
+	if wrap {
+		r.Header.Set("X-Vault-Wrap-TTL", "60")
+	}
+	w := httptest.NewRecorder()
+	wrapMiddleware(handler)(w, r)
+	return w
+}
+
+func TestWrapRefusedForSealPaths(t *testing.T) {
+	newTestVault(t)
+
+	// The root token and unseal keys of a wrapped init could not be
+	// stored in the sealed vault, so init must not run at all
+	initBody := `{"secret_shares":1,"secret_threshold":1}`
+	if w := serve(initHandler, "/v1/sys/init", "", initBody, true); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrapped init: %d %s, want 400", w.Code, w.Body)
+	}
+	if vaultInstance.IsInitialized() {
+		t.Fatal("wrapped init initialized the vault")
+	}
+
+	w := serve(initHandler, "/v1/sys/init", "", initBody, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("init: %d %s", w.Code, w.Body)
+	}
+	var initResp struct {
+		Keys      []string `json:"keys"`
+		RootToken string   `json:"root_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &initResp); err != nil {
+		t.Fatal(err)
+	}
+
+	unsealBody := `{"key":"` + initResp.Keys[0] + `"}`
+	if w := serve(unsealHandler, "/v1/sys/unseal", "", unsealBody, true); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrapped unseal: %d %s, want 400", w.Code, w.Body)
+	}
+	if !vaultInstance.IsSealed() {
+		t.Fatal("wrapped unseal unsealed the vault")
+	}
+	if w := serve(unsealHandler, "/v1/sys/unseal", "", unsealBody, false); w.Code != http.StatusOK {
+		t.Fatalf("unseal: %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(sealHandler, "/v1/sys/seal", initResp.RootToken, "", true); w.Code != http.StatusBadRequest {
+		t.Fatalf("wrapped seal: %d %s, want 400", w.Code, w.Body)
+	}
+	if vaultInstance.IsSealed() {
+		t.Fatal("wrapped seal sealed the vault")
+	}
+}
+
+func TestWrapRefusedWhileSealed(t *testing.T) {
+	newTestVault(t)
+
+	served := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		writeJSON(w, http.StatusOK, map[string]string{"value": "secret"})
+	}
+	if w := serve(handler, "/v1/secret/data/app", "", "", true); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("wrapped request to a sealed vault: %d %s, want 503", w.Code, w.Body)
+	}
+	if served {
+		t.Fatal("request served although its response could not be wrapped")
+	}
+}
+
+func TestRekeyCancelRequiresNonce(t *testing.T) {
+	newTestVault(t)
+	initResp, err := vaultInstance.Initialize(nil)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	for _, key := range initResp.Keys {
+		if _, err := vaultInstance.Unseal(key); err != nil {
+			t.Fatalf("Unseal: %v", err)
+		}
+	}
+	status, err := vaultInstance.RekeyInit(nil)
+	if err != nil {
+		t.Fatalf("RekeyInit: %v", err)
+	}
+
+	for _, body := range []string{"", `{"nonce":"wrong"}`} {
+		if w := serve(rekeyCancelHandler, "/v1/sys/rekey/cancel", "", body, false); w.Code != http.StatusBadRequest {
+			t.Fatalf("cancel with body %q: %d %s, want 400", body, w.Code, w.Body)
+		}
+	}
+	if !vaultInstance.RekeyStatus().Started {
+		t.Fatal("rekey cancelled without its nonce")
+	}
+
+	if w := serve(rekeyCancelHandler, "/v1/sys/rekey/cancel", "", `{"nonce":"`+status.Nonce+`"}`, false); w.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", w.Code, w.Body)
+	}
+	if vaultInstance.RekeyStatus().Started {
+		t.Fatal("rekey still in progress after cancel")
+	}
+}
+
+func TestCASErrorStatus(t *testing.T) {
+	for err, want := range map[error]int{
+		kv.ErrCASMismatch:                          http.StatusPreconditionFailed,
+		fmt.Errorf("write: %w", kv.ErrCASMismatch): http.StatusPreconditionFailed,
+		kv.ErrCASRequired:                          http.StatusBadRequest,
+	} {
+		if got := errorStatus(err, http.StatusInternalServerError); got != want {
+			t.Fatalf("errorStatus(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
@@ -3,3 +3,5 @@ module vault-clone
 go 1.25.2
 
 require golang.org/x/crypto v0.43.0
+
+require golang.org/x/sys v0.37.0 // indirect
@@ -0,0 +1,651 @@
+package approle
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// backend is the AppRole auth method for machine logins. Paths are
+// served relative to the mount point:
+//
+//	role/<name>                             create, read, update and delete roles
+//	role/<name>/role-id                     read or set the role ID
+//	role/<name>/secret-id                   generate a secret ID, or list their accessors
+//	role/<name>/secret-id/lookup            look up a secret_id
+//	role/<name>/secret-id/destroy           destroy a secret_id
+//	role/<name>/secret-id-accessor/lookup   look up a secret ID by secret_id_accessor
+//	role/<name>/secret-id-accessor/destroy  destroy a secret ID by secret_id_accessor
+//	login                                   log in with role_id and secret_id (no token required)
+type backend struct {
+	// mu serializes changes to roles and secret IDs, so that concurrent
+	// logins never take more uses of a secret ID than it has
+	mu sync.Mutex
+}
+
+// Factory creates an AppRole auth method for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// LoginPaths returns the paths served without a token
+func (b *backend) LoginPaths() []string {
+	return []string{"login"}
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Path == "login" {
+		return b.handleLogin(req)
+	}
+
+	rest, ok := strings.CutPrefix(req.Path, "role")
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return nil, logical.ErrUnsupportedPath
+	}
+	rest = strings.TrimPrefix(rest, "/")
+
+	if req.Operation == logical.ListOperation && rest == "" {
+		return b.listRoles(req)
+	}
+
+	name, sub, _ := strings.Cut(rest, "/")
+	if name == "" {
+		return nil, logical.InvalidRequest("missing role name")
+	}
+	name = strings.ToLower(name)
+
+	switch sub {
+	case "":
+		return b.handleRole(req, name)
+	case "role-id":
+		return b.handleRoleID(req, name)
+	case "secret-id":
+		if req.Operation == logical.ListOperation {
+			return b.listSecretIDs(req, name)
+		}
+		return b.handleGenerateSecretID(req, name)
+	case "secret-id/lookup", "secret-id/destroy", "secret-id-accessor/lookup", "secret-id-accessor/destroy":
+		return b.handleSecretID(req, name, sub)
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// LoginAlias names the role ID and source address of a login request,
+// so that failed logins lock out one client of a role rather than every
+// machine that shares it. Unknown role IDs name no user.
+func (b *backend) LoginAlias(req *logical.Request) string {
+	if req.Path != "login" {
+		return ""
+	}
+
+	roleID := req.GetString("role_id")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := roleNameByID(req.Storage, roleID); err != nil {
+		return ""
+	}
+	if req.RemoteAddr == "" {
+		return roleID
+	}
+	return roleID + "@" + req.RemoteAddr
+}
+
+// Exists reports whether the role targeted by a role write exists, so
+// that creating a role requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	name, ok := strings.CutPrefix(req.Path, "role/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadRole(req.Storage, strings.ToLower(name))
+	if errors.Is(err, ErrRoleNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) listRoles(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, rolePrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleRole(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: roleInfo(role)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		role, err := loadRole(req.Storage, name)
+		created := errors.Is(err, ErrRoleNotFound)
+		if created {
+			roleID, genErr := crypto.GenerateUUID()
+			if genErr != nil {
+				return nil, genErr
+			}
+			role, err = &Role{RoleID: roleID, BindSecretID: true}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := updateRole(req, role); err != nil {
+			return nil, err
+		}
+
+		if created {
+			if err := req.Storage.Put(roleIDPrefix+role.RoleID, []byte(name)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, saveRole(req.Storage, name, role)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Secret IDs go first so that an interrupted delete can be retried
+		keys, err := req.Storage.List(secretIDPrefix + name + "/")
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			key = strings.TrimPrefix(key, secretIDPrefix)
+			secret, err := loadSecretID(req.Storage, key)
+			if errors.Is(err, ErrSecretIDNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := deleteSecretID(req.Storage, key, secret); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := req.Storage.Delete(roleIDPrefix + role.RoleID); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(rolePrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// updateRole applies the fields present in a request to a role
+func updateRole(req *logical.Request, role *Role) error {
+	if bind, ok, err := req.GetBool("bind_secret_id"); err != nil {
+		return err
+	} else if ok {
+		role.BindSecretID = bind
+	}
+
+	for key, dest := range map[string]*time.Duration{
+		"secret_id_ttl": &role.SecretIDTTL,
+		"token_ttl":     &role.TokenTTL,
+		"token_max_ttl": &role.TokenMaxTTL,
+		"token_period":  &role.TokenPeriod,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		ttl, err := req.GetTTL(key)
+		if err != nil {
+			return err
+		}
+		*dest = ttl
+	}
+
+	for key, dest := range map[string]*int{
+		"secret_id_num_uses": &role.SecretIDNumUses,
+		"token_num_uses":     &role.TokenNumUses,
+	} {
+		n, ok, err := req.GetInt(key)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return logical.InvalidRequest("%s cannot be negative", key)
+		}
+		if ok {
+			*dest = n
+		}
+	}
+
+	for key, dest := range map[string]*[]string{
+		"secret_id_bound_cidrs": &role.SecretIDBoundCIDRs,
+		"token_policies":        &role.TokenPolicies,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		values, err := req.GetStrings(key)
+		if err != nil {
+			return err
+		}
+		*dest = values
+	}
+
+	if _, ok := req.Data["token_type"]; ok {
+		role.TokenType = req.GetString("token_type")
+	}
+
+	switch role.TokenType {
+	case "", "default", "service":
+	case "batch":
+		return logical.InvalidRequest("batch tokens are not supported")
+	default:
+		return logical.InvalidRequest("invalid token_type %q", role.TokenType)
+	}
+	if err := validateCIDRs(role.SecretIDBoundCIDRs); err != nil {
+		return logical.InvalidRequest("%v", err)
+	}
+	if role.TokenMaxTTL > 0 && role.TokenTTL > role.TokenMaxTTL {
+		return logical.InvalidRequest("token_ttl cannot exceed token_max_ttl")
+	}
+	if !role.BindSecretID && len(role.SecretIDBoundCIDRs) == 0 {
+		return logical.InvalidRequest("bind_secret_id can only be disabled with secret_id_bound_cidrs")
+	}
+	return nil
+}
+
+func (b *backend) handleRoleID(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: map[string]interface{}{"role_id": role.RoleID}}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		roleID := req.GetString("role_id")
+		if roleID == "" {
+			return nil, logical.InvalidRequest("missing role_id")
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if roleID == role.RoleID {
+			return nil, nil
+		}
+		if _, err := roleNameByID(req.Storage, roleID); err == nil {
+			return nil, logical.InvalidRequest("role_id is already in use")
+		} else if !errors.Is(err, ErrRoleNotFound) {
+			return nil, err
+		}
+
+		if err := req.Storage.Put(roleIDPrefix+roleID, []byte(name)); err != nil {
+			return nil, err
+		}
+		previous := role.RoleID
+		role.RoleID = roleID
+		if err := saveRole(req.Storage, name, role); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(roleIDPrefix + previous)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// handleGenerateSecretID issues a new secret ID for a role. The secret ID
+// itself is only ever returned here.
+func (b *backend) handleGenerateSecretID(req *logical.Request, name string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	cidrs, err := req.GetStrings("cidr_list")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateCIDRs(cidrs); err != nil {
+		return nil, logical.InvalidRequest("%v", err)
+	}
+	metadata, err := secretIDMetadata(req)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	role, err := loadRole(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	secretID, err := crypto.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := crypto.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	secret := &SecretID{
+		Accessor:  accessor,
+		CreatedAt: now,
+		NumUses:   role.SecretIDNumUses,
+		CIDRList:  cidrs,
+		Metadata:  metadata,
+	}
+	if role.SecretIDTTL > 0 {
+		secret.ExpiresAt = now.Add(role.SecretIDTTL)
+	}
+
+	key := name + "/" + hashSecretID(secretID)
+	if err := saveSecretID(req.Storage, key, secret); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(accessorPrefix+accessor, []byte(key)); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		"secret_id":          secretID,
+		"secret_id_accessor": accessor,
+		"secret_id_ttl":      int64(role.SecretIDTTL / time.Second),
+		"secret_id_num_uses": role.SecretIDNumUses,
+	}}, nil
+}
+
+// secretIDMetadata reads the metadata of a new secret ID, given as a JSON
+// object or a string containing one
+func secretIDMetadata(req *logical.Request) (map[string]string, error) {
+	raw, ok := req.Data["metadata"]
+	if !ok || raw == nil || raw == "" {
+		return nil, nil
+	}
+
+	var data []byte
+	if s, ok := raw.(string); ok {
+		data = []byte(s)
+	} else {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, logical.InvalidRequest("metadata must be an object of strings")
+	}
+	return metadata, nil
+}
+
+func (b *backend) listSecretIDs(req *logical.Request, name string) (*logical.Response, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := loadRole(req.Storage, name); err != nil {
+		return nil, err
+	}
+
+	keys, err := req.Storage.List(secretIDPrefix + name + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	accessors := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, secretIDPrefix)
+		secret, err := loadSecretID(req.Storage, key)
+		if errors.Is(err, ErrSecretIDNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Expired secret IDs are removed the first time they are seen
+		if secret.Expired(now) {
+			if err := deleteSecretID(req.Storage, key, secret); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		accessors = append(accessors, secret.Accessor)
+	}
+	sort.Strings(accessors)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": accessors}}, nil
+}
+
+// handleSecretID looks up or destroys a secret ID of a role, given the
+// secret ID or its accessor
+func (b *backend) handleSecretID(req *logical.Request, name, sub string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := loadRole(req.Storage, name); err != nil {
+		return nil, err
+	}
+
+	var key string
+	if strings.HasPrefix(sub, "secret-id-accessor/") {
+		accessor := req.GetString("secret_id_accessor")
+		if accessor == "" {
+			return nil, logical.InvalidRequest("missing secret_id_accessor")
+		}
+		data, err := req.Storage.Get(accessorPrefix + accessor)
+		if err != nil || !strings.HasPrefix(string(data), name+"/") {
+			return nil, ErrSecretIDNotFound
+		}
+		key = string(data)
+	} else {
+		secretID := req.GetString("secret_id")
+		if secretID == "" {
+			return nil, logical.InvalidRequest("missing secret_id")
+		}
+		key = name + "/" + hashSecretID(secretID)
+	}
+
+	secret, err := loadSecretID(req.Storage, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(sub, "/destroy") {
+		return nil, deleteSecretID(req.Storage, key, secret)
+	}
+
+	if secret.Expired(time.Now()) {
+		if err := deleteSecretID(req.Storage, key, secret); err != nil {
+			return nil, err
+		}
+		return nil, ErrSecretIDNotFound
+	}
+	return &logical.Response{Data: secretIDInfo(secret)}, nil
+}
+
+// handleLogin exchanges a role ID and a secret ID for a token with the
+// role's settings. A login that succeeds takes one use of a use-limited
+// secret ID.
+func (b *backend) handleLogin(req *logical.Request) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	roleID := req.GetString("role_id")
+	if roleID == "" {
+		return nil, logical.InvalidRequest("missing role_id")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name, err := roleNameByID(req.Storage, roleID)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil, logical.InvalidCredentials("invalid role_id or secret_id")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := loadRole(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cidrsAllow(role.SecretIDBoundCIDRs, req.RemoteAddr) {
+		return nil, logical.InvalidCredentials("source address %q is not allowed to log in with the role", req.RemoteAddr)
+	}
+
+	metadata := map[string]string{"role_name": name}
+	var consume func() error
+
+	if role.BindSecretID {
+		secretID := req.GetString("secret_id")
+		if secretID == "" {
+			return nil, logical.InvalidRequest("missing secret_id")
+		}
+
+		key := name + "/" + hashSecretID(secretID)
+		secret, err := loadSecretID(req.Storage, key)
+		if errors.Is(err, ErrSecretIDNotFound) {
+			return nil, logical.InvalidCredentials("invalid role_id or secret_id")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if secret.Expired(time.Now()) {
+			if err := deleteSecretID(req.Storage, key, secret); err != nil {
+				return nil, err
+			}
+			return nil, logical.InvalidCredentials("invalid role_id or secret_id")
+		}
+
+		if !cidrsAllow(secret.CIDRList, req.RemoteAddr) {
+			return nil, logical.InvalidCredentials("source address %q is not allowed to use the secret_id", req.RemoteAddr)
+		}
+
+		if secret.NumUses > 0 {
+			consume = func() error { return b.useSecretID(req.Storage, key) }
+		}
+
+		for k, v := range secret.Metadata {
+			if k != "role_name" {
+				metadata[k] = v
+			}
+		}
+	}
+
+	return &logical.Response{Auth: &logical.Auth{
+		Policies:  role.TokenPolicies,
+		TTL:       role.TokenTTL,
+		MaxTTL:    role.TokenMaxTTL,
+		Period:    role.TokenPeriod,
+		NumUses:   role.TokenNumUses,
+		Renewable: true,
+		Metadata:  metadata,
+		Alias:     name,
+		Consume:   consume,
+	}}, nil
+}
+
+// useSecretID takes one use of a use-limited secret ID once a login with
+// it has succeeded. The last use deletes it. Concurrent logins may have
+// used it up since the login checked it, in which case the login fails.
+func (b *backend) useSecretID(store storage.Storage, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	secret, err := loadSecretID(store, key)
+	if errors.Is(err, ErrSecretIDNotFound) || (err == nil && secret.Expired(time.Now())) {
+		return logical.InvalidCredentials("invalid role_id or secret_id")
+	}
+	if err != nil {
+		return err
+	}
+
+	if secret.NumUses == 1 {
+		return deleteSecretID(store, key, secret)
+	}
+	secret.NumUses--
+	return saveSecretID(store, key, secret)
+}
+
+func roleInfo(role *Role) map[string]interface{} {
+	tokenType := role.TokenType
+	if tokenType == "" {
+		tokenType = "default"
+	}
+	return map[string]interface{}{
+		"bind_secret_id":        role.BindSecretID,
+		"secret_id_ttl":         int64(role.SecretIDTTL / time.Second),
+		"secret_id_num_uses":    role.SecretIDNumUses,
+		"secret_id_bound_cidrs": nonNil(role.SecretIDBoundCIDRs),
+		"token_policies":        nonNil(role.TokenPolicies),
+		"token_ttl":             int64(role.TokenTTL / time.Second),
+		"token_max_ttl":         int64(role.TokenMaxTTL / time.Second),
+		"token_period":          int64(role.TokenPeriod / time.Second),
+		"token_num_uses":        role.TokenNumUses,
+		"token_type":            tokenType,
+	}
+}
+
+func secretIDInfo(secret *SecretID) map[string]interface{} {
+	info := map[string]interface{}{
+		"secret_id_accessor": secret.Accessor,
+		"creation_time":      secret.CreatedAt,
+		"secret_id_num_uses": secret.NumUses,
+		"cidr_list":          nonNil(secret.CIDRList),
+		"metadata":           secret.Metadata,
+	}
+	if !secret.ExpiresAt.IsZero() {
+		info["expiration_time"] = secret.ExpiresAt
+	}
+	return info
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,197 @@
+package approle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// Keys inside the method's storage view
+const (
+	rolePrefix = "role/"
+	// roleIDPrefix maps role IDs to role names
+	roleIDPrefix = "role_id/"
+	// secretIDPrefix stores secret IDs as "<role>/<secret ID hash>"
+	secretIDPrefix = "secret_id/"
+	// accessorPrefix maps secret ID accessors to "<role>/<secret ID hash>"
+	accessorPrefix = "accessor/"
+)
+
+var (
+	// ErrRoleNotFound is returned when a role does not exist
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrSecretIDNotFound is returned for unknown or expired secret IDs
+	ErrSecretIDNotFound = errors.New("secret_id not found")
+)
+
+// Role describes which machines can log in and the tokens they get
+type Role struct {
+	RoleID string `json:"role_id"`
+	// BindSecretID requires a secret ID at login
+	BindSecretID bool `json:"bind_secret_id"`
+	// SecretIDTTL and SecretIDNumUses limit new secret IDs; zero means
+	// unlimited
+	SecretIDTTL     time.Duration `json:"secret_id_ttl"`
+	SecretIDNumUses int           `json:"secret_id_num_uses"`
+	// SecretIDBoundCIDRs restricts the addresses that can log in
+	SecretIDBoundCIDRs []string `json:"secret_id_bound_cidrs"`
+
+	TokenPolicies []string      `json:"token_policies"`
+	TokenTTL      time.Duration `json:"token_ttl"`
+	TokenMaxTTL   time.Duration `json:"token_max_ttl"`
+	TokenPeriod   time.Duration `json:"token_period"`
+	TokenNumUses  int           `json:"token_num_uses"`
+	TokenType     string        `json:"token_type"`
+}
+
+// SecretID is a credential issued for a role. It is stored under the
+// hash of the secret ID, which is only returned when it is generated.
+type SecretID struct {
+	Accessor  string    `json:"accessor"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is zero for secret IDs that do not expire
+	ExpiresAt time.Time `json:"expires_at"`
+	// NumUses is the number of logins left; zero means unlimited
+	NumUses  int               `json:"num_uses"`
+	CIDRList []string          `json:"cidr_list"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Expired reports whether a secret ID can no longer be used
+func (s *SecretID) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt)
+}
+
+// validateCIDRs checks that every entry is an IP address or CIDR block
+func validateCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, err := parseCIDR(cidr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cidrsAllow reports whether addr is within any of the CIDR blocks. An
+// empty list allows every address.
+func cidrsAllow(cidrs []string, addr string) bool {
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if network, err := parseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDR parses a CIDR block, treating a bare address as a single host
+func parseCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR block %q", cidr)
+		}
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR block %q", cidr)
+	}
+	return network, nil
+}
+
+// hashSecretID returns the storage key of a secret ID within its role
+func hashSecretID(secretID string) string {
+	hash := sha256.Sum256([]byte(secretID))
+	return hex.EncodeToString(hash[:])
+}
+
+func loadRole(store storage.Storage, name string) (*Role, error) {
+	data, err := store.Get(rolePrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var role Role
+	if err := json.Unmarshal(data, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func saveRole(store storage.Storage, name string, role *Role) error {
+	data, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	return store.Put(rolePrefix+name, data)
+}
+
+// roleNameByID resolves a role ID to the name of its role
+func roleNameByID(store storage.Storage, roleID string) (string, error) {
+	data, err := store.Get(roleIDPrefix + roleID)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return "", ErrRoleNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func loadSecretID(store storage.Storage, key string) (*SecretID, error) {
+	data, err := store.Get(secretIDPrefix + key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrSecretIDNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var secret SecretID
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func saveSecretID(store storage.Storage, key string, secret *SecretID) error {
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	return store.Put(secretIDPrefix+key, data)
+}
+
+// deleteSecretID removes a secret ID and its accessor index
+func deleteSecretID(store storage.Storage, key string, secret *SecretID) error {
+	if err := store.Delete(accessorPrefix + secret.Accessor); err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
+	if err := store.Delete(secretIDPrefix + key); err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// hashPrefix marks values that were replaced by their HMAC
+const hashPrefix = "hmac-sha256:"
+
+// Entry types
+const (
+	TypeRequest  = "request"
+	TypeResponse = "response"
+	// TypeLockout records a user or client address locked out after too
+	// many failed logins
+	TypeLockout = "lockout"
+)
+
+// Entry is one line of the audit log
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Auth     *Auth     `json:"auth,omitempty"`
+	Request  *Request  `json:"request,omitempty"`
+	Response *Response `json:"response,omitempty"`
+	Lockout  *Lockout  `json:"lockout,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Auth describes the token a request was made with
+type Auth struct {
+	ClientToken string   `json:"client_token,omitempty"`
+	Accessor    string   `json:"accessor,omitempty"`
+	Policies    []string `json:"policies,omitempty"`
+}
+
+// Request describes an incoming request
+type Request struct {
+	ID            string                 `json:"id"`
+	Operation     string                 `json:"operation"`
+	Path          string                 `json:"path"`
+	RemoteAddress string                 `json:"remote_address"`
+	Data          map[string]interface{} `json:"data,omitempty"`
+}
+
+// Response describes the response sent for a request
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Lockout describes a login lockout. Mount path and alias are empty
+// when a client address is locked out of every auth method.
+type Lockout struct {
+	MountPath     string    `json:"mount_path,omitempty"`
+	Alias         string    `json:"alias,omitempty"`
+	RemoteAddress string    `json:"remote_address,omitempty"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// Salt computes the HMACs that replace sensitive values in the log of
+// one device
+type Salt struct {
+	key []byte
+}
+
+// NewSalt creates a salt from a device's HMAC key
+func NewSalt(key []byte) *Salt {
+	return &Salt{key: key}
+}
+
+// HMAC returns the "hmac-sha256:<hex>" form of a value
+func (s *Salt) HMAC(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return hashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hash returns a copy of an entry with the client token and every string
+// in the request and response data replaced by its HMAC. The accessor is
+// logged as is so operators can act on it.
+func (s *Salt) Hash(entry *Entry) *Entry {
+	hashed := *entry
+
+	if entry.Auth != nil {
+		auth := *entry.Auth
+		if auth.ClientToken != "" {
+			auth.ClientToken = s.HMAC(auth.ClientToken)
+		}
+		hashed.Auth = &auth
+	}
+
+	if entry.Request != nil {
+		req := *entry.Request
+		if req.Data != nil {
+			req.Data = s.hashValue(req.Data).(map[string]interface{})
+		}
+		hashed.Request = &req
+	}
+
+	if entry.Response != nil {
+		resp := *entry.Response
+		resp.Data = s.hashValue(resp.Data)
+		hashed.Response = &resp
+	}
+
+	return &hashed
+}
+
+// hashValue walks decoded JSON and replaces every string with its HMAC
+func (s *Salt) hashValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.HMAC(v)
+	case map[string]interface{}:
+		hashed := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			hashed[key] = s.hashValue(item)
+		}
+		return hashed
+	case []interface{}:
+		hashed := make([]interface{}, len(v))
+		for i, item := range v {
+			hashed[i] = s.hashValue(item)
+		}
+		return hashed
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Device types
+const (
+	TypeFile   = "file"
+	TypeStdout = "stdout"
+	TypeSocket = "socket"
+)
+
+// defaultWriteTimeout bounds how long a socket device may block a request
+const defaultWriteTimeout = 2 * time.Second
+
+// Device writes audit log lines to a destination
+type Device interface {
+	// Write records one line; the line includes its trailing newline
+	Write(line []byte) error
+	// Close releases the destination
+	Close() error
+}
+
+// NewDevice creates a device of the given type and checks that its
+// destination is reachable. Options:
+//
+//	file    file_path (required; "stdout" writes to standard output)
+//	socket  address (required), socket_type ("tcp" or "unix"),
+//	        write_timeout (duration, default 2s)
+func NewDevice(deviceType string, options map[string]string) (Device, error) {
+	d, err := OpenDevice(deviceType, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := d.(*socketDevice); ok {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// OpenDevice creates a device of the given type without waiting for a
+// socket to accept connections; sockets connect on their first write
+func OpenDevice(deviceType string, options map[string]string) (Device, error) {
+	switch deviceType {
+	case TypeFile:
+		path := options["file_path"]
+		if path == "" {
+			return nil, errors.New("file_path is required for file audit devices")
+		}
+		if path == "stdout" {
+			return &writerDevice{w: os.Stdout}, nil
+		}
+		return newFileDevice(path)
+	case TypeStdout:
+		return &writerDevice{w: os.Stdout}, nil
+	case TypeSocket:
+		return newSocketDevice(options)
+	}
+	return nil, fmt.Errorf("unknown audit device type %q", deviceType)
+}
+
+// writerDevice writes to a stream it does not own
+type writerDevice struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (d *writerDevice) Write(line []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.w.Write(line)
+	return err
+}
+
+func (d *writerDevice) Close() error { return nil }
+
+// fileDevice appends to a log file
+type fileDevice struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileDevice(path string) (*fileDevice, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDevice{file: file}, nil
+}
+
+func (d *fileDevice) Write(line []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err := d.file.Write(line)
+	return err
+}
+
+func (d *fileDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
+
+// socketDevice streams to a TCP or unix socket, reconnecting once when a
+// write fails
+type socketDevice struct {
+	mu      sync.Mutex
+	network string
+	address string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func newSocketDevice(options map[string]string) (*socketDevice, error) {
+	d := &socketDevice{
+		network: options["socket_type"],
+		address: options["address"],
+		timeout: defaultWriteTimeout,
+	}
+	if d.address == "" {
+		return nil, errors.New("address is required for socket audit devices")
+	}
+	if d.network == "" {
+		d.network = "tcp"
+	}
+	if d.network != "tcp" && d.network != "unix" {
+		return nil, fmt.Errorf("unsupported socket_type %q", d.network)
+	}
+	if raw := options["write_timeout"]; raw != "" {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid write_timeout %q", raw)
+		}
+		d.timeout = timeout
+	}
+	return d, nil
+}
+
+func (d *socketDevice) Write(line []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn != nil {
+		if err := d.write(line); err == nil {
+			return nil
+		}
+		d.conn.Close()
+		d.conn = nil
+	}
+
+	if err := d.connect(); err != nil {
+		return err
+	}
+	return d.write(line)
+}
+
+func (d *socketDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conn == nil {
+		return nil
+	}
+	err := d.conn.Close()
+	d.conn = nil
+	return err
+}
+
+func (d *socketDevice) connect() error {
+	conn, err := net.DialTimeout(d.network, d.address, d.timeout)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+func (d *socketDevice) write(line []byte) error {
+	if err := d.conn.SetWriteDeadline(time.Now().Add(d.timeout)); err != nil {
+		return err
+	}
+	_, err := d.conn.Write(line)
+	return err
+}
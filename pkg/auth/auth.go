@@ -3,95 +3,617 @@ package auth
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"strings"
 	"sync"
 	"time"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/storage"
+)
+
+const (
+	// tokenPrefix is the storage prefix for token entries, keyed by token hash
+	tokenPrefix = "auth/token/id/"
+	// parentPrefix indexes child tokens as "<parent hash>/<child hash>"
+	parentPrefix = "auth/token/parent/"
+	// accessorPrefix maps token accessors to token hashes
+	accessorPrefix = "auth/token/accessor/"
 )
 
-// TokenStore manages authentication tokens
+// ErrAccessorNotFound is returned for unknown token accessors
+var ErrAccessorNotFound = errors.New("invalid accessor")
+
+// ErrNotRenewable is returned when renewing a token created without
+// renewal
+var ErrNotRenewable = errors.New("token is not renewable")
+
+// TokenStore manages authentication tokens. Entries are persisted to the
+// storage backend under the hash of the token and loaded into memory
+// lazily the first time a token is looked up.
 type TokenStore struct {
-	mu     sync.RWMutex
-	tokens map[string]*Token
+	mu      sync.RWMutex
+	tokens  map[string]*Token
+	storage storage.Storage
+	// onRevoke is called with the hash of every token being revoked
+	onRevoke func(hash string) error
 }
 
 // Token represents an authentication token
 type Token struct {
-	ID        string
-	CreatedAt time.Time
-	ExpiresAt time.Time
-	IsRoot    bool
+	ID        string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IsRoot    bool      `json:"is_root"`
+	Policies  []string  `json:"policies"`
+	// Accessor identifies the token in audit logs and lets operators
+	// look up or revoke it without knowing the token itself
+	Accessor string `json:"accessor"`
+	// Parent is the hash of the token that created this one; orphan
+	// tokens have no parent
+	Parent string `json:"parent,omitempty"`
+	// LeaseID is the lease that revokes the token when it expires
+	LeaseID string `json:"lease_id,omitempty"`
+	// TTL is the TTL the token was created with and the default
+	// increment of its renewals
+	TTL       time.Duration `json:"ttl"`
+	Renewable bool          `json:"renewable"`
+	// ExplicitMaxTTL caps the lifetime of the token, counted from its
+	// creation, however often it is renewed
+	ExplicitMaxTTL time.Duration `json:"explicit_max_ttl,omitempty"`
+	// Period makes the token periodic: every renewal resets its TTL to
+	// the period, with no maximum lifetime other than ExplicitMaxTTL
+	Period time.Duration `json:"period,omitempty"`
+	// NumUses is the number of requests the token may still be used
+	// for; zero means unlimited
+	NumUses int `json:"num_uses,omitempty"`
+	// Meta is set by the auth method that issued the token
+	Meta map[string]string `json:"meta,omitempty"`
+	// EntityID identifies who the token was issued to, across logins
+	// and the tokens they create; MFA enrollments are keyed by it
+	EntityID string `json:"entity_id,omitempty"`
 }
 
-// NewTokenStore creates a new token store
-func NewTokenStore() *TokenStore {
+// TokenParams describes a token to create
+type TokenParams struct {
+	ID       string
+	IsRoot   bool
+	TTL      time.Duration
+	Policies []string
+	// Parent is the token creating this one; empty creates an orphan
+	Parent         string
+	LeaseID        string
+	Renewable      bool
+	ExplicitMaxTTL time.Duration
+	Period         time.Duration
+	NumUses        int
+	Meta           map[string]string
+	EntityID       string
+}
+
+// NewTokenStore creates a new token store. A nil storage keeps tokens
+// in memory only.
+func NewTokenStore(store storage.Storage) *TokenStore {
 	return &TokenStore{
-		tokens: make(map[string]*Token),
+		tokens:  make(map[string]*Token),
+		storage: store,
 	}
 }
 
-// CreateToken creates a new token
-func (ts *TokenStore) CreateToken(tokenID string, isRoot bool, ttl time.Duration) *Token {
+// OnRevoke registers a function that is called with the hash of every
+// token as it is revoked, before its entry is deleted, so that data tied
+// to the token goes with it. An error stops the revocation, leaving the
+// token in place. The function must not call back into the token store.
+func (ts *TokenStore) OnRevoke(fn func(hash string) error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.onRevoke = fn
+}
+
+// CreateToken creates a new token. A child token is revoked together
+// with its parent.
+func (ts *TokenStore) CreateToken(params *TokenParams) (*Token, error) {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
 
-	expiresAt := time.Now().Add(ttl)
+	now := time.Now()
+	expiresAt := now.Add(params.TTL)
 	// Root tokens never expire (ttl = 0)
-	if isRoot && ttl == 0 {
-		expiresAt = time.Now().Add(100 * 365 * 24 * time.Hour) // 100 years
+	if params.IsRoot && params.TTL == 0 {
+		expiresAt = now.Add(100 * 365 * 24 * time.Hour) // 100 years
 	}
 
 	token := &Token{
-		ID:        tokenID,
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
-		IsRoot:    isRoot,
+		ID:             params.ID,
+		CreatedAt:      now,
+		ExpiresAt:      expiresAt,
+		IsRoot:         params.IsRoot,
+		Policies:       params.Policies,
+		LeaseID:        params.LeaseID,
+		TTL:            params.TTL,
+		Renewable:      params.Renewable,
+		ExplicitMaxTTL: params.ExplicitMaxTTL,
+		Period:         params.Period,
+		NumUses:        params.NumUses,
+		Meta:           params.Meta,
+		EntityID:       params.EntityID,
+	}
+	if params.Parent != "" {
+		token.Parent = HashToken(params.Parent)
+	}
+
+	hash := HashToken(token.ID)
+
+	// A token registered again, as the root token is on authentication,
+	// keeps its accessor
+	if existing, err := ts.loadLocked(hash); err == nil && existing.Accessor != "" {
+		token.Accessor = existing.Accessor
+	} else if err := ts.assignAccessorLocked(hash, token); err != nil {
+		return nil, err
+	}
+
+	if token.Parent != "" && ts.storage != nil {
+		if err := ts.storage.Put(parentPrefix+token.Parent+"/"+hash, []byte{}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ts.persistLocked(hash, token); err != nil {
+		return nil, err
 	}
 
-	ts.tokens[tokenID] = token
-	return token
+	ts.tokens[hash] = token
+	return token, nil
 }
 
 // ValidateToken checks if a token is valid
 func (ts *TokenStore) ValidateToken(tokenID string) error {
-	ts.mu.RLock()
-	defer ts.mu.RUnlock()
+	_, err := ts.LookupToken(tokenID)
+	return err
+}
 
-	token, exists := ts.tokens[tokenID]
-	if !exists {
-		return errors.New("invalid token")
+// LookupToken returns a valid, unexpired token entry
+func (ts *TokenStore) LookupToken(tokenID string) (*Token, error) {
+	token, err := ts.lookup(tokenID)
+	if err != nil {
+		return nil, err
 	}
 
 	if time.Now().After(token.ExpiresAt) {
-		return errors.New("token expired")
+		// Expired tokens are removed the first time they are seen
+		ts.RevokeToken(tokenID)
+		return nil, errors.New("token expired")
 	}
 
-	return nil
+	return token, nil
+}
+
+// UseToken returns a valid token like LookupToken and counts a use of
+// it. A use-limited token is revoked, together with its descendants, as
+// soon as its last use is taken; concurrent requests never take more
+// uses than the token had.
+func (ts *TokenStore) UseToken(tokenID string) (*Token, error) {
+	token, err := ts.LookupToken(tokenID)
+	if err != nil || token.NumUses == 0 {
+		return token, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// Reload under the lock, since another request may have taken a use
+	// or revoked the token meanwhile
+	hash := HashToken(tokenID)
+	current, err := ts.loadLocked(hash)
+	if err != nil {
+		return nil, err
+	}
+	if current.NumUses == 0 {
+		return current, nil
+	}
+
+	// Replace rather than modify the entry, which readers may be holding
+	used := *current
+	used.ID = tokenID
+	used.NumUses--
+
+	if used.NumUses <= 0 {
+		if err := ts.revokeTreeLocked(hash); err != nil {
+			return nil, err
+		}
+		used.NumUses = 0
+		return &used, nil
+	}
+
+	if err := ts.persistLocked(hash, &used); err != nil {
+		return nil, err
+	}
+	ts.tokens[hash] = &used
+	return &used, nil
+}
+
+// RenewTokenHash extends the expiry of a renewable token by its hash.
+// Periodic tokens are extended by their period; others by increment, or
+// by the TTL they were created with if increment is zero, up to maxTTL
+// after their creation. No token outlives its explicit max TTL.
+func (ts *TokenStore) RenewTokenHash(hash string, increment, maxTTL time.Duration) (*Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, err := ts.loadLocked(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Renewable {
+		return nil, ErrNotRenewable
+	}
+
+	now := time.Now()
+	if now.After(token.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	var expiresAt time.Time
+	if token.Period > 0 {
+		expiresAt = now.Add(token.Period)
+	} else {
+		if increment == 0 {
+			increment = token.TTL
+		}
+		expiresAt = now.Add(increment)
+		if limit := token.CreatedAt.Add(maxTTL); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+	if token.ExplicitMaxTTL > 0 {
+		if limit := token.CreatedAt.Add(token.ExplicitMaxTTL); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+
+	// Replace rather than modify the entry, which readers may be holding
+	renewed := *token
+	renewed.ExpiresAt = expiresAt
+	if err := ts.persistLocked(hash, &renewed); err != nil {
+		return nil, err
+	}
+	if _, ok := ts.tokens[hash]; ok {
+		ts.tokens[hash] = &renewed
+	}
+	return &renewed, nil
 }
 
-// RevokeToken revokes a token
+// RevokeToken revokes a token and every token descended from it
 func (ts *TokenStore) RevokeToken(tokenID string) error {
+	if _, err := ts.lookup(tokenID); err != nil {
+		return errors.New("token not found")
+	}
+
+	return ts.RevokeTokenHash(HashToken(tokenID))
+}
+
+// RevokeTokenHash revokes a token and its descendants by the token's
+// hash, for callers such as the lease manager that never hold the token
+// itself. Revoking a token that no longer exists is not an error.
+func (ts *TokenStore) RevokeTokenHash(hash string) error {
 	ts.mu.Lock()
 	defer ts.mu.Unlock()
+	return ts.revokeTreeLocked(hash)
+}
 
-	if _, exists := ts.tokens[tokenID]; !exists {
+// RevokeOrphan revokes a token but keeps its children, which become
+// orphans
+func (ts *TokenStore) RevokeOrphan(tokenID string) error {
+	if _, err := ts.lookup(tokenID); err != nil {
 		return errors.New("token not found")
 	}
 
-	delete(ts.tokens, tokenID)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	hash := HashToken(tokenID)
+	children, err := ts.childrenLocked(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		token, err := ts.loadLocked(child)
+		if err != nil {
+			continue
+		}
+		// Replace rather than modify the entry, which readers may be holding
+		orphan := *token
+		orphan.Parent = ""
+		if err := ts.persistLocked(child, &orphan); err != nil {
+			return err
+		}
+		if _, ok := ts.tokens[child]; ok {
+			ts.tokens[child] = &orphan
+		}
+		if err := ts.deleteKey(parentPrefix + hash + "/" + child); err != nil {
+			return err
+		}
+	}
+
+	return ts.revokeLocked(hash)
+}
+
+// revokeTreeLocked revokes the descendants of a token before the token
+// itself, so an interrupted revocation never leaves unreachable children
+func (ts *TokenStore) revokeTreeLocked(hash string) error {
+	children, err := ts.childrenLocked(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := ts.revokeTreeLocked(child); err != nil {
+			return err
+		}
+	}
+
+	return ts.revokeLocked(hash)
+}
+
+// revokeLocked deletes a single token and its entry in its parent's index
+func (ts *TokenStore) revokeLocked(hash string) error {
+	token, err := ts.loadLocked(hash)
+	if err != nil {
+		// Already gone
+		return nil
+	}
+
+	if ts.onRevoke != nil {
+		if err := ts.onRevoke(hash); err != nil {
+			return err
+		}
+	}
+
+	delete(ts.tokens, hash)
+
+	if token.Accessor != "" {
+		if err := ts.deleteKey(accessorPrefix + token.Accessor); err != nil {
+			return err
+		}
+	}
+	if token.Parent != "" {
+		if err := ts.deleteKey(parentPrefix + token.Parent + "/" + hash); err != nil {
+			return err
+		}
+	}
+	return ts.deleteKey(tokenPrefix + hash)
+}
+
+// childrenLocked returns the hashes of a token's direct children
+func (ts *TokenStore) childrenLocked(hash string) ([]string, error) {
+	var children []string
+
+	if ts.storage == nil {
+		for childHash, token := range ts.tokens {
+			if token.Parent == hash {
+				children = append(children, childHash)
+			}
+		}
+		return children, nil
+	}
+
+	keys, err := ts.storage.List(parentPrefix + hash + "/")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		children = append(children, strings.TrimPrefix(key, parentPrefix+hash+"/"))
+	}
+	return children, nil
+}
+
+// loadLocked returns a token by hash from the cache or storage without
+// caching it, since the token ID is not known
+func (ts *TokenStore) loadLocked(hash string) (*Token, error) {
+	if token, ok := ts.tokens[hash]; ok {
+		return token, nil
+	}
+
+	if ts.storage == nil {
+		return nil, errors.New("invalid token")
+	}
+
+	data, err := ts.storage.Get(tokenPrefix + hash)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (ts *TokenStore) deleteKey(key string) error {
+	if ts.storage == nil {
+		return nil
+	}
+	if err := ts.storage.Delete(key); err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
 	return nil
 }
 
 // IsRootToken checks if a token is a root token
 func (ts *TokenStore) IsRootToken(tokenID string) bool {
+	token, err := ts.lookup(tokenID)
+	if err != nil {
+		return false
+	}
+
+	return token.IsRoot
+}
+
+// ClearCache drops every token held in memory. Tokens are reloaded from
+// storage on their next lookup.
+func (ts *TokenStore) ClearCache() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.tokens = make(map[string]*Token)
+}
+
+// lookup returns a token from the cache, loading it from storage on a miss
+func (ts *TokenStore) lookup(tokenID string) (*Token, error) {
+	hash := HashToken(tokenID)
+
+	ts.mu.RLock()
+	token, exists := ts.tokens[hash]
+	ts.mu.RUnlock()
+	if exists {
+		return token, nil
+	}
+
+	if ts.storage == nil {
+		return nil, errors.New("invalid token")
+	}
+
+	data, err := ts.storage.Get(tokenPrefix + hash)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+
+	var loaded Token
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, err
+	}
+	loaded.ID = tokenID
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	// Another request may have loaded or replaced the entry meanwhile
+	if existing, ok := ts.tokens[hash]; ok {
+		return existing, nil
+	}
+
+	// Tokens created before accessors existed get one on first use
+	if loaded.Accessor == "" {
+		if err := ts.assignAccessorLocked(hash, &loaded); err != nil {
+			return nil, err
+		}
+		if err := ts.persistLocked(hash, &loaded); err != nil {
+			return nil, err
+		}
+	}
+
+	ts.tokens[hash] = &loaded
+	return &loaded, nil
+}
+
+// assignAccessorLocked gives a token a new accessor and indexes it
+func (ts *TokenStore) assignAccessorLocked(hash string, token *Token) error {
+	accessor, err := crypto.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	token.Accessor = accessor
+
+	if ts.storage == nil {
+		return nil
+	}
+	return ts.storage.Put(accessorPrefix+accessor, []byte(hash))
+}
+
+// LookupAccessor returns the valid, unexpired token with an accessor. The
+// returned entry has no ID.
+func (ts *TokenStore) LookupAccessor(accessor string) (*Token, error) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
 
-	token, exists := ts.tokens[tokenID]
-	if !exists {
-		return false
+	hash, err := ts.accessorHashLocked(accessor)
+	if err != nil {
+		return nil, err
 	}
 
-	return token.IsRoot
+	token, err := ts.loadLocked(hash)
+	if err != nil || time.Now().After(token.ExpiresAt) {
+		return nil, ErrAccessorNotFound
+	}
+
+	info := *token
+	info.ID = ""
+	return &info, nil
+}
+
+// RevokeAccessor revokes the token with an accessor and its descendants
+func (ts *TokenStore) RevokeAccessor(accessor string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	hash, err := ts.accessorHashLocked(accessor)
+	if err != nil {
+		return err
+	}
+	return ts.revokeTreeLocked(hash)
+}
+
+// ListAccessors returns the accessors of every stored token
+func (ts *TokenStore) ListAccessors() ([]string, error) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	if ts.storage == nil {
+		accessors := make([]string, 0, len(ts.tokens))
+		for _, token := range ts.tokens {
+			accessors = append(accessors, token.Accessor)
+		}
+		return accessors, nil
+	}
+
+	keys, err := ts.storage.List(accessorPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	accessors := make([]string, 0, len(keys))
+	for _, key := range keys {
+		accessors = append(accessors, strings.TrimPrefix(key, accessorPrefix))
+	}
+	return accessors, nil
+}
+
+// accessorHashLocked resolves an accessor to the hash of its token
+func (ts *TokenStore) accessorHashLocked(accessor string) (string, error) {
+	if accessor == "" {
+		return "", ErrAccessorNotFound
+	}
+
+	if ts.storage == nil {
+		for hash, token := range ts.tokens {
+			if token.Accessor == accessor {
+				return hash, nil
+			}
+		}
+		return "", ErrAccessorNotFound
+	}
+
+	data, err := ts.storage.Get(accessorPrefix + accessor)
+	if err != nil {
+		return "", ErrAccessorNotFound
+	}
+	return string(data), nil
+}
+
+// persistLocked writes a token entry to storage under its hash
+func (ts *TokenStore) persistLocked(hash string, token *Token) error {
+	if ts.storage == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return ts.storage.Put(tokenPrefix+hash, data)
 }
 
 // HashToken creates a SHA-256 hash of a token
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// tokenStores returns a memory-only token store and one backed by storage
+func tokenStores(t *testing.T) map[string]*TokenStore {
+	t.Helper()
+	backend, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLogStorage: %v", err)
+	}
+	t.Cleanup(func() { backend.Close() })
+
+	return map[string]*TokenStore{
+		"memory":  NewTokenStore(nil),
+		"storage": NewTokenStore(backend),
+	}
+}
+
+func TestUseTokenConcurrent(t *testing.T) {
+	const uses, requests = 10, 64
+
+	for name, ts := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			var revoked atomic.Int32
+			ts.OnRevoke(func(hash string) error {
+				revoked.Add(1)
+				return nil
+			})
+
+			if _, err := ts.CreateToken(&TokenParams{ID: "parent", TTL: time.Hour, NumUses: uses}); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+			if _, err := ts.CreateToken(&TokenParams{ID: "child", Parent: "parent", TTL: time.Hour}); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+
+			var succeeded atomic.Int32
+			var wg sync.WaitGroup
+			for range requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := ts.UseToken("parent"); err == nil {
+						succeeded.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := succeeded.Load(); got != uses {
+				t.Fatalf("%d requests used the token, want %d", got, uses)
+			}
+			if _, err := ts.LookupToken("parent"); err == nil {
+				t.Fatal("token still valid after its last use")
+			}
+			if _, err := ts.LookupToken("child"); err == nil {
+				t.Fatal("child token still valid after its parent used its last use")
+			}
+			if got := revoked.Load(); got != 2 {
+				t.Fatalf("revoke hook called %d times, want 2", got)
+			}
+		})
+	}
+}
+
+func TestUseTokenCountsDown(t *testing.T) {
+	for name, ts := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ts.CreateToken(&TokenParams{ID: "token", TTL: time.Hour, NumUses: 3}); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+
+			for want := 2; want >= 0; want-- {
+				te, err := ts.UseToken("token")
+				if err != nil {
+					t.Fatalf("UseToken: %v", err)
+				}
+				if te.NumUses != want {
+					t.Fatalf("NumUses = %d, want %d", te.NumUses, want)
+				}
+			}
+			if _, err := ts.UseToken("token"); err == nil {
+				t.Fatal("UseToken succeeded after the last use")
+			}
+		})
+	}
+}
+
+func TestUseTokenUnlimited(t *testing.T) {
+	ts := NewTokenStore(nil)
+	if _, err := ts.CreateToken(&TokenParams{ID: "token", TTL: time.Hour}); err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	for range 100 {
+		if _, err := ts.UseToken("token"); err != nil {
+			t.Fatalf("UseToken: %v", err)
+		}
+	}
+}
+
+func TestRevokeOrphan(t *testing.T) {
+	for name, ts := range tokenStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ts.CreateToken(&TokenParams{ID: "parent", TTL: time.Hour}); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+			if _, err := ts.CreateToken(&TokenParams{ID: "child", Parent: "parent", TTL: time.Hour}); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+			held, err := ts.LookupToken("child")
+			if err != nil {
+				t.Fatalf("LookupToken: %v", err)
+			}
+
+			if err := ts.RevokeOrphan("parent"); err != nil {
+				t.Fatalf("RevokeOrphan: %v", err)
+			}
+
+			child, err := ts.LookupToken("child")
+			if err != nil {
+				t.Fatalf("child revoked with its parent: %v", err)
+			}
+			if child.Parent != "" {
+				t.Fatal("child still has a parent")
+			}
+			// The entry a reader already held is left alone
+			if held.Parent != HashToken("parent") {
+				t.Fatal("RevokeOrphan modified an entry readers may be holding")
+			}
+
+			if name == "memory" {
+				return
+			}
+			ts.ClearCache()
+			if child, err := ts.LookupToken("child"); err != nil || child.Parent != "" {
+				t.Fatalf("reloaded child = %+v, %v, want an orphan", child, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package barrier
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/storage"
+)
+
+const (
+	// keyringPath is where the keyring, encrypted with the master key, is stored
+	keyringPath = "core/keyring"
+	// termSize is the size of the key term prefix on every ciphertext
+	termSize = 4
+)
+
+var (
+	// ErrSealed is returned when the barrier is used before it is unsealed
+	ErrSealed = errors.New("barrier is sealed")
+	// ErrInvalidMasterKey is returned when the keyring cannot be decrypted
+	ErrInvalidMasterKey = errors.New("invalid unseal key")
+)
+
+// Key is a single data encryption key in the keyring
+type Key struct {
+	Term        uint32    `json:"term"`
+	Value       []byte    `json:"value"`
+	InstallTime time.Time `json:"install_time"`
+}
+
+// Keyring holds every data encryption key ever used by the barrier.
+// New data is always written with the active term; older terms are
+// kept so existing ciphertext remains readable after a rotation.
+type Keyring struct {
+	ActiveTerm uint32          `json:"active_term"`
+	Keys       map[uint32]*Key `json:"keys"`
+}
+
+// keyringRecord is the stored form of the keyring: its ciphertext under
+// the master key next to the seal configuration the master key was split
+// with, so that a rekey replaces both with a single write. Keyrings
+// stored before the record existed are the bare ciphertext.
+type keyringRecord struct {
+	Keyring    string          `json:"keyring"`
+	SealConfig json.RawMessage `json:"seal_config,omitempty"`
+}
+
+// KeyStatus describes the active key term
+type KeyStatus struct {
+	Term        uint32    `json:"term"`
+	InstallTime time.Time `json:"install_time"`
+	Terms       int       `json:"terms"`
+}
+
+// Barrier encrypts every value written to the underlying storage with
+// the active keyring key. It implements storage.Storage so callers can
+// use it in place of a raw backend.
+type Barrier struct {
+	backend   storage.Storage
+	mu        sync.RWMutex
+	keyring   *Keyring
+	masterKey []byte
+}
+
+// New creates a sealed barrier on top of a storage backend
+func New(backend storage.Storage) *Barrier {
+	return &Barrier{backend: backend}
+}
+
+// Initialized reports whether a keyring has been written
+func (b *Barrier) Initialized() bool {
+	_, err := b.backend.Get(keyringPath)
+	return err == nil
+}
+
+// Initialize creates a keyring with a single random key, stores it
+// encrypted with the master key along with the seal configuration and
+// leaves the barrier unsealed
+func (b *Barrier) Initialize(masterKey, sealConfig []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Initialized() {
+		return errors.New("barrier is already initialized")
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	keyring := &Keyring{
+		ActiveTerm: 1,
+		Keys: map[uint32]*Key{
+			1: {Term: 1, Value: key, InstallTime: time.Now()},
+		},
+	}
+
+	if err := b.persistKeyring(keyring, masterKey, sealConfig); err != nil {
+		return err
+	}
+
+	b.keyring = keyring
+	b.masterKey = masterKey
+	return nil
+}
+
+// SealConfig returns the seal configuration stored with the keyring, or
+// nil if the keyring was stored without one. It is readable while sealed.
+func (b *Barrier) SealConfig() ([]byte, error) {
+	record, err := b.loadRecord()
+	if err != nil {
+		return nil, err
+	}
+	return record.SealConfig, nil
+}
+
+// VerifyMasterKey checks that the master key can decrypt the keyring
+// without unsealing the barrier
+func (b *Barrier) VerifyMasterKey(masterKey []byte) error {
+	_, err := b.loadKeyring(masterKey)
+	return err
+}
+
+// Unseal decrypts the keyring with the master key
+func (b *Barrier) Unseal(masterKey []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keyring, err := b.loadKeyring(masterKey)
+	if err != nil {
+		return err
+	}
+
+	b.keyring = keyring
+	b.masterKey = masterKey
+	return nil
+}
+
+// Seal discards the keyring from memory
+func (b *Barrier) Seal() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keyring = nil
+	b.masterKey = nil
+}
+
+// Sealed reports whether the keyring is unavailable
+func (b *Barrier) Sealed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.keyring == nil
+}
+
+// Rotate adds a new key to the keyring and makes it the active term
+func (b *Barrier) Rotate() (*KeyStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.keyring == nil {
+		return nil, ErrSealed
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	term := b.keyring.ActiveTerm + 1
+	keyring := &Keyring{
+		ActiveTerm: term,
+		Keys:       make(map[uint32]*Key, len(b.keyring.Keys)+1),
+	}
+	for t, k := range b.keyring.Keys {
+		keyring.Keys[t] = k
+	}
+	keyring.Keys[term] = &Key{Term: term, Value: key, InstallTime: time.Now()}
+
+	record, err := b.loadRecord()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.persistKeyring(keyring, b.masterKey, record.SealConfig); err != nil {
+		return nil, err
+	}
+
+	b.keyring = keyring
+	return b.keyStatusLocked(), nil
+}
+
+// SetMasterKey re-encrypts the keyring with a new master key and stores
+// it with the seal configuration of the new key in one write, so the
+// stored configuration always matches the key that opens the keyring
+func (b *Barrier) SetMasterKey(masterKey, sealConfig []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.keyring == nil {
+		return ErrSealed
+	}
+
+	if err := b.persistKeyring(b.keyring, masterKey, sealConfig); err != nil {
+		return err
+	}
+
+	b.masterKey = masterKey
+	return nil
+}
+
+// KeyStatus returns information about the active key term
+func (b *Barrier) KeyStatus() (*KeyStatus, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.keyring == nil {
+		return nil, ErrSealed
+	}
+
+	return b.keyStatusLocked(), nil
+}
+
+// Get retrieves and decrypts a value by key
+func (b *Barrier) Get(key string) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.keyring == nil {
+		return nil, ErrSealed
+	}
+
+	data, err := b.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.decrypt(data)
+}
+
+// Put encrypts and stores a value by key
+func (b *Barrier) Put(key string, value []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.keyring == nil {
+		return ErrSealed
+	}
+
+	data, err := b.encrypt(value)
+	if err != nil {
+		return err
+	}
+
+	return b.backend.Put(key, data)
+}
+
+// Delete removes a value by key
+func (b *Barrier) Delete(key string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.keyring == nil {
+		return ErrSealed
+	}
+
+	return b.backend.Delete(key)
+}
+
+// List returns all keys with the given prefix
+func (b *Barrier) List(prefix string) ([]string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.keyring == nil {
+		return nil, ErrSealed
+	}
+
+	return b.backend.List(prefix)
+}
+
+func (b *Barrier) keyStatusLocked() *KeyStatus {
+	active := b.keyring.Keys[b.keyring.ActiveTerm]
+	return &KeyStatus{
+		Term:        active.Term,
+		InstallTime: active.InstallTime,
+		Terms:       len(b.keyring.Keys),
+	}
+}
+
+// encrypt seals a value with the active key and prefixes the key term
+func (b *Barrier) encrypt(plaintext []byte) ([]byte, error) {
+	active := b.keyring.Keys[b.keyring.ActiveTerm]
+	ciphertext, err := crypto.Encrypt(plaintext, active.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, termSize+len(ciphertext))
+	binary.BigEndian.PutUint32(out[:termSize], active.Term)
+	copy(out[termSize:], ciphertext)
+	return out, nil
+}
+
+// decrypt looks up the key for the ciphertext's term and opens it
+func (b *Barrier) decrypt(data []byte) ([]byte, error) {
+	if len(data) < termSize {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	term := binary.BigEndian.Uint32(data[:termSize])
+	key, ok := b.keyring.Keys[term]
+	if !ok {
+		return nil, errors.New("no key for ciphertext term")
+	}
+
+	return crypto.Decrypt(string(data[termSize:]), key.Value)
+}
+
+func (b *Barrier) persistKeyring(keyring *Keyring, masterKey, sealConfig []byte) error {
+	keyringJSON, err := json.Marshal(keyring)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := crypto.Encrypt(keyringJSON, masterKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&keyringRecord{Keyring: encrypted, SealConfig: sealConfig})
+	if err != nil {
+		return err
+	}
+	return b.backend.Put(keyringPath, data)
+}
+
+func (b *Barrier) loadRecord() (*keyringRecord, error) {
+	data, err := b.backend.Get(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bare ciphertext is base64 and never starts a JSON object
+	if len(data) == 0 || data[0] != '{' {
+		return &keyringRecord{Keyring: string(data)}, nil
+	}
+
+	var record keyringRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (b *Barrier) loadKeyring(masterKey []byte) (*Keyring, error) {
+	record, err := b.loadRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	keyringJSON, err := crypto.Decrypt(record.Keyring, masterKey)
+	if err != nil {
+		return nil, ErrInvalidMasterKey
+	}
+
+	var keyring Keyring
+	if err := json.Unmarshal(keyringJSON, &keyring); err != nil {
+		return nil, err
+	}
+	if _, ok := keyring.Keys[keyring.ActiveTerm]; !ok {
+		return nil, errors.New("keyring is missing the active key")
+	}
+
+	return &keyring, nil
+}
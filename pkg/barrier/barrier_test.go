@@ -0,0 +1,195 @@
+package barrier
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/storage"
+)
+
+func openStorage(t *testing.T, dir string) *storage.LogStorage {
+	t.Helper()
+	ls, err := storage.NewLogStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLogStorage: %v", err)
+	}
+	t.Cleanup(func() { ls.Close() })
+	return ls
+}
+
+// initialized returns an unsealed barrier on a fresh log and its master key
+func initialized(t *testing.T, dir string) (*Barrier, *storage.LogStorage, []byte) {
+	t.Helper()
+	masterKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := openStorage(t, dir)
+	b := New(ls)
+	if err := b.Initialize(masterKey, []byte(`{"secret_shares":1}`)); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return b, ls, masterKey
+}
+
+func expectValue(t *testing.T, b *Barrier, key, want string) {
+	t.Helper()
+	value, err := b.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if string(value) != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, value, want)
+	}
+}
+
+// term returns the key term a stored value was encrypted with
+func term(t *testing.T, ls storage.Storage, key string) uint32 {
+	t.Helper()
+	data, err := ls.Get(key)
+	if err != nil {
+		t.Fatalf("raw Get(%q): %v", key, err)
+	}
+	return binary.BigEndian.Uint32(data[:termSize])
+}
+
+func TestBarrierKeyringPersistence(t *testing.T) {
+	dir := t.TempDir()
+	b, ls, masterKey := initialized(t, dir)
+	if err := b.Put("secret", []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if raw, _ := ls.Get("secret"); string(raw) == "value" {
+		t.Fatal("value stored in plaintext")
+	}
+	ls.Close()
+
+	b = New(openStorage(t, dir))
+	if !b.Initialized() || !b.Sealed() {
+		t.Fatal("reopened barrier is not initialized and sealed")
+	}
+	config, err := b.SealConfig()
+	if err != nil || string(config) != `{"secret_shares":1}` {
+		t.Fatalf("SealConfig = %s, %v", config, err)
+	}
+	if err := b.Initialize(masterKey, nil); err == nil {
+		t.Fatal("Initialize succeeded on an initialized barrier")
+	}
+
+	wrongKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unseal(wrongKey); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Fatalf("Unseal with the wrong key: %v, want ErrInvalidMasterKey", err)
+	}
+	if err := b.Unseal(masterKey); err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	expectValue(t, b, "secret", "value")
+}
+
+func TestBarrierRotate(t *testing.T) {
+	dir := t.TempDir()
+	b, ls, masterKey := initialized(t, dir)
+	if err := b.Put("old", []byte("1")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	status, err := b.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if status.Term != 2 || status.Terms != 2 {
+		t.Fatalf("key status after rotation = %+v, want term 2 of 2", status)
+	}
+	if err := b.Put("new", []byte("2")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Every value is prefixed with the term of the key it was written with
+	if got := term(t, ls, "old"); got != 1 {
+		t.Fatalf("value written before the rotation has term %d, want 1", got)
+	}
+	if got := term(t, ls, "new"); got != 2 {
+		t.Fatalf("value written after the rotation has term %d, want 2", got)
+	}
+	expectValue(t, b, "old", "1")
+	expectValue(t, b, "new", "2")
+
+	// The rotated keyring is what the master key opens after a restart
+	ls.Close()
+	b = New(openStorage(t, dir))
+	if err := b.Unseal(masterKey); err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if status, err := b.KeyStatus(); err != nil || status.Term != 2 || status.Terms != 2 {
+		t.Fatalf("key status after restart = %+v, %v, want term 2 of 2", status, err)
+	}
+	expectValue(t, b, "old", "1")
+	expectValue(t, b, "new", "2")
+}
+
+func TestBarrierSealUnseal(t *testing.T) {
+	b, _, masterKey := initialized(t, t.TempDir())
+	if err := b.Put("secret", []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := b.Rotate(); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	b.Seal()
+	if !b.Sealed() {
+		t.Fatal("barrier not sealed")
+	}
+	if _, err := b.Get("secret"); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Get while sealed: %v, want ErrSealed", err)
+	}
+	if err := b.Put("secret", nil); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Put while sealed: %v, want ErrSealed", err)
+	}
+	if _, err := b.List(""); !errors.Is(err, ErrSealed) {
+		t.Fatalf("List while sealed: %v, want ErrSealed", err)
+	}
+	if _, err := b.Rotate(); !errors.Is(err, ErrSealed) {
+		t.Fatalf("Rotate while sealed: %v, want ErrSealed", err)
+	}
+
+	if err := b.Unseal(masterKey); err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+	if status, err := b.KeyStatus(); err != nil || status.Term != 2 {
+		t.Fatalf("key status after unseal = %+v, %v, want term 2", status, err)
+	}
+	expectValue(t, b, "secret", "value")
+}
+
+func TestBarrierSetMasterKey(t *testing.T) {
+	b, _, oldKey := initialized(t, t.TempDir())
+	if err := b.Put("secret", []byte("value")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	newKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetMasterKey(newKey, []byte(`{"secret_shares":3}`)); err != nil {
+		t.Fatalf("SetMasterKey: %v", err)
+	}
+	if config, err := b.SealConfig(); err != nil || string(config) != `{"secret_shares":3}` {
+		t.Fatalf("SealConfig = %s, %v", config, err)
+	}
+
+	b.Seal()
+	if err := b.Unseal(oldKey); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Fatalf("Unseal with the old key: %v, want ErrInvalidMasterKey", err)
+	}
+	if err := b.Unseal(newKey); err != nil {
+		t.Fatalf("Unseal with the new key: %v", err)
+	}
+	expectValue(t, b, "secret", "value")
+}
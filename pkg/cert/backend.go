@@ -0,0 +1,265 @@
+package cert
+
+import (
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/logical"
+)
+
+// backend is the TLS client certificate auth method. Paths are served
+// relative to the mount point:
+//
+//	certs/<name>   create, read, update and delete certificate roles
+//	login          log in with the certificate presented on the TLS
+//	               connection (no token required)
+type backend struct {
+	// mu serializes changes to roles
+	mu sync.Mutex
+}
+
+// Factory creates a cert auth method for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// LoginPaths returns the paths served without a token
+func (b *backend) LoginPaths() []string {
+	return []string{"login"}
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	switch {
+	case req.Path == "login":
+		return b.handleLogin(req)
+	case req.Path == "certs" || req.Path == "certs/":
+		if req.Operation == logical.ListOperation {
+			return b.listRoles(req)
+		}
+	case strings.HasPrefix(req.Path, "certs/"):
+		name := strings.TrimPrefix(req.Path, "certs/")
+		if strings.Contains(name, "/") {
+			break
+		}
+		return b.handleRole(req, strings.ToLower(name))
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// Exists reports whether the role targeted by a role write exists, so
+// that creating a role requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	name, ok := strings.CutPrefix(req.Path, "certs/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadRole(req.Storage, strings.ToLower(name))
+	if errors.Is(err, ErrRoleNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) listRoles(req *logical.Request) (*logical.Response, error) {
+	names, err := roleNames(req)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func roleNames(req *logical.Request) ([]string, error) {
+	keys, err := req.Storage.List(rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, rolePrefix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *backend) handleRole(req *logical.Request, name string) (*logical.Response, error) {
+	if name == "" {
+		return nil, logical.InvalidRequest("missing role name")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: roleInfo(role)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		role, err := loadRole(req.Storage, name)
+		if errors.Is(err, ErrRoleNotFound) {
+			if req.GetString("certificate") == "" {
+				return nil, logical.InvalidRequest("missing certificate")
+			}
+			role, err = &Role{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := updateRole(req, role); err != nil {
+			return nil, err
+		}
+		return nil, saveRole(req.Storage, name, role)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadRole(req.Storage, name); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(rolePrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// updateRole applies the fields present in a request to a role
+func updateRole(req *logical.Request, role *Role) error {
+	if certificate := req.GetString("certificate"); certificate != "" {
+		if _, err := parseCertificates(certificate); err != nil {
+			return logical.InvalidRequest("invalid certificate: %v", err)
+		}
+		role.Certificate = certificate
+	}
+
+	for key, dest := range map[string]*[]string{
+		"allowed_common_names":         &role.AllowedCommonNames,
+		"allowed_dns_sans":             &role.AllowedDNSSANs,
+		"allowed_organizational_units": &role.AllowedOrganizationalUnits,
+		"token_policies":               &role.TokenPolicies,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		values, err := req.GetStrings(key)
+		if err != nil {
+			return err
+		}
+		*dest = values
+	}
+
+	for key, dest := range map[string]*time.Duration{
+		"token_ttl":     &role.TokenTTL,
+		"token_max_ttl": &role.TokenMaxTTL,
+		"token_period":  &role.TokenPeriod,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		ttl, err := req.GetTTL(key)
+		if err != nil {
+			return err
+		}
+		*dest = ttl
+	}
+
+	if n, ok, err := req.GetInt("token_num_uses"); err != nil {
+		return err
+	} else if n < 0 {
+		return logical.InvalidRequest("token_num_uses cannot be negative")
+	} else if ok {
+		role.TokenNumUses = n
+	}
+
+	if role.TokenMaxTTL > 0 && role.TokenTTL > role.TokenMaxTTL {
+		return logical.InvalidRequest("token_ttl cannot exceed token_max_ttl")
+	}
+	return nil
+}
+
+// handleLogin issues a token for the client certificate on the request's
+// TLS connection. The certificate must chain to a role's trusted
+// certificates and meet its name constraints; with "name", only that role
+// is tried, and otherwise the first matching role by name is used.
+func (b *backend) handleLogin(req *logical.Request) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	chain := req.PeerCertificates
+	if len(chain) == 0 {
+		return nil, logical.InvalidCredentials("no client certificate was presented")
+	}
+	leaf := chain[0]
+
+	names := []string{strings.ToLower(req.GetString("name"))}
+	if names[0] == "" {
+		var err error
+		if names, err = roleNames(req); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		role, err := loadRole(req.Storage, name)
+		if errors.Is(err, ErrRoleNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if role.verify(chain, now) != nil || !role.allows(leaf) {
+			continue
+		}
+
+		return &logical.Response{Auth: &logical.Auth{
+			Policies:  role.TokenPolicies,
+			TTL:       role.TokenTTL,
+			MaxTTL:    role.TokenMaxTTL,
+			Period:    role.TokenPeriod,
+			NumUses:   role.TokenNumUses,
+			Renewable: true,
+			Metadata: map[string]string{
+				"cert_name":      name,
+				"common_name":    leaf.Subject.CommonName,
+				"serial_number":  leaf.SerialNumber.String(),
+				"subject_key_id": hex.EncodeToString(leaf.SubjectKeyId),
+			},
+			Alias: leaf.Subject.CommonName,
+		}}, nil
+	}
+
+	return nil, logical.InvalidCredentials("invalid certificate or no matching role")
+}
+
+func roleInfo(role *Role) map[string]interface{} {
+	return map[string]interface{}{
+		"certificate":                  role.Certificate,
+		"allowed_common_names":         nonNil(role.AllowedCommonNames),
+		"allowed_dns_sans":             nonNil(role.AllowedDNSSANs),
+		"allowed_organizational_units": nonNil(role.AllowedOrganizationalUnits),
+		"token_policies":               nonNil(role.TokenPolicies),
+		"token_ttl":                    int64(role.TokenTTL / time.Second),
+		"token_max_ttl":                int64(role.TokenMaxTTL / time.Second),
+		"token_period":                 int64(role.TokenPeriod / time.Second),
+		"token_num_uses":               role.TokenNumUses,
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,160 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// rolePrefix is where roles are stored in the method's storage view
+const rolePrefix = "cert/"
+
+// ErrRoleNotFound is returned when a certificate role does not exist
+var ErrRoleNotFound = errors.New("certificate role not found")
+
+// Role trusts client certificates issued by its CAs, optionally limited
+// to certain names. Name constraints may use "*" wildcards.
+type Role struct {
+	// Certificate holds the PEM CA certificates, or pinned client
+	// certificates, that the role trusts
+	Certificate                string   `json:"certificate"`
+	AllowedCommonNames         []string `json:"allowed_common_names"`
+	AllowedDNSSANs             []string `json:"allowed_dns_sans"`
+	AllowedOrganizationalUnits []string `json:"allowed_organizational_units"`
+
+	TokenPolicies []string      `json:"token_policies"`
+	TokenTTL      time.Duration `json:"token_ttl"`
+	TokenMaxTTL   time.Duration `json:"token_max_ttl"`
+	TokenPeriod   time.Duration `json:"token_period"`
+	TokenNumUses  int           `json:"token_num_uses"`
+}
+
+// parseCertificates parses every certificate in a PEM bundle
+func parseCertificates(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// verify checks that the client's chain leads to one of the role's
+// certificates and that the leaf is valid for client authentication
+func (r *Role) verify(chain []*x509.Certificate, now time.Time) error {
+	trusted, err := parseCertificates(r.Certificate)
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range trusted {
+		opts.Roots.AddCert(cert)
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err = chain[0].Verify(opts)
+	return err
+}
+
+// allows reports whether the leaf meets every name constraint of the role
+func (r *Role) allows(leaf *x509.Certificate) bool {
+	if len(r.AllowedCommonNames) > 0 && !matchesAny(r.AllowedCommonNames, []string{leaf.Subject.CommonName}, false) {
+		return false
+	}
+	if len(r.AllowedDNSSANs) > 0 && !matchesAny(r.AllowedDNSSANs, leaf.DNSNames, true) {
+		return false
+	}
+	if len(r.AllowedOrganizationalUnits) > 0 && !matchesAny(r.AllowedOrganizationalUnits, leaf.Subject.OrganizationalUnit, false) {
+		return false
+	}
+	return true
+}
+
+// matchesAny reports whether any value matches any pattern
+func matchesAny(patterns, values []string, ignoreCase bool) bool {
+	return slices.ContainsFunc(values, func(value string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			if ignoreCase {
+				return globMatch(strings.ToLower(pattern), strings.ToLower(value))
+			}
+			return globMatch(pattern, value)
+		})
+	})
+}
+
+// globMatch matches value against a pattern in which "*" stands for any
+// run of characters, including an empty one
+func globMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+
+	first, last := parts[0], parts[len(parts)-1]
+	if !strings.HasPrefix(value, first) {
+		return false
+	}
+	value = value[len(first):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, last)
+}
+
+func loadRole(store storage.Storage, name string) (*Role, error) {
+	data, err := store.Get(rolePrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var role Role
+	if err := json.Unmarshal(data, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func saveRole(store storage.Storage, name string, role *Role) error {
+	data, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	return store.Put(rolePrefix+name, data)
+}
@@ -0,0 +1,97 @@
+package cubbyhole
+
+import (
+	"encoding/json"
+	"errors"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// ErrSecretNotFound is returned when no secret exists at a path
+var ErrSecretNotFound = errors.New("secret not found")
+
+// backend is the cubbyhole secrets engine: unversioned key/value storage
+// private to each token. The vault serves every request from the
+// storage of the calling token's cubbyhole, so the engine itself knows
+// nothing about tokens. Paths are served relative to the mount point:
+//
+//	<path>    read, write, delete and list secrets
+type backend struct{}
+
+// Factory creates a cubbyhole engine for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// HandleRequest dispatches a request to the handler for its operation
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Operation == logical.ListOperation {
+		return listSecrets(req.Storage, req.Path)
+	}
+
+	if req.Path == "" {
+		return nil, logical.InvalidRequest("missing secret path")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		return readSecret(req.Storage, req.Path)
+	case logical.CreateOperation, logical.UpdateOperation:
+		return nil, writeSecret(req)
+	case logical.DeleteOperation:
+		err := req.Storage.Delete(req.Path)
+		if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func readSecret(store storage.Storage, path string) (*logical.Response, error) {
+	data, err := store.Get(path)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: map[string]interface{}{"data": fields}}, nil
+}
+
+// writeSecret replaces the secret at a path with the "data" fields of
+// the request
+func writeSecret(req *logical.Request) error {
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := req.Decode(&body); err != nil {
+		return err
+	}
+	if len(body.Data) == 0 {
+		return logical.InvalidRequest("missing data")
+	}
+
+	data, err := json.Marshal(body.Data)
+	if err != nil {
+		return err
+	}
+	return req.Storage.Put(req.Path, data)
+}
+
+func listSecrets(store storage.Storage, prefix string) (*logical.Response, error) {
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	return &logical.Response{Data: map[string]interface{}{"keys": keys}}, nil
+}
@@ -0,0 +1,611 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// backend is the JWT auth method, for workloads that hold tokens signed
+// by a trusted issuer. Paths are served relative to the mount point:
+//
+//	config        where the signing keys come from
+//	role/<name>   create, read, update and delete roles
+//	groups/<name> policies for a group named by a groups claim
+//	login         log in with a role and a jwt (no token required)
+type backend struct {
+	// mu serializes changes to the config and roles
+	mu sync.Mutex
+
+	// keysMu guards keys, the cached signing keys of the current config,
+	// and fetch, the refresh of keys in progress. It is never held while
+	// keys are fetched.
+	keysMu sync.Mutex
+	keys   *keySet
+	fetch  *keyFetch
+}
+
+// keyFetch is a refresh of the signing keys, shared by every login that
+// finds the same keys stale
+type keyFetch struct {
+	stale *keySet
+	done  chan struct{}
+	keys  *keySet
+	err   error
+}
+
+// Factory creates a JWT auth method for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// LoginPaths returns the paths served without a token
+func (b *backend) LoginPaths() []string {
+	return []string{"login"}
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	switch {
+	case req.Path == "login":
+		return b.handleLogin(req)
+	case req.Path == "config":
+		return b.handleConfig(req)
+	case req.Path == "role" || req.Path == "role/":
+		if req.Operation == logical.ListOperation {
+			return b.listRoles(req)
+		}
+	case strings.HasPrefix(req.Path, "role/"):
+		name := strings.TrimPrefix(req.Path, "role/")
+		if strings.Contains(name, "/") {
+			break
+		}
+		return b.handleRole(req, strings.ToLower(name))
+	case req.Path == "groups" || req.Path == "groups/":
+		if req.Operation == logical.ListOperation {
+			return b.listGroups(req)
+		}
+	case strings.HasPrefix(req.Path, "groups/"):
+		name := strings.TrimPrefix(req.Path, "groups/")
+		if strings.Contains(name, "/") {
+			break
+		}
+		return b.handleGroup(req, strings.ToLower(name))
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// Exists reports whether the role or group targeted by a write exists,
+// so that creating one requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	if name, ok := strings.CutPrefix(req.Path, "groups/"); ok {
+		if name == "" || strings.Contains(name, "/") {
+			return true, nil
+		}
+		_, err := loadGroup(req.Storage, strings.ToLower(name))
+		if errors.Is(err, ErrGroupNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	name, ok := strings.CutPrefix(req.Path, "role/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadRole(req.Storage, strings.ToLower(name))
+	if errors.Is(err, ErrRoleNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) handleConfig(req *logical.Request) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		config, err := loadConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = &Config{}
+		}
+		return &logical.Response{Data: map[string]interface{}{
+			"jwks_url":               config.JWKSURL,
+			"jwks_ca_pem":            config.JWKSCAPEM,
+			"jwt_validation_pubkeys": nonNil(config.JWTValidationPubKeys),
+			"oidc_discovery_url":     config.OIDCDiscoveryURL,
+			"oidc_discovery_ca_pem":  config.OIDCDiscoveryCAPEM,
+			"bound_issuer":           config.BoundIssuer,
+			"default_role":           config.DefaultRole,
+		}}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		pubKeys, err := req.GetStrings("jwt_validation_pubkeys")
+		if err != nil {
+			return nil, err
+		}
+		config := &Config{
+			JWKSURL:              req.GetString("jwks_url"),
+			JWKSCAPEM:            req.GetString("jwks_ca_pem"),
+			JWTValidationPubKeys: pubKeys,
+			OIDCDiscoveryURL:     req.GetString("oidc_discovery_url"),
+			OIDCDiscoveryCAPEM:   req.GetString("oidc_discovery_ca_pem"),
+			BoundIssuer:          req.GetString("bound_issuer"),
+			DefaultRole:          strings.ToLower(req.GetString("default_role")),
+		}
+
+		sources := 0
+		for _, set := range []bool{config.JWKSURL != "", len(pubKeys) > 0, config.OIDCDiscoveryURL != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, logical.InvalidRequest("exactly one of jwks_url, jwt_validation_pubkeys and oidc_discovery_url is required")
+		}
+
+		// Loading the keys now reports unreachable URLs and bad keys
+		// when the method is configured rather than at the first login
+		keys, err := loadKeySet(config)
+		if err != nil {
+			return nil, logical.InvalidRequest("error loading keys: %v", err)
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if err := saveConfig(req.Storage, config); err != nil {
+			return nil, err
+		}
+
+		b.keysMu.Lock()
+		b.keys = keys
+		b.fetch = nil
+		b.keysMu.Unlock()
+		return nil, nil
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (b *backend) listRoles(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(rolePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, rolePrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleRole(req *logical.Request, name string) (*logical.Response, error) {
+	if name == "" {
+		return nil, logical.InvalidRequest("missing role name")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		role, err := loadRole(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: roleInfo(role)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		role, err := loadRole(req.Storage, name)
+		if errors.Is(err, ErrRoleNotFound) {
+			role, err = &Role{ClockSkewLeeway: defaultClockSkewLeeway}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := updateRole(req, role); err != nil {
+			return nil, err
+		}
+		return nil, saveRole(req.Storage, name, role)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadRole(req.Storage, name); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(rolePrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (b *backend) listGroups(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(groupPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, groupPrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleGroup(req *logical.Request, name string) (*logical.Response, error) {
+	if name == "" {
+		return nil, logical.InvalidRequest("missing group name")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		group, err := loadGroup(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: map[string]interface{}{"policies": nonNil(group.Policies)}}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		policies, err := req.GetStrings("policies")
+		if err != nil {
+			return nil, err
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		return nil, saveGroup(req.Storage, name, &Group{Policies: policies})
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadGroup(req.Storage, name); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(groupPrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// updateRole applies the fields present in a request to a role
+func updateRole(req *logical.Request, role *Role) error {
+	for key, dest := range map[string]*[]string{
+		"bound_audiences": &role.BoundAudiences,
+		"token_policies":  &role.TokenPolicies,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		values, err := req.GetStrings(key)
+		if err != nil {
+			return err
+		}
+		*dest = values
+	}
+
+	for key, dest := range map[string]*string{
+		"bound_subject": &role.BoundSubject,
+		"groups_claim":  &role.GroupsClaim,
+	} {
+		if _, ok := req.Data[key]; ok {
+			*dest = req.GetString(key)
+		}
+	}
+
+	for key, dest := range map[string]*time.Duration{
+		"clock_skew_leeway": &role.ClockSkewLeeway,
+		"token_ttl":         &role.TokenTTL,
+		"token_max_ttl":     &role.TokenMaxTTL,
+		"token_period":      &role.TokenPeriod,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		ttl, err := req.GetTTL(key)
+		if err != nil {
+			return err
+		}
+		*dest = ttl
+	}
+
+	if n, ok, err := req.GetInt("token_num_uses"); err != nil {
+		return err
+	} else if n < 0 {
+		return logical.InvalidRequest("token_num_uses cannot be negative")
+	} else if ok {
+		role.TokenNumUses = n
+	}
+
+	if _, ok := req.Data["bound_claims"]; ok {
+		claims, err := getObject(req, "bound_claims")
+		if err != nil {
+			return err
+		}
+		for name, value := range claims {
+			if _, ok := value.([]interface{}); !ok {
+				value = []interface{}{value}
+			}
+			for _, item := range value.([]interface{}) {
+				if _, ok := scalarString(item); !ok {
+					return logical.InvalidRequest("bound claim %q must be a value or a list of values", name)
+				}
+			}
+		}
+		role.BoundClaims = claims
+	}
+
+	if _, ok := req.Data["claim_mappings"]; ok {
+		mappings, err := getObject(req, "claim_mappings")
+		if err != nil {
+			return err
+		}
+		role.ClaimMappings = make(map[string]string, len(mappings))
+		for claim, value := range mappings {
+			key, ok := value.(string)
+			if !ok || key == "" {
+				return logical.InvalidRequest("claim mapping for %q must name a metadata key", claim)
+			}
+			if key == "role" {
+				return logical.InvalidRequest("metadata key %q is reserved", key)
+			}
+			role.ClaimMappings[claim] = key
+		}
+	}
+
+	if len(role.BoundAudiences) == 0 && role.BoundSubject == "" && len(role.BoundClaims) == 0 {
+		return logical.InvalidRequest("one of bound_audiences, bound_subject and bound_claims is required")
+	}
+	if role.TokenMaxTTL > 0 && role.TokenTTL > role.TokenMaxTTL {
+		return logical.InvalidRequest("token_ttl cannot exceed token_max_ttl")
+	}
+	return nil
+}
+
+// getObject reads a JSON object field, given as an object or a string
+// containing one
+func getObject(req *logical.Request, key string) (map[string]interface{}, error) {
+	switch raw := req.Data[key].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return raw, nil
+	case string:
+		if raw == "" {
+			return nil, nil
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &object); err != nil {
+			return nil, logical.InvalidRequest("%s must be a JSON object", key)
+		}
+		return object, nil
+	}
+	return nil, logical.InvalidRequest("%s must be a JSON object", key)
+}
+
+// handleLogin exchanges a signed JWT for a token. The JWT must be signed
+// by a configured key, be within its validity period and satisfy the
+// role's bindings.
+func (b *backend) handleLogin(req *logical.Request) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	raw := req.GetString("jwt")
+	if raw == "" {
+		return nil, logical.InvalidRequest("missing jwt")
+	}
+
+	config, err := loadConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, logical.InvalidRequest("the jwt auth method is not configured")
+	}
+
+	name := strings.ToLower(req.GetString("role"))
+	if name == "" {
+		name = config.DefaultRole
+	}
+	if name == "" {
+		return nil, logical.InvalidRequest("missing role")
+	}
+
+	role, err := loadRole(req.Storage, name)
+	if errors.Is(err, ErrRoleNotFound) {
+		return nil, logical.InvalidCredentials("role %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := Parse(raw)
+	if err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+
+	keys, err := b.verify(config, token)
+	if err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+
+	if err := token.ValidateTimes(time.Now(), role.ClockSkewLeeway); err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+
+	iss, _ := token.Claims["iss"].(string)
+	for _, issuer := range []string{keys.issuer, config.BoundIssuer} {
+		if issuer != "" && iss != issuer {
+			return nil, logical.InvalidCredentials("invalid jwt: iss claim does not match the bound issuer")
+		}
+	}
+
+	if err := role.validateBindings(token); err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+
+	groups, err := role.groups(token)
+	if err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+	policies, err := groupPolicies(req.Storage, role.TokenPolicies, groups)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := role.metadata(token, name)
+	if err != nil {
+		return nil, logical.InvalidCredentials("invalid jwt: %v", err)
+	}
+
+	// The subject is unique within the issuer
+	sub, _ := token.Claims["sub"].(string)
+
+	return &logical.Response{Auth: &logical.Auth{
+		Policies:  policies,
+		TTL:       role.TokenTTL,
+		MaxTTL:    role.TokenMaxTTL,
+		Period:    role.TokenPeriod,
+		NumUses:   role.TokenNumUses,
+		Renewable: true,
+		Metadata:  metadata,
+		Alias:     sub,
+	}}, nil
+}
+
+// verify checks a token's signature with the cached keys. When that
+// fails and the keys are remote, they are fetched again in case the
+// issuer rotated them, at most once per jwksMinRefresh.
+func (b *backend) verify(config *Config, t *Token) (*keySet, error) {
+	b.keysMu.Lock()
+	keys := b.keys
+	b.keysMu.Unlock()
+
+	if keys == nil || time.Since(keys.fetchedAt) > jwksCacheTTL {
+		var err error
+		if keys, err = b.refreshKeys(config, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	err := t.Verify(keys.candidates(t.Header.KeyID))
+	if errors.Is(err, errInvalidSignature) && len(config.JWTValidationPubKeys) == 0 &&
+		time.Since(keys.fetchedAt) > jwksMinRefresh {
+		fresh, fetchErr := b.refreshKeys(config, keys)
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		keys = fresh
+		err = t.Verify(keys.candidates(t.Header.KeyID))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// refreshKeys replaces stale cached keys with freshly fetched ones. The
+// fetch runs without keysMu, so logins with valid cached keys are never
+// held up by a slow key endpoint, and concurrent refreshes of the same
+// keys wait for a single fetch. Keys that were replaced meanwhile are
+// returned without fetching.
+func (b *backend) refreshKeys(config *Config, stale *keySet) (*keySet, error) {
+	b.keysMu.Lock()
+	if b.keys != stale {
+		keys := b.keys
+		b.keysMu.Unlock()
+		return keys, nil
+	}
+	f := b.fetch
+	if f != nil && f.stale == stale {
+		b.keysMu.Unlock()
+		<-f.done
+		return f.keys, f.err
+	}
+	f = &keyFetch{stale: stale, done: make(chan struct{})}
+	b.fetch = f
+	b.keysMu.Unlock()
+
+	f.keys, f.err = loadKeySet(config)
+
+	b.keysMu.Lock()
+	if f.err == nil && b.keys == stale {
+		b.keys = f.keys
+	}
+	if b.fetch == f {
+		b.fetch = nil
+	}
+	b.keysMu.Unlock()
+	close(f.done)
+
+	return f.keys, f.err
+}
+
+// groupPolicies returns a role's token policies plus the policies mapped
+// to the groups. Group names come from the token, so they only select
+// mappings made by an operator and never name policies themselves.
+func groupPolicies(store storage.Storage, tokenPolicies, groups []string) ([]string, error) {
+	policies := slices.Clone(tokenPolicies)
+	for _, name := range groups {
+		group, err := loadGroup(store, name)
+		if errors.Is(err, ErrGroupNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range group.Policies {
+			if !slices.Contains(policies, p) {
+				policies = append(policies, p)
+			}
+		}
+	}
+	return policies, nil
+}
+
+func roleInfo(role *Role) map[string]interface{} {
+	boundClaims := role.BoundClaims
+	if boundClaims == nil {
+		boundClaims = map[string]interface{}{}
+	}
+	claimMappings := role.ClaimMappings
+	if claimMappings == nil {
+		claimMappings = map[string]string{}
+	}
+	return map[string]interface{}{
+		"bound_audiences":   nonNil(role.BoundAudiences),
+		"bound_subject":     role.BoundSubject,
+		"bound_claims":      boundClaims,
+		"claim_mappings":    claimMappings,
+		"groups_claim":      role.GroupsClaim,
+		"clock_skew_leeway": int64(role.ClockSkewLeeway / time.Second),
+		"token_policies":    nonNil(role.TokenPolicies),
+		"token_ttl":         int64(role.TokenTTL / time.Second),
+		"token_max_ttl":     int64(role.TokenMaxTTL / time.Second),
+		"token_period":      int64(role.TokenPeriod / time.Second),
+		"token_num_uses":    role.TokenNumUses,
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
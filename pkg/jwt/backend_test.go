@@ -0,0 +1,360 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// signingKey is an ES256 key published in a test JWKS
+type signingKey struct {
+	id  string
+	key *ecdsa.PrivateKey
+}
+
+func newSigningKey(t *testing.T, id string) *signingKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingKey{id: id, key: key}
+}
+
+func (k *signingKey) jwk() map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": k.id,
+		"use": "sig",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(k.key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(k.key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+// sign returns a compact JWS of the claims
+func (k *signingKey) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	input := encode(map[string]string{"alg": "ES256", "kid": k.id}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwksServer publishes a JWKS whose keys can be rotated. A server with a
+// gate blocks every fetch until the gate is closed.
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []*signingKey
+	gate    chan struct{}
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...*signingKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		gate := s.gate
+		jwks := make([]map[string]string, 0, len(s.keys))
+		for _, key := range s.keys {
+			jwks = append(jwks, key.jwk())
+		}
+		s.mu.Unlock()
+
+		if gate != nil {
+			<-gate
+		}
+		s.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": jwks})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...*signingKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) setGate(gate chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gate = gate
+}
+
+// testMethod is a JWT auth method with its storage
+type testMethod struct {
+	b     *backend
+	store storage.Storage
+}
+
+func newTestMethod(t *testing.T, jwksURL string) *testMethod {
+	t.Helper()
+	store, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	m := &testMethod{b: &backend{}, store: store}
+	m.write(t, "config", map[string]interface{}{"jwks_url": jwksURL})
+	return m
+}
+
+func (m *testMethod) request(op logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+	return m.b.HandleRequest(&logical.Request{Operation: op, Path: path, Data: data, Storage: m.store})
+}
+
+func (m *testMethod) write(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	if _, err := m.request(logical.UpdateOperation, path, data); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func (m *testMethod) login(role, jwt string) (*logical.Auth, error) {
+	resp, err := m.request(logical.UpdateOperation, "login", map[string]interface{}{"role": role, "jwt": jwt})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// claims returns valid claims for the "ci" role, with overrides applied;
+// a nil override removes the claim
+func claims(overrides map[string]interface{}) map[string]interface{} {
+	now := time.Now()
+	c := map[string]interface{}{
+		"iss":     "https://issuer.example.com",
+		"sub":     "project:42",
+		"aud":     "vault",
+		"exp":     now.Add(time.Hour).Unix(),
+		"iat":     now.Unix(),
+		"nbf":     now.Unix(),
+		"ref":     "main",
+		"project": map[string]interface{}{"id": 42},
+	}
+	for name, value := range overrides {
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+	}
+	return c
+}
+
+func ciRole() map[string]interface{} {
+	return map[string]interface{}{
+		"bound_audiences":   []interface{}{"vault", "other"},
+		"bound_subject":     "project:42",
+		"bound_claims":      map[string]interface{}{"ref": []interface{}{"main", "release"}, "/project/id": "42"},
+		"clock_skew_leeway": "30s",
+		"token_policies":    []interface{}{"ci"},
+	}
+}
+
+func TestLoginBindings(t *testing.T) {
+	key := newSigningKey(t, "k1")
+	server := newJWKSServer(t, key)
+	m := newTestMethod(t, server.URL)
+	m.write(t, "role/ci", ciRole())
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		overrides map[string]interface{}
+		ok        bool
+	}{
+		{"valid", nil, true},
+		{"aud in list", map[string]interface{}{"aud": []interface{}{"someone", "other"}}, true},
+		{"wrong aud", map[string]interface{}{"aud": "someone"}, false},
+		{"missing aud", map[string]interface{}{"aud": nil}, false},
+		{"wrong sub", map[string]interface{}{"sub": "project:43"}, false},
+		{"other bound value", map[string]interface{}{"ref": "release"}, true},
+		{"wrong bound value", map[string]interface{}{"ref": "feature"}, false},
+		{"missing bound claim", map[string]interface{}{"ref": nil}, false},
+		{"wrong nested claim", map[string]interface{}{"project": map[string]interface{}{"id": 43}}, false},
+		{"missing exp", map[string]interface{}{"exp": nil}, false},
+		{"expired within leeway", map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()}, true},
+		{"expired beyond leeway", map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}, false},
+		{"nbf within leeway", map[string]interface{}{"nbf": now.Add(10 * time.Second).Unix()}, true},
+		{"nbf beyond leeway", map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}, false},
+		{"iat within leeway", map[string]interface{}{"iat": now.Add(10 * time.Second).Unix()}, true},
+		{"iat beyond leeway", map[string]interface{}{"iat": now.Add(time.Minute).Unix()}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := m.login("ci", key.sign(t, claims(tt.overrides)))
+			if !tt.ok {
+				if !errors.Is(err, logical.ErrInvalidCredentials) {
+					t.Fatalf("login error = %v, want invalid credentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("login: %v", err)
+			}
+			if auth.Alias != "project:42" || !slices.Equal(auth.Policies, []string{"ci"}) {
+				t.Fatalf("login auth = %+v", auth)
+			}
+		})
+	}
+}
+
+func TestLoginUnknownKey(t *testing.T) {
+	key := newSigningKey(t, "k1")
+	server := newJWKSServer(t, key)
+	m := newTestMethod(t, server.URL)
+	m.write(t, "role/ci", ciRole())
+
+	other := newSigningKey(t, "k1")
+	if _, err := m.login("ci", other.sign(t, claims(nil))); !errors.Is(err, logical.ErrInvalidCredentials) {
+		t.Fatalf("login error = %v, want invalid credentials", err)
+	}
+}
+
+func TestLoginKeyRotation(t *testing.T) {
+	oldKey, newKey := newSigningKey(t, "old"), newSigningKey(t, "new")
+	server := newJWKSServer(t, oldKey)
+	m := newTestMethod(t, server.URL)
+	m.write(t, "role/ci", ciRole())
+
+	if _, err := m.login("ci", oldKey.sign(t, claims(nil))); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	server.setKeys(newKey)
+	fetches := server.fetches.Load()
+
+	// Keys fetched within jwksMinRefresh are not fetched again
+	if _, err := m.login("ci", newKey.sign(t, claims(nil))); err == nil {
+		t.Fatal("login with a new key succeeded before the keys could be refetched")
+	}
+	if got := server.fetches.Load(); got != fetches {
+		t.Fatalf("keys fetched %d times, want %d", got, fetches)
+	}
+
+	m.b.keys.fetchedAt = time.Now().Add(-2 * jwksMinRefresh)
+	if _, err := m.login("ci", newKey.sign(t, claims(nil))); err != nil {
+		t.Fatalf("login after rotation: %v", err)
+	}
+	if got := server.fetches.Load(); got != fetches+1 {
+		t.Fatalf("keys fetched %d times, want %d", got, fetches+1)
+	}
+
+	if _, err := m.login("ci", oldKey.sign(t, claims(nil))); err == nil {
+		t.Fatal("login with a rotated out key succeeded")
+	}
+}
+
+func TestLoginDuringSlowRefresh(t *testing.T) {
+	known := newSigningKey(t, "known")
+	server := newJWKSServer(t, known)
+	m := newTestMethod(t, server.URL)
+	m.write(t, "role/ci", ciRole())
+
+	gate := make(chan struct{})
+	server.setGate(gate)
+	m.b.keys.fetchedAt = time.Now().Add(-2 * jwksMinRefresh)
+
+	// Logins with unknown keys wait for one shared refresh
+	unknown := newSigningKey(t, "unknown")
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.login("ci", unknown.sign(t, claims(nil)))
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		m.b.keysMu.Lock()
+		fetching := m.b.fetch != nil
+		m.b.keysMu.Unlock()
+		if fetching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no refresh started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A login with a cached key is not held up by the refresh
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.login("ci", known.sign(t, claims(nil)))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("login: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login with a cached key blocked on the key refresh")
+	}
+
+	fetches := server.fetches.Load()
+	close(gate)
+	wg.Wait()
+	if got := server.fetches.Load() - fetches; got != 1 {
+		t.Fatalf("concurrent refreshes fetched the keys %d times, want 1", got)
+	}
+}
+
+func TestLoginGroups(t *testing.T) {
+	key := newSigningKey(t, "k1")
+	server := newJWKSServer(t, key)
+	m := newTestMethod(t, server.URL)
+
+	role := ciRole()
+	role["groups_claim"] = "groups"
+	m.write(t, "role/ci", role)
+	m.write(t, "groups/Admins", map[string]interface{}{"policies": []interface{}{"admin", "ci"}})
+
+	jwt := key.sign(t, claims(map[string]interface{}{"groups": []interface{}{"ADMINS", "root", "unmapped"}}))
+	auth, err := m.login("ci", jwt)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !slices.Equal(auth.Policies, []string{"ci", "admin"}) {
+		t.Fatalf("policies = %v, want [ci admin]", auth.Policies)
+	}
+
+	if _, err := m.login("ci", key.sign(t, claims(nil))); !errors.Is(err, logical.ErrInvalidCredentials) {
+		t.Fatalf("login without the groups claim: error = %v, want invalid credentials", err)
+	}
+}
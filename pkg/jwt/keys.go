@@ -0,0 +1,297 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDocumentSize bounds JWKS and discovery documents
+	maxDocumentSize = 1 << 20
+	// fetchTimeout bounds each request to a JWKS or discovery URL
+	fetchTimeout = 10 * time.Second
+	// jwksCacheTTL is how long fetched keys are used before refetching
+	jwksCacheTTL = time.Hour
+	// jwksMinRefresh is the shortest interval between fetches triggered by
+	// tokens signed with unknown key IDs
+	jwksMinRefresh = time.Minute
+)
+
+// keySet holds the keys tokens are verified with
+type keySet struct {
+	// keys maps key IDs to keys; static PEM keys have no ID
+	keys      map[string]crypto.PublicKey
+	anonymous []crypto.PublicKey
+	// issuer is the issuer named by an OIDC discovery document
+	issuer    string
+	fetchedAt time.Time
+}
+
+// candidates returns the keys that may have signed a token with the given
+// key ID. Tokens without a key ID are tried against every key.
+func (s *keySet) candidates(kid string) []crypto.PublicKey {
+	if kid != "" {
+		if key, ok := s.keys[kid]; ok {
+			return []crypto.PublicKey{key}
+		}
+		return s.anonymous
+	}
+
+	keys := append([]crypto.PublicKey{}, s.anonymous...)
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// loadKeySet builds the key set for a config, fetching remote keys
+func loadKeySet(config *Config) (*keySet, error) {
+	switch {
+	case len(config.JWTValidationPubKeys) > 0:
+		set := &keySet{fetchedAt: time.Now()}
+		for _, data := range config.JWTValidationPubKeys {
+			key, err := parsePEMKey(data)
+			if err != nil {
+				return nil, err
+			}
+			set.anonymous = append(set.anonymous, key)
+		}
+		return set, nil
+
+	case config.JWKSURL != "":
+		client, err := httpClient(config.JWKSCAPEM)
+		if err != nil {
+			return nil, err
+		}
+		return fetchJWKS(client, config.JWKSURL)
+
+	case config.OIDCDiscoveryURL != "":
+		client, err := httpClient(config.OIDCDiscoveryCAPEM)
+		if err != nil {
+			return nil, err
+		}
+		discovery, err := fetchDiscovery(client, config.OIDCDiscoveryURL)
+		if err != nil {
+			return nil, err
+		}
+		set, err := fetchJWKS(client, discovery.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		set.issuer = discovery.Issuer
+		return set, nil
+	}
+	return nil, errors.New("no key source configured")
+}
+
+// httpClient returns a client that trusts caPEM, or the system roots
+// when it is empty
+func httpClient(caPEM string) (*http.Client, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	if caPEM == "" {
+		return client, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+		return nil, errors.New("could not parse CA PEM")
+	}
+	client.Transport = &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}
+	return client, nil
+}
+
+func fetchJSON(client *http.Client, url string, out interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: unexpected status %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(out); err != nil {
+		return fmt.Errorf("fetching %s: %v", url, err)
+	}
+	return nil
+}
+
+// discoveryDocument is the part of an OIDC provider's configuration used
+// to find its keys
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// fetchDiscovery reads the provider configuration of an OIDC issuer. The
+// issuer in the document must be the discovery URL itself.
+func fetchDiscovery(client *http.Client, issuer string) (*discoveryDocument, error) {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	var doc discoveryDocument
+	if err := fetchJSON(client, url, &doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", doc.Issuer, issuer)
+	}
+	if doc.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+	return &doc, nil
+}
+
+// jsonWebKey is a public key in a JWKS
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// fetchJWKS downloads a JWK set. Encryption keys and key types that
+// cannot verify signatures are skipped.
+func fetchJWKS(client *http.Client, url string) (*keySet, error) {
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := fetchJSON(client, url, &doc); err != nil {
+		return nil, err
+	}
+
+	set := &keySet{keys: make(map[string]crypto.PublicKey), fetchedAt: time.Now()}
+	for _, jwk := range doc.Keys {
+		if jwk.Use == "enc" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", jwk.KeyID, err)
+		}
+		if key == nil {
+			continue
+		}
+		if jwk.KeyID == "" {
+			set.anonymous = append(set.anonymous, key)
+		} else {
+			set.keys[jwk.KeyID] = key
+		}
+	}
+
+	if len(set.keys) == 0 && len(set.anonymous) == 0 {
+		return nil, fmt.Errorf("%s contains no signing keys", url)
+	}
+	return set, nil
+}
+
+// publicKey decodes an RSA, EC or Ed25519 key. Other key types return a
+// nil key.
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// parsePEMKey parses a PEM public key or certificate
+func parsePEMKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("invalid PEM key")
+	}
+
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEM key: %v", err)
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("unsupported PEM key type")
+}
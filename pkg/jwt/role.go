@@ -0,0 +1,261 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// Keys inside the method's storage view
+const (
+	configPath  = "config"
+	rolePrefix  = "role/"
+	groupPrefix = "group/"
+)
+
+// defaultClockSkewLeeway is allowed on time claims when a role sets none
+const defaultClockSkewLeeway = 60 * time.Second
+
+var (
+	// ErrRoleNotFound is returned when a role does not exist
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrGroupNotFound is returned when a group mapping does not exist
+	ErrGroupNotFound = errors.New("group not found")
+)
+
+// Config says where the keys that sign tokens come from. Exactly one of
+// JWKSURL, JWTValidationPubKeys and OIDCDiscoveryURL is set.
+type Config struct {
+	JWKSURL   string `json:"jwks_url,omitempty"`
+	JWKSCAPEM string `json:"jwks_ca_pem,omitempty"`
+	// JWTValidationPubKeys are PEM public keys or certificates
+	JWTValidationPubKeys []string `json:"jwt_validation_pubkeys,omitempty"`
+	OIDCDiscoveryURL     string   `json:"oidc_discovery_url,omitempty"`
+	OIDCDiscoveryCAPEM   string   `json:"oidc_discovery_ca_pem,omitempty"`
+	// BoundIssuer must match the iss claim when set. With discovery, the
+	// discovered issuer is required instead.
+	BoundIssuer string `json:"bound_issuer,omitempty"`
+	// DefaultRole is used for logins that do not name a role
+	DefaultRole string `json:"default_role,omitempty"`
+}
+
+// Role says which tokens can log in and the Vault tokens they get
+type Role struct {
+	BoundAudiences []string `json:"bound_audiences"`
+	BoundSubject   string   `json:"bound_subject"`
+	// BoundClaims maps claim names or JSON pointers to a required value
+	// or a list of accepted values
+	BoundClaims map[string]interface{} `json:"bound_claims"`
+	// ClaimMappings copies claims into token metadata, keyed by the
+	// metadata name
+	ClaimMappings map[string]string `json:"claim_mappings"`
+	// GroupsClaim names a claim whose values are group names. Groups get
+	// the policies mapped to them by groups/<name>; other groups are
+	// ignored.
+	GroupsClaim     string        `json:"groups_claim"`
+	ClockSkewLeeway time.Duration `json:"clock_skew_leeway"`
+
+	TokenPolicies []string      `json:"token_policies"`
+	TokenTTL      time.Duration `json:"token_ttl"`
+	TokenMaxTTL   time.Duration `json:"token_max_ttl"`
+	TokenPeriod   time.Duration `json:"token_period"`
+	TokenNumUses  int           `json:"token_num_uses"`
+}
+
+// validateBindings checks a token's aud, sub and bound claims against the
+// role
+func (r *Role) validateBindings(t *Token) error {
+	audiences := t.Audiences()
+	if len(r.BoundAudiences) > 0 {
+		if !slices.ContainsFunc(audiences, func(aud string) bool { return slices.Contains(r.BoundAudiences, aud) }) {
+			return errors.New("aud claim does not match any bound audience")
+		}
+	} else if len(audiences) > 0 {
+		return errors.New("token has an aud claim but the role has no bound_audiences")
+	}
+
+	if r.BoundSubject != "" {
+		if sub, _ := t.Claims["sub"].(string); sub != r.BoundSubject {
+			return errors.New("sub claim does not match the bound subject")
+		}
+	}
+
+	for name, expected := range r.BoundClaims {
+		value, ok := t.Claim(name)
+		if !ok {
+			return fmt.Errorf("claim %q is missing", name)
+		}
+		if !claimMatches(value, expected) {
+			return fmt.Errorf("claim %q does not match any bound value", name)
+		}
+	}
+	return nil
+}
+
+// claimMatches reports whether any value of a claim equals any expected
+// value. Both may be a single value or a list.
+func claimMatches(value, expected interface{}) bool {
+	for _, want := range scalars(expected) {
+		if slices.Contains(scalars(value), want) {
+			return true
+		}
+	}
+	return false
+}
+
+// scalars returns the string forms of a claim value or of the items of a
+// list value. Objects and nested lists are dropped.
+func scalars(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+
+	var values []string
+	for _, item := range items {
+		if s, ok := scalarString(item); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64, bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// metadata returns the token metadata for a login, from the claim
+// mappings. Missing claims are skipped.
+func (r *Role) metadata(t *Token, roleName string) (map[string]string, error) {
+	metadata := map[string]string{"role": roleName}
+	for claim, key := range r.ClaimMappings {
+		value, ok := t.Claim(claim)
+		if !ok {
+			continue
+		}
+		s, ok := scalarString(value)
+		if !ok {
+			return nil, fmt.Errorf("claim %q is not a string, number or boolean", claim)
+		}
+		metadata[key] = s
+	}
+	return metadata, nil
+}
+
+// groups returns the lowercased values of the role's groups claim
+func (r *Role) groups(t *Token) ([]string, error) {
+	if r.GroupsClaim == "" {
+		return nil, nil
+	}
+
+	value, ok := t.Claim(r.GroupsClaim)
+	if !ok {
+		return nil, fmt.Errorf("groups claim %q is missing", r.GroupsClaim)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+	var groups []string
+	for _, item := range items {
+		group, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("groups claim %q must be a string or a list of strings", r.GroupsClaim)
+		}
+		if group = strings.ToLower(strings.TrimSpace(group)); group != "" && !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// Group gives policies to the members of a group named by the groups
+// claim
+type Group struct {
+	Policies []string `json:"policies"`
+}
+
+func loadConfig(store storage.Storage) (*Config, error) {
+	data, err := store.Get(configPath)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func saveConfig(store storage.Storage, config *Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return store.Put(configPath, data)
+}
+
+func loadRole(store storage.Storage, name string) (*Role, error) {
+	data, err := store.Get(rolePrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var role Role
+	if err := json.Unmarshal(data, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func saveRole(store storage.Storage, name string, role *Role) error {
+	data, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	return store.Put(rolePrefix+name, data)
+}
+
+func loadGroup(store storage.Storage, name string) (*Group, error) {
+	data, err := store.Get(groupPrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var group Group
+	if err := json.Unmarshal(data, &group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func saveGroup(store storage.Storage, name string, group *Group) error {
+	data, err := json.Marshal(group)
+	if err != nil {
+		return err
+	}
+	return store.Put(groupPrefix+name, data)
+}
@@ -0,0 +1,229 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// errInvalidSignature is returned when no key verifies a token
+var errInvalidSignature = errors.New("signature verification failed")
+
+// header is the JOSE header of a signed token
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Token is a parsed JSON Web Token whose signature has not been checked
+type Token struct {
+	Header header
+	Claims map[string]interface{}
+
+	signingInput []byte
+	signature    []byte
+}
+
+// Parse splits a compact JWS and decodes its header and claims. Numbers
+// in the claims are kept as json.Number.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact JWS")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("invalid token header encoding")
+	}
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("invalid token claims encoding")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("invalid token signature encoding")
+	}
+
+	t := &Token{signingInput: []byte(parts[0] + "." + parts[1]), signature: signature}
+	if err := json.Unmarshal(headerJSON, &t.Header); err != nil {
+		return nil, errors.New("invalid token header")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(claimsJSON))
+	dec.UseNumber()
+	if err := dec.Decode(&t.Claims); err != nil || t.Claims == nil {
+		return nil, errors.New("invalid token claims")
+	}
+	return t, nil
+}
+
+// Verify checks the signature against each key in turn. Keys that do not
+// suit the token's algorithm are skipped; "none" is never accepted.
+func (t *Token) Verify(keys []crypto.PublicKey) error {
+	hash, ok := algorithmHashes[t.Header.Algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", t.Header.Algorithm)
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(t.signingInput)
+		digest = h.Sum(nil)
+	}
+
+	for _, key := range keys {
+		if t.verifyWith(key, hash, digest) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+// algorithmHashes are the supported JWS algorithms. EdDSA signs the
+// message itself, so it has no hash.
+var algorithmHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"PS256": crypto.SHA256,
+	"PS384": crypto.SHA384,
+	"PS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+	"EdDSA": 0,
+}
+
+func (t *Token) verifyWith(key crypto.PublicKey, hash crypto.Hash, digest []byte) bool {
+	alg := t.Header.Algorithm
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(key, hash, digest, t.signature) == nil
+		case "PS":
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(key, hash, digest, t.signature, opts) == nil
+		}
+
+	case *ecdsa.PublicKey:
+		// The curve is fixed by the algorithm, and the signature is the
+		// fixed-size concatenation of r and s
+		curves := map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+		if curves[alg] != key.Curve.Params().Name {
+			return false
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(key, t.signingInput, t.signature)
+	}
+	return false
+}
+
+// numericDate reads a NumericDate claim. ok is false when the claim is
+// missing.
+func (t *Token) numericDate(name string) (time.Time, bool, error) {
+	raw, ok := t.Claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	n, ok := raw.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid %s claim", name)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+}
+
+// ValidateTimes checks the exp, nbf and iat claims against now, allowing
+// leeway for clock skew between the issuer and the server. exp is
+// required.
+func (t *Token) ValidateTimes(now time.Time, leeway time.Duration) error {
+	exp, ok, err := t.numericDate("exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("token has no exp claim")
+	}
+	if now.After(exp.Add(leeway)) {
+		return errors.New("token is expired")
+	}
+
+	if nbf, ok, err := t.numericDate("nbf"); err != nil {
+		return err
+	} else if ok && now.Add(leeway).Before(nbf) {
+		return errors.New("token is not yet valid")
+	}
+
+	if iat, ok, err := t.numericDate("iat"); err != nil {
+		return err
+	} else if ok && now.Add(leeway).Before(iat) {
+		return errors.New("token was issued in the future")
+	}
+	return nil
+}
+
+// Audiences returns the aud claim, which may be a string or a list
+func (t *Token) Audiences() []string {
+	switch aud := t.Claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var audiences []string
+		for _, item := range aud {
+			if s, ok := item.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+// Claim returns a claim by name, or a nested claim by JSON pointer such
+// as "/kubernetes.io/namespace"
+func (t *Token) Claim(name string) (interface{}, bool) {
+	if !strings.HasPrefix(name, "/") {
+		value, ok := t.Claims[name]
+		return value, ok
+	}
+
+	var value interface{} = t.Claims
+	for _, part := range strings.Split(name[1:], "/") {
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
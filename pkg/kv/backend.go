@@ -0,0 +1,420 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// Keys inside the engine's storage view
+const (
+	configKey  = "config"
+	dataPrefix = "data/"
+)
+
+// ErrSecretNotFound is returned when no secret exists at a path
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Config holds the mount-wide settings of a KV engine
+type Config struct {
+	MaxVersions int  `json:"max_versions"`
+	CASRequired bool `json:"cas_required"`
+}
+
+// backend is the versioned key/value secrets engine. Reads of secrets
+// with a "ttl" field are leased. Paths are served relative to the mount
+// point:
+//
+//	config              engine-wide settings
+//	data/<path>         read, write, soft-delete and list secrets
+//	metadata/<path>     version history and per-path settings; list secrets
+//	delete/<path>       soft-delete versions
+//	undelete/<path>     restore soft-deleted versions
+//	destroy/<path>      permanently destroy versions
+//
+// Secret data only lives under data/, so no secret path can collide
+// with the engine's other endpoints.
+type backend struct {
+	// mu serializes read-modify-write cycles on entries
+	mu sync.Mutex
+}
+
+// Factory creates a KV engine for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// ConfigKey returns the storage key of the engine settings
+func ConfigKey() string { return configKey }
+
+// DataKey returns the storage key of the entry for a secret path
+func DataKey(path string) string { return dataPrefix + path }
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Path == configKey {
+		return b.handleConfig(req)
+	}
+
+	action, path, _ := strings.Cut(req.Path, "/")
+	switch action {
+	case "data":
+		return b.handleData(req, path)
+	case "metadata":
+		return b.handleMetadata(req, path)
+	case "delete", "undelete", "destroy":
+		return b.handleVersions(req, action, path)
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// Exists reports whether the entry targeted by a write already exists
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	action, path, _ := strings.Cut(req.Path, "/")
+	if action != "data" && action != "metadata" {
+		return true, nil
+	}
+
+	_, err := loadEntry(req.Storage, path)
+	if errors.Is(err, ErrSecretNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) handleData(req *logical.Request, path string) (*logical.Response, error) {
+	if req.Operation == logical.ListOperation {
+		return listEntries(req.Storage, path)
+	}
+
+	if path == "" {
+		return nil, logical.InvalidRequest("missing secret path")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		return b.readSecret(req, path)
+	case logical.CreateOperation, logical.UpdateOperation:
+		return b.writeSecret(req, path)
+	case logical.DeleteOperation:
+		return nil, b.updateEntry(req.Storage, path, func(entry *Entry) error {
+			entry.Delete([]int{entry.CurrentVersion}, time.Now())
+			return nil
+		})
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (b *backend) readSecret(req *logical.Request, path string) (*logical.Response, error) {
+	version, _, err := req.GetInt("version")
+	if err != nil {
+		return nil, err
+	}
+	if version < 0 {
+		return nil, logical.InvalidRequest("invalid version")
+	}
+
+	entry, err := loadEntry(req.Storage, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ver, number, err := entry.Get(version)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{Data: map[string]interface{}{
+		"data": ver.Data,
+		"metadata": map[string]interface{}{
+			"version":      number,
+			"created_time": ver.CreatedTime,
+		},
+		"created_at": entry.CreatedTime,
+		"updated_at": ver.CreatedTime,
+	}}
+
+	// A "ttl" field tells clients how long they may cache the secret
+	if raw, ok := ver.Data["ttl"]; ok {
+		ttl, err := logical.ParseTTL(raw)
+		if err != nil || ttl < 0 {
+			return nil, logical.InvalidRequest("invalid ttl stored in secret")
+		}
+		resp.Secret = &logical.Secret{TTL: ttl, Renewable: true}
+	}
+	return resp, nil
+}
+
+func (b *backend) writeSecret(req *logical.Request, path string) (*logical.Response, error) {
+	var body struct {
+		Data    map[string]interface{} `json:"data"`
+		Options struct {
+			CAS *int `json:"cas"`
+		} `json:"options"`
+	}
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+	if raw, ok := body.Data["ttl"]; ok {
+		if ttl, err := logical.ParseTTL(raw); err != nil || ttl < 0 {
+			return nil, logical.InvalidRequest("invalid ttl: must be a number of seconds or a duration string")
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, err := loadEntry(req.Storage, path)
+	if errors.Is(err, ErrSecretNotFound) {
+		entry, err = NewEntry(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := loadConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := entry.CheckCAS(body.Options.CAS, config.CASRequired); err != nil {
+		return nil, err
+	}
+
+	version := entry.Put(body.Data, maxVersions(entry, config), time.Now())
+	if err := saveEntry(req.Storage, path, entry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{Data: map[string]interface{}{
+		"status":       "success",
+		"version":      version.Version,
+		"created_time": version.CreatedTime,
+	}}, nil
+}
+
+func (b *backend) handleMetadata(req *logical.Request, path string) (*logical.Response, error) {
+	if req.Operation == logical.ListOperation {
+		return listEntries(req.Storage, path)
+	}
+
+	if path == "" {
+		return nil, logical.InvalidRequest("missing secret path")
+	}
+
+	switch req.Operation {
+	case logical.ReadOperation:
+		entry, err := loadEntry(req.Storage, path)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: entry.Metadata()}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		var body struct {
+			MaxVersions *int  `json:"max_versions"`
+			CASRequired *bool `json:"cas_required"`
+		}
+		if err := req.Decode(&body); err != nil {
+			return nil, err
+		}
+		if body.MaxVersions != nil && *body.MaxVersions < 0 {
+			return nil, logical.InvalidRequest("max_versions cannot be negative")
+		}
+
+		// The metadata may be written before the first version so that a
+		// path can require check-and-set from the start
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		entry, err := loadEntry(req.Storage, path)
+		if errors.Is(err, ErrSecretNotFound) {
+			entry, err = NewEntry(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if body.MaxVersions != nil {
+			entry.SetMaxVersions(*body.MaxVersions)
+		}
+		if body.CASRequired != nil {
+			entry.CASRequired = *body.CASRequired
+		}
+		return nil, saveEntry(req.Storage, path, entry)
+
+	case logical.DeleteOperation:
+		// Removes the secret and all of its versions
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if err := req.Storage.Delete(DataKey(path)); err != nil {
+			if errors.Is(err, storage.ErrKeyNotFound) {
+				return nil, ErrSecretNotFound
+			}
+			return nil, err
+		}
+		return nil, nil
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (b *backend) handleVersions(req *logical.Request, action, path string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	if path == "" {
+		return nil, logical.InvalidRequest("missing secret path")
+	}
+
+	var body struct {
+		Versions []int `json:"versions"`
+	}
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Versions) == 0 {
+		return nil, logical.InvalidRequest("no versions provided")
+	}
+
+	return nil, b.updateEntry(req.Storage, path, func(entry *Entry) error {
+		switch action {
+		case "delete":
+			entry.Delete(body.Versions, time.Now())
+		case "undelete":
+			entry.Undelete(body.Versions)
+		case "destroy":
+			entry.Destroy(body.Versions)
+		}
+		return nil
+	})
+}
+
+func (b *backend) handleConfig(req *logical.Request) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		config, err := loadConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: config}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		var body struct {
+			MaxVersions *int  `json:"max_versions"`
+			CASRequired *bool `json:"cas_required"`
+		}
+		if err := req.Decode(&body); err != nil {
+			return nil, err
+		}
+		if body.MaxVersions != nil && *body.MaxVersions < 0 {
+			return nil, logical.InvalidRequest("max_versions cannot be negative")
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		config, err := loadConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if body.MaxVersions != nil {
+			config.MaxVersions = *body.MaxVersions
+		}
+		if body.CASRequired != nil {
+			config.CASRequired = *body.CASRequired
+		}
+
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Put(configKey, data)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// updateEntry applies a read-modify-write to a stored entry
+func (b *backend) updateEntry(store storage.Storage, path string, update func(*Entry) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, err := loadEntry(store, path)
+	if err != nil {
+		return err
+	}
+
+	if err := update(entry); err != nil {
+		return err
+	}
+
+	return saveEntry(store, path, entry)
+}
+
+func listEntries(store storage.Storage, prefix string) (*logical.Response, error) {
+	keys, err := store.List(DataKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]string, 0, len(keys))
+	for _, key := range keys {
+		secrets = append(secrets, strings.TrimPrefix(key, dataPrefix))
+	}
+
+	return &logical.Response{Data: map[string]interface{}{"keys": secrets}}, nil
+}
+
+func loadEntry(store storage.Storage, path string) (*Entry, error) {
+	data, err := store.Get(DataKey(path))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrSecretNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Decode(data)
+}
+
+func saveEntry(store storage.Storage, path string, entry *Entry) error {
+	data, err := entry.Encode()
+	if err != nil {
+		return err
+	}
+	return store.Put(DataKey(path), data)
+}
+
+func loadConfig(store storage.Storage) (*Config, error) {
+	data, err := store.Get(configKey)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return &Config{MaxVersions: DefaultMaxVersions}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// maxVersions resolves the version limit for an entry: the per-path
+// setting if present, otherwise the mount-wide default
+func maxVersions(entry *Entry, config *Config) int {
+	if entry.MaxVersions > 0 {
+		return entry.MaxVersions
+	}
+	if config.MaxVersions == 0 {
+		return DefaultMaxVersions
+	}
+	return config.MaxVersions
+}
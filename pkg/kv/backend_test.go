@@ -0,0 +1,255 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// testEngine is a KV engine with its storage
+type testEngine struct {
+	b     *backend
+	store storage.Storage
+}
+
+func newTestEngine(t *testing.T) *testEngine {
+	t.Helper()
+	store, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return &testEngine{b: &backend{}, store: store}
+}
+
+// request returns the data of the response to a request
+func (e *testEngine) request(op logical.Operation, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := e.b.HandleRequest(&logical.Request{Operation: op, Path: path, Data: data, Storage: e.store})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	out, _ := resp.Data.(map[string]interface{})
+	return out, nil
+}
+
+// write sends an update request and fails the test on an error
+func (e *testEngine) write(t *testing.T, path string, data map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	resp, err := e.request(logical.UpdateOperation, path, data)
+	if err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return resp
+}
+
+// put writes a secret with a single "value" field and returns its version
+func (e *testEngine) put(t *testing.T, path, value string) int {
+	t.Helper()
+	resp := e.write(t, "data/"+path, map[string]interface{}{"data": map[string]interface{}{"value": value}})
+	return resp["version"].(int)
+}
+
+// get reads the "value" field of a version of a secret
+func (e *testEngine) get(path string, version int) (string, error) {
+	resp, err := e.request(logical.ReadOperation, "data/"+path, map[string]interface{}{"version": version})
+	if err != nil {
+		return "", err
+	}
+	data := resp["data"].(map[string]interface{})
+	return data["value"].(string), nil
+}
+
+func (e *testEngine) metadata(t *testing.T, path string) *Metadata {
+	t.Helper()
+	resp, err := e.b.HandleRequest(&logical.Request{Operation: logical.ReadOperation, Path: "metadata/" + path, Storage: e.store})
+	if err != nil {
+		t.Fatalf("read metadata: %v", err)
+	}
+	return resp.Data.(*Metadata)
+}
+
+func expectVersion(t *testing.T, e *testEngine, path string, version int, want string) {
+	t.Helper()
+	value, err := e.get(path, version)
+	if err != nil {
+		t.Fatalf("read version %d of %s: %v", version, path, err)
+	}
+	if value != want {
+		t.Fatalf("version %d of %s = %q, want %q", version, path, value, want)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	e := newTestEngine(t)
+	for i, value := range []string{"one", "two", "three"} {
+		if version := e.put(t, "app", value); version != i+1 {
+			t.Fatalf("write %d created version %d", i+1, version)
+		}
+	}
+
+	expectVersion(t, e, "app", 0, "three")
+	expectVersion(t, e, "app", 1, "one")
+	expectVersion(t, e, "app", 2, "two")
+	if _, err := e.get("app", 4); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("read of an unwritten version: %v, want ErrVersionNotFound", err)
+	}
+	if _, err := e.get("missing", 0); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("read of a missing secret: %v, want ErrSecretNotFound", err)
+	}
+
+	md := e.metadata(t, "app")
+	if md.CurrentVersion != 3 || md.OldestVersion != 1 || len(md.Versions) != 3 {
+		t.Fatalf("metadata = %+v, want versions 1 to 3", md)
+	}
+}
+
+func TestMaxVersions(t *testing.T) {
+	e := newTestEngine(t)
+	e.write(t, "config", map[string]interface{}{"max_versions": 3})
+
+	for _, value := range []string{"1", "2", "3", "4", "5"} {
+		e.put(t, "app", value)
+	}
+	for _, version := range []int{1, 2} {
+		if _, err := e.get("app", version); !errors.Is(err, ErrVersionNotFound) {
+			t.Fatalf("read of pruned version %d: %v, want ErrVersionNotFound", version, err)
+		}
+	}
+	expectVersion(t, e, "app", 3, "3")
+	if md := e.metadata(t, "app"); md.OldestVersion != 3 || len(md.Versions) != 3 {
+		t.Fatalf("metadata = %+v, want versions 3 to 5", md)
+	}
+
+	// A per-path limit overrides the mount's and prunes right away
+	e.write(t, "metadata/app", map[string]interface{}{"max_versions": 1})
+	if md := e.metadata(t, "app"); md.OldestVersion != 5 || len(md.Versions) != 1 {
+		t.Fatalf("metadata = %+v, want only version 5", md)
+	}
+	e.put(t, "app", "6")
+	if _, err := e.get("app", 5); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("read of pruned version 5: %v, want ErrVersionNotFound", err)
+	}
+	expectVersion(t, e, "app", 0, "6")
+
+	if _, err := e.request(logical.UpdateOperation, "metadata/app", map[string]interface{}{"max_versions": -1}); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("negative max_versions: %v, want an invalid request", err)
+	}
+}
+
+func TestDeleteUndeleteDestroy(t *testing.T) {
+	e := newTestEngine(t)
+	e.put(t, "app", "one")
+	e.put(t, "app", "two")
+	e.put(t, "app", "three")
+
+	// Deleting the secret soft-deletes its current version
+	if _, err := e.request(logical.DeleteOperation, "data/app", nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := e.get("app", 0); !errors.Is(err, ErrVersionDeleted) {
+		t.Fatalf("read of the deleted current version: %v, want ErrVersionDeleted", err)
+	}
+	expectVersion(t, e, "app", 2, "two")
+
+	e.write(t, "delete/app", map[string]interface{}{"versions": []int{1}})
+	if _, err := e.get("app", 1); !errors.Is(err, ErrVersionDeleted) {
+		t.Fatalf("read of deleted version 1: %v, want ErrVersionDeleted", err)
+	}
+
+	e.write(t, "undelete/app", map[string]interface{}{"versions": []int{1, 3}})
+	expectVersion(t, e, "app", 1, "one")
+	expectVersion(t, e, "app", 0, "three")
+
+	// Destroyed versions lose their data and cannot be undeleted
+	e.write(t, "destroy/app", map[string]interface{}{"versions": []int{2}})
+	e.write(t, "undelete/app", map[string]interface{}{"versions": []int{2}})
+	if _, err := e.get("app", 2); !errors.Is(err, ErrVersionDestroyed) {
+		t.Fatalf("read of destroyed version 2: %v, want ErrVersionDestroyed", err)
+	}
+	entry, err := loadEntry(e.store, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Versions[2].Data != nil {
+		t.Fatal("destroyed version still holds its data")
+	}
+
+	if _, err := e.request(logical.UpdateOperation, "destroy/app", nil); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("destroy without versions: %v, want an invalid request", err)
+	}
+
+	// Deleting the metadata removes every version
+	if _, err := e.request(logical.DeleteOperation, "metadata/app", nil); err != nil {
+		t.Fatalf("delete metadata: %v", err)
+	}
+	if _, err := e.get("app", 1); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("read after deleting the metadata: %v, want ErrSecretNotFound", err)
+	}
+}
+
+// cas writes a secret with a check-and-set version, or none if cas is nil
+func (e *testEngine) cas(path, value string, cas *int) error {
+	data := map[string]interface{}{"data": map[string]interface{}{"value": value}}
+	if cas != nil {
+		data["options"] = map[string]interface{}{"cas": *cas}
+	}
+	_, err := e.request(logical.UpdateOperation, "data/"+path, data)
+	return err
+}
+
+func TestCheckAndSet(t *testing.T) {
+	e := newTestEngine(t)
+	zero, one, two := 0, 1, 2
+
+	// cas=0 only creates
+	if err := e.cas("app", "one", &zero); err != nil {
+		t.Fatalf("create with cas=0: %v", err)
+	}
+	if err := e.cas("app", "again", &zero); !errors.Is(err, ErrCASMismatch) {
+		t.Fatalf("cas=0 on an existing secret: %v, want ErrCASMismatch", err)
+	}
+
+	if err := e.cas("app", "two", &two); !errors.Is(err, ErrCASMismatch) {
+		t.Fatalf("cas ahead of the current version: %v, want ErrCASMismatch", err)
+	}
+	if err := e.cas("app", "two", &one); err != nil {
+		t.Fatalf("cas matching the current version: %v", err)
+	}
+	if err := e.cas("app", "stale", &one); !errors.Is(err, ErrCASMismatch) {
+		t.Fatalf("stale cas: %v, want ErrCASMismatch", err)
+	}
+	expectVersion(t, e, "app", 0, "two")
+
+	// Without cas_required the check is optional
+	if err := e.cas("app", "three", nil); err != nil {
+		t.Fatalf("write without cas: %v", err)
+	}
+}
+
+func TestCASRequired(t *testing.T) {
+	e := newTestEngine(t)
+	zero, one := 0, 1
+
+	// Per path, set in the metadata before the first write
+	e.write(t, "metadata/app", map[string]interface{}{"cas_required": true})
+	if err := e.cas("app", "one", nil); !errors.Is(err, ErrCASRequired) {
+		t.Fatalf("write without cas to a path requiring it: %v, want ErrCASRequired", err)
+	}
+	if err := e.cas("app", "one", &zero); err != nil {
+		t.Fatalf("write with cas: %v", err)
+	}
+	if err := e.cas("other", "one", nil); err != nil {
+		t.Fatalf("write without cas to another path: %v", err)
+	}
+
+	// Mount-wide
+	e.write(t, "config", map[string]interface{}{"cas_required": true})
+	if err := e.cas("other", "two", nil); !errors.Is(err, ErrCASRequired) {
+		t.Fatalf("write without cas with cas_required set on the mount: %v, want ErrCASRequired", err)
+	}
+	if err := e.cas("other", "two", &one); err != nil {
+		t.Fatalf("write with cas: %v", err)
+	}
+}
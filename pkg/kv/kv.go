@@ -0,0 +1,237 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+)
+
+// DefaultMaxVersions is the number of versions kept when no limit is configured
+const DefaultMaxVersions = 10
+
+var (
+	// ErrVersionNotFound is returned for versions that were never written or have been pruned
+	ErrVersionNotFound = errors.New("secret version not found")
+	// ErrVersionDeleted is returned when reading a soft-deleted version
+	ErrVersionDeleted = errors.New("secret version has been deleted")
+	// ErrVersionDestroyed is returned when reading a destroyed version
+	ErrVersionDestroyed = errors.New("secret version has been destroyed")
+	// ErrCASMismatch is returned when a check-and-set version does not match the current version
+	ErrCASMismatch = errors.New("check-and-set parameter did not match the current version")
+	// ErrCASRequired is returned when a write omits the check-and-set parameter but one is required
+	ErrCASRequired = errors.New("check-and-set parameter required for this call")
+)
+
+// Version is a single write to a secret path
+type Version struct {
+	Data         map[string]interface{} `json:"data,omitempty"`
+	CreatedTime  time.Time              `json:"created_time"`
+	DeletionTime *time.Time             `json:"deletion_time,omitempty"`
+	Destroyed    bool                   `json:"destroyed"`
+}
+
+// VersionMetadata describes a version without its data
+type VersionMetadata struct {
+	Version      int        `json:"version"`
+	CreatedTime  time.Time  `json:"created_time"`
+	DeletionTime *time.Time `json:"deletion_time,omitempty"`
+	Destroyed    bool       `json:"destroyed"`
+}
+
+// Metadata describes a secret path and all of its retained versions
+type Metadata struct {
+	CurrentVersion int                `json:"current_version"`
+	OldestVersion  int                `json:"oldest_version"`
+	MaxVersions    int                `json:"max_versions"`
+	CASRequired    bool               `json:"cas_required"`
+	CreatedTime    time.Time          `json:"created_time"`
+	UpdatedTime    time.Time          `json:"updated_time"`
+	Versions       []*VersionMetadata `json:"versions"`
+}
+
+// Entry is the stored form of a versioned secret path
+type Entry struct {
+	CurrentVersion int              `json:"current_version"`
+	OldestVersion  int              `json:"oldest_version"`
+	MaxVersions    int              `json:"max_versions"`
+	CASRequired    bool             `json:"cas_required"`
+	CreatedTime    time.Time        `json:"created_time"`
+	UpdatedTime    time.Time        `json:"updated_time"`
+	Versions       map[int]*Version `json:"versions"`
+}
+
+// legacyEntry is the un-versioned format written before versioning
+type legacyEntry struct {
+	Data      map[string]interface{} `json:"data"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// NewEntry creates an empty entry for a path that has not been written
+func NewEntry() *Entry {
+	return &Entry{Versions: make(map[int]*Version)}
+}
+
+// Decode parses a stored entry. Secrets written before versioning are
+// returned as an entry holding a single version.
+func Decode(data []byte) (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	if entry.Versions != nil {
+		return &entry, nil
+	}
+
+	var legacy legacyEntry
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	return &Entry{
+		CurrentVersion: 1,
+		OldestVersion:  1,
+		CreatedTime:    legacy.CreatedAt,
+		UpdatedTime:    legacy.UpdatedAt,
+		Versions: map[int]*Version{
+			1: {Data: legacy.Data, CreatedTime: legacy.UpdatedAt},
+		},
+	}, nil
+}
+
+// Encode serializes an entry for storage
+func (e *Entry) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// CheckCAS validates a check-and-set version against the current version.
+// A nil cas skips the check unless required is set; cas 0 only allows
+// writing a path that has no versions yet.
+func (e *Entry) CheckCAS(cas *int, required bool) error {
+	if cas == nil {
+		if required || e.CASRequired {
+			return ErrCASRequired
+		}
+		return nil
+	}
+	if *cas != e.CurrentVersion {
+		return ErrCASMismatch
+	}
+	return nil
+}
+
+// Put adds a new version and prunes versions beyond maxVersions
+func (e *Entry) Put(data map[string]interface{}, maxVersions int, now time.Time) *VersionMetadata {
+	if e.CurrentVersion == 0 {
+		e.CreatedTime = now
+		e.OldestVersion = 1
+	}
+
+	e.CurrentVersion++
+	e.UpdatedTime = now
+	e.Versions[e.CurrentVersion] = &Version{Data: data, CreatedTime: now}
+
+	if maxVersions > 0 {
+		for e.CurrentVersion-e.OldestVersion+1 > maxVersions {
+			delete(e.Versions, e.OldestVersion)
+			e.OldestVersion++
+		}
+	}
+
+	return e.versionMetadata(e.CurrentVersion)
+}
+
+// Get returns a version; version 0 means the current version
+func (e *Entry) Get(version int) (*Version, int, error) {
+	if version == 0 {
+		version = e.CurrentVersion
+	}
+
+	v, ok := e.Versions[version]
+	if !ok {
+		return nil, version, ErrVersionNotFound
+	}
+	if v.Destroyed {
+		return nil, version, ErrVersionDestroyed
+	}
+	if v.DeletionTime != nil {
+		return nil, version, ErrVersionDeleted
+	}
+
+	return v, version, nil
+}
+
+// Delete soft-deletes versions; their data can be restored with Undelete
+func (e *Entry) Delete(versions []int, now time.Time) {
+	for _, n := range versions {
+		if v, ok := e.Versions[n]; ok && !v.Destroyed && v.DeletionTime == nil {
+			deleted := now
+			v.DeletionTime = &deleted
+		}
+	}
+}
+
+// Undelete restores soft-deleted versions
+func (e *Entry) Undelete(versions []int) {
+	for _, n := range versions {
+		if v, ok := e.Versions[n]; ok && !v.Destroyed {
+			v.DeletionTime = nil
+		}
+	}
+}
+
+// Destroy permanently removes the data of versions
+func (e *Entry) Destroy(versions []int) {
+	for _, n := range versions {
+		if v, ok := e.Versions[n]; ok {
+			v.Data = nil
+			v.Destroyed = true
+		}
+	}
+}
+
+// SetMaxVersions changes the per-path version limit, pruning immediately
+func (e *Entry) SetMaxVersions(maxVersions int) {
+	e.MaxVersions = maxVersions
+	if maxVersions <= 0 {
+		return
+	}
+	for e.CurrentVersion-e.OldestVersion+1 > maxVersions {
+		delete(e.Versions, e.OldestVersion)
+		e.OldestVersion++
+	}
+}
+
+// Metadata returns the path metadata without any secret data
+func (e *Entry) Metadata() *Metadata {
+	md := &Metadata{
+		CurrentVersion: e.CurrentVersion,
+		OldestVersion:  e.OldestVersion,
+		MaxVersions:    e.MaxVersions,
+		CASRequired:    e.CASRequired,
+		CreatedTime:    e.CreatedTime,
+		UpdatedTime:    e.UpdatedTime,
+	}
+
+	numbers := make([]int, 0, len(e.Versions))
+	for n := range e.Versions {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	for _, n := range numbers {
+		md.Versions = append(md.Versions, e.versionMetadata(n))
+	}
+	return md
+}
+
+func (e *Entry) versionMetadata(n int) *VersionMetadata {
+	v := e.Versions[n]
+	return &VersionMetadata{
+		Version:      n,
+		CreatedTime:  v.CreatedTime,
+		DeletionTime: v.DeletionTime,
+		Destroyed:    v.Destroyed,
+	}
+}
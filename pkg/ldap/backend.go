@@ -0,0 +1,449 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/logical"
+)
+
+// backend is the LDAP auth method. Users log in with their directory
+// password, and their directory groups are mapped to policies. Paths are
+// served relative to the mount point:
+//
+//	config            how to reach the directory and find users and groups
+//	groups/<name>     policies for a directory group
+//	users/<name>      policies and extra groups for a directory user
+//	login/<username>  log in with a password (no token required)
+type backend struct {
+	// mu serializes changes to the config and mappings
+	mu sync.Mutex
+}
+
+// Factory creates an LDAP auth method for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// LoginPaths returns the paths served without a token
+func (b *backend) LoginPaths() []string {
+	return []string{"login/*"}
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if req.Path == "config" {
+		return b.handleConfig(req)
+	}
+
+	action, name, _ := strings.Cut(req.Path, "/")
+	if strings.Contains(name, "/") {
+		return nil, logical.ErrUnsupportedPath
+	}
+	name = strings.ToLower(name)
+
+	switch action {
+	case "login":
+		if name == "" {
+			return nil, logical.InvalidRequest("missing username")
+		}
+		return b.handleLogin(req, name)
+	case "groups", "users":
+		prefix := groupPrefix
+		if action == "users" {
+			prefix = userPrefix
+		}
+		if req.Operation == logical.ListOperation {
+			return b.listMappings(req, prefix)
+		}
+		if name == "" {
+			return nil, logical.InvalidRequest("missing name")
+		}
+		return b.handleMapping(req, prefix, name)
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// LoginAlias returns the username a login request is for
+func (b *backend) LoginAlias(req *logical.Request) string {
+	name, ok := strings.CutPrefix(req.Path, "login/")
+	if !ok || strings.Contains(name, "/") {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// Exists reports whether the mapping targeted by a write exists, so that
+// creating one requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	action, name, _ := strings.Cut(req.Path, "/")
+	if (action != "groups" && action != "users") || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	prefix := groupPrefix
+	if action == "users" {
+		prefix = userPrefix
+	}
+	_, err := loadMapping(req.Storage, prefix, strings.ToLower(name))
+	if errors.Is(err, ErrGroupNotFound) || errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) handleConfig(req *logical.Request) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		config, err := loadConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = defaultConfig()
+		}
+		// The bind password is write-only
+		return &logical.Response{Data: map[string]interface{}{
+			"url":             config.URL,
+			"starttls":        config.StartTLS,
+			"insecure_tls":    config.InsecureTLS,
+			"certificate":     config.Certificate,
+			"binddn":          config.BindDN,
+			"userdn":          config.UserDN,
+			"userattr":        config.UserAttr,
+			"userfilter":      config.UserFilter,
+			"groupdn":         config.GroupDN,
+			"groupfilter":     config.GroupFilter,
+			"groupattr":       config.GroupAttr,
+			"deny_null_bind":  config.DenyNullBind,
+			"request_timeout": int64(config.RequestTimeout / time.Second),
+			"token_policies":  nonNil(config.TokenPolicies),
+			"token_ttl":       int64(config.TokenTTL / time.Second),
+			"token_max_ttl":   int64(config.TokenMaxTTL / time.Second),
+		}}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		config, err := loadConfig(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = defaultConfig()
+		}
+
+		if err := updateConfig(req, config); err != nil {
+			return nil, err
+		}
+		if err := config.validate(); err != nil {
+			return nil, logical.InvalidRequest("%v", err)
+		}
+		return nil, saveConfig(req.Storage, config)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// updateConfig applies the fields present in a request to a config
+func updateConfig(req *logical.Request, config *Config) error {
+	for key, dest := range map[string]*string{
+		"url":         &config.URL,
+		"certificate": &config.Certificate,
+		"binddn":      &config.BindDN,
+		"bindpass":    &config.BindPass,
+		"userdn":      &config.UserDN,
+		"userattr":    &config.UserAttr,
+		"userfilter":  &config.UserFilter,
+		"groupdn":     &config.GroupDN,
+		"groupfilter": &config.GroupFilter,
+		"groupattr":   &config.GroupAttr,
+	} {
+		if _, ok := req.Data[key]; ok {
+			*dest = req.GetString(key)
+		}
+	}
+
+	// Empty attributes and filters fall back to the defaults
+	defaults := defaultConfig()
+	for _, field := range []struct{ value, fallback *string }{
+		{&config.UserAttr, &defaults.UserAttr},
+		{&config.UserFilter, &defaults.UserFilter},
+		{&config.GroupFilter, &defaults.GroupFilter},
+		{&config.GroupAttr, &defaults.GroupAttr},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+
+	for key, dest := range map[string]*bool{
+		"starttls":       &config.StartTLS,
+		"insecure_tls":   &config.InsecureTLS,
+		"deny_null_bind": &config.DenyNullBind,
+	} {
+		value, ok, err := req.GetBool(key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*dest = value
+		}
+	}
+
+	for key, dest := range map[string]*time.Duration{
+		"request_timeout": &config.RequestTimeout,
+		"token_ttl":       &config.TokenTTL,
+		"token_max_ttl":   &config.TokenMaxTTL,
+	} {
+		if _, ok := req.Data[key]; !ok {
+			continue
+		}
+		ttl, err := req.GetTTL(key)
+		if err != nil {
+			return err
+		}
+		*dest = ttl
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+
+	if _, ok := req.Data["token_policies"]; ok {
+		policies, err := req.GetStrings("token_policies")
+		if err != nil {
+			return err
+		}
+		config.TokenPolicies = policies
+	}
+	return nil
+}
+
+func (b *backend) listMappings(req *logical.Request, prefix string) (*logical.Response, error) {
+	keys, err := req.Storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, prefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleMapping(req *logical.Request, prefix, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		m, err := loadMapping(req.Storage, prefix, name)
+		if err != nil {
+			return nil, err
+		}
+		data := map[string]interface{}{"policies": nonNil(m.Policies)}
+		if prefix == userPrefix {
+			data["groups"] = nonNil(m.Groups)
+		}
+		return &logical.Response{Data: data}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		policies, err := req.GetStrings("policies")
+		if err != nil {
+			return nil, err
+		}
+		groups, err := req.GetStrings("groups")
+		if err != nil {
+			return nil, err
+		}
+		if prefix == groupPrefix && groups != nil {
+			return nil, logical.InvalidRequest("groups can only be set for users")
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		m, err := loadMapping(req.Storage, prefix, name)
+		if errors.Is(err, ErrGroupNotFound) || errors.Is(err, ErrUserNotFound) {
+			m, err = &Mapping{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := req.Data["policies"]; ok {
+			m.Policies = policies
+		}
+		if _, ok := req.Data["groups"]; ok {
+			m.Groups = nil
+			for _, group := range groups {
+				m.Groups = append(m.Groups, strings.ToLower(group))
+			}
+		}
+		return nil, saveMapping(req.Storage, prefix, name, m)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadMapping(req.Storage, prefix, name); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(prefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// handleLogin checks a password by binding to the directory as the user,
+// then resolves the user's groups and the policies mapped to the user and
+// the groups
+func (b *backend) handleLogin(req *logical.Request, username string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	config, err := loadConfig(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, logical.InvalidRequest("the ldap auth method is not configured")
+	}
+
+	password := req.GetString("password")
+	if password == "" && config.DenyNullBind {
+		return nil, logical.InvalidCredentials("invalid username or password")
+	}
+
+	conn, err := config.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	userDN, err := findUserDN(conn, config, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(userDN, password); errors.Is(err, ErrInvalidCredentials) {
+		return nil, logical.InvalidCredentials("invalid username or password")
+	} else if err != nil {
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	groups, err := findGroups(conn, config, username, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	policies := slices.Clone(config.TokenPolicies)
+	addPolicies := func(m *Mapping) {
+		for _, policy := range m.Policies {
+			if !slices.Contains(policies, policy) {
+				policies = append(policies, policy)
+			}
+		}
+	}
+
+	user, err := loadMapping(req.Storage, userPrefix, username)
+	if err == nil {
+		addPolicies(user)
+		groups = append(groups, user.Groups...)
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		m, err := loadMapping(req.Storage, groupPrefix, group)
+		if errors.Is(err, ErrGroupNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		addPolicies(m)
+	}
+
+	return &logical.Response{Auth: &logical.Auth{
+		Policies:  policies,
+		TTL:       config.TokenTTL,
+		MaxTTL:    config.TokenMaxTTL,
+		Renewable: true,
+		Metadata:  map[string]string{"username": username},
+		Alias:     username,
+	}}, nil
+}
+
+// findUserDN searches for the user's entry, bound as the configured
+// search account. Exactly one entry must match.
+func findUserDN(conn *Conn, config *Config, username string) (string, error) {
+	if config.BindDN != "" {
+		if err := conn.Bind(config.BindDN, config.BindPass); err != nil {
+			return "", fmt.Errorf("LDAP bind as %s failed: %w", config.BindDN, err)
+		}
+	}
+
+	filter, err := renderFilter(config.UserFilter, filterData{UserAttr: config.UserAttr, Username: username})
+	if err != nil {
+		return "", err
+	}
+
+	// "1.1" asks for no attributes; only the DN is needed
+	entries, err := conn.Search(config.UserDN, ScopeWholeSubtree, filter, []string{"1.1"})
+	if err != nil {
+		return "", fmt.Errorf("LDAP user search failed: %w", err)
+	}
+	switch len(entries) {
+	case 0:
+		return "", logical.InvalidCredentials("invalid username or password")
+	case 1:
+		return entries[0].DN, nil
+	}
+	return "", fmt.Errorf("LDAP user search for %q matched %d entries", username, len(entries))
+}
+
+// findGroups returns the lowercased names of the groups the user is a
+// member of. Searches run as the search account when one is configured,
+// and as the user otherwise.
+func findGroups(conn *Conn, config *Config, username, userDN string) ([]string, error) {
+	if config.GroupDN == "" {
+		return nil, nil
+	}
+
+	if config.BindDN != "" {
+		if err := conn.Bind(config.BindDN, config.BindPass); err != nil {
+			return nil, fmt.Errorf("LDAP bind as %s failed: %w", config.BindDN, err)
+		}
+	}
+
+	filter, err := renderFilter(config.GroupFilter, filterData{UserAttr: config.UserAttr, Username: username, UserDN: userDN})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := conn.Search(config.GroupDN, ScopeWholeSubtree, filter, []string{config.GroupAttr})
+	if err != nil {
+		return nil, fmt.Errorf("LDAP group search failed: %w", err)
+	}
+
+	var groups []string
+	for _, entry := range entries {
+		if values := entry.Attribute(config.GroupAttr); len(values) > 0 {
+			groups = append(groups, strings.ToLower(values[0]))
+		}
+	}
+	return groups, nil
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
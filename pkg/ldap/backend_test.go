@@ -0,0 +1,202 @@
+package ldap
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// testMethod is an LDAP auth method with its storage
+type testMethod struct {
+	b     *backend
+	store storage.Storage
+}
+
+func newTestMethod(t *testing.T, config map[string]interface{}) *testMethod {
+	t.Helper()
+	store, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	m := &testMethod{b: &backend{}, store: store}
+	m.write(t, "config", config)
+	return m
+}
+
+func (m *testMethod) write(t *testing.T, path string, data map[string]interface{}) {
+	t.Helper()
+	req := &logical.Request{Operation: logical.UpdateOperation, Path: path, Data: data, Storage: m.store}
+	if _, err := m.b.HandleRequest(req); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func (m *testMethod) login(username, password string) (*logical.Auth, error) {
+	resp, err := m.b.HandleRequest(&logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/" + username,
+		Data:      map[string]interface{}{"password": password},
+		Storage:   m.store,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Auth, nil
+}
+
+// directoryConfig returns a config for the test directory
+func directoryConfig(d *directory) map[string]interface{} {
+	return map[string]interface{}{
+		"url":            d.url(),
+		"certificate":    d.caPEM,
+		"binddn":         "cn=search,ou=svc,dc=example,dc=com",
+		"bindpass":       "searchpw",
+		"userdn":         "ou=users,dc=example,dc=com",
+		"userattr":       "uid",
+		"groupdn":        "ou=groups,dc=example,dc=com",
+		"token_policies": []interface{}{"ldap"},
+	}
+}
+
+func TestLoginGroups(t *testing.T) {
+	d := newDirectory(t, false)
+	m := newTestMethod(t, directoryConfig(d))
+	m.write(t, "groups/admins", map[string]interface{}{"policies": []interface{}{"admin"}})
+	m.write(t, "groups/devs", map[string]interface{}{"policies": []interface{}{"dev"}})
+	m.write(t, "groups/ops", map[string]interface{}{"policies": []interface{}{"ops"}})
+	m.write(t, "groups/extra", map[string]interface{}{"policies": []interface{}{"extra"}})
+	m.write(t, "users/Alice", map[string]interface{}{"policies": []interface{}{"alice"}, "groups": []interface{}{"Extra"}})
+
+	tests := []struct {
+		username, password string
+		policies           []string
+	}{
+		// member, memberUid and a local group
+		{"alice", "alicepw", []string{"admin", "alice", "dev", "extra", "ldap"}},
+		// memberUid and uniqueMember
+		{"BOB", "bobpw", []string{"dev", "ldap", "ops"}},
+	}
+	for _, tt := range tests {
+		auth, err := m.login(tt.username, tt.password)
+		if err != nil {
+			t.Fatalf("login %s: %v", tt.username, err)
+		}
+		policies := slices.Sorted(slices.Values(auth.Policies))
+		if !slices.Equal(policies, tt.policies) {
+			t.Fatalf("login %s: policies = %v, want %v", tt.username, policies, tt.policies)
+		}
+	}
+}
+
+func TestLoginInvalidCredentials(t *testing.T) {
+	d := newDirectory(t, false)
+	m := newTestMethod(t, directoryConfig(d))
+
+	tests := []struct {
+		name, username, password string
+	}{
+		{"wrong password", "alice", "bobpw"},
+		{"unknown user", "carol", "alicepw"},
+		{"filter injection", "alice)(uid=*", "alicepw"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.login(tt.username, tt.password); !errors.Is(err, logical.ErrInvalidCredentials) {
+				t.Fatalf("login error = %v, want invalid credentials", err)
+			}
+		})
+	}
+}
+
+func TestLoginEmptyPassword(t *testing.T) {
+	d := newDirectory(t, false)
+	m := newTestMethod(t, directoryConfig(d))
+
+	if _, err := m.login("alice", ""); !errors.Is(err, logical.ErrInvalidCredentials) {
+		t.Fatalf("login error = %v, want invalid credentials", err)
+	}
+
+	// An empty password would be an unauthenticated bind, which succeeds
+	if events := d.events(); len(events) != 0 {
+		t.Fatalf("directory saw %d requests for an empty password", len(events))
+	}
+}
+
+func TestLoginSearchAccount(t *testing.T) {
+	d := newDirectory(t, false)
+	config := directoryConfig(d)
+	config["bindpass"] = "wrong"
+	m := newTestMethod(t, config)
+
+	_, err := m.login("alice", "alicepw")
+	if err == nil || errors.Is(err, logical.ErrInvalidCredentials) {
+		t.Fatalf("login error = %v, want a search bind failure", err)
+	}
+}
+
+func TestLoginStartTLS(t *testing.T) {
+	d := newDirectory(t, false)
+	config := directoryConfig(d)
+	config["starttls"] = true
+	m := newTestMethod(t, config)
+
+	if _, err := m.login("alice", "alicepw"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	events := d.events()
+	if len(events) == 0 {
+		t.Fatal("directory saw no requests")
+	}
+	for _, event := range events {
+		if !event.tls {
+			t.Fatalf("%s of %s was sent before StartTLS", event.op, event.dn)
+		}
+	}
+}
+
+func TestLoginStartTLSUntrusted(t *testing.T) {
+	d := newDirectory(t, false)
+	config := directoryConfig(d)
+	config["starttls"] = true
+	delete(config, "certificate")
+	m := newTestMethod(t, config)
+
+	if _, err := m.login("alice", "alicepw"); err == nil {
+		t.Fatal("login succeeded with an untrusted directory certificate")
+	}
+	for _, event := range d.events() {
+		if event.op == "bind" {
+			t.Fatalf("bind as %s sent to an untrusted directory", event.dn)
+		}
+	}
+}
+
+func TestLoginLDAPS(t *testing.T) {
+	d := newDirectory(t, true)
+	m := newTestMethod(t, directoryConfig(d))
+
+	auth, err := m.login("alice", "alicepw")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if auth.Alias != "alice" {
+		t.Fatalf("alias = %q, want alice", auth.Alias)
+	}
+}
+
+func TestLoginFailover(t *testing.T) {
+	d := newDirectory(t, false)
+	config := directoryConfig(d)
+	config["url"] = "ldap://127.0.0.1:1," + d.url()
+	m := newTestMethod(t, config)
+
+	if _, err := m.login("alice", "alicepw"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tag classes
+const (
+	classUniversal   = 0x00
+	classApplication = 0x40
+	classContext     = 0x80
+)
+
+// Universal tag numbers
+const (
+	tagBoolean     = 1
+	tagInteger     = 2
+	tagOctetString = 4
+	tagEnumerated  = 10
+	tagSequence    = 16
+	tagSet         = 17
+)
+
+// maxPacketSize bounds a single LDAP message read from the server
+const maxPacketSize = 16 << 20
+
+// packet is a BER element. Primitive elements carry value; constructed
+// elements carry children.
+type packet struct {
+	class       byte
+	constructed bool
+	tag         byte
+	value       []byte
+	children    []*packet
+}
+
+func newSequence(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSequence, children: children}
+}
+
+func newSet(children ...*packet) *packet {
+	return &packet{class: classUniversal, constructed: true, tag: tagSet, children: children}
+}
+
+func newString(s string) *packet {
+	return &packet{class: classUniversal, tag: tagOctetString, value: []byte(s)}
+}
+
+func newInteger(tag byte, n int64) *packet {
+	// Minimal two's complement encoding
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		if (n >= -128 && n < 128) || len(value) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return &packet{class: classUniversal, tag: tag, value: value}
+}
+
+func newBoolean(b bool) *packet {
+	value := byte(0)
+	if b {
+		value = 0xff
+	}
+	return &packet{class: classUniversal, tag: tagBoolean, value: []byte{value}}
+}
+
+// tagged returns a copy of p with an application or context tag
+func tagged(class, tag byte, p *packet) *packet {
+	return &packet{class: class, constructed: p.constructed, tag: tag, value: p.value, children: p.children}
+}
+
+// encode returns the DER-style encoding of p. Tags above 30 are never
+// needed by LDAP.
+func (p *packet) encode() []byte {
+	content := p.value
+	if p.constructed {
+		content = nil
+		for _, child := range p.children {
+			content = append(content, child.encode()...)
+		}
+	}
+
+	id := p.class | p.tag
+	if p.constructed {
+		id |= 0x20
+	}
+	out := []byte{id}
+
+	n := len(content)
+	if n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+// readPacket reads one BER element from r. io.EOF is only returned when
+// r ends before the element starts.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	id, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := readElement(r, id)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return p, err
+}
+
+func readElement(r *bufio.Reader, id byte) (*packet, error) {
+	if id&0x1f == 0x1f {
+		return nil, errors.New("ldap: high tag numbers are not supported")
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first&0x80 != 0 {
+		size := int(first & 0x7f)
+		if size == 0 || size > 4 {
+			return nil, errors.New("ldap: unsupported length encoding")
+		}
+		length = 0
+		for i := 0; i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPacketSize {
+		return nil, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parsePacket(id, content)
+}
+
+func parsePacket(id byte, content []byte) (*packet, error) {
+	p := &packet{class: id & 0xc0, constructed: id&0x20 != 0, tag: id & 0x1f}
+	if !p.constructed {
+		p.value = content
+		return p, nil
+	}
+
+	r := bufio.NewReader(bytes.NewReader(content))
+	for {
+		child, err := readPacket(r)
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("ldap: truncated element")
+			}
+			return nil, err
+		}
+		p.children = append(p.children, child)
+	}
+}
+
+// str returns the value of a primitive element as a string
+func (p *packet) str() string {
+	return string(p.value)
+}
+
+// integer decodes an INTEGER or ENUMERATED value
+func (p *packet) integer() (int64, error) {
+	if len(p.value) == 0 || len(p.value) > 8 {
+		return 0, errors.New("ldap: invalid integer")
+	}
+	n := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
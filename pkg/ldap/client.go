@@ -0,0 +1,326 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Protocol operation tags (RFC 4511)
+const (
+	opBindRequest       = 0
+	opBindResponse      = 1
+	opUnbindRequest     = 2
+	opSearchRequest     = 3
+	opSearchResultEntry = 4
+	opSearchResultDone  = 5
+	opSearchResultRef   = 19
+	opExtendedRequest   = 23
+	opExtendedResponse  = 24
+)
+
+// Result codes the client checks for
+const (
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+// Search scopes
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// startTLSOID is the extended operation that upgrades a connection
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ErrInvalidCredentials is returned when a bind is refused because of a
+// wrong DN or password
+var ErrInvalidCredentials = errors.New("ldap: invalid credentials")
+
+// ResultError is an LDAP result other than success
+type ResultError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// Entry is a search result
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Conn is a synchronous LDAPv3 client connection. It is not safe for
+// concurrent use.
+type Conn struct {
+	// host is the server name verified by StartTLS
+	host    string
+	conn    net.Conn
+	reader  *bufio.Reader
+	nextID  int64
+	timeout time.Duration
+}
+
+// Dial connects to an ldap:// or ldaps:// URL. tlsConfig is used for
+// ldaps and for StartTLS; its ServerName defaults to the URL's host.
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, withServerName(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{host: u.Hostname(), conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func withServerName(config *tls.Config, host string) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// StartTLS upgrades a plain connection to TLS. tlsConfig's ServerName
+// defaults to the host that was dialed.
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	op := &packet{class: classApplication, constructed: true, tag: opExtendedRequest, children: []*packet{
+		{class: classContext, tag: 0, value: []byte(startTLSOID)},
+	}}
+	if _, err := c.request(op, opExtendedResponse); err != nil {
+		return err
+	}
+
+	conn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	c.setDeadline()
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// Bind authenticates with a simple bind. An empty password makes an
+// unauthenticated bind, which servers accept for any DN, so callers
+// checking a user's password must reject empty passwords first.
+func (c *Conn) Bind(dn, password string) error {
+	op := &packet{class: classApplication, constructed: true, tag: opBindRequest, children: []*packet{
+		newInteger(tagInteger, 3),
+		newString(dn),
+		{class: classContext, tag: 0, value: []byte(password)},
+	}}
+	_, err := c.request(op, opBindResponse)
+
+	var result *ResultError
+	if errors.As(err, &result) && result.Code == resultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+// Search returns the entries below base matching filter, with the given
+// attributes
+func (c *Conn) Search(base string, scope int, filter string, attributes []string) ([]*Entry, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := newSequence()
+	for _, attr := range attributes {
+		attrs.children = append(attrs.children, newString(attr))
+	}
+
+	op := &packet{class: classApplication, constructed: true, tag: opSearchRequest, children: []*packet{
+		newString(base),
+		newInteger(tagEnumerated, int64(scope)),
+		newInteger(tagEnumerated, 0), // never dereference aliases
+		newInteger(tagInteger, 0),    // no size limit
+		newInteger(tagInteger, int64(c.timeout/time.Second)),
+		newBoolean(false),
+		compiled,
+		attrs,
+	}}
+
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		resp, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.tag {
+		case opSearchResultEntry:
+			entry, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchResultRef:
+			// Referrals to other servers are not followed
+		case opSearchResultDone:
+			if err := resultError(resp); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response %d to search", resp.tag)
+		}
+	}
+}
+
+// Close sends an unbind request and closes the connection
+func (c *Conn) Close() error {
+	c.send(&packet{class: classApplication, tag: opUnbindRequest})
+	return c.conn.Close()
+}
+
+// request sends an operation and reads its single response
+func (c *Conn) request(op *packet, responseTag byte) (*packet, error) {
+	id, err := c.send(op)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if resp.tag != responseTag {
+		return nil, fmt.Errorf("ldap: unexpected response %d", resp.tag)
+	}
+	return resp, resultError(resp)
+}
+
+func (c *Conn) send(op *packet) (int64, error) {
+	c.nextID++
+	msg := newSequence(newInteger(tagInteger, c.nextID), op)
+
+	c.setDeadline()
+	_, err := c.conn.Write(msg.encode())
+	return c.nextID, err
+}
+
+// receive reads the next message, which must answer message id, and
+// returns its protocol operation
+func (c *Conn) receive(id int64) (*packet, error) {
+	c.setDeadline()
+	msg, err := readPacket(c.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.tag != tagSequence || len(msg.children) < 2 {
+		return nil, errors.New("ldap: malformed message")
+	}
+	msgID, err := msg.children[0].integer()
+	if err != nil {
+		return nil, err
+	}
+	if msgID != id {
+		return nil, fmt.Errorf("ldap: response to message %d while waiting for %d", msgID, id)
+	}
+
+	op := msg.children[1]
+	if op.class != classApplication {
+		return nil, errors.New("ldap: malformed message")
+	}
+	return op, nil
+}
+
+func (c *Conn) setDeadline() {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+// resultError returns the error carried by an LDAPResult, if any
+func resultError(op *packet) error {
+	if len(op.children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	code, err := op.children[0].integer()
+	if err != nil {
+		return err
+	}
+	if code != resultSuccess {
+		return &ResultError{Code: code, Message: op.children[2].str()}
+	}
+	return nil
+}
+
+func parseEntry(op *packet) (*Entry, error) {
+	if len(op.children) < 2 {
+		return nil, errors.New("ldap: malformed search entry")
+	}
+
+	entry := &Entry{DN: op.children[0].str(), Attributes: make(map[string][]string)}
+	for _, attr := range op.children[1].children {
+		if len(attr.children) < 2 {
+			return nil, errors.New("ldap: malformed search entry")
+		}
+		name := attr.children[0].str()
+		for _, value := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.str())
+		}
+	}
+	return entry, nil
+}
+
+// Attribute returns the values of an attribute, matching its name
+// without regard to case as LDAP does
+func (e *Entry) Attribute(name string) []string {
+	if values, ok := e.Attributes[name]; ok {
+		return values
+	}
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
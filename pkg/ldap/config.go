@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// Keys inside the method's storage view
+const (
+	configPath  = "config"
+	groupPrefix = "group/"
+	userPrefix  = "user/"
+)
+
+// Defaults for unset config fields. The filters are templates over
+// filterData.
+const (
+	defaultUserAttr       = "cn"
+	defaultUserFilter     = "({{.UserAttr}}={{.Username}})"
+	defaultGroupFilter    = "(|(memberUid={{.Username}})(member={{.UserDN}})(uniqueMember={{.UserDN}}))"
+	defaultGroupAttr      = "cn"
+	defaultRequestTimeout = 90 * time.Second
+)
+
+var (
+	// ErrGroupNotFound is returned when a group mapping does not exist
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrUserNotFound is returned when a user mapping does not exist
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// Config says how to reach the directory and find users and groups
+type Config struct {
+	// URL is a comma-separated list of ldap:// or ldaps:// URLs, tried in
+	// order
+	URL         string `json:"url"`
+	StartTLS    bool   `json:"starttls"`
+	InsecureTLS bool   `json:"insecure_tls"`
+	// Certificate is the PEM CA bundle used to verify the server
+	Certificate string `json:"certificate"`
+
+	// BindDN and BindPass are used to search for users and groups;
+	// without them the searches are anonymous
+	BindDN   string `json:"binddn"`
+	BindPass string `json:"bindpass"`
+
+	UserDN     string `json:"userdn"`
+	UserAttr   string `json:"userattr"`
+	UserFilter string `json:"userfilter"`
+
+	GroupDN     string `json:"groupdn"`
+	GroupFilter string `json:"groupfilter"`
+	GroupAttr   string `json:"groupattr"`
+
+	// DenyNullBind rejects logins with an empty password, which the
+	// directory would accept as an unauthenticated bind
+	DenyNullBind   bool          `json:"deny_null_bind"`
+	RequestTimeout time.Duration `json:"request_timeout"`
+
+	TokenPolicies []string      `json:"token_policies"`
+	TokenTTL      time.Duration `json:"token_ttl"`
+	TokenMaxTTL   time.Duration `json:"token_max_ttl"`
+}
+
+// defaultConfig returns a config with every default applied
+func defaultConfig() *Config {
+	return &Config{
+		UserAttr:       defaultUserAttr,
+		UserFilter:     defaultUserFilter,
+		GroupFilter:    defaultGroupFilter,
+		GroupAttr:      defaultGroupAttr,
+		DenyNullBind:   true,
+		RequestTimeout: defaultRequestTimeout,
+	}
+}
+
+// filterData is what the user and group filter templates can refer to.
+// Values are escaped for use in a filter.
+type filterData struct {
+	UserAttr string
+	Username string
+	UserDN   string
+}
+
+// renderFilter fills in a filter template
+func renderFilter(filter string, data filterData) (string, error) {
+	tmpl, err := template.New("filter").Option("missingkey=error").Parse(filter)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	escaped := filterData{
+		UserAttr: data.UserAttr,
+		Username: EscapeFilter(data.Username),
+		UserDN:   EscapeFilter(data.UserDN),
+	}
+	if err := tmpl.Execute(&b, escaped); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validate checks the config and that its filters render to valid
+// filters
+func (c *Config) validate() error {
+	if c.URL == "" {
+		return errors.New("missing url")
+	}
+	for _, u := range c.urls() {
+		if !strings.HasPrefix(u, "ldap://") && !strings.HasPrefix(u, "ldaps://") {
+			return fmt.Errorf("url %q must start with ldap:// or ldaps://", u)
+		}
+	}
+	if c.UserDN == "" {
+		return errors.New("missing userdn")
+	}
+	if (c.BindDN == "") != (c.BindPass == "") {
+		return errors.New("binddn and bindpass must be set together")
+	}
+	if c.Certificate != "" {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(c.Certificate)) {
+			return errors.New("could not parse certificate")
+		}
+	}
+
+	sample := filterData{UserAttr: c.UserAttr, Username: "user", UserDN: "cn=user"}
+	for name, filter := range map[string]string{"userfilter": c.UserFilter, "groupfilter": c.GroupFilter} {
+		rendered, err := renderFilter(filter, sample)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if _, err := compileFilter(rendered); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+
+	if c.TokenMaxTTL > 0 && c.TokenTTL > c.TokenMaxTTL {
+		return errors.New("token_ttl cannot exceed token_max_ttl")
+	}
+	return nil
+}
+
+func (c *Config) urls() []string {
+	var urls []string
+	for _, u := range strings.Split(c.URL, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (c *Config) tlsConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.InsecureTLS}
+	if c.Certificate != "" {
+		config.RootCAs = x509.NewCertPool()
+		config.RootCAs.AppendCertsFromPEM([]byte(c.Certificate))
+	}
+	return config
+}
+
+// dial connects to the first reachable server, upgrading the connection
+// with StartTLS when configured
+func (c *Config) dial() (*Conn, error) {
+	var errs []error
+	for _, u := range c.urls() {
+		conn, err := Dial(u, c.tlsConfig(), c.RequestTimeout)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if c.StartTLS && strings.HasPrefix(u, "ldap://") {
+			if err := conn.StartTLS(c.tlsConfig()); err != nil {
+				conn.Close()
+				errs = append(errs, err)
+				continue
+			}
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("could not connect to LDAP: %w", errors.Join(errs...))
+}
+
+// Mapping gives policies to a directory group or user. Users can also be
+// placed in extra groups.
+type Mapping struct {
+	Policies []string `json:"policies"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+func loadConfig(store storage.Storage) (*Config, error) {
+	data, err := store.Get(configPath)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := defaultConfig()
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func saveConfig(store storage.Storage, config *Config) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return store.Put(configPath, data)
+}
+
+func loadMapping(store storage.Storage, prefix, name string) (*Mapping, error) {
+	data, err := store.Get(prefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		if prefix == groupPrefix {
+			return nil, ErrGroupNotFound
+		}
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m Mapping
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func saveMapping(store storage.Storage, prefix, name string, m *Mapping) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return store.Put(prefix+name, data)
+}
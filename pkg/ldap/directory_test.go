@@ -0,0 +1,270 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// directory is an in-process LDAP stand-in. It speaks enough LDAPv3 over
+// BER for the client: simple binds, StartTLS, and searches with and, or,
+// not, equality and presence filters.
+type directory struct {
+	listener net.Listener
+	// tlsConfig serves StartTLS, and every connection when implicit
+	tlsConfig *tls.Config
+	implicit  bool
+	// caPEM is the certificate clients verify the directory with
+	caPEM string
+
+	// passwords maps bind DNs to their passwords
+	passwords map[string]string
+	entries   []*Entry
+
+	mu   sync.Mutex
+	log  []directoryEvent
+	done sync.WaitGroup
+}
+
+// directoryEvent records a bind or search and whether it was protected
+// by TLS
+type directoryEvent struct {
+	op  string
+	dn  string
+	tls bool
+}
+
+// newDirectory starts a directory. With implicit TLS, connections are
+// TLS from the start, as for ldaps.
+func newDirectory(t *testing.T, implicit bool) *directory {
+	t.Helper()
+	d := &directory{
+		implicit: implicit,
+		passwords: map[string]string{
+			"cn=search,ou=svc,dc=example,dc=com":   "searchpw",
+			"uid=alice,ou=users,dc=example,dc=com": "alicepw",
+			"uid=bob,ou=users,dc=example,dc=com":   "bobpw",
+		},
+		entries: []*Entry{
+			{DN: "uid=alice,ou=users,dc=example,dc=com", Attributes: map[string][]string{"uid": {"alice"}, "cn": {"Alice"}}},
+			{DN: "uid=bob,ou=users,dc=example,dc=com", Attributes: map[string][]string{"uid": {"bob"}, "cn": {"Bob"}}},
+			{DN: "cn=admins,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+				"cn": {"Admins"}, "member": {"uid=alice,ou=users,dc=example,dc=com"},
+			}},
+			{DN: "cn=devs,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+				"cn": {"devs"}, "memberUid": {"alice", "bob"},
+			}},
+			{DN: "cn=ops,ou=groups,dc=example,dc=com", Attributes: map[string][]string{
+				"cn": {"ops"}, "uniqueMember": {"uid=bob,ou=users,dc=example,dc=com"},
+			}},
+		},
+	}
+	d.tlsConfig, d.caPEM = selfSignedTLS(t)
+
+	var err error
+	if implicit {
+		d.listener, err = tls.Listen("tcp", "127.0.0.1:0", d.tlsConfig)
+	} else {
+		d.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.done.Add(1)
+	go d.serve()
+	t.Cleanup(func() {
+		d.listener.Close()
+		d.done.Wait()
+	})
+	return d
+}
+
+// url returns the URL clients connect to
+func (d *directory) url() string {
+	if d.implicit {
+		return "ldaps://" + d.listener.Addr().String()
+	}
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *directory) events() []directoryEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]directoryEvent{}, d.log...)
+}
+
+func (d *directory) record(op, dn string, tls bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, directoryEvent{op: op, dn: dn, tls: tls})
+}
+
+func (d *directory) serve() {
+	defer d.done.Done()
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		d.done.Add(1)
+		go func() {
+			defer d.done.Done()
+			defer conn.Close()
+			d.handle(conn)
+		}()
+	}
+}
+
+// handle answers the requests of one connection until it is closed
+func (d *directory) handle(conn net.Conn) {
+	secure := d.implicit
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	for {
+		msg, err := readPacket(reader)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id, err := msg.children[0].integer()
+		if err != nil {
+			return
+		}
+		op := msg.children[1]
+
+		reply := func(tag byte, children ...*packet) {
+			resp := &packet{class: classApplication, constructed: true, tag: tag, children: children}
+			conn.Write(newSequence(newInteger(tagInteger, id), resp).encode())
+		}
+		result := func(code int64) []*packet {
+			return []*packet{newInteger(tagEnumerated, code), newString(""), newString("")}
+		}
+
+		switch op.tag {
+		case opBindRequest:
+			if len(op.children) < 3 {
+				return
+			}
+			dn, password := op.children[1].str(), op.children[2].str()
+			d.record("bind", dn, secure)
+			code := int64(resultSuccess)
+			if want, ok := d.passwords[dn]; !ok || password == "" || password != want {
+				code = resultInvalidCredentials
+			}
+			reply(opBindResponse, result(code)...)
+
+		case opExtendedRequest:
+			if secure || len(op.children) < 1 || op.children[0].str() != startTLSOID {
+				reply(opExtendedResponse, result(2)...)
+				continue
+			}
+			reply(opExtendedResponse, result(resultSuccess)...)
+			tlsConn := tls.Server(conn, d.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+
+		case opSearchRequest:
+			if len(op.children) < 8 {
+				return
+			}
+			base := op.children[0].str()
+			d.record("search", base, secure)
+			for _, entry := range d.entries {
+				if !strings.HasSuffix(strings.ToLower(entry.DN), ","+strings.ToLower(base)) || !matches(op.children[6], entry) {
+					continue
+				}
+				attrs := newSequence()
+				for name, values := range entry.Attributes {
+					set := newSet()
+					for _, value := range values {
+						set.children = append(set.children, newString(value))
+					}
+					attrs.children = append(attrs.children, newSequence(newString(name), set))
+				}
+				reply(opSearchResultEntry, newString(entry.DN), attrs)
+			}
+			reply(opSearchResultDone, result(resultSuccess)...)
+
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+// matches evaluates a compiled search filter against an entry. Values
+// are compared without regard to case.
+func matches(filter *packet, entry *Entry) bool {
+	switch filter.tag {
+	case filterAnd:
+		for _, child := range filter.children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(filter.children) == 1 && !matches(filter.children[0], entry)
+	case filterPresent:
+		return len(entry.Attribute(filter.str())) > 0
+	case filterEqualityMatch:
+		if len(filter.children) != 2 {
+			return false
+		}
+		want := filter.children[1].str()
+		for _, value := range entry.Attribute(filter.children[0].str()) {
+			if strings.EqualFold(value, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// selfSignedTLS returns a server config with a certificate for 127.0.0.1
+// and the certificate as PEM
+func selfSignedTLS(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "directory"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return config, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,176 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Filter choice tags (RFC 4511 section 4.5.1)
+const (
+	filterAnd            = 0
+	filterOr             = 1
+	filterNot            = 2
+	filterEqualityMatch  = 3
+	filterSubstrings     = 4
+	filterGreaterOrEqual = 5
+	filterLessOrEqual    = 6
+	filterPresent        = 7
+	filterApproxMatch    = 8
+)
+
+// EscapeFilter escapes a value for use inside a search filter
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter parses an RFC 4515 string filter such as
+// "(&(objectClass=person)(uid=alice))"
+func compileFilter(filter string) (*packet, error) {
+	p, rest, err := parseFilter(strings.TrimSpace(filter))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: unexpected %q after filter", rest)
+	}
+	return p, nil
+}
+
+func parseFilter(s string) (*packet, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("ldap: filter %q must start with (", s)
+	}
+	s = s[1:]
+
+	if s == "" {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	switch s[0] {
+	case '&', '|':
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		set := &packet{class: classContext, constructed: true, tag: tag}
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			child, rest, err := parseFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			set.children = append(set.children, child)
+			s = rest
+		}
+		if !strings.HasPrefix(s, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return set, s[1:], nil
+
+	case '!':
+		child, rest, err := parseFilter(s[1:])
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", fmt.Errorf("ldap: unterminated filter")
+		}
+		return &packet{class: classContext, constructed: true, tag: filterNot, children: []*packet{child}}, rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("ldap: unterminated filter")
+	}
+	item, err := parseItem(s[:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return item, s[end+1:], nil
+}
+
+// parseItem parses a simple filter item such as "uid=alice", "cn=*",
+// "cn=a*b" or "uidNumber>=1000"
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("ldap: invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	tag := byte(filterEqualityMatch)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApproxMatch, attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\") {
+		return nil, fmt.Errorf("ldap: invalid attribute in filter item %q", item)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return &packet{class: classContext, tag: filterPresent, value: []byte(attr)}, nil
+	}
+
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		subs := newSequence()
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+			unescaped, err := unescapeFilterValue(part)
+			if err != nil {
+				return nil, err
+			}
+			subTag := byte(1) // any
+			switch i {
+			case 0:
+				subTag = 0 // initial
+			case len(parts) - 1:
+				subTag = 2 // final
+			}
+			subs.children = append(subs.children, &packet{class: classContext, tag: subTag, value: []byte(unescaped)})
+		}
+		return &packet{class: classContext, constructed: true, tag: filterSubstrings, children: []*packet{newString(attr), subs}}, nil
+	}
+
+	unescaped, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &packet{class: classContext, constructed: true, tag: tag, children: []*packet{newString(attr), newString(unescaped)}}, nil
+}
+
+// unescapeFilterValue decodes the \XX escapes of a filter value
+func unescapeFilterValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in filter value %q", value)
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
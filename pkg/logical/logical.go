@@ -0,0 +1,304 @@
+package logical
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/storage"
+)
+
+// Operation is the kind of request made against a secrets engine
+type Operation string
+
+// Operations a secrets engine can handle
+const (
+	ReadOperation   Operation = "read"
+	CreateOperation Operation = "create"
+	UpdateOperation Operation = "update"
+	DeleteOperation Operation = "delete"
+	ListOperation   Operation = "list"
+	// RevokeOperation is sent to a Revoker when a lease ends
+	RevokeOperation Operation = "revoke"
+)
+
+var (
+	// ErrUnsupportedPath is returned for paths an engine does not handle
+	ErrUnsupportedPath = errors.New("unsupported path")
+	// ErrUnsupportedOperation is returned for operations a path does not support
+	ErrUnsupportedOperation = errors.New("unsupported operation")
+	// ErrInvalidRequest is matched by errors caused by bad request input
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrInvalidCredentials is matched by errors returned for failed logins
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// requestError carries a message about bad request input
+type requestError struct {
+	msg string
+}
+
+func (e *requestError) Error() string { return e.msg }
+
+func (e *requestError) Is(target error) bool { return target == ErrInvalidRequest }
+
+// InvalidRequest returns an error that matches ErrInvalidRequest
+func InvalidRequest(format string, args ...interface{}) error {
+	return &requestError{msg: fmt.Sprintf(format, args...)}
+}
+
+// credentialsError carries a message about a failed login
+type credentialsError struct {
+	msg string
+}
+
+func (e *credentialsError) Error() string { return e.msg }
+
+func (e *credentialsError) Is(target error) bool { return target == ErrInvalidCredentials }
+
+// InvalidCredentials returns an error that matches ErrInvalidCredentials
+func InvalidCredentials(format string, args ...interface{}) error {
+	return &credentialsError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Request is a request routed to a secrets engine
+type Request struct {
+	Operation Operation
+	// Path is relative to the mount point
+	Path string
+	// MountPoint is the path the engine is mounted at, with a trailing slash
+	MountPoint string
+	Data       map[string]interface{}
+	// Storage is the engine's private view of the barrier
+	Storage storage.Storage
+	// Secret is the leased secret being revoked, for revoke requests
+	Secret *Secret
+	// RemoteAddr is the IP address of the client
+	RemoteAddr string
+	// PeerCertificates are the certificates the client presented on its
+	// TLS connection, leaf first. They have not been verified.
+	PeerCertificates []*x509.Certificate
+	// MFACredentials are the passcodes sent with the request, keyed by
+	// MFA method name. The vault checks them on login; auth methods can
+	// ignore them.
+	MFACredentials map[string]string
+}
+
+// Response is the result of a request. Data is encoded as the JSON body.
+// Responses carrying a Secret are leased. Auth methods return Auth on a
+// successful login, and the vault issues a token for it.
+type Response struct {
+	Data   interface{}
+	Secret *Secret
+	Auth   *Auth
+}
+
+// Secret describes the lease of a secret returned by an engine
+type Secret struct {
+	// LeaseID is assigned by the vault when the lease is registered
+	LeaseID string
+	// TTL is the requested lease duration; zero uses the mount default
+	TTL       time.Duration
+	Renewable bool
+	// InternalData is stored with the lease and returned on revocation
+	InternalData map[string]interface{}
+}
+
+// Auth describes the token to issue for a successful login
+type Auth struct {
+	Policies []string
+	// TTL is the requested token TTL; zero uses the mount default
+	TTL time.Duration
+	// MaxTTL caps the lifetime of the token across renewals
+	MaxTTL    time.Duration
+	Period    time.Duration
+	NumUses   int
+	Renewable bool
+	// Metadata is attached to the token and shown by lookups
+	Metadata map[string]string
+	// Alias names the principal that logged in, such as the username,
+	// so that the vault can recognize it across logins
+	Alias string
+	// Consume, if set, is called once the login has passed MFA and its
+	// token has been issued, so that single-use credentials are only
+	// spent on logins that succeed. An error fails the login and revokes
+	// the token.
+	Consume func() error
+}
+
+// Backend is a secrets engine mounted in the mount table
+type Backend interface {
+	HandleRequest(req *Request) (*Response, error)
+}
+
+// ExistenceChecker is implemented by engines that distinguish create from
+// update, so that policies can grant one without the other
+type ExistenceChecker interface {
+	Exists(req *Request) (bool, error)
+}
+
+// Revoker is implemented by engines that must clean up a leased secret
+// when its lease is revoked or expires
+type Revoker interface {
+	Revoke(req *Request) error
+}
+
+// LoginHandler is implemented by auth methods. Requests to login paths
+// are served without a token. Paths may end in "*" to match any suffix.
+type LoginHandler interface {
+	LoginPaths() []string
+}
+
+// AliasResolver is implemented by auth methods that can tell who a login
+// request is for without checking its credentials, so that failed logins
+// can be counted per user. LoginAlias returns the name failures are
+// counted under, such as the username, or "" if the request names no
+// known user.
+type AliasResolver interface {
+	LoginAlias(req *Request) string
+}
+
+// BackendConfig is passed to a factory when an engine is mounted
+type BackendConfig struct {
+	MountPoint string
+	Storage    storage.Storage
+	Options    map[string]string
+}
+
+// Factory creates an engine instance for a mount
+type Factory func(config *BackendConfig) (Backend, error)
+
+// Decode copies the request data into a struct using its JSON tags
+func (r *Request) Decode(out interface{}) error {
+	data, err := json.Marshal(r.Data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return InvalidRequest("invalid request data: %v", err)
+	}
+	return nil
+}
+
+// GetInt returns an integer field from the request data. Fields may be
+// JSON numbers or, for query parameters, numeric strings.
+func (r *Request) GetInt(key string) (int, bool, error) {
+	raw, ok := r.Data[key]
+	if !ok || raw == nil {
+		return 0, false, nil
+	}
+
+	switch value := raw.(type) {
+	case float64:
+		return int(value), true, nil
+	case int:
+		return value, true, nil
+	case json.Number:
+		n, err := strconv.Atoi(value.String())
+		if err != nil {
+			return 0, false, InvalidRequest("invalid %s", key)
+		}
+		return n, true, nil
+	case string:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false, InvalidRequest("invalid %s", key)
+		}
+		return n, true, nil
+	}
+	return 0, false, InvalidRequest("invalid %s", key)
+}
+
+// GetBool returns a boolean field from the request data. Fields may be
+// JSON booleans or, for query parameters and CLI input, "true" or
+// "false".
+func (r *Request) GetBool(key string) (bool, bool, error) {
+	switch value := r.Data[key].(type) {
+	case nil:
+		return false, false, nil
+	case bool:
+		return value, true, nil
+	case string:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, false, InvalidRequest("invalid %s", key)
+		}
+		return b, true, nil
+	}
+	return false, false, InvalidRequest("invalid %s", key)
+}
+
+// GetString returns a string field from the request data
+func (r *Request) GetString(key string) string {
+	value, _ := r.Data[key].(string)
+	return value
+}
+
+// GetStrings returns a list field from the request data, given either as
+// a JSON array or a comma-separated string
+func (r *Request) GetStrings(key string) ([]string, error) {
+	var values []string
+	switch raw := r.Data[key].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		for _, value := range strings.Split(raw, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	case []interface{}:
+		for _, item := range raw {
+			value, ok := item.(string)
+			if !ok {
+				return nil, InvalidRequest("invalid %s", key)
+			}
+			values = append(values, value)
+		}
+	default:
+		return nil, InvalidRequest("invalid %s", key)
+	}
+	return values, nil
+}
+
+// GetTTL returns a TTL field from the request data
+func (r *Request) GetTTL(key string) (time.Duration, error) {
+	ttl, err := ParseTTL(r.Data[key])
+	if err != nil {
+		return 0, InvalidRequest("invalid %s: %v", key, err)
+	}
+	if ttl < 0 {
+		return 0, InvalidRequest("%s cannot be negative", key)
+	}
+	return ttl, nil
+}
+
+// ParseTTL parses a TTL given as a number of seconds, a numeric string
+// or a duration string
+func ParseTTL(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return time.Duration(v) * time.Second, nil
+	case json.Number:
+		seconds, err := v.Int64()
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		if seconds, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		return time.ParseDuration(v)
+	}
+	return 0, errors.New("must be a number of seconds or a duration string")
+}
@@ -0,0 +1,301 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Capabilities that can be granted on a path
+const (
+	CreateCapability = "create"
+	ReadCapability   = "read"
+	UpdateCapability = "update"
+	DeleteCapability = "delete"
+	ListCapability   = "list"
+	DenyCapability   = "deny"
+	SudoCapability   = "sudo"
+)
+
+// RootPolicy is the built-in policy that grants every capability on every path
+const RootPolicy = "root"
+
+// ResponseWrappingPolicy is the only policy of response wrapping tokens.
+// It is reserved and grants nothing, so a wrapping token can only be
+// unwrapped, looked up or rewrapped.
+const ResponseWrappingPolicy = "response-wrapping"
+
+var validCapabilities = map[string]bool{
+	CreateCapability: true,
+	ReadCapability:   true,
+	UpdateCapability: true,
+	DeleteCapability: true,
+	ListCapability:   true,
+	DenyCapability:   true,
+	SudoCapability:   true,
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// PathRules lists the capabilities granted on a path pattern.
+// MFAMethods names MFA methods the token must have passed before it can
+// use them.
+type PathRules struct {
+	Capabilities []string `json:"capabilities"`
+	MFAMethods   []string `json:"mfa_methods,omitempty"`
+}
+
+// Policy is a named set of path rules. Path patterns may end in "*" to
+// match any suffix and may use "+" to match exactly one path segment.
+type Policy struct {
+	Name  string                `json:"name"`
+	Paths map[string]*PathRules `json:"path"`
+	Raw   string                `json:"-"`
+}
+
+// Parse parses a JSON policy document of the form
+//
+//	{"path": {"secret/data/app/*": {"capabilities": ["read"]}}}
+func Parse(name, raw string) (*Policy, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Paths map[string]*PathRules `json:"path"`
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %v", err)
+	}
+
+	for path, rules := range doc.Paths {
+		if path == "" {
+			return nil, errors.New("policy path cannot be empty")
+		}
+		if strings.Contains(strings.TrimSuffix(path, "*"), "*") {
+			return nil, fmt.Errorf("path %q: '*' is only allowed at the end", path)
+		}
+		if rules == nil || len(rules.Capabilities) == 0 {
+			return nil, fmt.Errorf("path %q: no capabilities given", path)
+		}
+		for _, capability := range rules.Capabilities {
+			if !validCapabilities[capability] {
+				return nil, fmt.Errorf("path %q: invalid capability %q", path, capability)
+			}
+		}
+		for _, method := range rules.MFAMethods {
+			if !validName.MatchString(method) {
+				return nil, fmt.Errorf("path %q: invalid MFA method name %q", path, method)
+			}
+		}
+	}
+
+	return &Policy{Name: name, Paths: doc.Paths, Raw: raw}, nil
+}
+
+// ValidateName checks that a policy name is usable and not reserved
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return errors.New("invalid policy name")
+	}
+	if name == RootPolicy || name == ResponseWrappingPolicy {
+		return fmt.Errorf("cannot modify the %s policy", name)
+	}
+	return nil
+}
+
+// ACL is the combined view of a set of policies used to authorize requests
+type ACL struct {
+	root  bool
+	rules map[string]map[string]bool
+	mfa   map[string][]string
+}
+
+// NewACL merges policies into a single ACL. Rules for the same path in
+// different policies are combined; "deny" on a path overrides everything
+// else granted on it, and the MFA methods they require are all required.
+func NewACL(policies ...*Policy) *ACL {
+	acl := &ACL{rules: make(map[string]map[string]bool), mfa: make(map[string][]string)}
+
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+		if p.Name == RootPolicy {
+			acl.root = true
+			continue
+		}
+		for path, rules := range p.Paths {
+			caps, ok := acl.rules[path]
+			if !ok {
+				caps = make(map[string]bool)
+				acl.rules[path] = caps
+			}
+			for _, capability := range rules.Capabilities {
+				caps[capability] = true
+			}
+			for _, method := range rules.MFAMethods {
+				if !slices.Contains(acl.mfa[path], method) {
+					acl.mfa[path] = append(acl.mfa[path], method)
+				}
+			}
+		}
+	}
+
+	return acl
+}
+
+// Capabilities returns the capabilities granted on a path by the most
+// specific matching rule
+func (a *ACL) Capabilities(path string) []string {
+	if a.root {
+		return []string{RootPolicy}
+	}
+
+	best, found := a.bestMatch(path)
+	if !found {
+		return []string{DenyCapability}
+	}
+
+	caps := a.rules[best]
+	if caps[DenyCapability] {
+		return []string{DenyCapability}
+	}
+
+	result := make([]string, 0, len(caps))
+	for capability := range caps {
+		result = append(result, capability)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Allowed reports whether a capability is granted on a path
+func (a *ACL) Allowed(path, capability string) bool {
+	if a.root {
+		return true
+	}
+
+	for _, granted := range a.Capabilities(path) {
+		if granted == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// MFAMethods returns the MFA methods required on a path by the most
+// specific matching rule. The root policy grants every capability but
+// does not lift the MFA methods the other policies require.
+func (a *ACL) MFAMethods(path string) []string {
+	best, found := a.bestMatch(path)
+	if !found {
+		return nil
+	}
+	methods := slices.Clone(a.mfa[best])
+	sort.Strings(methods)
+	return methods
+}
+
+// bestMatch returns the most specific pattern matching a path
+func (a *ACL) bestMatch(path string) (string, bool) {
+	var best string
+	found := false
+	for pattern := range a.rules {
+		if !matches(pattern, path) {
+			continue
+		}
+		if !found || moreSpecific(pattern, best) {
+			best = pattern
+			found = true
+		}
+	}
+	return best, found
+}
+
+// IsRoot reports whether the ACL includes the root policy
+func (a *ACL) IsRoot() bool {
+	return a.root
+}
+
+// matches reports whether a path pattern matches a request path
+func matches(pattern, path string) bool {
+	glob := strings.HasSuffix(pattern, "*")
+	if glob {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	if !strings.Contains(pattern, "+") {
+		if glob {
+			return strings.HasPrefix(path, pattern)
+		}
+		return path == pattern
+	}
+
+	patternSegs := strings.Split(pattern, "/")
+	pathSegs := strings.Split(path, "/")
+	if len(pathSegs) < len(patternSegs) {
+		return false
+	}
+	if !glob && len(pathSegs) != len(patternSegs) {
+		return false
+	}
+
+	for i, seg := range patternSegs {
+		last := i == len(patternSegs)-1
+		switch {
+		case seg == "+":
+			if pathSegs[i] == "" {
+				return false
+			}
+		case last && glob:
+			if !strings.HasPrefix(pathSegs[i], seg) {
+				return false
+			}
+		case seg != pathSegs[i]:
+			return false
+		}
+	}
+
+	return true
+}
+
+// moreSpecific reports whether pattern a should take precedence over b
+func moreSpecific(a, b string) bool {
+	// A later first wildcard means a longer literal prefix
+	wa, wb := firstWildcard(a), firstWildcard(b)
+	if wa != wb {
+		return wa > wb
+	}
+
+	// Exact segments beat a trailing glob
+	ga, gb := strings.HasSuffix(a, "*"), strings.HasSuffix(b, "*")
+	if ga != gb {
+		return !ga
+	}
+
+	// Fewer single-segment wildcards
+	pa, pb := strings.Count(a, "+"), strings.Count(b, "+")
+	if pa != pb {
+		return pa < pb
+	}
+
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
+
+func firstWildcard(pattern string) int {
+	if i := strings.IndexAny(pattern, "+*"); i >= 0 {
+		return i
+	}
+	return len(pattern)
+}
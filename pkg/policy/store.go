@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"vault-clone/pkg/storage"
+)
+
+// policyPrefix is the storage prefix for policy documents
+const policyPrefix = "sys/policy/"
+
+// ErrPolicyNotFound is returned when a named policy does not exist
+var ErrPolicyNotFound = errors.New("policy not found")
+
+// storedPolicy is the persisted form of a policy
+type storedPolicy struct {
+	Name   string `json:"name"`
+	Policy string `json:"policy"`
+}
+
+// Store persists named policies and caches parsed copies in memory
+type Store struct {
+	mu      sync.RWMutex
+	storage storage.Storage
+	cache   map[string]*Policy
+}
+
+// NewStore creates a policy store on top of a storage backend
+func NewStore(store storage.Storage) *Store {
+	return &Store{
+		storage: store,
+		cache:   make(map[string]*Policy),
+	}
+}
+
+// Get returns a policy by name
+func (s *Store) Get(name string) (*Policy, error) {
+	name = strings.ToLower(name)
+	if name == RootPolicy {
+		return &Policy{Name: RootPolicy}, nil
+	}
+
+	s.mu.RLock()
+	p, ok := s.cache[name]
+	s.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+
+	data, err := s.storage.Get(policyPrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrPolicyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored storedPolicy
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	p, err = Parse(stored.Name, stored.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[name] = p
+	s.mu.Unlock()
+
+	return p, nil
+}
+
+// Put stores or replaces a policy
+func (s *Store) Put(p *Policy) error {
+	if err := ValidateName(p.Name); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(storedPolicy{Name: p.Name, Policy: p.Raw})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.storage.Put(policyPrefix+p.Name, data); err != nil {
+		return err
+	}
+
+	s.cache[p.Name] = p
+	return nil
+}
+
+// Delete removes a policy
+func (s *Store) Delete(name string) error {
+	name = strings.ToLower(name)
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.cache, name)
+	if err := s.storage.Delete(policyPrefix + name); err != nil {
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return ErrPolicyNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// List returns the names of all policies, including the built-in root policy
+func (s *Store) List() ([]string, error) {
+	keys, err := s.storage.List(policyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{RootPolicy}
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, policyPrefix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ACL builds an ACL from the named policies. Policies that no longer
+// exist are skipped so deleting a policy revokes what it granted.
+func (s *Store) ACL(names []string) (*ACL, error) {
+	var policies []*Policy
+	for _, name := range names {
+		p, err := s.Get(name)
+		if errors.Is(err, ErrPolicyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	return NewACL(policies...), nil
+}
+
+// ClearCache drops all cached policies
+func (s *Store) ClearCache() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = make(map[string]*Policy)
+}
@@ -0,0 +1,185 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+)
+
+const (
+	// ShareOverhead is the number of bytes a share adds to the secret (the x coordinate)
+	ShareOverhead = 1
+	// MaxShares is the largest number of shares that can be produced over GF(256)
+	MaxShares = 255
+)
+
+// Split divides a secret into parts shares, any threshold of which can
+// reconstruct it. Each share is the secret length plus one trailing byte
+// holding the share's x coordinate.
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if parts < threshold {
+		return nil, errors.New("parts cannot be less than threshold")
+	}
+	if parts > MaxShares {
+		return nil, errors.New("parts cannot exceed 255")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("cannot split an empty secret")
+	}
+
+	// Pick distinct, non-zero x coordinates in random order
+	xCoords, err := randomCoordinates(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+ShareOverhead)
+		shares[i][len(secret)] = xCoords[i]
+	}
+
+	// Build a fresh random polynomial for every byte of the secret,
+	// with the secret byte as the constant term
+	coefficients := make([]byte, threshold)
+	for idx, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for i := range shares {
+			shares[i][idx] = evaluate(coefficients, xCoords[i])
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine reconstructs a secret from at least threshold shares
+func Combine(parts [][]byte) ([]byte, error) {
+	if len(parts) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	shareLen := len(parts[0])
+	if shareLen <= ShareOverhead {
+		return nil, errors.New("shares are too short")
+	}
+
+	xCoords := make([]byte, len(parts))
+	seen := make(map[byte]bool, len(parts))
+	for i, part := range parts {
+		if len(part) != shareLen {
+			return nil, errors.New("all shares must be the same length")
+		}
+		x := part[shareLen-1]
+		if x == 0 {
+			return nil, errors.New("invalid share: x coordinate is zero")
+		}
+		if seen[x] {
+			return nil, errors.New("duplicate share detected")
+		}
+		seen[x] = true
+		xCoords[i] = x
+	}
+
+	secret := make([]byte, shareLen-ShareOverhead)
+	yCoords := make([]byte, len(parts))
+	for idx := range secret {
+		for i, part := range parts {
+			yCoords[i] = part[idx]
+		}
+		secret[idx] = interpolate(xCoords, yCoords)
+	}
+
+	return secret, nil
+}
+
+// evaluate computes the polynomial at x using Horner's method
+func evaluate(coefficients []byte, x byte) byte {
+	var out byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		out = add(mult(out, x), coefficients[i])
+	}
+	return out
+}
+
+// interpolate returns the Lagrange interpolation of the points at x = 0
+func interpolate(xCoords, yCoords []byte) byte {
+	var result byte
+	for i := range xCoords {
+		basis := byte(1)
+		for j := range xCoords {
+			if i == j {
+				continue
+			}
+			num := xCoords[j]
+			denom := add(xCoords[i], xCoords[j])
+			basis = mult(basis, div(num, denom))
+		}
+		result = add(result, mult(yCoords[i], basis))
+	}
+	return result
+}
+
+// randomCoordinates returns n distinct values in 1..255
+func randomCoordinates(n int) ([]byte, error) {
+	coords := make([]byte, MaxShares)
+	for i := range coords {
+		coords[i] = byte(i + 1)
+	}
+
+	// Fisher-Yates shuffle using crypto/rand, with every index as likely
+	// as the others
+	for i := len(coords) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return nil, err
+		}
+		coords[i], coords[j.Int64()] = coords[j.Int64()], coords[i]
+	}
+
+	return coords[:n], nil
+}
+
+// add adds two elements of GF(256); addition is XOR
+func add(a, b byte) byte {
+	return a ^ b
+}
+
+// mult multiplies two elements of GF(256) modulo x^8 + x^4 + x^3 + x + 1.
+// It always runs eight rounds so timing does not depend on the operands.
+func mult(a, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		mask := -(b & 1)
+		product ^= a & mask
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+	return product
+}
+
+// inverse returns the multiplicative inverse of a (a^254)
+func inverse(a byte) byte {
+	result := byte(1)
+	base := a
+	for exp := 254; exp > 0; exp >>= 1 {
+		if exp&1 == 1 {
+			result = mult(result, base)
+		}
+		base = mult(base, base)
+	}
+	return result
+}
+
+// div divides a by b in GF(256)
+func div(a, b byte) byte {
+	return mult(a, inverse(b))
+}
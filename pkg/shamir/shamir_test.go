@@ -0,0 +1,111 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func randomSecret(t *testing.T) []byte {
+	t.Helper()
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestSplitCombine(t *testing.T) {
+	for _, tc := range []struct{ parts, threshold int }{
+		{2, 2}, {3, 2}, {5, 3}, {7, 7}, {10, 4}, {MaxShares, 2}, {MaxShares, 32},
+	} {
+		secret := randomSecret(t)
+		shares, err := Split(secret, tc.parts, tc.threshold)
+		if err != nil {
+			t.Fatalf("Split(%d, %d): %v", tc.parts, tc.threshold, err)
+		}
+		if len(shares) != tc.parts {
+			t.Fatalf("Split(%d, %d) returned %d shares", tc.parts, tc.threshold, len(shares))
+		}
+
+		// Any threshold of the shares recovers the secret, and so do all
+		// of them
+		for _, subset := range [][][]byte{
+			shares[:tc.threshold],
+			shares[tc.parts-tc.threshold:],
+			shares,
+		} {
+			recovered, err := Combine(subset)
+			if err != nil {
+				t.Fatalf("Combine of %d/%d shares: %v", len(subset), tc.parts, err)
+			}
+			if !bytes.Equal(recovered, secret) {
+				t.Fatalf("Combine of %d shares of a %d/%d split did not recover the secret", len(subset), tc.threshold, tc.parts)
+			}
+		}
+
+		// One share fewer than the threshold reveals nothing
+		if tc.threshold > 2 {
+			recovered, err := Combine(shares[:tc.threshold-1])
+			if err != nil {
+				t.Fatalf("Combine: %v", err)
+			}
+			if bytes.Equal(recovered, secret) {
+				t.Fatalf("%d shares of a %d/%d split recovered the secret", tc.threshold-1, tc.threshold, tc.parts)
+			}
+		}
+	}
+}
+
+func TestSplitInvalid(t *testing.T) {
+	secret := randomSecret(t)
+	for _, tc := range []struct {
+		secret           []byte
+		parts, threshold int
+	}{
+		{secret, 2, 3},
+		{secret, MaxShares + 1, 2},
+		{secret, 3, 1},
+		{nil, 3, 2},
+	} {
+		if _, err := Split(tc.secret, tc.parts, tc.threshold); err == nil {
+			t.Fatalf("Split of a %d-byte secret into %d/%d succeeded", len(tc.secret), tc.threshold, tc.parts)
+		}
+	}
+}
+
+func TestCombineInvalid(t *testing.T) {
+	shares, err := Split(randomSecret(t), 5, 3)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+
+	zero := bytes.Clone(shares[1])
+	zero[len(zero)-1] = 0
+
+	for name, parts := range map[string][][]byte{
+		"one share":        shares[:1],
+		"duplicate share":  {shares[0], shares[1], shares[0]},
+		"different length": {shares[0], shares[1], shares[2][1:]},
+		"too short":        {{1}, {2}},
+		"zero coordinate":  {shares[0], zero, shares[2]},
+	} {
+		if _, err := Combine(parts); err == nil {
+			t.Fatalf("Combine with %s succeeded", name)
+		}
+	}
+}
+
+func TestRandomCoordinates(t *testing.T) {
+	coords, err := randomCoordinates(MaxShares)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[byte]bool)
+	for _, x := range coords {
+		if x == 0 || seen[x] {
+			t.Fatalf("coordinates %v are not distinct and non-zero", coords)
+		}
+		seen[x] = true
+	}
+}
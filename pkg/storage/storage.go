@@ -8,6 +8,9 @@ import (
 	"sync"
 )
 
+// ErrKeyNotFound is returned when a key does not exist in the backend
+var ErrKeyNotFound = errors.New("key not found")
+
 // Storage represents the storage backend interface
 type Storage interface {
 	Get(key string) ([]byte, error)
@@ -49,7 +52,7 @@ func (fs *FileStorage) Get(key string) ([]byte, error) {
 
 	value, exists := fs.data[key]
 	if !exists {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	}
 
 	return value, nil
@@ -70,7 +73,7 @@ func (fs *FileStorage) Delete(key string) error {
 	defer fs.mu.Unlock()
 
 	if _, exists := fs.data[key]; !exists {
-		return errors.New("key not found")
+		return ErrKeyNotFound
 	}
 
 	delete(fs.data, key)
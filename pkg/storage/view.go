@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+// View restricts a storage backend to the keys under a prefix. Keys passed
+// to and returned from a view are relative to the prefix, so a view can
+// neither read nor list anything outside of it.
+type View struct {
+	backend Storage
+	prefix  string
+}
+
+// NewView creates a view of backend rooted at prefix
+func NewView(backend Storage, prefix string) *View {
+	return &View{backend: backend, prefix: prefix}
+}
+
+// Prefix returns the prefix of the view in the underlying backend
+func (v *View) Prefix() string {
+	return v.prefix
+}
+
+// Get retrieves a value by key
+func (v *View) Get(key string) ([]byte, error) {
+	return v.backend.Get(v.prefix + key)
+}
+
+// Put stores a key-value pair
+func (v *View) Put(key string, value []byte) error {
+	return v.backend.Put(v.prefix+key, value)
+}
+
+// Delete removes a key
+func (v *View) Delete(key string) error {
+	return v.backend.Delete(v.prefix + key)
+}
+
+// List returns all keys with the given prefix, relative to the view
+func (v *View) List(prefix string) ([]string, error) {
+	keys, err := v.backend.List(v.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, v.prefix)
+	}
+	return keys, nil
+}
+
+// Clear deletes every key in the view
+func (v *View) Clear() error {
+	keys, err := v.List("")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := v.Delete(key); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// logFileName is the name of the append-only log inside the base path
+	logFileName = "vault.wal"
+	// legacyFileName is the snapshot written by FileStorage
+	legacyFileName = "vault.db"
+
+	// recordHeaderSize is checksum (4) + op (1) + key length (4) + value length (4)
+	recordHeaderSize = 13
+	// maxRecordSize guards against allocating huge buffers for a corrupt header
+	maxRecordSize = 64 << 20
+
+	// compactMinRecords is the log size below which compaction is never attempted
+	compactMinRecords = 1024
+	// compactRatio triggers compaction once the log holds this many records per live key
+	compactRatio = 4
+)
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrRecordTooLarge is returned for writes whose key and value together
+// exceed the largest record the log can replay
+var ErrRecordTooLarge = errors.New("key and value exceed the maximum record size")
+
+// errTornRecord is returned by decodeRecord when the log ends inside a
+// record
+var errTornRecord = errors.New("truncated record")
+
+// LogStorage implements an append-only, log-structured storage backend.
+// Every mutation is appended as a checksummed record and fsynced before
+// returning. The log is replayed into memory on startup and rewritten
+// with only live keys once it grows too large.
+type LogStorage struct {
+	basePath string
+	mu       sync.RWMutex
+	data     map[string][]byte
+	file     *os.File
+	records  int
+}
+
+// NewLogStorage opens (or creates) a log-structured storage backend.
+// An existing FileStorage snapshot is imported on first open.
+func NewLogStorage(basePath string) (*LogStorage, error) {
+	if err := os.MkdirAll(basePath, 0700); err != nil {
+		return nil, err
+	}
+
+	ls := &LogStorage{
+		basePath: basePath,
+		data:     make(map[string][]byte),
+	}
+
+	logPath := ls.logPath()
+	_, statErr := os.Stat(logPath)
+	logExists := statErr == nil
+
+	if !logExists {
+		if err := ls.importLegacy(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	ls.file = file
+
+	if logExists {
+		if err := ls.replay(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	// Write imported data (or shrink an oversized log) before serving requests
+	if (!logExists && len(ls.data) > 0) || ls.needsCompaction() {
+		if err := ls.compact(); err != nil {
+			ls.file.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := ls.file.Seek(0, io.SeekEnd); err != nil {
+		ls.file.Close()
+		return nil, err
+	}
+
+	return ls, nil
+}
+
+// Get retrieves a value by key
+func (ls *LogStorage) Get(key string) ([]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	value, exists := ls.data[key]
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+
+	return value, nil
+}
+
+// Put stores a value by key
+func (ls *LogStorage) Put(key string, value []byte) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if err := ls.append(opPut, key, value); err != nil {
+		return err
+	}
+
+	ls.data[key] = value
+	ls.maybeCompact()
+	return nil
+}
+
+// Delete removes a value by key
+func (ls *LogStorage) Delete(key string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if _, exists := ls.data[key]; !exists {
+		return ErrKeyNotFound
+	}
+
+	if err := ls.append(opDelete, key, nil); err != nil {
+		return err
+	}
+
+	delete(ls.data, key)
+	ls.maybeCompact()
+	return nil
+}
+
+// List returns all keys with the given prefix
+func (ls *LogStorage) List(prefix string) ([]string, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var keys []string
+	for key := range ls.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Compact rewrites the log so it contains a single record per live key
+func (ls *LogStorage) Compact() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.compact()
+}
+
+// Close closes the underlying log file
+func (ls *LogStorage) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.file.Close()
+}
+
+func (ls *LogStorage) logPath() string {
+	return filepath.Join(ls.basePath, logFileName)
+}
+
+// append writes a single record to the end of the log and fsyncs it.
+// A record that could not be written completely is cut off again, so
+// that the records appended after it can still be replayed.
+func (ls *LogStorage) append(op byte, key string, value []byte) error {
+	if uint64(len(key))+uint64(len(value)) > maxRecordSize {
+		return ErrRecordTooLarge
+	}
+
+	offset, err := ls.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	_, err = ls.file.Write(encodeRecord(op, key, value))
+	if err == nil {
+		err = ls.file.Sync()
+	}
+	if err != nil {
+		return errors.Join(err, ls.rollback(offset))
+	}
+	ls.records++
+	return nil
+}
+
+// rollback truncates the log to an offset and continues writing there
+func (ls *LogStorage) rollback(offset int64) error {
+	if err := ls.file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to remove partial record: %w", err)
+	}
+	if _, err := ls.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to remove partial record: %w", err)
+	}
+	return ls.file.Sync()
+}
+
+func (ls *LogStorage) needsCompaction() bool {
+	return ls.records >= compactMinRecords && ls.records > compactRatio*len(ls.data)
+}
+
+// maybeCompact compacts the log once it has grown too large. It runs
+// after a write has already been applied, so a failure is only logged
+// and compaction is tried again on the next write.
+func (ls *LogStorage) maybeCompact() {
+	if !ls.needsCompaction() {
+		return
+	}
+	if err := ls.compact(); err != nil {
+		log.Printf("storage: compaction of %s failed: %v", ls.logPath(), err)
+	}
+}
+
+// compact writes the live data set to a temporary log and atomically
+// renames it over the current one
+func (ls *LogStorage) compact() error {
+	tmpPath := ls.logPath() + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for key, value := range ls.data {
+		if _, err := w.Write(encodeRecord(opPut, key, value)); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, ls.logPath()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncDir(ls.basePath); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	ls.file.Close()
+	ls.file = tmp
+	ls.records = len(ls.data)
+
+	_, err = ls.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// replay reads every record from the log into memory. A bad record with
+// no valid record after it was torn by a crash while it was appended,
+// and the log is truncated to the last good record. A bad record
+// anywhere else is corruption: replay fails rather than drop the
+// records after it.
+func (ls *LogStorage) replay() error {
+	info, err := ls.file.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := ls.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(ls.file)
+	var offset int64
+	for {
+		op, key, value, n, err := decodeRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// A corrupt length can make any record look like the last
+			// one, so only the records that follow tell a torn tail apart
+			followed, scanErr := ls.recordAfter(offset, info.Size())
+			if scanErr != nil {
+				return scanErr
+			}
+			if !followed {
+				return ls.file.Truncate(offset)
+			}
+			return fmt.Errorf("%s is corrupt at offset %d: %w", ls.logPath(), offset, err)
+		}
+
+		switch op {
+		case opPut:
+			ls.data[key] = value
+		case opDelete:
+			delete(ls.data, key)
+		}
+		ls.records++
+		offset += n
+	}
+}
+
+// recordAfter reports whether a valid record starts anywhere in the log
+// between offset and size, not counting offset itself
+func (ls *LogStorage) recordAfter(offset, size int64) (bool, error) {
+	if size <= offset+1 {
+		return false, nil
+	}
+	rest := make([]byte, size-offset-1)
+	if _, err := ls.file.ReadAt(rest, offset+1); err != nil {
+		return false, err
+	}
+	for i := range rest {
+		if validRecord(rest[i:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// validRecord reports whether b starts with a complete record whose
+// checksum matches
+func validRecord(b []byte) bool {
+	if len(b) < recordHeaderSize {
+		return false
+	}
+	if op := b[4]; op != opPut && op != opDelete {
+		return false
+	}
+	size := uint64(recordHeaderSize) + uint64(binary.BigEndian.Uint32(b[5:9])) + uint64(binary.BigEndian.Uint32(b[9:13]))
+	if size > uint64(len(b)) {
+		return false
+	}
+	return crc32.Checksum(b[4:size], crcTable) == binary.BigEndian.Uint32(b[0:4])
+}
+
+// importLegacy loads a FileStorage snapshot if one exists
+func (ls *LogStorage) importLegacy() error {
+	data, err := os.ReadFile(filepath.Join(ls.basePath, legacyFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &ls.data)
+}
+
+func encodeRecord(op byte, key string, value []byte) []byte {
+	buf := make([]byte, recordHeaderSize+len(key)+len(value))
+	buf[4] = op
+	binary.BigEndian.PutUint32(buf[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(value)))
+	copy(buf[recordHeaderSize:], key)
+	copy(buf[recordHeaderSize+len(key):], value)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crcTable))
+	return buf
+}
+
+// decodeRecord reads one record and returns its size in bytes. io.EOF is
+// returned only when the reader is exhausted on a record boundary, and
+// errTornRecord when it is exhausted inside a record.
+func decodeRecord(r io.Reader) (byte, string, []byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return 0, "", nil, 0, io.EOF
+		}
+		return 0, "", nil, 0, errTornRecord
+	}
+
+	op := header[4]
+	keyLen := binary.BigEndian.Uint32(header[5:9])
+	valueLen := binary.BigEndian.Uint32(header[9:13])
+	if op != opPut && op != opDelete {
+		return 0, "", nil, 0, errors.New("invalid record op")
+	}
+	if uint64(keyLen)+uint64(valueLen) > maxRecordSize {
+		return 0, "", nil, 0, errors.New("record too large")
+	}
+
+	body := make([]byte, int(keyLen)+int(valueLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, "", nil, 0, errTornRecord
+	}
+
+	crc := crc32.Update(crc32.Checksum(header[4:], crcTable), crcTable, body)
+	if crc != binary.BigEndian.Uint32(header[0:4]) {
+		return 0, "", nil, 0, errors.New("record checksum mismatch")
+	}
+
+	key := string(body[:keyLen])
+	var value []byte
+	if op == opPut {
+		value = body[keyLen:]
+	}
+
+	return op, key, value, int64(recordHeaderSize) + int64(len(body)), nil
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
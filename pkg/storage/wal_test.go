@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openLog(t *testing.T, dir string) *LogStorage {
+	t.Helper()
+	ls, err := NewLogStorage(dir)
+	if err != nil {
+		t.Fatalf("NewLogStorage: %v", err)
+	}
+	t.Cleanup(func() { ls.Close() })
+	return ls
+}
+
+func put(t *testing.T, ls *LogStorage, key, value string) {
+	t.Helper()
+	if err := ls.Put(key, []byte(value)); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, ls *LogStorage, key, want string) {
+	t.Helper()
+	got, err := ls.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if string(got) != want {
+		t.Fatalf("Get(%q) = %q, want %q", key, got, want)
+	}
+}
+
+func expectMissing(t *testing.T, ls *LogStorage, key string) {
+	t.Helper()
+	if _, err := ls.Get(key); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get(%q) error = %v, want ErrKeyNotFound", key, err)
+	}
+}
+
+func TestLogStorageReplay(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+	put(t, ls, "b", "2")
+	put(t, ls, "a", "3")
+	if err := ls.Delete("b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	ls.Close()
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "3")
+	expectMissing(t, ls, "b")
+}
+
+func TestLogStorageTornTail(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+	put(t, ls, "b", "2")
+	ls.Close()
+
+	// Cut the last record in half, as a crash during its write would
+	logPath := filepath.Join(dir, logFileName)
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(logPath, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "1")
+	expectMissing(t, ls, "b")
+
+	// Writes after recovery must survive the next replay
+	put(t, ls, "c", "3")
+	ls.Close()
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "1")
+	expectValue(t, ls, "c", "3")
+}
+
+func TestLogStorageTornTailChecksum(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+	put(t, ls, "b", "2")
+	ls.Close()
+
+	// A complete last record with a bad checksum was torn as well
+	corruptByte(t, filepath.Join(dir, logFileName), -1)
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "1")
+	expectMissing(t, ls, "b")
+}
+
+func TestLogStorageCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+	put(t, ls, "b", "2")
+	put(t, ls, "c", "3")
+	ls.Close()
+
+	// Corrupt the value of the middle record
+	recordSize := int64(recordHeaderSize + 2)
+	corruptByte(t, filepath.Join(dir, logFileName), 2*recordSize-1)
+
+	if _, err := NewLogStorage(dir); err == nil {
+		t.Fatal("NewLogStorage succeeded on a log corrupt in the middle")
+	}
+
+	// The records after the corruption must not have been dropped
+	info, err := os.Stat(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 3*recordSize {
+		t.Fatalf("log size = %d, want %d", info.Size(), 3*recordSize)
+	}
+}
+
+func TestLogStorageCorruptLength(t *testing.T) {
+	recordSize := int64(recordHeaderSize + 2)
+	// The low byte of the middle record's value length makes it run past
+	// the end of the log, the high byte makes it claim to be too large
+	for _, offset := range []int64{recordSize + 12, recordSize + 9} {
+		dir := t.TempDir()
+		ls := openLog(t, dir)
+		put(t, ls, "a", "1")
+		put(t, ls, "b", "2")
+		put(t, ls, "c", "3")
+		ls.Close()
+
+		corruptByte(t, filepath.Join(dir, logFileName), offset)
+
+		if _, err := NewLogStorage(dir); err == nil {
+			t.Fatalf("NewLogStorage succeeded on a log with a corrupt length at offset %d", offset)
+		}
+
+		info, err := os.Stat(filepath.Join(dir, logFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 3*recordSize {
+			t.Fatalf("log size = %d, want %d", info.Size(), 3*recordSize)
+		}
+	}
+}
+
+func TestLogStorageRecordTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+
+	err := ls.Put("big", make([]byte, maxRecordSize))
+	if !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Put error = %v, want ErrRecordTooLarge", err)
+	}
+	expectMissing(t, ls, "big")
+
+	put(t, ls, "b", "2")
+	ls.Close()
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "1")
+	expectValue(t, ls, "b", "2")
+}
+
+func TestLogStorageRollback(t *testing.T) {
+	dir := t.TempDir()
+	ls := openLog(t, dir)
+	put(t, ls, "a", "1")
+
+	// Simulate a short write of a record, then the rollback append does
+	offset, err := ls.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := encodeRecord(opPut, "lost", []byte("value"))
+	if _, err := ls.file.Write(partial[:len(partial)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ls.rollback(offset); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	put(t, ls, "b", "2")
+	ls.Close()
+
+	ls = openLog(t, dir)
+	expectValue(t, ls, "a", "1")
+	expectValue(t, ls, "b", "2")
+	expectMissing(t, ls, "lost")
+}
+
+// corruptByte flips the bits of the byte at an offset of a file. Negative
+// offsets count from the end.
+func corruptByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 0 {
+		offset += int64(len(data))
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,333 @@
+package totp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// keyPrefix is where keys are stored in the engine's storage view
+const keyPrefix = "key/"
+
+// Defaults for generated keys
+const (
+	defaultKeySize = SecretSize
+	defaultSkew    = 1
+)
+
+// ErrKeyNotFound is returned when a named key does not exist
+var ErrKeyNotFound = errors.New("totp key not found")
+
+// backend is the TOTP secrets engine. It either generates keys and
+// validates the passcodes of the authenticator apps they are enrolled
+// in, or imports the keys of other services and generates their
+// passcodes. Paths are served relative to the mount point:
+//
+//	keys/<name>    create, read and delete keys
+//	code/<name>    read the current passcode or validate a passcode
+type backend struct {
+	// mu serializes the bookkeeping of used passcodes
+	mu sync.Mutex
+}
+
+// Key is a named TOTP secret with the parameters its passcodes use
+type Key struct {
+	Name        string        `json:"name"`
+	Issuer      string        `json:"issuer"`
+	AccountName string        `json:"account_name"`
+	Secret      []byte        `json:"secret"`
+	Algorithm   string        `json:"algorithm"`
+	Digits      int           `json:"digits"`
+	Period      time.Duration `json:"period"`
+	Skew        int           `json:"skew"`
+	// UsedCounters are the time steps of validated passcodes that are
+	// still inside the skew window, so that none is accepted twice
+	UsedCounters []uint64 `json:"used_counters,omitempty"`
+}
+
+// Factory creates a TOTP engine for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	action, name, _ := strings.Cut(req.Path, "/")
+
+	switch action {
+	case "keys":
+		if req.Operation == logical.ListOperation {
+			return b.listKeys(req)
+		}
+	case "code":
+	default:
+		return nil, logical.ErrUnsupportedPath
+	}
+
+	if name == "" || strings.Contains(name, "/") {
+		return nil, logical.InvalidRequest("missing key name")
+	}
+	if action == "keys" {
+		return b.handleKey(req, name)
+	}
+	return b.handleCode(req, name)
+}
+
+// Exists reports whether the key targeted by a key write exists, so that
+// creating a key requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	action, name, _ := strings.Cut(req.Path, "/")
+	if action != "keys" || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadKey(req.Storage, name)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) listKeys(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, keyPrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleKey(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		key, err := loadKey(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: keyInfo(key)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		return b.createKey(req, name)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return nil, req.Storage.Delete(keyPrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// createKey generates a key, returning the URL and secret to enroll it
+// in an authenticator app unless exported is false, or imports one from
+// a url or a base32 key
+func (b *backend) createKey(req *logical.Request, name string) (*logical.Response, error) {
+	var body struct {
+		Generate    bool   `json:"generate"`
+		Exported    *bool  `json:"exported"`
+		KeySize     int    `json:"key_size"`
+		URL         string `json:"url"`
+		Key         string `json:"key"`
+		Issuer      string `json:"issuer"`
+		AccountName string `json:"account_name"`
+		Algorithm   string `json:"algorithm"`
+		Digits      int    `json:"digits"`
+		Skew        *int   `json:"skew"`
+	}
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+	period, err := req.GetTTL("period")
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		Name:        name,
+		Issuer:      body.Issuer,
+		AccountName: body.AccountName,
+		Algorithm:   strings.ToUpper(body.Algorithm),
+		Digits:      body.Digits,
+		Period:      period,
+		Skew:        defaultSkew,
+	}
+	if key.Algorithm == "" {
+		key.Algorithm = DefaultParams.Algorithm
+	}
+	if key.Digits == 0 {
+		key.Digits = DefaultParams.Digits
+	}
+	if key.Period == 0 {
+		key.Period = DefaultParams.Period
+	}
+	if body.Skew != nil {
+		key.Skew = *body.Skew
+	}
+
+	switch {
+	case body.Generate:
+		if body.URL != "" || body.Key != "" {
+			return nil, logical.InvalidRequest("url and key cannot be given with generate")
+		}
+		if key.Issuer == "" || key.AccountName == "" {
+			return nil, logical.InvalidRequest("generated keys need an issuer and an account_name")
+		}
+		if body.KeySize == 0 {
+			body.KeySize = defaultKeySize
+		}
+		if body.KeySize < 16 || body.KeySize > 64 {
+			return nil, logical.InvalidRequest("key_size must be between 16 and 64 bytes")
+		}
+		key.Secret = make([]byte, body.KeySize)
+		if _, err := rand.Read(key.Secret); err != nil {
+			return nil, err
+		}
+	case body.URL != "":
+		var p Params
+		key.Issuer, key.AccountName, key.Secret, p, err = ParseURL(body.URL)
+		if err != nil {
+			return nil, logical.InvalidRequest("invalid url: %v", err)
+		}
+		key.Algorithm, key.Digits, key.Period = p.Algorithm, p.Digits, p.Period
+	case body.Key != "":
+		if key.Secret, err = DecodeSecret(body.Key); err != nil {
+			return nil, logical.InvalidRequest("invalid key: %v", err)
+		}
+	default:
+		return nil, logical.InvalidRequest("missing url or key, or generate")
+	}
+
+	if err := key.params().Validate(); err != nil {
+		return nil, logical.InvalidRequest("%v", err)
+	}
+	if key.Skew != 0 && key.Skew != 1 {
+		return nil, logical.InvalidRequest("skew must be 0 or 1")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := loadKey(req.Storage, name); err == nil {
+		return nil, logical.InvalidRequest("key %q already exists", name)
+	} else if !errors.Is(err, ErrKeyNotFound) {
+		return nil, err
+	}
+	if err := saveKey(req.Storage, key); err != nil {
+		return nil, err
+	}
+
+	if !body.Generate || (body.Exported != nil && !*body.Exported) {
+		return nil, nil
+	}
+	return &logical.Response{Data: map[string]interface{}{
+		"url": URL(key.Issuer, key.AccountName, key.Secret, key.params()),
+		"key": EncodeSecret(key.Secret),
+	}}, nil
+}
+
+// handleCode returns the current passcode of a key, or validates the
+// code of an update. A passcode is only accepted once.
+func (b *backend) handleCode(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		key, err := loadKey(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		code, err := Code(key.Secret, Counter(time.Now(), key.Period), key.params())
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: map[string]interface{}{"code": code}}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		code := req.GetString("code")
+		if code == "" {
+			return nil, logical.InvalidRequest("missing code")
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		key, err := loadKey(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		counter, valid := Validate(key.Secret, code, now, key.params(), key.Skew)
+		if valid {
+			if slices.Contains(key.UsedCounters, counter) {
+				return nil, logical.InvalidRequest("code already used; wait for the next one")
+			}
+			key.useCounter(counter, Counter(now, key.Period))
+			if err := saveKey(req.Storage, key); err != nil {
+				return nil, err
+			}
+		}
+		return &logical.Response{Data: map[string]interface{}{"valid": valid}}, nil
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (k *Key) params() Params {
+	return Params{Algorithm: k.Algorithm, Digits: k.Digits, Period: k.Period}
+}
+
+// useCounter records a used time step and forgets those that have left
+// the skew window around current
+func (k *Key) useCounter(counter, current uint64) {
+	k.UsedCounters = slices.DeleteFunc(k.UsedCounters, func(used uint64) bool {
+		return used+uint64(k.Skew) < current
+	})
+	k.UsedCounters = append(k.UsedCounters, counter)
+}
+
+// keyInfo describes a key without exposing its secret
+func keyInfo(k *Key) map[string]interface{} {
+	return map[string]interface{}{
+		"issuer":       k.Issuer,
+		"account_name": k.AccountName,
+		"algorithm":    k.Algorithm,
+		"digits":       k.Digits,
+		"period":       int64(k.Period / time.Second),
+		"skew":         k.Skew,
+	}
+}
+
+func loadKey(store storage.Storage, name string) (*Key, error) {
+	data, err := store.Get(keyPrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key Key
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func saveKey(store storage.Storage, key *Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return store.Put(keyPrefix+key.Name, data)
+}
@@ -0,0 +1,130 @@
+package totp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// testEngine is a TOTP engine with its storage
+type testEngine struct {
+	b     *backend
+	store storage.Storage
+}
+
+func newTestEngine(t *testing.T) *testEngine {
+	t.Helper()
+	store, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return &testEngine{b: &backend{}, store: store}
+}
+
+// request returns the data of the response to a request
+func (e *testEngine) request(op logical.Operation, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := e.b.HandleRequest(&logical.Request{Operation: op, Path: path, Data: data, Storage: e.store})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	out, _ := resp.Data.(map[string]interface{})
+	return out, nil
+}
+
+func TestGeneratedKeyCodes(t *testing.T) {
+	e := newTestEngine(t)
+	resp, err := e.request(logical.UpdateOperation, "keys/alice", map[string]interface{}{
+		"generate": true, "issuer": "Vault", "account_name": "alice@example.com",
+	})
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	// The authenticator app computes passcodes from the URL it enrolled
+	_, _, secret, p, err := ParseURL(resp["url"].(string))
+	if err != nil {
+		t.Fatalf("ParseURL: %v", err)
+	}
+	resp, err = e.request(logical.ReadOperation, "code/alice", nil)
+	if err != nil {
+		t.Fatalf("read code: %v", err)
+	}
+	code := resp["code"].(string)
+	if _, valid := Validate(secret, code, time.Now(), p, 1); !valid {
+		t.Fatalf("engine code %s does not match the enrolled secret", code)
+	}
+
+	validate := func(code string) (bool, error) {
+		resp, err := e.request(logical.UpdateOperation, "code/alice", map[string]interface{}{"code": code})
+		if err != nil {
+			return false, err
+		}
+		return resp["valid"].(bool), nil
+	}
+	if valid, err := validate(code); err != nil || !valid {
+		t.Fatalf("validate = %v, %v, want valid", valid, err)
+	}
+	if _, err := validate(code); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("second use of a code: %v, want an invalid request", err)
+	}
+	if valid, err := validate("000000x"); err != nil || valid {
+		t.Fatalf("validate of a wrong code = %v, %v, want invalid", valid, err)
+	}
+
+	// The secret is only returned when the key is generated
+	resp, err = e.request(logical.ReadOperation, "keys/alice", nil)
+	if err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	if _, ok := resp["key"]; ok {
+		t.Fatal("key read returned the secret")
+	}
+}
+
+func TestImportedKey(t *testing.T) {
+	e := newTestEngine(t)
+	_, err := e.request(logical.UpdateOperation, "keys/bob", map[string]interface{}{
+		"url": "otpauth://totp/Example:bob@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example&digits=8&period=60&algorithm=SHA256",
+	})
+	if err != nil {
+		t.Fatalf("import key: %v", err)
+	}
+
+	resp, err := e.request(logical.ReadOperation, "keys/bob", nil)
+	if err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	want := map[string]interface{}{
+		"issuer": "Example", "account_name": "bob@example.com",
+		"algorithm": SHA256, "digits": 8, "period": int64(60),
+	}
+	for field, value := range want {
+		if resp[field] != value {
+			t.Fatalf("%s = %v, want %v", field, resp[field], value)
+		}
+	}
+
+	secret, err := DecodeSecret("jbsw y3dp ehpk 3pxp")
+	if err != nil {
+		t.Fatalf("DecodeSecret: %v", err)
+	}
+	resp, err = e.request(logical.ReadOperation, "code/bob", nil)
+	if err != nil {
+		t.Fatalf("read code: %v", err)
+	}
+	p := Params{Algorithm: SHA256, Digits: 8, Period: 60 * time.Second}
+	if _, valid := Validate(secret, resp["code"].(string), time.Now(), p, 1); !valid {
+		t.Fatal("code does not match the imported secret")
+	}
+
+	if _, err := e.request(logical.UpdateOperation, "keys/bob", map[string]interface{}{"key": "JBSWY3DPEHPK3PXP"}); err == nil {
+		t.Fatal("created a key over an existing one")
+	}
+	if _, err := e.request(logical.ReadOperation, "keys/nobody", nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("read of a missing key: %v, want ErrKeyNotFound", err)
+	}
+}
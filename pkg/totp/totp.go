@@ -0,0 +1,209 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithms an authenticator app can compute codes with
+const (
+	SHA1   = "SHA1"
+	SHA256 = "SHA256"
+	SHA512 = "SHA512"
+)
+
+// SecretSize is the length of generated secrets, the HMAC-SHA1 block
+// size recommended by RFC 4226
+const SecretSize = 20
+
+// encoding is the unpadded base32 alphabet authenticator apps expect
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Params are the settings shared by the server and the authenticator
+type Params struct {
+	Algorithm string
+	Digits    int
+	Period    time.Duration
+}
+
+// DefaultParams are the settings every authenticator app supports
+var DefaultParams = Params{Algorithm: SHA1, Digits: 6, Period: 30 * time.Second}
+
+// Validate checks that the parameters are usable
+func (p Params) Validate() error {
+	if _, err := p.hash(); err != nil {
+		return err
+	}
+	if p.Digits != 6 && p.Digits != 8 {
+		return errors.New("digits must be 6 or 8")
+	}
+	if p.Period < time.Second || p.Period%time.Second != 0 {
+		return errors.New("period must be a positive number of seconds")
+	}
+	return nil
+}
+
+func (p Params) hash() (func() hash.Hash, error) {
+	switch p.Algorithm {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", p.Algorithm)
+}
+
+// GenerateSecret returns a random secret
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// EncodeSecret returns the base32 form of a secret that users can type
+// into an authenticator app
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// DecodeSecret parses the base32 form of a secret. Case, padding and the
+// spaces apps show secrets with are ignored.
+func DecodeSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+	secret, err := encoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(secret) == 0 {
+		return nil, errors.New("secret must be base32-encoded")
+	}
+	return secret, nil
+}
+
+// ParseURL reads the issuer, account name, secret and parameters from an
+// otpauth:// URL. Parameters the URL leaves out take the defaults.
+func ParseURL(raw string) (issuer, account string, secret []byte, p Params, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "otpauth" || u.Host != "totp" {
+		return "", "", nil, p, errors.New("not an otpauth://totp/ URL")
+	}
+
+	query := u.Query()
+	if secret, err = DecodeSecret(query.Get("secret")); err != nil {
+		return "", "", nil, p, err
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if before, after, ok := strings.Cut(label, ":"); ok {
+		issuer, account = before, strings.TrimSpace(after)
+	} else {
+		account = label
+	}
+	if query.Has("issuer") {
+		issuer = query.Get("issuer")
+	}
+
+	p = DefaultParams
+	if query.Has("algorithm") {
+		p.Algorithm = strings.ToUpper(query.Get("algorithm"))
+	}
+	if query.Has("digits") {
+		if p.Digits, err = strconv.Atoi(query.Get("digits")); err != nil {
+			return "", "", nil, p, errors.New("invalid digits")
+		}
+	}
+	if query.Has("period") {
+		seconds, err := strconv.Atoi(query.Get("period"))
+		if err != nil {
+			return "", "", nil, p, errors.New("invalid period")
+		}
+		p.Period = time.Duration(seconds) * time.Second
+	}
+	return issuer, account, secret, p, p.Validate()
+}
+
+// URL returns the otpauth:// URL that authenticator apps import,
+// usually by scanning it as a QR code
+func URL(issuer, account string, secret []byte, p Params) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", p.Algorithm)
+	query.Set("digits", strconv.Itoa(p.Digits))
+	query.Set("period", strconv.Itoa(int(p.Period/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+// Counter returns the time step t falls in
+func Counter(t time.Time, period time.Duration) uint64 {
+	return uint64(t.Unix()) / uint64(period/time.Second)
+}
+
+// Code returns the passcode for a time step (RFC 4226 and RFC 6238)
+func Code(secret []byte, counter uint64, p Params) (string, error) {
+	h, err := p.hash()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(h, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < p.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", p.Digits, value%mod), nil
+}
+
+// Validate checks a passcode against the time step now falls in and up
+// to skew steps on either side. It returns the step the passcode belongs
+// to, which callers record to refuse the same passcode a second time.
+func Validate(secret []byte, passcode string, now time.Time, p Params, skew int) (uint64, bool) {
+	passcode = strings.TrimSpace(passcode)
+	if len(passcode) != p.Digits {
+		return 0, false
+	}
+
+	current := Counter(now, p.Period)
+	for i := -skew; i <= skew; i++ {
+		if i < 0 && current < uint64(-i) {
+			continue
+		}
+		counter := current + uint64(i)
+		code, err := Code(secret, counter, p)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,516 @@
+package transit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// keyPrefix is where keys are stored in the engine's storage view
+const keyPrefix = "policy/"
+
+// ErrKeyNotFound is returned when a named key does not exist
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// backend is the transit encryption-as-a-service engine. Paths are served
+// relative to the mount point:
+//
+//	keys/<name>           create, read and delete keys
+//	keys/<name>/config    set min_decryption_version and deletion_allowed
+//	keys/<name>/rotate    add a new key version
+//	encrypt/<name>        encrypt plaintext, creating the key if needed
+//	decrypt/<name>        decrypt ciphertext
+//	rewrap/<name>         re-encrypt ciphertext with the latest version
+//	sign/<name>           sign input with an ed25519 key
+//	verify/<name>         verify a signature or HMAC
+//	hmac/<name>           compute an HMAC of input
+type backend struct {
+	// mu serializes changes to keys
+	mu sync.Mutex
+}
+
+// batchItem is one entry of a batch_input array
+type batchItem struct {
+	Plaintext  string `json:"plaintext"`
+	Ciphertext string `json:"ciphertext"`
+	Context    string `json:"context"`
+}
+
+// cryptoRequest is the body of encrypt, decrypt and rewrap requests
+type cryptoRequest struct {
+	batchItem
+	Type                 string      `json:"type"`
+	ConvergentEncryption bool        `json:"convergent_encryption"`
+	BatchInput           []batchItem `json:"batch_input"`
+}
+
+// Factory creates a transit engine for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	action, rest, _ := strings.Cut(req.Path, "/")
+
+	if action == "keys" {
+		if req.Operation == logical.ListOperation {
+			return b.listKeys(req)
+		}
+		name, sub, _ := strings.Cut(rest, "/")
+		if name == "" {
+			return nil, logical.InvalidRequest("missing key name")
+		}
+		switch sub {
+		case "":
+			return b.handleKey(req, name)
+		case "config":
+			return b.handleKeyConfig(req, name)
+		case "rotate":
+			return b.handleRotate(req, name)
+		}
+		return nil, logical.ErrUnsupportedPath
+	}
+
+	switch action {
+	case "encrypt", "decrypt", "rewrap", "sign", "verify", "hmac":
+	default:
+		return nil, logical.ErrUnsupportedPath
+	}
+
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+	if rest == "" || strings.Contains(rest, "/") {
+		return nil, logical.InvalidRequest("missing key name")
+	}
+
+	switch action {
+	case "encrypt":
+		return b.handleEncrypt(req, rest)
+	case "decrypt":
+		return b.handleDecrypt(req, rest)
+	case "rewrap":
+		return b.handleRewrap(req, rest)
+	case "sign":
+		return b.handleSign(req, rest)
+	case "verify":
+		return b.handleVerify(req, rest)
+	default:
+		return b.handleHMAC(req, rest)
+	}
+}
+
+// Exists reports whether the key targeted by a key write or an encrypt
+// request exists, so that creating a key requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	action, name, _ := strings.Cut(req.Path, "/")
+	if (action != "keys" && action != "encrypt") || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadKey(req.Storage, name)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) listKeys(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(keyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, keyPrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleKey(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		key, err := loadKey(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: keyInfo(key)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		var body struct {
+			Type                 string `json:"type"`
+			Derived              bool   `json:"derived"`
+			ConvergentEncryption bool   `json:"convergent_encryption"`
+		}
+		if err := req.Decode(&body); err != nil {
+			return nil, err
+		}
+		if body.Type == "" {
+			body.Type = KeyTypeAES256GCM96
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadKey(req.Storage, name); err == nil {
+			return nil, logical.InvalidRequest("key %q already exists", name)
+		} else if !errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+
+		key, err := newKey(name, body.Type, body.Derived, body.ConvergentEncryption)
+		if err != nil {
+			return nil, err
+		}
+		return nil, saveKey(req.Storage, key)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		key, err := loadKey(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if !key.DeletionAllowed {
+			return nil, logical.InvalidRequest("deletion is not allowed for this key")
+		}
+		return nil, req.Storage.Delete(keyPrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+func (b *backend) handleKeyConfig(req *logical.Request, name string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	var body struct {
+		MinDecryptionVersion *int  `json:"min_decryption_version"`
+		DeletionAllowed      *bool `json:"deletion_allowed"`
+	}
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return nil, b.updateKey(req.Storage, name, func(key *Key) error {
+		if body.MinDecryptionVersion != nil {
+			if err := key.SetMinDecryptionVersion(*body.MinDecryptionVersion); err != nil {
+				return err
+			}
+		}
+		if body.DeletionAllowed != nil {
+			key.DeletionAllowed = *body.DeletionAllowed
+		}
+		return nil
+	})
+}
+
+func (b *backend) handleRotate(req *logical.Request, name string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	return nil, b.updateKey(req.Storage, name, func(key *Key) error {
+		return key.Rotate()
+	})
+}
+
+func (b *backend) handleEncrypt(req *logical.Request, name string) (*logical.Response, error) {
+	var body cryptoRequest
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	key, err := loadKey(req.Storage, name)
+	if errors.Is(err, ErrKeyNotFound) && req.Operation == logical.CreateOperation {
+		key, err = b.upsertKey(req.Storage, name, &body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return batch(&body, func(item batchItem) (map[string]interface{}, error) {
+		plaintext, err := decodeBase64("plaintext", item.Plaintext)
+		if err != nil {
+			return nil, err
+		}
+		context, err := decodeBase64("context", item.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		ciphertext, err := key.Encrypt(plaintext, context)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"ciphertext": ciphertext, "key_version": key.LatestVersion}, nil
+	})
+}
+
+func (b *backend) handleDecrypt(req *logical.Request, name string) (*logical.Response, error) {
+	var body cryptoRequest
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	key, err := loadKey(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch(&body, func(item batchItem) (map[string]interface{}, error) {
+		context, err := decodeBase64("context", item.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		plaintext, err := key.Decrypt(item.Ciphertext, context)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}, nil
+	})
+}
+
+func (b *backend) handleRewrap(req *logical.Request, name string) (*logical.Response, error) {
+	var body cryptoRequest
+	if err := req.Decode(&body); err != nil {
+		return nil, err
+	}
+
+	key, err := loadKey(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return batch(&body, func(item batchItem) (map[string]interface{}, error) {
+		context, err := decodeBase64("context", item.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		plaintext, err := key.Decrypt(item.Ciphertext, context)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext, err := key.Encrypt(plaintext, context)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"ciphertext": ciphertext, "key_version": key.LatestVersion}, nil
+	})
+}
+
+func (b *backend) handleSign(req *logical.Request, name string) (*logical.Response, error) {
+	key, input, err := loadKeyAndInput(req, name)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := key.Sign(input)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: map[string]interface{}{
+		"signature":   signature,
+		"key_version": key.LatestVersion,
+	}}, nil
+}
+
+func (b *backend) handleVerify(req *logical.Request, name string) (*logical.Response, error) {
+	key, input, err := loadKeyAndInput(req, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var valid bool
+	signature, mac := req.GetString("signature"), req.GetString("hmac")
+	switch {
+	case signature != "" && mac != "":
+		return nil, logical.InvalidRequest("provide either signature or hmac, not both")
+	case signature != "":
+		valid, err = key.Verify(input, signature)
+	case mac != "":
+		valid, err = key.VerifyHMAC(input, mac, req.GetString("algorithm"))
+	default:
+		return nil, logical.InvalidRequest("missing signature or hmac")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{Data: map[string]interface{}{"valid": valid}}, nil
+}
+
+func (b *backend) handleHMAC(req *logical.Request, name string) (*logical.Response, error) {
+	key, input, err := loadKeyAndInput(req, name)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := key.HMAC(input, req.GetString("algorithm"))
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: map[string]interface{}{
+		"hmac":        mac,
+		"key_version": key.LatestVersion,
+	}}, nil
+}
+
+// upsertKey creates the key for an encrypt request to a missing key. The
+// key is derived when the request carries a context.
+func (b *backend) upsertKey(store storage.Storage, name string, body *cryptoRequest) (*Key, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Another request may have created the key meanwhile
+	if key, err := loadKey(store, name); !errors.Is(err, ErrKeyNotFound) {
+		return key, err
+	}
+
+	derived := body.Context != ""
+	for _, item := range body.BatchInput {
+		derived = derived || item.Context != ""
+	}
+
+	keyType := body.Type
+	if keyType == "" {
+		keyType = KeyTypeAES256GCM96
+	}
+	// The key would be saved before the encryption it was created for fails
+	if keyType == KeyTypeEd25519 || keyType == KeyTypeHMAC {
+		return nil, logical.InvalidRequest("key type %s does not support encryption", keyType)
+	}
+
+	key, err := newKey(name, keyType, derived, body.ConvergentEncryption)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveKey(store, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// updateKey applies a read-modify-write to a stored key
+func (b *backend) updateKey(store storage.Storage, name string, update func(*Key) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key, err := loadKey(store, name)
+	if err != nil {
+		return err
+	}
+
+	if err := update(key); err != nil {
+		return err
+	}
+
+	return saveKey(store, key)
+}
+
+// batch runs fn for the single item in body or for every entry of
+// batch_input. Errors in a batch are reported per item.
+func batch(body *cryptoRequest, fn func(batchItem) (map[string]interface{}, error)) (*logical.Response, error) {
+	if len(body.BatchInput) == 0 {
+		result, err := fn(body.batchItem)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: result}, nil
+	}
+
+	results := make([]map[string]interface{}, len(body.BatchInput))
+	for i, item := range body.BatchInput {
+		result, err := fn(item)
+		if err != nil {
+			result = map[string]interface{}{"error": err.Error()}
+		}
+		results[i] = result
+	}
+	return &logical.Response{Data: map[string]interface{}{"batch_results": results}}, nil
+}
+
+// keyInfo describes a key without exposing private key material
+func keyInfo(key *Key) map[string]interface{} {
+	versions := make(map[string]interface{}, len(key.Versions))
+	for n, v := range key.Versions {
+		info := map[string]interface{}{"creation_time": v.CreationTime}
+		if v.PublicKey != nil {
+			info["public_key"] = base64.StdEncoding.EncodeToString(v.PublicKey)
+		}
+		versions[strconv.Itoa(n)] = info
+	}
+
+	return map[string]interface{}{
+		"name":                   key.Name,
+		"type":                   key.Type,
+		"derived":                key.Derived,
+		"convergent_encryption":  key.ConvergentEncryption,
+		"latest_version":         key.LatestVersion,
+		"min_decryption_version": key.MinDecryptionVersion,
+		"deletion_allowed":       key.DeletionAllowed,
+		"keys":                   versions,
+	}
+}
+
+func loadKeyAndInput(req *logical.Request, name string) (*Key, []byte, error) {
+	input, err := decodeBase64("input", req.GetString("input"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := loadKey(req.Storage, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, input, nil
+}
+
+func loadKey(store storage.Storage, name string) (*Key, error) {
+	data, err := store.Get(keyPrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key Key
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func saveKey(store storage.Storage, key *Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return store.Put(keyPrefix+key.Name, data)
+}
+
+func decodeBase64(field, value string) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, logical.InvalidRequest("%s must be base64-encoded", field)
+	}
+	return data, nil
+}
@@ -0,0 +1,237 @@
+package transit
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+// testEngine is a transit engine with its storage
+type testEngine struct {
+	b     *backend
+	store storage.Storage
+}
+
+func newTestEngine(t *testing.T) *testEngine {
+	t.Helper()
+	store, err := storage.NewLogStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return &testEngine{b: &backend{}, store: store}
+}
+
+// request returns the data of the response to a request
+func (e *testEngine) request(op logical.Operation, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := e.b.HandleRequest(&logical.Request{Operation: op, Path: path, Data: data, Storage: e.store})
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	out, _ := resp.Data.(map[string]interface{})
+	return out, nil
+}
+
+// write sends an update request and fails the test on an error
+func (e *testEngine) write(t *testing.T, path string, data map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	resp, err := e.request(logical.UpdateOperation, path, data)
+	if err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return resp
+}
+
+func (e *testEngine) encrypt(t *testing.T, name, plaintext string) string {
+	t.Helper()
+	resp := e.write(t, "encrypt/"+name, map[string]interface{}{"plaintext": encode(plaintext)})
+	return resp["ciphertext"].(string)
+}
+
+func (e *testEngine) decrypt(name, ciphertext string) (string, error) {
+	resp, err := e.request(logical.UpdateOperation, "decrypt/"+name, map[string]interface{}{"ciphertext": ciphertext})
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp["plaintext"].(string))
+	return string(plaintext), err
+}
+
+func encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, keyType := range []string{KeyTypeAES256GCM96, KeyTypeChaCha20Poly1305} {
+		t.Run(keyType, func(t *testing.T) {
+			e := newTestEngine(t)
+			e.write(t, "keys/app", map[string]interface{}{"type": keyType})
+
+			ciphertext := e.encrypt(t, "app", "secret data")
+			if !strings.HasPrefix(ciphertext, "vault:v1:") {
+				t.Fatalf("ciphertext %q is not of version 1", ciphertext)
+			}
+			if again := e.encrypt(t, "app", "secret data"); again == ciphertext {
+				t.Fatal("encrypting twice gave the same ciphertext")
+			}
+
+			plaintext, err := e.decrypt("app", ciphertext)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if plaintext != "secret data" {
+				t.Fatalf("plaintext = %q, want %q", plaintext, "secret data")
+			}
+
+			tampered := ciphertext[:len(ciphertext)-4] + "AAA="
+			if _, err := e.decrypt("app", tampered); !errors.Is(err, logical.ErrInvalidRequest) {
+				t.Fatalf("decrypt of tampered ciphertext: %v, want an invalid request", err)
+			}
+		})
+	}
+}
+
+func TestEncryptCreatesKey(t *testing.T) {
+	e := newTestEngine(t)
+
+	// The vault sends an encrypt to a missing key as a create
+	resp, err := e.request(logical.CreateOperation, "encrypt/upserted", map[string]interface{}{"plaintext": encode("data")})
+	if err != nil {
+		t.Fatalf("encrypt creating a key: %v", err)
+	}
+	ciphertext := resp["ciphertext"].(string)
+	if plaintext, err := e.decrypt("upserted", ciphertext); err != nil || plaintext != "data" {
+		t.Fatalf("decrypt = %q, %v", plaintext, err)
+	}
+
+	// Key types that cannot encrypt are refused before anything is saved
+	for _, keyType := range []string{KeyTypeEd25519, KeyTypeHMAC} {
+		_, err := e.request(logical.CreateOperation, "encrypt/"+keyType, map[string]interface{}{
+			"plaintext": encode("data"), "type": keyType,
+		})
+		if !errors.Is(err, logical.ErrInvalidRequest) {
+			t.Fatalf("encrypt creating an %s key: %v, want an invalid request", keyType, err)
+		}
+		if _, err := e.request(logical.ReadOperation, "keys/"+keyType, nil); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("read %s key: %v, want it not to exist", keyType, err)
+		}
+	}
+}
+
+func TestRotateAndRewrap(t *testing.T) {
+	e := newTestEngine(t)
+	e.write(t, "keys/app", nil)
+
+	old := e.encrypt(t, "app", "data")
+	e.write(t, "keys/app/rotate", nil)
+
+	if current := e.encrypt(t, "app", "data"); !strings.HasPrefix(current, "vault:v2:") {
+		t.Fatalf("ciphertext after rotation %q is not of version 2", current)
+	}
+	if plaintext, err := e.decrypt("app", old); err != nil || plaintext != "data" {
+		t.Fatalf("decrypt of version 1 = %q, %v", plaintext, err)
+	}
+
+	resp := e.write(t, "rewrap/app", map[string]interface{}{"ciphertext": old})
+	rewrapped := resp["ciphertext"].(string)
+	if !strings.HasPrefix(rewrapped, "vault:v2:") || resp["key_version"] != 2 {
+		t.Fatalf("rewrap = %v, want version 2", resp)
+	}
+	if plaintext, err := e.decrypt("app", rewrapped); err != nil || plaintext != "data" {
+		t.Fatalf("decrypt of rewrapped = %q, %v", plaintext, err)
+	}
+}
+
+func TestMinDecryptionVersion(t *testing.T) {
+	e := newTestEngine(t)
+	e.write(t, "keys/app", nil)
+
+	old := e.encrypt(t, "app", "data")
+	e.write(t, "keys/app/rotate", nil)
+	current := e.encrypt(t, "app", "data")
+
+	for _, version := range []int{0, 3} {
+		if _, err := e.request(logical.UpdateOperation, "keys/app/config", map[string]interface{}{"min_decryption_version": version}); !errors.Is(err, logical.ErrInvalidRequest) {
+			t.Fatalf("min_decryption_version %d: %v, want an invalid request", version, err)
+		}
+	}
+	e.write(t, "keys/app/config", map[string]interface{}{"min_decryption_version": 2})
+
+	if _, err := e.decrypt("app", old); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("decrypt of version 1: %v, want an invalid request", err)
+	}
+	if _, err := e.request(logical.UpdateOperation, "rewrap/app", map[string]interface{}{"ciphertext": old}); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("rewrap of version 1: %v, want an invalid request", err)
+	}
+	if plaintext, err := e.decrypt("app", current); err != nil || plaintext != "data" {
+		t.Fatalf("decrypt of version 2 = %q, %v", plaintext, err)
+	}
+}
+
+func TestConvergentEncryption(t *testing.T) {
+	e := newTestEngine(t)
+	e.write(t, "keys/app", map[string]interface{}{"derived": true, "convergent_encryption": true})
+
+	encrypt := func(plaintext, context string) string {
+		t.Helper()
+		resp := e.write(t, "encrypt/app", map[string]interface{}{"plaintext": encode(plaintext), "context": encode(context)})
+		return resp["ciphertext"].(string)
+	}
+
+	first := encrypt("data", "tenant-a")
+	if again := encrypt("data", "tenant-a"); again != first {
+		t.Fatalf("convergent encryption gave %q, then %q", first, again)
+	}
+	if other := encrypt("other", "tenant-a"); other == first {
+		t.Fatal("different plaintexts gave the same ciphertext")
+	}
+	if other := encrypt("data", "tenant-b"); other == first {
+		t.Fatal("different contexts gave the same ciphertext")
+	}
+
+	resp := e.write(t, "decrypt/app", map[string]interface{}{"ciphertext": first, "context": encode("tenant-a")})
+	if resp["plaintext"] != encode("data") {
+		t.Fatalf("decrypt = %v, want %q", resp["plaintext"], encode("data"))
+	}
+	if _, err := e.request(logical.UpdateOperation, "decrypt/app", map[string]interface{}{"ciphertext": first, "context": encode("tenant-b")}); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("decrypt with the wrong context: %v, want an invalid request", err)
+	}
+
+	// Convergent encryption needs a context to derive from
+	if _, err := e.request(logical.UpdateOperation, "keys/plain", map[string]interface{}{"convergent_encryption": true}); !errors.Is(err, logical.ErrInvalidRequest) {
+		t.Fatalf("convergent key without derivation: %v, want an invalid request", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	e := newTestEngine(t)
+	e.write(t, "keys/app", nil)
+
+	resp := e.write(t, "encrypt/app", map[string]interface{}{"batch_input": []interface{}{
+		map[string]interface{}{"plaintext": encode("one")},
+		map[string]interface{}{"plaintext": "not base64!"},
+		map[string]interface{}{"plaintext": encode("three")},
+	}})
+	results := resp["batch_results"].([]map[string]interface{})
+	if len(results) != 3 {
+		t.Fatalf("got %d batch results, want 3", len(results))
+	}
+	if _, ok := results[1]["error"]; !ok {
+		t.Fatalf("invalid batch item = %v, want an error", results[1])
+	}
+
+	resp = e.write(t, "decrypt/app", map[string]interface{}{"batch_input": []interface{}{
+		map[string]interface{}{"ciphertext": results[0]["ciphertext"]},
+		map[string]interface{}{"ciphertext": results[2]["ciphertext"]},
+	}})
+	decrypted := resp["batch_results"].([]map[string]interface{})
+	for i, want := range []string{"one", "three"} {
+		if decrypted[i]["plaintext"] != encode(want) {
+			t.Fatalf("batch result %d = %v, want %q", i, decrypted[i], encode(want))
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package transit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/logical"
+)
+
+// Key types
+const (
+	KeyTypeAES256GCM96      = "aes256-gcm96"
+	KeyTypeChaCha20Poly1305 = "chacha20-poly1305"
+	KeyTypeEd25519          = "ed25519"
+	KeyTypeHMAC             = "hmac"
+)
+
+// ciphertextPrefix starts every ciphertext, signature and HMAC
+const ciphertextPrefix = "vault:v"
+
+// convergentNonceInfo is the HKDF info of the key that computes the
+// nonces of convergent encryption, kept apart from the encryption key
+const convergentNonceInfo = "transit convergent nonce"
+
+// Key is a named, versioned transit key. Every version keeps its own key
+// material so data encrypted under an older version can still be
+// decrypted until min_decryption_version moves past it.
+type Key struct {
+	Name                 string              `json:"name"`
+	Type                 string              `json:"type"`
+	Derived              bool                `json:"derived"`
+	ConvergentEncryption bool                `json:"convergent_encryption"`
+	LatestVersion        int                 `json:"latest_version"`
+	MinDecryptionVersion int                 `json:"min_decryption_version"`
+	DeletionAllowed      bool                `json:"deletion_allowed"`
+	Versions             map[int]*KeyVersion `json:"versions"`
+}
+
+// KeyVersion holds the key material of one version of a key
+type KeyVersion struct {
+	Key          []byte    `json:"key,omitempty"`
+	PublicKey    []byte    `json:"public_key,omitempty"`
+	HMACKey      []byte    `json:"hmac_key"`
+	CreationTime time.Time `json:"creation_time"`
+}
+
+// newKey creates a key with its first version
+func newKey(name, keyType string, derived, convergent bool) (*Key, error) {
+	switch keyType {
+	case KeyTypeAES256GCM96, KeyTypeChaCha20Poly1305:
+	case KeyTypeEd25519, KeyTypeHMAC:
+		if derived || convergent {
+			return nil, logical.InvalidRequest("key type %s does not support key derivation", keyType)
+		}
+	default:
+		return nil, logical.InvalidRequest("unsupported key type %q", keyType)
+	}
+
+	if convergent && !derived {
+		return nil, logical.InvalidRequest("convergent encryption requires key derivation")
+	}
+
+	k := &Key{
+		Name:                 name,
+		Type:                 keyType,
+		Derived:              derived,
+		ConvergentEncryption: convergent,
+		MinDecryptionVersion: 1,
+		Versions:             make(map[int]*KeyVersion),
+	}
+	if err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Rotate adds a new version and makes it the one used for encryption
+func (k *Key) Rotate() error {
+	hmacKey, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+	version := &KeyVersion{HMACKey: hmacKey, CreationTime: time.Now()}
+
+	switch k.Type {
+	case KeyTypeAES256GCM96, KeyTypeChaCha20Poly1305:
+		if version.Key, err = crypto.GenerateKey(); err != nil {
+			return err
+		}
+	case KeyTypeEd25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		version.Key = private.Seed()
+		version.PublicKey = public
+	}
+
+	k.LatestVersion++
+	k.Versions[k.LatestVersion] = version
+	return nil
+}
+
+// SetMinDecryptionVersion stops ciphertext of older versions from being
+// decrypted, and signatures and HMACs of older versions from verifying
+func (k *Key) SetMinDecryptionVersion(version int) error {
+	if version < 1 || version > k.LatestVersion {
+		return logical.InvalidRequest("min_decryption_version must be between 1 and %d", k.LatestVersion)
+	}
+	k.MinDecryptionVersion = version
+	return nil
+}
+
+// Encrypt encrypts plaintext with the latest version of the key
+func (k *Key) Encrypt(plaintext, context []byte) (string, error) {
+	aead, key, err := k.aead(k.LatestVersion, context)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if k.ConvergentEncryption {
+		// The same plaintext and context always produce the same ciphertext
+		nonceKey, err := hkdf.Key(sha256.New, key, nil, convergentNonceInfo, len(key))
+		if err != nil {
+			return "", err
+		}
+		mac := hmac.New(sha256.New, nonceKey)
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return encodeVersioned(k.LatestVersion, aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decrypt decrypts a "vault:v<N>:" ciphertext
+func (k *Key) Decrypt(ciphertext string, context []byte) ([]byte, error) {
+	version, data, err := decodeVersioned(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.allowDecryption(version, "ciphertext"); err != nil {
+		return nil, err
+	}
+
+	aead, _, err := k.aead(version, context)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return nil, logical.InvalidRequest("invalid ciphertext: too short")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, logical.InvalidRequest("failed to decrypt: ciphertext or context is invalid")
+	}
+	return plaintext, nil
+}
+
+// Sign signs input with the latest version of an ed25519 key
+func (k *Key) Sign(input []byte) (string, error) {
+	if k.Type != KeyTypeEd25519 {
+		return "", logical.InvalidRequest("key type %s does not support signing", k.Type)
+	}
+
+	private := ed25519.NewKeyFromSeed(k.Versions[k.LatestVersion].Key)
+	return encodeVersioned(k.LatestVersion, ed25519.Sign(private, input)), nil
+}
+
+// Verify checks a signature produced by Sign
+func (k *Key) Verify(input []byte, signature string) (bool, error) {
+	if k.Type != KeyTypeEd25519 {
+		return false, logical.InvalidRequest("key type %s does not support verification", k.Type)
+	}
+
+	version, sig, err := decodeVersioned(signature)
+	if err != nil {
+		return false, err
+	}
+	if err := k.allowDecryption(version, "signature"); err != nil {
+		return false, err
+	}
+	v, err := k.version(version)
+	if err != nil {
+		return false, err
+	}
+
+	return ed25519.Verify(v.PublicKey, input, sig), nil
+}
+
+// HMAC computes an HMAC of input with the latest version of the key
+func (k *Key) HMAC(input []byte, algorithm string) (string, error) {
+	mac, err := k.hmac(k.LatestVersion, input, algorithm)
+	if err != nil {
+		return "", err
+	}
+	return encodeVersioned(k.LatestVersion, mac), nil
+}
+
+// VerifyHMAC checks an HMAC produced by HMAC
+func (k *Key) VerifyHMAC(input []byte, value, algorithm string) (bool, error) {
+	version, expected, err := decodeVersioned(value)
+	if err != nil {
+		return false, err
+	}
+	if err := k.allowDecryption(version, "hmac"); err != nil {
+		return false, err
+	}
+
+	mac, err := k.hmac(version, input, algorithm)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(mac, expected), nil
+}
+
+func (k *Key) hmac(version int, input []byte, algorithm string) ([]byte, error) {
+	v, err := k.version(version)
+	if err != nil {
+		return nil, err
+	}
+
+	var h func() hash.Hash
+	switch algorithm {
+	case "", "sha2-256":
+		h = sha256.New
+	case "sha2-512":
+		h = sha512.New
+	default:
+		return nil, logical.InvalidRequest("unsupported hash algorithm %q", algorithm)
+	}
+
+	mac := hmac.New(h, v.HMACKey)
+	mac.Write(input)
+	return mac.Sum(nil), nil
+}
+
+// aead returns the cipher for a key version along with the key it uses,
+// which is derived from the context for derived keys
+func (k *Key) aead(version int, context []byte) (cipher.AEAD, []byte, error) {
+	if k.Type != KeyTypeAES256GCM96 && k.Type != KeyTypeChaCha20Poly1305 {
+		return nil, nil, logical.InvalidRequest("key type %s does not support encryption", k.Type)
+	}
+
+	v, err := k.version(version)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := v.Key
+	if k.Derived {
+		if len(context) == 0 {
+			return nil, nil, logical.InvalidRequest("missing context for derived key")
+		}
+		if key, err = hkdf.Key(sha256.New, v.Key, nil, string(context), len(v.Key)); err != nil {
+			return nil, nil, err
+		}
+	} else if len(context) > 0 {
+		return nil, nil, logical.InvalidRequest("context is only allowed for derived keys")
+	}
+
+	var aead cipher.AEAD
+	if k.Type == KeyTypeChaCha20Poly1305 {
+		aead, err = chacha20poly1305.New(key)
+	} else {
+		var block cipher.Block
+		if block, err = aes.NewCipher(key); err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, key, nil
+}
+
+// allowDecryption refuses values of versions below min_decryption_version
+func (k *Key) allowDecryption(version int, what string) error {
+	if version < k.MinDecryptionVersion {
+		return logical.InvalidRequest("%s version %d is disallowed by policy (too old)", what, version)
+	}
+	return nil
+}
+
+func (k *Key) version(version int) (*KeyVersion, error) {
+	v, ok := k.Versions[version]
+	if !ok {
+		return nil, logical.InvalidRequest("key version %d does not exist", version)
+	}
+	return v, nil
+}
+
+// encodeVersioned formats data as "vault:v<N>:<base64>"
+func encodeVersioned(version int, data []byte) string {
+	return fmt.Sprintf("%s%d:%s", ciphertextPrefix, version, base64.StdEncoding.EncodeToString(data))
+}
+
+// decodeVersioned parses a value produced by encodeVersioned
+func decodeVersioned(value string) (int, []byte, error) {
+	rest, ok := strings.CutPrefix(value, ciphertextPrefix)
+	if !ok {
+		return 0, nil, logical.InvalidRequest("invalid ciphertext: no prefix")
+	}
+
+	versionStr, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, nil, logical.InvalidRequest("invalid ciphertext: wrong number of fields")
+	}
+
+	version, err := strconv.Atoi(versionStr)
+	if err != nil || version < 1 {
+		return 0, nil, logical.InvalidRequest("invalid ciphertext: bad version")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, logical.InvalidRequest("invalid ciphertext: could not decode")
+	}
+	return version, data, nil
+}
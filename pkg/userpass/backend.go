@@ -0,0 +1,246 @@
+package userpass
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"vault-clone/pkg/logical"
+)
+
+// backend is the username/password auth method. Paths are served
+// relative to the mount point:
+//
+//	users/<name>            create, read, update and delete users
+//	users/<name>/password   change a user's password
+//	users/<name>/policies   change a user's policies
+//	login/<name>            log in with a password (no token required)
+type backend struct {
+	// mu serializes changes to users
+	mu sync.Mutex
+}
+
+// Factory creates a userpass auth method for a mount
+func Factory(config *logical.BackendConfig) (logical.Backend, error) {
+	return &backend{}, nil
+}
+
+// LoginPaths returns the paths served without a token
+func (b *backend) LoginPaths() []string {
+	return []string{"login/*"}
+}
+
+// HandleRequest dispatches a request to the handler for its path
+func (b *backend) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	action, rest, _ := strings.Cut(req.Path, "/")
+
+	switch action {
+	case "login":
+		if rest == "" || strings.Contains(rest, "/") {
+			return nil, logical.InvalidRequest("missing username")
+		}
+		return b.handleLogin(req, strings.ToLower(rest))
+	case "users":
+		if req.Operation == logical.ListOperation {
+			return b.listUsers(req)
+		}
+		name, sub, _ := strings.Cut(rest, "/")
+		if name == "" {
+			return nil, logical.InvalidRequest("missing username")
+		}
+		name = strings.ToLower(name)
+		switch sub {
+		case "":
+			return b.handleUser(req, name)
+		case "password", "policies":
+			return b.handleUserField(req, name, sub)
+		}
+	}
+	return nil, logical.ErrUnsupportedPath
+}
+
+// LoginAlias returns the username a login request is for
+func (b *backend) LoginAlias(req *logical.Request) string {
+	name, ok := strings.CutPrefix(req.Path, "login/")
+	if !ok || strings.Contains(name, "/") {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// Exists reports whether the user targeted by a user write exists, so
+// that creating a user requires the create capability
+func (b *backend) Exists(req *logical.Request) (bool, error) {
+	name, ok := strings.CutPrefix(req.Path, "users/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return true, nil
+	}
+
+	_, err := loadUser(req.Storage, strings.ToLower(name))
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *backend) listUsers(req *logical.Request) (*logical.Response, error) {
+	keys, err := req.Storage.List(userPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, userPrefix))
+	}
+	sort.Strings(names)
+
+	return &logical.Response{Data: map[string]interface{}{"keys": names}}, nil
+}
+
+func (b *backend) handleUser(req *logical.Request, name string) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.ReadOperation:
+		u, err := loadUser(req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{Data: userInfo(u)}, nil
+
+	case logical.CreateOperation, logical.UpdateOperation:
+		policies, err := req.GetStrings("policies")
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := req.GetTTL("ttl")
+		if err != nil {
+			return nil, err
+		}
+		maxTTL, err := req.GetTTL("max_ttl")
+		if err != nil {
+			return nil, err
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		u, err := loadUser(req.Storage, name)
+		if errors.Is(err, ErrUserNotFound) {
+			if req.GetString("password") == "" {
+				return nil, logical.InvalidRequest("missing password")
+			}
+			u, err = &User{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if password := req.GetString("password"); password != "" {
+			if err := u.SetPassword(password); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := req.Data["policies"]; ok {
+			u.Policies = policies
+		}
+		if _, ok := req.Data["ttl"]; ok {
+			u.TTL = ttl
+		}
+		if _, ok := req.Data["max_ttl"]; ok {
+			u.MaxTTL = maxTTL
+		}
+		if u.MaxTTL > 0 && u.TTL > u.MaxTTL {
+			return nil, logical.InvalidRequest("ttl cannot exceed max_ttl")
+		}
+		return nil, saveUser(req.Storage, name, u)
+
+	case logical.DeleteOperation:
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, err := loadUser(req.Storage, name); err != nil {
+			return nil, err
+		}
+		return nil, req.Storage.Delete(userPrefix + name)
+	}
+	return nil, logical.ErrUnsupportedOperation
+}
+
+// handleUserField changes only the password or the policies of a user
+func (b *backend) handleUserField(req *logical.Request, name, field string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u, err := loadUser(req.Storage, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if field == "password" {
+		password := req.GetString("password")
+		if password == "" {
+			return nil, logical.InvalidRequest("missing password")
+		}
+		if err := u.SetPassword(password); err != nil {
+			return nil, err
+		}
+	} else {
+		policies, err := req.GetStrings("policies")
+		if err != nil {
+			return nil, err
+		}
+		u.Policies = policies
+	}
+	return nil, saveUser(req.Storage, name, u)
+}
+
+func (b *backend) handleLogin(req *logical.Request, name string) (*logical.Response, error) {
+	if req.Operation != logical.CreateOperation && req.Operation != logical.UpdateOperation {
+		return nil, logical.ErrUnsupportedOperation
+	}
+
+	password := req.GetString("password")
+	if password == "" {
+		return nil, logical.InvalidRequest("missing password")
+	}
+
+	u, err := loadUser(req.Storage, name)
+	if errors.Is(err, ErrUserNotFound) {
+		// Hash anyway so that unknown users cannot be told apart by timing
+		dummyUser.CheckPassword(password)
+		return nil, logical.InvalidCredentials("invalid username or password")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.CheckPassword(password) {
+		return nil, logical.InvalidCredentials("invalid username or password")
+	}
+
+	return &logical.Response{Auth: &logical.Auth{
+		Policies:  u.Policies,
+		TTL:       u.TTL,
+		MaxTTL:    u.MaxTTL,
+		Renewable: true,
+		Metadata:  map[string]string{"username": name},
+		Alias:     name,
+	}}, nil
+}
+
+func userInfo(u *User) map[string]interface{} {
+	policies := u.Policies
+	if policies == nil {
+		policies = []string{}
+	}
+	return map[string]interface{}{
+		"policies": policies,
+		"ttl":      int64(u.TTL.Seconds()),
+		"max_ttl":  int64(u.MaxTTL.Seconds()),
+	}
+}
@@ -0,0 +1,112 @@
+package userpass
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+
+	"vault-clone/pkg/storage"
+)
+
+// userPrefix is where users are stored in the method's storage view
+const userPrefix = "user/"
+
+// Argon2id parameters for new password hashes. They are stored with each
+// hash so that they can be raised without invalidating existing users.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	saltSize     = 16
+)
+
+// maxConcurrentHashes bounds the memory taken by hashing to that many
+// times argonMemory, however many logins arrive at once
+const maxConcurrentHashes = 4
+
+// hashSlots is a semaphore of the hashes that may be computed at once;
+// further logins wait for a slot
+var hashSlots = make(chan struct{}, maxConcurrentHashes)
+
+// ErrUserNotFound is returned when a user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
+// User is a username/password entry with the settings of the tokens
+// issued when it logs in
+type User struct {
+	PasswordHash []byte        `json:"password_hash"`
+	Salt         []byte        `json:"salt"`
+	KDF          KDFParams     `json:"kdf"`
+	Policies     []string      `json:"policies"`
+	TTL          time.Duration `json:"ttl"`
+	MaxTTL       time.Duration `json:"max_ttl"`
+}
+
+// KDFParams are the Argon2id parameters a password was hashed with
+type KDFParams struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// SetPassword replaces the password hash with one of password under a
+// new salt
+func (u *User) SetPassword(password string) error {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	u.Salt = salt
+	u.KDF = KDFParams{Time: argonTime, Memory: argonMemory, Threads: argonThreads}
+	u.PasswordHash = u.KDF.hash(password, salt)
+	return nil
+}
+
+// CheckPassword reports whether password matches the stored hash
+func (u *User) CheckPassword(password string) bool {
+	return subtle.ConstantTimeCompare(u.KDF.hash(password, u.Salt), u.PasswordHash) == 1
+}
+
+func (p KDFParams) hash(password string, salt []byte) []byte {
+	hashSlots <- struct{}{}
+	defer func() { <-hashSlots }()
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argonKeyLen)
+}
+
+// dummyUser is checked against when a login names an unknown user, so
+// that the response takes as long as for a wrong password
+var dummyUser = &User{
+	PasswordHash: make([]byte, argonKeyLen),
+	Salt:         make([]byte, saltSize),
+	KDF:          KDFParams{Time: argonTime, Memory: argonMemory, Threads: argonThreads},
+}
+
+func loadUser(store storage.Storage, name string) (*User, error) {
+	data, err := store.Get(userPrefix + name)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func saveUser(store storage.Storage, name string, u *User) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return store.Put(userPrefix+name, data)
+}
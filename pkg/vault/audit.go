@@ -0,0 +1,305 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"vault-clone/pkg/audit"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+)
+
+// auditTablePath stores the enabled audit devices behind the barrier
+const auditTablePath = "core/audit"
+
+// ErrAuditFailed is returned when audit devices are enabled but none of
+// them could record a request, in which case the request is refused
+var ErrAuditFailed = errors.New("no audit device could record the request")
+
+// ErrAuditNotFound is returned when no audit device is enabled at a path
+var ErrAuditNotFound = errors.New("no audit device enabled at path")
+
+// AuditEntry describes an enabled audit device
+type AuditEntry struct {
+	Path        string            `json:"path"`
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	Options     map[string]string `json:"options,omitempty"`
+}
+
+// auditTableEntry is the persisted form of an audit device. The HMAC key
+// never leaves the barrier.
+type auditTableEntry struct {
+	*AuditEntry
+	HMACKey []byte `json:"hmac_key"`
+}
+
+// auditTable is the persisted form of the audit device table
+type auditTable struct {
+	Entries []*auditTableEntry `json:"entries"`
+}
+
+// auditDevice is an enabled audit device with its open destination
+type auditDevice struct {
+	entry   *AuditEntry
+	hmacKey []byte
+	salt    *audit.Salt
+	device  audit.Device
+}
+
+// ListAudit returns the enabled audit devices sorted by path
+func (v *Vault) ListAudit(token string) ([]*AuditEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/audit", policy.SudoCapability); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*AuditEntry, 0, len(v.audits))
+	for _, d := range v.audits {
+		entries = append(entries, d.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// EnableAudit enables an audit device at entry.Path. The device must be
+// able to open its destination before it is added.
+func (v *Vault) EnableAudit(token string, entry *AuditEntry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(entry.Path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/audit/"+path, policy.SudoCapability); err != nil {
+		return err
+	}
+
+	if _, ok := v.audits[path]; ok {
+		return fmt.Errorf("an audit device is already enabled at %q", path)
+	}
+
+	hmacKey, err := crypto.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	added := &AuditEntry{
+		Path:        path,
+		Type:        entry.Type,
+		Description: entry.Description,
+		Options:     entry.Options,
+	}
+
+	device, err := audit.NewDevice(added.Type, added.Options)
+	if err != nil {
+		return err
+	}
+
+	d := newAuditDevice(added, hmacKey, device)
+
+	v.audits[path] = d
+	if err := v.persistAuditLocked(); err != nil {
+		delete(v.audits, path)
+		d.device.Close()
+		return err
+	}
+	return nil
+}
+
+// DisableAudit disables an audit device and closes its destination
+func (v *Vault) DisableAudit(token, path string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/audit/"+path, policy.SudoCapability); err != nil {
+		return err
+	}
+
+	d, ok := v.audits[path]
+	if !ok {
+		return ErrAuditNotFound
+	}
+
+	delete(v.audits, path)
+	if err := v.persistAuditLocked(); err != nil {
+		v.audits[path] = d
+		return err
+	}
+
+	return d.device.Close()
+}
+
+// AuditHash returns the HMAC an audit device would log for a value, so a
+// known value can be found in the log
+func (v *Vault) AuditHash(token, path, input string) (string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return "", errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := v.authorize(token, "sys/audit-hash/"+path, policy.UpdateCapability); err != nil {
+		return "", err
+	}
+
+	d, ok := v.audits[path]
+	if !ok {
+		return "", ErrAuditNotFound
+	}
+	return d.salt.HMAC(input), nil
+}
+
+// AuditRequest records an incoming request with every audit device. An
+// error means the request must not be served.
+func (v *Vault) AuditRequest(token string, req *audit.Request) error {
+	return v.logAudit(token, &audit.Entry{Type: audit.TypeRequest, Request: req})
+}
+
+// AuditResponse records the response to a request with every audit
+// device. An error means the response must not be returned.
+func (v *Vault) AuditResponse(token string, req *audit.Request, resp *audit.Response, respErr string) error {
+	return v.logAudit(token, &audit.Entry{
+		Type:     audit.TypeResponse,
+		Request:  req,
+		Response: resp,
+		Error:    respErr,
+	})
+}
+
+// logAudit writes an entry to every device. Nothing is recorded while
+// the vault is sealed or no device is enabled; otherwise at least one
+// device has to succeed.
+func (v *Vault) logAudit(token string, entry *audit.Entry) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.logAuditLocked(token, entry)
+}
+
+// logAuditLocked is logAudit for callers that hold v.mu
+func (v *Vault) logAuditLocked(token string, entry *audit.Entry) error {
+	if v.sealed || len(v.audits) == 0 {
+		return nil
+	}
+
+	entry.Time = time.Now().UTC()
+	// Known tokens are recorded by accessor; only a token that does not
+	// resolve is logged, as its HMAC
+	if token != "" {
+		if te, err := v.tokenStore.LookupToken(token); err == nil {
+			entry.Auth = &audit.Auth{Accessor: te.Accessor, Policies: te.Policies}
+		} else {
+			entry.Auth = &audit.Auth{ClientToken: token}
+		}
+	}
+
+	var lastErr error
+	recorded := 0
+	for _, d := range v.audits {
+		line, err := json.Marshal(d.salt.Hash(entry))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := d.device.Write(append(line, '\n')); err != nil {
+			lastErr = fmt.Errorf("audit device %s: %v", d.entry.Path, err)
+			continue
+		}
+		recorded++
+	}
+
+	if recorded == 0 {
+		return fmt.Errorf("%w: %v", ErrAuditFailed, lastErr)
+	}
+	return nil
+}
+
+// setupAudit opens the audit devices in the audit table. A file that
+// cannot be opened fails the unseal; an unreachable socket does not, but
+// requests fail closed until it can be reached or another device works.
+func (v *Vault) setupAudit() error {
+	data, err := v.barrier.Get(auditTablePath)
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
+
+	var table auditTable
+	if err == nil {
+		if err := json.Unmarshal(data, &table); err != nil {
+			return err
+		}
+	}
+
+	audits := make(map[string]*auditDevice, len(table.Entries))
+	for _, entry := range table.Entries {
+		device, err := audit.OpenDevice(entry.Type, entry.Options)
+		if err != nil {
+			closeAuditDevices(audits)
+			return fmt.Errorf("failed to open audit device %s: %v", entry.Path, err)
+		}
+		audits[entry.Path] = newAuditDevice(entry.AuditEntry, entry.HMACKey, device)
+	}
+
+	v.audits = audits
+	return nil
+}
+
+func newAuditDevice(entry *AuditEntry, hmacKey []byte, device audit.Device) *auditDevice {
+	return &auditDevice{
+		entry:   entry,
+		hmacKey: hmacKey,
+		salt:    audit.NewSalt(hmacKey),
+		device:  device,
+	}
+}
+
+func (v *Vault) persistAuditLocked() error {
+	table := &auditTable{}
+	for _, d := range v.audits {
+		table.Entries = append(table.Entries, &auditTableEntry{AuditEntry: d.entry, HMACKey: d.hmacKey})
+	}
+	sort.Slice(table.Entries, func(i, j int) bool { return table.Entries[i].Path < table.Entries[j].Path })
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(auditTablePath, data)
+}
+
+func closeAuditDevices(audits map[string]*auditDevice) {
+	for _, d := range audits {
+		d.device.Close()
+	}
+}
@@ -0,0 +1,436 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"vault-clone/pkg/approle"
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/cert"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/jwt"
+	"vault-clone/pkg/ldap"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+	"vault-clone/pkg/userpass"
+)
+
+const (
+	// authTablePath stores the auth method table behind the barrier
+	authTablePath = "core/auth"
+	// credentialPrefix is the root of the storage views of auth methods
+	credentialPrefix = "credential/"
+	// credentialRoutePrefix is the request path auth methods are mounted
+	// under
+	credentialRoutePrefix = "auth/"
+)
+
+// ErrAuthNotFound is returned when no auth method is enabled at a path
+var ErrAuthNotFound = errors.New("no auth method enabled at path")
+
+// ErrMissingToken is returned for requests made without a token to paths
+// that require one
+var ErrMissingToken = errors.New("missing token")
+
+// reservedAuthPaths cannot be used for auth methods
+var reservedAuthPaths = []string{"token/"}
+
+// credentials are the auth method types that can be enabled
+var credentials = map[string]logical.Factory{
+	"userpass": userpass.Factory,
+	"approle":  approle.Factory,
+	"jwt":      jwt.Factory,
+	"cert":     cert.Factory,
+	"ldap":     ldap.Factory,
+}
+
+// ListAuth returns the enabled auth methods sorted by path. Paths are
+// relative to "auth/".
+func (v *Vault) ListAuth(token string) ([]*MountEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/auth", policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*MountEntry, 0, len(v.auths))
+	for _, m := range v.auths {
+		entries = append(entries, m.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// EnableAuth enables an auth method at "auth/<entry.Path>"
+func (v *Vault) EnableAuth(token string, entry *MountEntry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(entry.Path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/auth/"+path, policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	if _, ok := credentials[entry.Type]; !ok {
+		return fmt.Errorf("unknown auth method type %q", entry.Type)
+	}
+	if err := entry.Config.Validate(); err != nil {
+		return err
+	}
+	if err := v.checkAuthPathLocked(path); err != nil {
+		return err
+	}
+
+	uuid, err := crypto.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	added := &MountEntry{
+		Path:        path,
+		Type:        entry.Type,
+		Description: entry.Description,
+		UUID:        uuid,
+		Config:      entry.Config,
+		Options:     entry.Options,
+	}
+
+	m, err := v.newCredential(added)
+	if err != nil {
+		return err
+	}
+
+	v.auths[path] = m
+	if err := v.persistAuthLocked(); err != nil {
+		delete(v.auths, path)
+		return err
+	}
+	return nil
+}
+
+// DisableAuth disables an auth method, revokes every token it issued and
+// deletes all of its data
+func (v *Vault) DisableAuth(token, path string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/auth/"+path, policy.DeleteCapability); err != nil {
+		return err
+	}
+
+	m, ok := v.auths[path]
+	if !ok {
+		return ErrAuthNotFound
+	}
+
+	// Login tokens are leased under the path they were issued for
+	if err := v.revokePrefixLocked(credentialRoutePrefix + path); err != nil {
+		return err
+	}
+
+	delete(v.auths, path)
+	if err := v.persistAuthLocked(); err != nil {
+		v.auths[path] = m
+		return err
+	}
+
+	return m.view.Clear()
+}
+
+// isLoginPath reports whether a path relative to a mount is served
+// without a token
+func isLoginPath(m *mount, path string) bool {
+	handler, ok := m.backend.(logical.LoginHandler)
+	if !ok || !m.credential {
+		return false
+	}
+
+	for _, pattern := range handler.LoginPaths() {
+		if prefix, glob := strings.CutSuffix(pattern, "*"); glob {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// loginMount returns the auth method serving req and its mount path if
+// req is a login request, and points req at the method
+func (v *Vault) loginMount(req *logical.Request) (*mount, string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, "", false
+	}
+
+	m, relative := v.routeLocked(req.Path)
+	if m == nil || !isLoginPath(m, relative) {
+		return nil, "", false
+	}
+
+	req.Path = relative
+	req.MountPoint = m.mountPoint()
+	req.Storage = m.view
+	return m, m.entry.Path, true
+}
+
+// handleLogin serves a request to a login path of the auth method
+// mounted at m. The method checks the credentials without v.mu, since it
+// may wait on a directory, a key endpoint or password hashing, and the
+// vault is only locked before it to refuse locked out users and after it
+// to count the outcome and issue the token.
+func (v *Vault) handleLogin(path string, m *mount, mountPath string, req *logical.Request) (*logical.Response, error) {
+	user := loginUser{mountPath: mountPath, alias: loginAlias(m, req)}
+	if err := v.checkLogin(user, req.RemoteAddr); err != nil {
+		return nil, err
+	}
+
+	resp, err := m.backend.HandleRequest(req)
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if err != nil {
+		if errors.Is(err, logical.ErrInvalidCredentials) {
+			v.failLoginLocked(user, req.RemoteAddr)
+		}
+		return nil, err
+	}
+	if resp == nil || resp.Auth == nil {
+		return resp, nil
+	}
+	return v.loginLocked(path, m, user, req, resp)
+}
+
+// checkLogin refuses a login attempt with checkLoginLocked
+func (v *Vault) checkLogin(user loginUser, addr string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+	return v.checkLoginLocked(user, addr)
+}
+
+// loginLocked issues a token for a login that the auth method mounted at
+// m accepted, once it passes MFA, unless the vault was sealed or the
+// method disabled or moved while the method ran. Callers must hold v.mu.
+func (v *Vault) loginLocked(path string, m *mount, user loginUser, req *logical.Request, resp *logical.Response) (*logical.Response, error) {
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+	if v.auths[user.mountPath] != m {
+		return nil, ErrAuthNotFound
+	}
+
+	if err := v.enforceLoginMFALocked(m, resp.Auth, req.MFACredentials); err != nil {
+		return nil, err
+	}
+	if err := v.succeedLoginLocked(user, req.RemoteAddr); err != nil {
+		return nil, err
+	}
+
+	tokenAuth, err := v.issueLoginTokenLocked(path, m, resp.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Data = tokenAuth
+	return resp, nil
+}
+
+// issueLoginTokenLocked creates an orphan token for a successful login
+// to the auth method mounted at m. The TTL defaults to and is capped by
+// the mount's lease TTLs, and the token is leased under the login path
+// so that disabling the method revokes it. The credentials of the login
+// are consumed last. Callers must hold v.mu.
+func (v *Vault) issueLoginTokenLocked(path string, m *mount, a *logical.Auth) (*TokenAuth, error) {
+	if a.TTL < 0 || a.MaxTTL < 0 || a.Period < 0 || a.NumUses < 0 {
+		return nil, errors.New("auth method returned a negative ttl, max_ttl, period or num_uses")
+	}
+
+	defaultTTL, maxTTL := leaseTTLs(m.entry.Config)
+
+	ttl := a.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+		if a.Period > 0 {
+			ttl = a.Period
+		}
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	if a.MaxTTL > 0 && ttl > a.MaxTTL {
+		ttl = a.MaxTTL
+	}
+
+	// Root tokens can only be created from the root token or with
+	// unseal keys, whatever an auth method was configured with
+	if slices.ContainsFunc(a.Policies, isRootPolicy) {
+		return nil, fmt.Errorf("%w: auth methods cannot issue tokens with the %s policy", ErrPermissionDenied, policy.RootPolicy)
+	}
+
+	policies := slices.Clone(a.Policies)
+	if !slices.Contains(policies, "default") {
+		policies = append(policies, "default")
+	}
+
+	newToken, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	leaseID, err := crypto.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	te, err := v.tokenStore.CreateToken(&auth.TokenParams{
+		ID:             newToken,
+		TTL:            ttl,
+		Policies:       policies,
+		LeaseID:        strings.TrimSuffix(path, "/") + "/" + leaseID,
+		Renewable:      a.Renewable,
+		ExplicitMaxTTL: a.MaxTTL,
+		Period:         a.Period,
+		NumUses:        a.NumUses,
+		Meta:           a.Metadata,
+		EntityID:       loginEntityID(m, a),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	le, err := v.registerTokenLeaseLocked(te, path, maxTTL)
+	if err != nil {
+		v.tokenStore.RevokeToken(newToken)
+		return nil, err
+	}
+
+	if a.Consume != nil {
+		if err := a.Consume(); err != nil {
+			v.expiration.mu.Lock()
+			defer v.expiration.mu.Unlock()
+			if revokeErr := v.revokeLease(le); revokeErr != nil {
+				return nil, errors.Join(err, revokeErr)
+			}
+			return nil, err
+		}
+	}
+
+	return tokenAuth(newToken, te, le), nil
+}
+
+// isRootPolicy reports whether a policy name refers to the root policy
+func isRootPolicy(name string) bool {
+	return strings.EqualFold(strings.TrimSpace(name), policy.RootPolicy)
+}
+
+// checkAuthPathLocked rejects paths that overlap an enabled auth method
+func (v *Vault) checkAuthPathLocked(path string) error {
+	for _, reserved := range reservedAuthPaths {
+		if strings.HasPrefix(path, reserved) {
+			return fmt.Errorf("cannot enable an auth method at reserved path %q", reserved)
+		}
+	}
+
+	for existing := range v.auths {
+		if strings.HasPrefix(path, existing) || strings.HasPrefix(existing, path) {
+			return fmt.Errorf("path %q conflicts with existing auth method %q", path, existing)
+		}
+	}
+	return nil
+}
+
+// setupCredentials loads the auth method table and starts every method
+func (v *Vault) setupCredentials() error {
+	data, err := v.barrier.Get(authTablePath)
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
+
+	var table mountTable
+	if err == nil {
+		if err := json.Unmarshal(data, &table); err != nil {
+			return err
+		}
+	}
+
+	auths := make(map[string]*mount, len(table.Entries))
+	for _, entry := range table.Entries {
+		m, err := v.newCredential(entry)
+		if err != nil {
+			return err
+		}
+		auths[entry.Path] = m
+	}
+
+	v.auths = auths
+	return nil
+}
+
+func (v *Vault) newCredential(entry *MountEntry) (*mount, error) {
+	factory, ok := credentials[entry.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown auth method type %q", entry.Type)
+	}
+
+	view := storage.NewView(v.barrier, credentialPrefix+entry.UUID+"/")
+	backend, err := factory(&logical.BackendConfig{
+		MountPoint: credentialRoutePrefix + entry.Path,
+		Storage:    view,
+		Options:    entry.Options,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mount{entry: entry, backend: backend, view: view, credential: true}, nil
+}
+
+func (v *Vault) persistAuthLocked() error {
+	table := &mountTable{}
+	for _, m := range v.auths {
+		table.Entries = append(table.Entries, m.entry)
+	}
+	sort.Slice(table.Entries, func(i, j int) bool { return table.Entries[i].Path < table.Entries[j].Path })
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(authTablePath, data)
+}
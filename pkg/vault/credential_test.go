@@ -0,0 +1,218 @@
+package vault
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/userpass"
+)
+
+// blockingMethod is an auth method whose logins wait until released
+type blockingMethod struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingMethod) LoginPaths() []string {
+	return []string{"login"}
+}
+
+func (b *blockingMethod) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return &logical.Response{Auth: &logical.Auth{Policies: []string{"default"}, Alias: "alice"}}, nil
+}
+
+// unsealedVault returns an initialized and unsealed vault and its
+// root token
+func unsealedVault(t *testing.T) (*Vault, string) {
+	t.Helper()
+	v := openVault(t, t.TempDir())
+	initResp, err := v.Initialize(nil)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	unseal(t, v, initResp.Keys)
+	if err := v.AuthenticateRootToken(initResp.RootToken, ""); err != nil {
+		t.Fatalf("AuthenticateRootToken: %v", err)
+	}
+	return v, initResp.RootToken
+}
+
+// waitFor fails the test unless done is closed before the timeout
+func waitFor(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func TestLoginDoesNotHoldVaultLock(t *testing.T) {
+	method := &blockingMethod{started: make(chan struct{}), release: make(chan struct{})}
+	credentials["blocking"] = func(*logical.BackendConfig) (logical.Backend, error) { return method, nil }
+	t.Cleanup(func() { delete(credentials, "blocking") })
+
+	v, root := unsealedVault(t)
+	if err := v.EnableAuth(root, &MountEntry{Path: "blocking", Type: "blocking"}); err != nil {
+		t.Fatalf("EnableAuth: %v", err)
+	}
+
+	loginErr := make(chan error, 1)
+	go func() {
+		_, err := v.HandleRequest("", &logical.Request{Operation: logical.UpdateOperation, Path: "auth/blocking/login"})
+		loginErr <- err
+	}()
+	<-method.started
+
+	// The vault can be sealed while the auth method is still answering
+	sealed := make(chan struct{})
+	go func() {
+		if err := v.Seal(root); err != nil {
+			t.Errorf("Seal: %v", err)
+		}
+		close(sealed)
+	}()
+	waitFor(t, sealed, "the seal")
+
+	close(method.release)
+	if err := <-loginErr; err == nil {
+		t.Fatal("login sealed in the meantime was issued a token")
+	}
+}
+
+func TestLDAPLoginDoesNotHoldVaultLock(t *testing.T) {
+	// A directory that accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	v, root := unsealedVault(t)
+	if err := v.EnableAuth(root, &MountEntry{Path: "ldap", Type: "ldap"}); err != nil {
+		t.Fatalf("EnableAuth: %v", err)
+	}
+	_, err = v.HandleRequest(root, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "auth/ldap/config",
+		Data:      map[string]interface{}{"url": "ldap://" + ln.Addr().String(), "userdn": "ou=users,dc=example,dc=com"},
+	})
+	if err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	loginErr := make(chan error, 1)
+	go func() {
+		_, err := v.HandleRequest("", &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "auth/ldap/login/alice",
+			Data:      map[string]interface{}{"password": "secret"},
+		})
+		loginErr <- err
+	}()
+	conn := <-accepted
+
+	sealed := make(chan struct{})
+	go func() {
+		if err := v.Seal(root); err != nil {
+			t.Errorf("Seal: %v", err)
+		}
+		close(sealed)
+	}()
+	waitFor(t, sealed, "the seal while the directory hangs")
+
+	conn.Close()
+	if err := <-loginErr; err == nil {
+		t.Fatal("login against a directory that hung up succeeded")
+	}
+}
+
+// enteredMethod wraps an auth method and reports every login that
+// reaches it
+type enteredMethod struct {
+	logical.Backend
+	entered chan struct{}
+}
+
+func (m *enteredMethod) LoginPaths() []string {
+	return m.Backend.(logical.LoginHandler).LoginPaths()
+}
+
+func (m *enteredMethod) HandleRequest(req *logical.Request) (*logical.Response, error) {
+	if strings.HasPrefix(req.Path, "login/") {
+		m.entered <- struct{}{}
+	}
+	return m.Backend.HandleRequest(req)
+}
+
+func TestUserpassLoginBurstDoesNotHoldVaultLock(t *testing.T) {
+	// More logins than there are hash slots, so that most of them queue
+	// for one
+	const logins = 12
+	entered := make(chan struct{}, logins)
+	credentials["entered-userpass"] = func(config *logical.BackendConfig) (logical.Backend, error) {
+		b, err := userpass.Factory(config)
+		return &enteredMethod{Backend: b, entered: entered}, err
+	}
+	t.Cleanup(func() { delete(credentials, "entered-userpass") })
+
+	v, root := unsealedVault(t)
+	if err := v.EnableAuth(root, &MountEntry{Path: "userpass", Type: "entered-userpass"}); err != nil {
+		t.Fatalf("EnableAuth: %v", err)
+	}
+	for i := range logins {
+		_, err := v.HandleRequest(root, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("auth/userpass/users/user%d", i),
+			Data:      map[string]interface{}{"password": "secret"},
+		})
+		if err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var issued atomic.Int32
+	for i := range logins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := v.HandleRequest("", &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      fmt.Sprintf("auth/userpass/login/user%d", i),
+				Data:      map[string]interface{}{"password": "secret"},
+			})
+			if err == nil {
+				issued.Add(1)
+			}
+		}()
+	}
+	for range logins {
+		<-entered
+	}
+
+	// Sealing must not wait for the logins queued for a hash slot, which
+	// then find the vault sealed once their passwords are checked
+	if err := v.Seal(root); err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	wg.Wait()
+
+	if issued.Load() == logins {
+		t.Fatal("the seal waited for every queued login to be hashed")
+	}
+}
@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/storage"
+)
+
+const (
+	// cubbyholePrefix stores the cubbyhole of each token behind the
+	// barrier as "<token hash>/<key>"
+	cubbyholePrefix = "cubbyhole/"
+	// cubbyholeMountPath and cubbyholeMountType identify the built-in
+	// cubbyhole engine, which cannot be unmounted, moved or mounted twice
+	cubbyholeMountPath = "cubbyhole/"
+	cubbyholeMountType = "cubbyhole"
+)
+
+// cubbyholeView returns the storage private to the token with a hash
+func (v *Vault) cubbyholeView(hash string) *storage.View {
+	return storage.NewView(v.barrier, cubbyholePrefix+hash+"/")
+}
+
+// destroyCubbyhole deletes the cubbyhole of the token with a hash. The
+// token store calls it for every token it revokes, including tokens
+// revoked when their lease expires.
+func (v *Vault) destroyCubbyhole(hash string) error {
+	return v.cubbyholeView(hash).Clear()
+}
+
+// handleCubbyholeLocked serves a request to the cubbyhole engine from
+// the calling token's own cubbyhole. Policies do not apply: every token
+// may use its own cubbyhole, and no token, root included, can reach
+// another's. Wrapping tokens are refused, so that a wrapped response can
+// only be unwrapped. Callers must hold v.mu.
+func (v *Vault) handleCubbyholeLocked(token string, m *mount, req *logical.Request) (*logical.Response, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	te, err := v.tokenStore.LookupToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if isWrappingToken(te) {
+		return nil, ErrPermissionDenied
+	}
+
+	hash := auth.HashToken(token)
+	req.Storage = v.cubbyholeView(hash)
+
+	if te.NumUses == 0 {
+		return m.backend.HandleRequest(req)
+	}
+
+	if _, err := v.tokenStore.UseToken(token); err != nil {
+		return nil, err
+	}
+	resp, err := m.backend.HandleRequest(req)
+
+	// Taking the last use revoked the token, and its cubbyhole with it,
+	// before the request was served; a write must not outlive it
+	if _, lookupErr := v.tokenStore.LookupToken(token); lookupErr != nil {
+		if destroyErr := v.destroyCubbyhole(hash); destroyErr != nil {
+			return nil, destroyErr
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,495 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+)
+
+const (
+	// leasePrefix stores lease entries behind the barrier by lease ID
+	leasePrefix = "sys/expire/id/"
+	// tokenLeasePath is the path of the leases issued for tokens
+	tokenLeasePath = "auth/token/create"
+	// systemDefaultLeaseTTL and systemMaxLeaseTTL apply to mounts that do
+	// not configure their own lease TTLs
+	systemDefaultLeaseTTL = 768 * time.Hour
+	systemMaxLeaseTTL     = 768 * time.Hour
+	// revokeRetryInterval is how long to wait before retrying a failed
+	// revocation of an expired lease
+	revokeRetryInterval = time.Minute
+)
+
+// ErrLeaseNotFound is returned for unknown or already revoked leases
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// leaseEntry is the persisted state of a lease
+type leaseEntry struct {
+	LeaseID string `json:"lease_id"`
+	// Path is the request path that issued the lease
+	Path string `json:"path"`
+	// TokenHash identifies the token a token lease revokes
+	TokenHash       string                 `json:"token_hash,omitempty"`
+	InternalData    map[string]interface{} `json:"internal_data,omitempty"`
+	Renewable       bool                   `json:"renewable"`
+	TTL             time.Duration          `json:"ttl"`
+	IssueTime       time.Time              `json:"issue_time"`
+	ExpireTime      time.Time              `json:"expire_time"`
+	MaxExpireTime   time.Time              `json:"max_expire_time"`
+	LastRenewalTime *time.Time             `json:"last_renewal_time,omitempty"`
+}
+
+// LeaseInfo describes a lease
+type LeaseInfo struct {
+	ID              string     `json:"id"`
+	IssueTime       time.Time  `json:"issue_time"`
+	ExpireTime      time.Time  `json:"expire_time"`
+	LastRenewalTime *time.Time `json:"last_renewal"`
+	Renewable       bool       `json:"renewable"`
+	// TTL is the number of seconds left on the lease
+	TTL int64 `json:"ttl"`
+}
+
+// expirationManager keeps a timer for every pending lease. mu also
+// serializes changes to lease entries; it is always taken after v.mu.
+type expirationManager struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func newExpirationManager() *expirationManager {
+	return &expirationManager{timers: make(map[string]*time.Timer)}
+}
+
+// scheduleLocked arranges for expire to be called with the lease ID at
+// the given time, replacing any earlier timer for the lease
+func (e *expirationManager) scheduleLocked(leaseID string, at time.Time, expire func(string)) {
+	if timer, ok := e.timers[leaseID]; ok {
+		timer.Stop()
+	}
+	e.timers[leaseID] = time.AfterFunc(time.Until(at), func() { expire(leaseID) })
+}
+
+func (e *expirationManager) cancelLocked(leaseID string) {
+	if timer, ok := e.timers[leaseID]; ok {
+		timer.Stop()
+		delete(e.timers, leaseID)
+	}
+}
+
+// stop cancels every timer; leases stay persisted for the next unseal
+func (e *expirationManager) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, timer := range e.timers {
+		timer.Stop()
+	}
+	e.timers = make(map[string]*time.Timer)
+}
+
+// LookupLease returns the state of a lease
+func (v *Vault) LookupLease(token, leaseID string) (*LeaseInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/leases/lookup", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	le, err := v.loadLease(leaseID)
+	if err != nil {
+		return nil, err
+	}
+	return le.info(), nil
+}
+
+// RenewLease extends a renewable lease by increment, or by the TTL it was
+// issued with if increment is zero. A lease is never extended past the
+// maximum TTL of its mount, counted from when it was issued. Token leases
+// renew their token within the limits it was created with.
+func (v *Vault) RenewLease(token, leaseID string, increment time.Duration) (*LeaseInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/leases/renew", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	if increment < 0 {
+		return nil, errors.New("increment cannot be negative")
+	}
+
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	le, err := v.loadLease(leaseID)
+	if err != nil {
+		return nil, err
+	}
+	if !le.Renewable {
+		return nil, errors.New("lease is not renewable")
+	}
+
+	if le.TokenHash != "" {
+		if _, err := v.renewTokenLeaseLocked(le, increment); err != nil {
+			return nil, err
+		}
+		return le.info(), nil
+	}
+
+	now := time.Now()
+	if !now.Before(le.ExpireTime) {
+		return nil, errors.New("lease has expired")
+	}
+
+	if increment == 0 {
+		increment = le.TTL
+	}
+
+	// The mount's maximum TTL may have been lowered since the lease was issued
+	maxExpire := le.MaxExpireTime
+	if m, _ := v.routeLocked(le.Path); m != nil {
+		_, maxTTL := leaseTTLs(m.entry.Config)
+		maxExpire = minTime(maxExpire, le.IssueTime.Add(maxTTL))
+	}
+
+	le.ExpireTime = minTime(now.Add(increment), maxExpire)
+	le.LastRenewalTime = &now
+
+	if err := v.persistLease(le); err != nil {
+		return nil, err
+	}
+	v.expiration.scheduleLocked(le.LeaseID, le.ExpireTime, v.expireLease)
+	return le.info(), nil
+}
+
+// renewTokenLeaseLocked renews the token of a token lease and moves the
+// lease's expiry along with it. Callers must hold v.mu and
+// v.expiration.mu.
+func (v *Vault) renewTokenLeaseLocked(le *leaseEntry, increment time.Duration) (*auth.Token, error) {
+	// Tokens issued by an auth method are capped by its maximum TTL
+	maxTTL := systemMaxLeaseTTL
+	if m, _ := v.routeLocked(le.Path); m != nil {
+		_, maxTTL = leaseTTLs(m.entry.Config)
+	}
+
+	te, err := v.tokenStore.RenewTokenHash(le.TokenHash, increment, maxTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	le.ExpireTime = te.ExpiresAt
+	le.LastRenewalTime = &now
+
+	if err := v.persistLease(le); err != nil {
+		return nil, err
+	}
+	v.expiration.scheduleLocked(le.LeaseID, le.ExpireTime, v.expireLease)
+	return te, nil
+}
+
+// RevokeLease revokes a lease immediately
+func (v *Vault) RevokeLease(token, leaseID string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/leases/revoke", policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	le, err := v.loadLease(leaseID)
+	if err != nil {
+		return err
+	}
+	return v.revokeLease(le)
+}
+
+// RevokePrefix revokes every lease whose ID starts with prefix
+func (v *Vault) RevokePrefix(token, prefix string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/leases/revoke-prefix/"+prefix, policy.SudoCapability); err != nil {
+		return err
+	}
+
+	return v.revokePrefixLocked(prefix)
+}
+
+// revokePrefixLocked revokes every lease under a prefix. Callers must
+// hold v.mu.
+func (v *Vault) revokePrefixLocked(prefix string) error {
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	keys, err := v.barrier.List(leasePrefix + prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		le, err := v.loadLease(strings.TrimPrefix(key, leasePrefix))
+		if errors.Is(err, ErrLeaseNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := v.revokeLease(le); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerLeaseLocked leases a secret returned for a request to the
+// engine mounted at m. The TTL defaults to and is capped by the mount's
+// lease TTLs. The lease ID and final TTL are written back to the secret.
+// Callers must hold v.mu.
+func (v *Vault) registerLeaseLocked(path string, m *mount, secret *logical.Secret) error {
+	defaultTTL, maxTTL := leaseTTLs(m.entry.Config)
+
+	ttl := secret.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	id, err := crypto.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	le := &leaseEntry{
+		LeaseID:       strings.TrimSuffix(path, "/") + "/" + id,
+		Path:          path,
+		InternalData:  secret.InternalData,
+		Renewable:     secret.Renewable,
+		TTL:           ttl,
+		IssueTime:     now,
+		ExpireTime:    now.Add(ttl),
+		MaxExpireTime: now.Add(maxTTL),
+	}
+	if err := v.addLease(le); err != nil {
+		return err
+	}
+
+	secret.LeaseID = le.LeaseID
+	secret.TTL = ttl
+	return nil
+}
+
+// registerTokenLeaseLocked leases a token issued for a request to path
+// under the lease ID it was created with, so that it is revoked as soon
+// as it expires. Periodic tokens without an explicit max TTL have no
+// maximum expire time. Callers must hold v.mu.
+func (v *Vault) registerTokenLeaseLocked(te *auth.Token, path string, maxTTL time.Duration) (*leaseEntry, error) {
+	var maxExpire time.Time
+	switch {
+	case te.ExplicitMaxTTL > 0:
+		maxExpire = te.CreatedAt.Add(te.ExplicitMaxTTL)
+	case te.Period == 0:
+		maxExpire = te.CreatedAt.Add(maxTTL)
+	}
+
+	le := &leaseEntry{
+		LeaseID:       te.LeaseID,
+		Path:          path,
+		TokenHash:     auth.HashToken(te.ID),
+		Renewable:     te.Renewable,
+		TTL:           te.TTL,
+		IssueTime:     te.CreatedAt,
+		ExpireTime:    te.ExpiresAt,
+		MaxExpireTime: maxExpire,
+	}
+	if err := v.addLease(le); err != nil {
+		return nil, err
+	}
+	return le, nil
+}
+
+func (v *Vault) addLease(le *leaseEntry) error {
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	if err := v.persistLease(le); err != nil {
+		return err
+	}
+	v.expiration.scheduleLocked(le.LeaseID, le.ExpireTime, v.expireLease)
+	return nil
+}
+
+// expireLease is called when a lease's timer fires. The entry is
+// reloaded so that a lease renewed or revoked meanwhile is left alone.
+func (v *Vault) expireLease(leaseID string) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return
+	}
+
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	le, err := v.loadLease(leaseID)
+	if err != nil || time.Now().Before(le.ExpireTime) {
+		return
+	}
+
+	if err := v.revokeLease(le); err != nil {
+		// Keep the lease and try again rather than losing track of it
+		v.expiration.scheduleLocked(leaseID, time.Now().Add(revokeRetryInterval), v.expireLease)
+	}
+}
+
+// revokeLease runs the revocation for a lease and deletes it: tokens are
+// revoked, and engines implementing logical.Revoker clean up the secret.
+// Leases of engines that are no longer mounted are simply deleted.
+// Callers must hold v.mu and v.expiration.mu.
+func (v *Vault) revokeLease(le *leaseEntry) error {
+	if le.TokenHash != "" {
+		if err := v.tokenStore.RevokeTokenHash(le.TokenHash); err != nil {
+			return err
+		}
+	} else if m, relative := v.routeLocked(le.Path); m != nil {
+		if revoker, ok := m.backend.(logical.Revoker); ok {
+			err := revoker.Revoke(&logical.Request{
+				Operation:  logical.RevokeOperation,
+				Path:       relative,
+				MountPoint: m.mountPoint(),
+				Storage:    m.view,
+				Secret: &logical.Secret{
+					LeaseID:      le.LeaseID,
+					TTL:          le.TTL,
+					Renewable:    le.Renewable,
+					InternalData: le.InternalData,
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revoke lease %s: %v", le.LeaseID, err)
+			}
+		}
+	}
+
+	if err := v.barrier.Delete(leasePrefix + le.LeaseID); err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return err
+	}
+	v.expiration.cancelLocked(le.LeaseID)
+	return nil
+}
+
+// setupExpiration restores the timers of every persisted lease. Leases
+// that expired while the vault was sealed are revoked right away.
+func (v *Vault) setupExpiration() error {
+	keys, err := v.barrier.List(leasePrefix)
+	if err != nil {
+		return err
+	}
+
+	e := newExpirationManager()
+	for _, key := range keys {
+		le, err := v.loadLease(strings.TrimPrefix(key, leasePrefix))
+		if err != nil {
+			return err
+		}
+		e.scheduleLocked(le.LeaseID, le.ExpireTime, v.expireLease)
+	}
+
+	v.expiration = e
+	return nil
+}
+
+func (v *Vault) loadLease(leaseID string) (*leaseEntry, error) {
+	data, err := v.barrier.Get(leasePrefix + leaseID)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, ErrLeaseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var le leaseEntry
+	if err := json.Unmarshal(data, &le); err != nil {
+		return nil, err
+	}
+	return &le, nil
+}
+
+func (v *Vault) persistLease(le *leaseEntry) error {
+	data, err := json.Marshal(le)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(leasePrefix+le.LeaseID, data)
+}
+
+func (le *leaseEntry) info() *LeaseInfo {
+	ttl := time.Until(le.ExpireTime)
+	if ttl < 0 {
+		ttl = 0
+	}
+	return &LeaseInfo{
+		ID:              le.LeaseID,
+		IssueTime:       le.IssueTime,
+		ExpireTime:      le.ExpireTime,
+		LastRenewalTime: le.LastRenewalTime,
+		Renewable:       le.Renewable,
+		TTL:             int64(ttl / time.Second),
+	}
+}
+
+// leaseTTLs resolves the default and maximum lease TTLs of a mount
+func leaseTTLs(config MountConfig) (time.Duration, time.Duration) {
+	defaultTTL, maxTTL := config.DefaultLeaseTTL, config.MaxLeaseTTL
+	if maxTTL == 0 {
+		maxTTL = systemMaxLeaseTTL
+	}
+	if defaultTTL == 0 || defaultTTL > maxTTL {
+		defaultTTL = min(systemDefaultLeaseTTL, maxTTL)
+	}
+	return defaultTTL, maxTTL
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
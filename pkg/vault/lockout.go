@@ -0,0 +1,538 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/audit"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+)
+
+// lockoutConfigPath stores the login lockout config behind the barrier
+const lockoutConfigPath = "core/lockout-config"
+
+// Defaults for the login lockout config
+const (
+	defaultLockoutThreshold    = 5
+	defaultIPLockoutThreshold  = 20
+	defaultLockoutDuration     = 15 * time.Minute
+	defaultLockoutCounterReset = 15 * time.Minute
+)
+
+var (
+	// ErrLoginLocked is returned for logins by a user or from an address
+	// that is locked out after too many failed attempts
+	ErrLoginLocked = errors.New("too many failed login attempts, try again later")
+	// ErrLockoutNotFound is returned when unlocking a user or address
+	// that is not locked out
+	ErrLockoutNotFound = errors.New("no lockout found")
+)
+
+// LockoutConfig controls how failed logins lock out users and client
+// addresses
+type LockoutConfig struct {
+	// Threshold is the number of failed logins after which a user of an
+	// auth method is locked out
+	Threshold int
+	// IPThreshold is the number of failed logins, for any user and auth
+	// method, after which a client address is locked out
+	IPThreshold int
+	// Duration is how long a lockout lasts
+	Duration time.Duration
+	// CounterReset is how long after the last failure the count of
+	// failed logins starts over
+	CounterReset time.Duration
+	// Disabled turns lockouts off, including current ones; failures are
+	// still counted
+	Disabled bool
+}
+
+// LockoutTune lists the settings changed by a lockout config update; nil
+// fields are left unchanged
+type LockoutTune struct {
+	Threshold    *int
+	IPThreshold  *int
+	Duration     *time.Duration
+	CounterReset *time.Duration
+	Disabled     *bool
+}
+
+// LockedUser is a user locked out of an auth method
+type LockedUser struct {
+	MountPath   string    `json:"mount_path"`
+	Alias       string    `json:"alias"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// LockedAddress is a client address locked out of every auth method
+type LockedAddress struct {
+	RemoteAddress string    `json:"remote_address"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// LockedLogins lists the current lockouts
+type LockedLogins struct {
+	Users     []*LockedUser    `json:"users"`
+	Addresses []*LockedAddress `json:"addresses"`
+}
+
+type lockoutConfigJSON struct {
+	Threshold    int         `json:"lockout_threshold"`
+	IPThreshold  int         `json:"ip_lockout_threshold"`
+	Duration     interface{} `json:"lockout_duration"`
+	CounterReset interface{} `json:"lockout_counter_reset"`
+	Disabled     bool        `json:"disable_lockout"`
+}
+
+// MarshalJSON encodes durations as whole seconds
+func (c LockoutConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"lockout_threshold":     c.Threshold,
+		"ip_lockout_threshold":  c.IPThreshold,
+		"lockout_duration":      int64(c.Duration / time.Second),
+		"lockout_counter_reset": int64(c.CounterReset / time.Second),
+		"disable_lockout":       c.Disabled,
+	})
+}
+
+// UnmarshalJSON accepts durations as seconds or duration strings
+func (c *LockoutConfig) UnmarshalJSON(data []byte) error {
+	var raw lockoutConfigJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.Threshold = raw.Threshold
+	c.IPThreshold = raw.IPThreshold
+	c.Disabled = raw.Disabled
+
+	var err error
+	if c.Duration, err = logical.ParseTTL(raw.Duration); err != nil {
+		return fmt.Errorf("invalid lockout_duration: %v", err)
+	}
+	if c.CounterReset, err = logical.ParseTTL(raw.CounterReset); err != nil {
+		return fmt.Errorf("invalid lockout_counter_reset: %v", err)
+	}
+	return nil
+}
+
+// Validate checks that thresholds and durations are positive
+func (c *LockoutConfig) Validate() error {
+	if c.Threshold < 1 || c.IPThreshold < 1 {
+		return errors.New("lockout thresholds must be at least 1")
+	}
+	if c.Duration <= 0 || c.CounterReset <= 0 {
+		return errors.New("lockout_duration and lockout_counter_reset must be positive")
+	}
+	return nil
+}
+
+func defaultLockoutConfig() *LockoutConfig {
+	return &LockoutConfig{
+		Threshold:    defaultLockoutThreshold,
+		IPThreshold:  defaultIPLockoutThreshold,
+		Duration:     defaultLockoutDuration,
+		CounterReset: defaultLockoutCounterReset,
+	}
+}
+
+// loginUser identifies a user of an auth method
+type loginUser struct {
+	mountPath string
+	alias     string
+}
+
+// loginFailures counts the failed logins of a user or address
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// locked reports whether the lockout is still in force
+func (f *loginFailures) locked(now time.Time) bool {
+	return now.Before(f.lockedUntil)
+}
+
+// stale reports whether the entry can be forgotten: it is not locked and
+// its count has started over
+func (f *loginFailures) stale(now time.Time, config *LockoutConfig) bool {
+	return !f.locked(now) && now.Sub(f.lastFailure) > config.CounterReset
+}
+
+// fail counts a failure and locks the entry once it reaches the
+// threshold. It reports whether the failure started a lockout.
+func (f *loginFailures) fail(now time.Time, threshold int, config *LockoutConfig) bool {
+	if !f.locked(now) && now.Sub(f.lastFailure) > config.CounterReset {
+		f.count = 0
+	}
+	f.count++
+	f.lastFailure = now
+	if config.Disabled || f.count < threshold || f.locked(now) {
+		return false
+	}
+
+	f.count = 0
+	f.lockedUntil = now.Add(config.Duration)
+	return true
+}
+
+// loginTracker counts failed logins per user and per client address. It
+// is not persisted and survives a seal, so that sealing and unsealing
+// does not lift lockouts.
+type loginTracker struct {
+	mu        sync.Mutex
+	users     map[loginUser]*loginFailures
+	addresses map[string]*loginFailures
+}
+
+func newLoginTracker() *loginTracker {
+	return &loginTracker{
+		users:     make(map[loginUser]*loginFailures),
+		addresses: make(map[string]*loginFailures),
+	}
+}
+
+// check returns ErrLoginLocked if the user or the address of a login
+// attempt is locked out, unless lockouts are disabled. An empty alias or
+// address is not tracked.
+func (t *loginTracker) check(user loginUser, addr string, now time.Time, config *LockoutConfig) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checkLocked(user, addr, now, config)
+}
+
+func (t *loginTracker) checkLocked(user loginUser, addr string, now time.Time, config *LockoutConfig) error {
+	if config.Disabled {
+		return nil
+	}
+	if f, ok := t.users[user]; ok && user.alias != "" && f.locked(now) {
+		return ErrLoginLocked
+	}
+	if f, ok := t.addresses[addr]; ok && addr != "" && f.locked(now) {
+		return ErrLoginLocked
+	}
+	return nil
+}
+
+// succeed records a login with the right credentials. Failures of
+// concurrent attempts may have locked out the user or the address while
+// they were checked, in which case the login is refused with
+// ErrLoginLocked. A success clears the failures of the user, but not of
+// the address, so that one valid account does not let a client keep
+// guessing the passwords of others.
+func (t *loginTracker) succeed(user loginUser, addr string, now time.Time, config *LockoutConfig) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.checkLocked(user, addr, now, config); err != nil {
+		return err
+	}
+	if user.alias != "" {
+		delete(t.users, user)
+	}
+	return nil
+}
+
+// fail records a login with wrong credentials and reports whether it
+// locked out the user or the address
+func (t *loginTracker) fail(user loginUser, addr string, now time.Time, config *LockoutConfig) (userLocked, addrLocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pruneLocked(now, config)
+
+	if user.alias != "" {
+		userLocked = failuresFor(t.users, user).fail(now, config.Threshold, config)
+	}
+	if addr != "" {
+		addrLocked = failuresFor(t.addresses, addr).fail(now, config.IPThreshold, config)
+	}
+	return userLocked, addrLocked
+}
+
+// failuresFor returns the entry for key, starting one if there is none
+func failuresFor[K comparable](failures map[K]*loginFailures, key K) *loginFailures {
+	f, ok := failures[key]
+	if !ok {
+		f = &loginFailures{}
+		failures[key] = f
+	}
+	return f
+}
+
+// pruneLocked forgets entries that are neither locked nor counting
+func (t *loginTracker) pruneLocked(now time.Time, config *LockoutConfig) {
+	for user, f := range t.users {
+		if f.stale(now, config) {
+			delete(t.users, user)
+		}
+	}
+	for addr, f := range t.addresses {
+		if f.stale(now, config) {
+			delete(t.addresses, addr)
+		}
+	}
+}
+
+// ReadLockoutConfig returns the login lockout config
+func (v *Vault) ReadLockoutConfig(token string) (*LockoutConfig, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/config/lockout", policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	return v.lockoutConfigLocked()
+}
+
+// TuneLockoutConfig changes the login lockout config. The new settings
+// apply to the next failed login; current lockouts keep their end time.
+func (v *Vault) TuneLockoutConfig(token string, tune *LockoutTune) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/config/lockout", policy.SudoCapability); err != nil {
+		return err
+	}
+
+	config, err := v.lockoutConfigLocked()
+	if err != nil {
+		return err
+	}
+	if tune.Threshold != nil {
+		config.Threshold = *tune.Threshold
+	}
+	if tune.IPThreshold != nil {
+		config.IPThreshold = *tune.IPThreshold
+	}
+	if tune.Duration != nil {
+		config.Duration = *tune.Duration
+	}
+	if tune.CounterReset != nil {
+		config.CounterReset = *tune.CounterReset
+	}
+	if tune.Disabled != nil {
+		config.Disabled = *tune.Disabled
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", logical.ErrInvalidRequest, err)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(lockoutConfigPath, data)
+}
+
+// ListLockedLogins returns the users and addresses that are locked out,
+// sorted by mount path and alias, and by address
+func (v *Vault) ListLockedLogins(token string) (*LockedLogins, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/locked-users", policy.SudoCapability); err != nil {
+		return nil, err
+	}
+
+	t := v.logins
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	locked := &LockedLogins{Users: []*LockedUser{}, Addresses: []*LockedAddress{}}
+	for user, f := range t.users {
+		if f.locked(now) {
+			locked.Users = append(locked.Users, &LockedUser{
+				MountPath:   user.mountPath,
+				Alias:       user.alias,
+				LockedUntil: f.lockedUntil.UTC(),
+			})
+		}
+	}
+	for addr, f := range t.addresses {
+		if f.locked(now) {
+			locked.Addresses = append(locked.Addresses, &LockedAddress{
+				RemoteAddress: addr,
+				LockedUntil:   f.lockedUntil.UTC(),
+			})
+		}
+	}
+
+	sort.Slice(locked.Users, func(i, j int) bool {
+		a, b := locked.Users[i], locked.Users[j]
+		if a.MountPath != b.MountPath {
+			return a.MountPath < b.MountPath
+		}
+		return a.Alias < b.Alias
+	})
+	sort.Slice(locked.Addresses, func(i, j int) bool {
+		return locked.Addresses[i].RemoteAddress < locked.Addresses[j].RemoteAddress
+	})
+	return locked, nil
+}
+
+// UnlockUser lifts the lockout of a user of the auth method at mountPath,
+// which is relative to "auth/", and forgets its failed logins
+func (v *Vault) UnlockUser(token, mountPath, alias string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	mountPath, err := sanitizeMountPath(mountPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/locked-users/unlock", policy.SudoCapability); err != nil {
+		return err
+	}
+
+	t := v.logins
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	user := loginUser{mountPath: mountPath, alias: alias}
+	f, ok := t.users[user]
+	if !ok || !f.locked(time.Now()) {
+		return ErrLockoutNotFound
+	}
+	delete(t.users, user)
+	return nil
+}
+
+// UnlockAddress lifts the lockout of a client address and forgets its
+// failed logins
+func (v *Vault) UnlockAddress(token, addr string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/locked-users/unlock", policy.SudoCapability); err != nil {
+		return err
+	}
+
+	t := v.logins
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.addresses[addr]
+	if !ok || !f.locked(time.Now()) {
+		return ErrLockoutNotFound
+	}
+	delete(t.addresses, addr)
+	return nil
+}
+
+// lockoutConfigLocked loads the lockout config, or the defaults if none
+// was written. Callers must hold v.mu.
+func (v *Vault) lockoutConfigLocked() (*LockoutConfig, error) {
+	data, err := v.barrier.Get(lockoutConfigPath)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return defaultLockoutConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config LockoutConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// checkLoginLocked refuses a login attempt if the user or the address
+// is locked out. Callers must hold v.mu.
+func (v *Vault) checkLoginLocked(user loginUser, addr string) error {
+	config, err := v.lockoutConfigLocked()
+	if err != nil {
+		return err
+	}
+	return v.logins.check(user, addr, time.Now(), config)
+}
+
+// succeedLoginLocked records a login with the right credentials, which
+// is refused if concurrent failures locked out the user or the address
+// in the meantime. Callers must hold v.mu.
+func (v *Vault) succeedLoginLocked(user loginUser, addr string) error {
+	config, err := v.lockoutConfigLocked()
+	if err != nil {
+		return err
+	}
+	return v.logins.succeed(user, addr, time.Now(), config)
+}
+
+// failLoginLocked counts a login with wrong credentials. Logins that
+// fail for another reason, such as a missing MFA passcode, are not
+// counted. A lockout is recorded with every audit device. Callers must
+// hold v.mu.
+func (v *Vault) failLoginLocked(user loginUser, addr string) {
+	config, err := v.lockoutConfigLocked()
+	if err != nil {
+		config = defaultLockoutConfig()
+	}
+
+	now := time.Now()
+	userLocked, addrLocked := v.logins.fail(user, addr, now, config)
+	until := now.Add(config.Duration).UTC()
+
+	// Lockouts are recorded on a best-effort basis; the failed login
+	// itself is audited with its response
+	if userLocked {
+		v.logAuditLocked("", &audit.Entry{
+			Type: audit.TypeLockout,
+			Lockout: &audit.Lockout{
+				MountPath:     user.mountPath,
+				Alias:         user.alias,
+				RemoteAddress: addr,
+				LockedUntil:   until,
+			},
+		})
+	}
+	if addrLocked {
+		v.logAuditLocked("", &audit.Entry{
+			Type: audit.TypeLockout,
+			Lockout: &audit.Lockout{
+				RemoteAddress: addr,
+				LockedUntil:   until,
+			},
+		})
+	}
+}
+
+// loginAlias names the user a login request is for, if the auth method
+// can tell before checking credentials
+func loginAlias(m *mount, req *logical.Request) string {
+	resolver, ok := m.backend.(logical.AliasResolver)
+	if !ok {
+		return ""
+	}
+	return resolver.LoginAlias(req)
+}
@@ -0,0 +1,160 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/logical"
+)
+
+func TestLoginTrackerConcurrentAttempts(t *testing.T) {
+	const attempts = 64
+	config := defaultLockoutConfig()
+	tracker := newLoginTracker()
+	user := loginUser{mountPath: "approle/", alias: "web"}
+
+	// Attempts in flight are not failures, so every one may start before
+	// any of them fails
+	var checked, wg sync.WaitGroup
+	var locks atomic.Int32
+	checked.Add(attempts)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A distinct address each, so only the user limit applies
+			addr := fmt.Sprintf("192.0.2.%d", i)
+			err := tracker.check(user, addr, time.Now(), config)
+			checked.Done()
+			if err != nil {
+				t.Errorf("check: %v", err)
+				return
+			}
+			checked.Wait()
+			if userLocked, _ := tracker.fail(user, addr, time.Now(), config); userLocked {
+				locks.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := locks.Load(); got != 1 {
+		t.Fatalf("%d failures locked the user out, want 1", got)
+	}
+	if err := tracker.check(user, "192.0.2.200", time.Now(), config); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("check after the lockout: %v, want ErrLoginLocked", err)
+	}
+}
+
+func TestLoginTrackerSucceed(t *testing.T) {
+	config := defaultLockoutConfig()
+	tracker := newLoginTracker()
+	user := loginUser{mountPath: "userpass/", alias: "alice"}
+	now := time.Now()
+
+	// A success clears the failures of the user
+	for range config.Threshold - 1 {
+		tracker.fail(user, "", now, config)
+	}
+	if err := tracker.succeed(user, "", now, config); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+
+	// A success checked while concurrent failures locked the user out is
+	// refused
+	if err := tracker.check(user, "", now, config); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	for i := range config.Threshold {
+		userLocked, _ := tracker.fail(user, "", now, config)
+		if want := i == config.Threshold-1; userLocked != want {
+			t.Fatalf("failure %d locked = %v, want %v", i+1, userLocked, want)
+		}
+	}
+	if err := tracker.succeed(user, "", now, config); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("success after the lockout: %v, want ErrLoginLocked", err)
+	}
+
+	// Unless lockouts are disabled
+	config.Disabled = true
+	if err := tracker.succeed(user, "", now, config); err != nil {
+		t.Fatalf("success with lockouts disabled: %v", err)
+	}
+}
+
+func TestAuthenticateRootTokenLockout(t *testing.T) {
+	v := openVault(t, t.TempDir())
+	initResp, err := v.Initialize(nil)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	unseal(t, v, initResp.Keys)
+
+	config := defaultLockoutConfig()
+	for range config.IPThreshold {
+		if err := v.AuthenticateRootToken("wrong", "192.0.2.1"); errors.Is(err, ErrLoginLocked) {
+			t.Fatal("address locked out before its threshold")
+		}
+	}
+	if err := v.AuthenticateRootToken(initResp.RootToken, "192.0.2.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("root token from a locked out address: %v, want ErrLoginLocked", err)
+	}
+
+	// Guesses from one address must not lock the root token out elsewhere
+	if err := v.AuthenticateRootToken(initResp.RootToken, "192.0.2.2"); err != nil {
+		t.Fatalf("root token from another address: %v", err)
+	}
+}
+
+func TestAppRoleLockoutPerClient(t *testing.T) {
+	v, root := unsealedVault(t)
+	if err := v.EnableAuth(root, &MountEntry{Path: "approle", Type: "approle"}); err != nil {
+		t.Fatalf("EnableAuth: %v", err)
+	}
+	request := func(token string, path string, data map[string]interface{}, addr string) (map[string]interface{}, error) {
+		resp, err := v.HandleRequest(token, &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       path,
+			Data:       data,
+			RemoteAddr: addr,
+		})
+		if err != nil || resp == nil {
+			return nil, err
+		}
+		out, _ := resp.Data.(map[string]interface{})
+		return out, nil
+	}
+	if _, err := request(root, "auth/approle/role/ci", map[string]interface{}{"token_policies": "default"}, ""); err != nil {
+		t.Fatalf("create role: %v", err)
+	}
+	if _, err := request(root, "auth/approle/role/ci/role-id", map[string]interface{}{"role_id": "ci-role"}, ""); err != nil {
+		t.Fatalf("set role_id: %v", err)
+	}
+	resp, err := request(root, "auth/approle/role/ci/secret-id", nil, "")
+	if err != nil {
+		t.Fatalf("generate secret_id: %v", err)
+	}
+	secretID := resp["secret_id"].(string)
+
+	login := func(secretID, addr string) error {
+		_, err := request("", "auth/approle/login", map[string]interface{}{"role_id": "ci-role", "secret_id": secretID}, addr)
+		return err
+	}
+	for range defaultLockoutThreshold {
+		if err := login("wrong", "192.0.2.1"); !errors.Is(err, logical.ErrInvalidCredentials) {
+			t.Fatalf("login with a wrong secret_id: %v", err)
+		}
+	}
+	if err := login(secretID, "192.0.2.1"); !errors.Is(err, ErrLoginLocked) {
+		t.Fatalf("login from the guessing client: %v, want ErrLoginLocked", err)
+	}
+
+	// Other machines sharing the role can still log in
+	if err := login(secretID, "192.0.2.2"); err != nil {
+		t.Fatalf("login from another client: %v", err)
+	}
+}
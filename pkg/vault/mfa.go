@@ -0,0 +1,789 @@
+package vault
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+	"vault-clone/pkg/totp"
+)
+
+const (
+	// mfaMethodPrefix stores MFA method configs behind the barrier
+	mfaMethodPrefix = "core/mfa/method/"
+	// mfaEnforcementPrefix stores login enforcements behind the barrier
+	mfaEnforcementPrefix = "core/mfa/login-enforcement/"
+	// mfaSecretPrefix stores enrolled TOTP secrets as
+	// "<method>/<hash of entity ID>"
+	mfaSecretPrefix = "core/mfa/secret/"
+
+	// mfaGrantTTL is how long a validated passcode satisfies the paths
+	// that require its method. A passcode can only be used once, so
+	// this lets a client make several requests within one period.
+	mfaGrantTTL = time.Minute
+	// mfaLockoutDuration is how long an entity cannot use a method after
+	// too many wrong passcodes
+	mfaLockoutDuration = 5 * time.Minute
+)
+
+// Defaults for unset MFA method fields
+const (
+	defaultMFAIssuer                = "Vault"
+	defaultMFASkew                  = 1
+	defaultMFAMaxValidationAttempts = 5
+)
+
+var (
+	// ErrMFARequired is returned when a login or path requires an MFA
+	// method and no passcode for it was given
+	ErrMFARequired = errors.New("multi-factor authentication required")
+	// ErrMFAFailed is returned for a wrong or reused passcode, or when
+	// the entity has not enrolled in the method
+	ErrMFAFailed = errors.New("multi-factor authentication failed")
+	// ErrMFALocked is returned while an entity is locked out of a method
+	// after too many wrong passcodes
+	ErrMFALocked = errors.New("too many failed passcode attempts")
+	// ErrMFAMethodNotFound is returned for unknown MFA methods
+	ErrMFAMethodNotFound = errors.New("MFA method not found")
+	// ErrMFAEnforcementNotFound is returned for unknown login enforcements
+	ErrMFAEnforcementNotFound = errors.New("MFA login enforcement not found")
+)
+
+// MFAMethod is a TOTP method users enroll in with an authenticator app
+type MFAMethod struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Issuer string `json:"issuer"`
+	// Period is the number of seconds a passcode is valid for
+	Period    int    `json:"period"`
+	Digits    int    `json:"digits"`
+	Algorithm string `json:"algorithm"`
+	// Skew is the number of periods before and after the current one
+	// whose passcodes are also accepted, to allow for clock drift. It
+	// defaults to 1.
+	Skew *int `json:"skew"`
+	// MaxValidationAttempts is the number of wrong passcodes after which
+	// the entity is locked out of the method for a while
+	MaxValidationAttempts int `json:"max_validation_attempts"`
+}
+
+// MFALoginEnforcement requires passcodes for MFA methods on logins to
+// the auth methods of the given types or at the given paths
+type MFALoginEnforcement struct {
+	Name            string   `json:"name"`
+	MFAMethods      []string `json:"mfa_methods"`
+	AuthMethodTypes []string `json:"auth_method_types"`
+	AuthMethodPaths []string `json:"auth_method_paths"`
+}
+
+// MFASecret is returned when a TOTP secret is generated. The secret is
+// shown only once.
+type MFASecret struct {
+	EntityID string `json:"entity_id"`
+	// URL is the otpauth:// URL to show as a QR code
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// mfaEnrollment is the persisted TOTP secret of an entity
+type mfaEnrollment struct {
+	EntityID string `json:"entity_id"`
+	Secret   []byte `json:"secret"`
+	// LastCounter is the time step of the last accepted passcode; older
+	// and equal steps are refused so that a passcode works only once
+	LastCounter uint64 `json:"last_counter"`
+}
+
+// mfaTracker holds the state of passcode validation that is not
+// persisted: failed attempts and the grants of validated passcodes. Its
+// mutex also serializes every change to MFA storage.
+type mfaTracker struct {
+	mu       sync.Mutex
+	failures map[string]*mfaFailures
+	// grants maps token accessors to the methods they passed and when
+	// each grant runs out
+	grants map[string]map[string]time.Time
+}
+
+type mfaFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+func newMFATracker() *mfaTracker {
+	return &mfaTracker{
+		failures: make(map[string]*mfaFailures),
+		grants:   make(map[string]map[string]time.Time),
+	}
+}
+
+// reset forgets failed attempts and grants
+func (t *mfaTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures = make(map[string]*mfaFailures)
+	t.grants = make(map[string]map[string]time.Time)
+}
+
+// checkGrants returns an error naming the methods a token has not
+// passed recently
+func (t *mfaTracker) checkGrants(accessor string, methods []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var missing []string
+	for _, method := range methods {
+		if expiry, ok := t.grants[accessor][method]; !ok || now.After(expiry) {
+			missing = append(missing, method)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w for MFA methods: %s", ErrMFARequired, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// grantLocked records a validated passcode for a token. Callers must
+// hold t.mu.
+func (t *mfaTracker) grantLocked(accessor, method string, now time.Time) {
+	for a, methods := range t.grants {
+		for m, expiry := range methods {
+			if now.After(expiry) {
+				delete(methods, m)
+			}
+		}
+		if len(methods) == 0 {
+			delete(t.grants, a)
+		}
+	}
+
+	if t.grants[accessor] == nil {
+		t.grants[accessor] = make(map[string]time.Time)
+	}
+	t.grants[accessor][method] = now.Add(mfaGrantTTL)
+}
+
+// mfaEntityID returns the identity a token's MFA enrollments belong to:
+// the entity it was issued to, or the token itself
+func mfaEntityID(te *auth.Token) string {
+	if te.EntityID != "" {
+		return te.EntityID
+	}
+	return "token/" + te.Accessor
+}
+
+// loginEntityID returns the identity of a principal that logged in to
+// the auth method mounted at m, or "" when the method did not name one
+func loginEntityID(m *mount, a *logical.Auth) string {
+	if a.Alias == "" {
+		return ""
+	}
+	return credentialRoutePrefix + m.entry.Path + a.Alias
+}
+
+func (m *MFAMethod) params() totp.Params {
+	return totp.Params{Algorithm: m.Algorithm, Digits: m.Digits, Period: time.Duration(m.Period) * time.Second}
+}
+
+// setDefaults fills in unset fields and validates the method
+func (m *MFAMethod) setDefaults() error {
+	if m.Type == "" {
+		m.Type = "totp"
+	}
+	if m.Type != "totp" {
+		return fmt.Errorf("unsupported MFA method type %q", m.Type)
+	}
+	if m.Issuer == "" {
+		m.Issuer = defaultMFAIssuer
+	}
+	if m.Period == 0 {
+		m.Period = int(totp.DefaultParams.Period / time.Second)
+	}
+	if m.Digits == 0 {
+		m.Digits = totp.DefaultParams.Digits
+	}
+	if m.Algorithm == "" {
+		m.Algorithm = totp.DefaultParams.Algorithm
+	}
+	m.Algorithm = strings.ToUpper(m.Algorithm)
+	if m.Skew == nil {
+		skew := defaultMFASkew
+		m.Skew = &skew
+	}
+	if m.MaxValidationAttempts == 0 {
+		m.MaxValidationAttempts = defaultMFAMaxValidationAttempts
+	}
+
+	if err := m.params().Validate(); err != nil {
+		return err
+	}
+	if *m.Skew < 0 || *m.Skew > 1 {
+		return errors.New("skew must be 0 or 1")
+	}
+	if m.MaxValidationAttempts < 0 {
+		return errors.New("max_validation_attempts cannot be negative")
+	}
+	return nil
+}
+
+// ListMFAMethods returns the names of all MFA methods
+func (v *Vault) ListMFAMethods(token string) ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method", policy.ListCapability); err != nil {
+		return nil, err
+	}
+
+	return v.listMFAKeys(mfaMethodPrefix)
+}
+
+// ReadMFAMethod returns an MFA method's settings
+func (v *Vault) ReadMFAMethod(token, name string) (*MFAMethod, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method/totp/"+name, policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	return v.loadMFAMethod(name)
+}
+
+// WriteMFAMethod creates or updates a TOTP method. Changing the
+// algorithm, digits or period of a method invalidates the secrets
+// enrolled with it.
+func (v *Vault) WriteMFAMethod(token string, method *MFAMethod) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	method.Name = strings.ToLower(method.Name)
+	if err := policy.ValidateName(method.Name); err != nil {
+		return errors.New("invalid MFA method name")
+	}
+
+	capability := policy.UpdateCapability
+	if _, err := v.loadMFAMethod(method.Name); errors.Is(err, ErrMFAMethodNotFound) {
+		capability = policy.CreateCapability
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method/totp/"+method.Name, capability); err != nil {
+		return err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	if err := method.setDefaults(); err != nil {
+		return err
+	}
+	return v.putMFAJSON(mfaMethodPrefix+method.Name, method)
+}
+
+// DeleteMFAMethod deletes an MFA method and every secret enrolled with
+// it. Methods used by login enforcements cannot be deleted; paths whose
+// policies still require the method can no longer be used.
+func (v *Vault) DeleteMFAMethod(token, name string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method/totp/"+name, policy.DeleteCapability); err != nil {
+		return err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	if _, err := v.loadMFAMethod(name); err != nil {
+		return err
+	}
+
+	enforcements, err := v.loadMFAEnforcements()
+	if err != nil {
+		return err
+	}
+	for _, e := range enforcements {
+		if slices.Contains(e.MFAMethods, name) {
+			return fmt.Errorf("MFA method %q is used by login enforcement %q", name, e.Name)
+		}
+	}
+
+	keys, err := v.barrier.List(mfaSecretPrefix + name + "/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := v.barrier.Delete(key); err != nil {
+			return err
+		}
+	}
+	return v.barrier.Delete(mfaMethodPrefix + name)
+}
+
+// GenerateMFASecret enrolls the calling token's entity in a TOTP method.
+// An entity that is already enrolled must have an operator destroy its
+// secret first, so a stolen token cannot replace it.
+func (v *Vault) GenerateMFASecret(token, name string) (*MFASecret, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	te, err := v.authorize(token, "sys/mfa/method/totp/"+name+"/generate", policy.UpdateCapability)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	entityID := mfaEntityID(te)
+	if _, err := v.loadMFAEnrollment(name, entityID); err == nil {
+		return nil, fmt.Errorf("entity %q is already enrolled in MFA method %q", entityID, name)
+	}
+	return v.generateMFASecretLocked(name, entityID)
+}
+
+// AdminGenerateMFASecret enrolls an entity in a TOTP method, replacing
+// any secret it already has
+func (v *Vault) AdminGenerateMFASecret(token, name, entityID string) (*MFASecret, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method/totp/"+name+"/admin-generate", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+	if entityID == "" {
+		return nil, errors.New("missing entity_id")
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	return v.generateMFASecretLocked(name, entityID)
+}
+
+// AdminDestroyMFASecret removes an entity's secret for a TOTP method
+func (v *Vault) AdminDestroyMFASecret(token, name, entityID string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/method/totp/"+name+"/admin-destroy", policy.UpdateCapability); err != nil {
+		return err
+	}
+	if entityID == "" {
+		return errors.New("missing entity_id")
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	if _, err := v.loadMFAMethod(name); err != nil {
+		return err
+	}
+	delete(v.mfa.failures, name+"/"+entityID)
+	return v.barrier.Delete(mfaSecretKey(name, entityID))
+}
+
+// generateMFASecretLocked creates and stores a new secret. Callers must
+// hold v.mfa.mu.
+func (v *Vault) generateMFASecretLocked(name, entityID string) (*MFASecret, error) {
+	method, err := v.loadMFAMethod(name)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.putMFAJSON(mfaSecretKey(name, entityID), &mfaEnrollment{EntityID: entityID, Secret: secret}); err != nil {
+		return nil, err
+	}
+	delete(v.mfa.failures, name+"/"+entityID)
+
+	return &MFASecret{
+		EntityID: entityID,
+		URL:      totp.URL(method.Issuer, entityID, secret, method.params()),
+		Secret:   totp.EncodeSecret(secret),
+	}, nil
+}
+
+// ListMFALoginEnforcements returns the names of all login enforcements
+func (v *Vault) ListMFALoginEnforcements(token string) ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/login-enforcement", policy.ListCapability); err != nil {
+		return nil, err
+	}
+
+	return v.listMFAKeys(mfaEnforcementPrefix)
+}
+
+// ReadMFALoginEnforcement returns a login enforcement
+func (v *Vault) ReadMFALoginEnforcement(token, name string) (*MFALoginEnforcement, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/login-enforcement/"+name, policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	return v.loadMFAEnforcement(name)
+}
+
+// WriteMFALoginEnforcement creates or replaces a login enforcement. Its
+// methods must exist, and it must name at least one auth method type or
+// path.
+func (v *Vault) WriteMFALoginEnforcement(token string, e *MFALoginEnforcement) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	e.Name = strings.ToLower(e.Name)
+	if err := policy.ValidateName(e.Name); err != nil {
+		return errors.New("invalid login enforcement name")
+	}
+
+	capability := policy.UpdateCapability
+	if _, err := v.loadMFAEnforcement(e.Name); errors.Is(err, ErrMFAEnforcementNotFound) {
+		capability = policy.CreateCapability
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/login-enforcement/"+e.Name, capability); err != nil {
+		return err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	if len(e.MFAMethods) == 0 {
+		return errors.New("missing mfa_methods")
+	}
+	for _, name := range e.MFAMethods {
+		if _, err := v.loadMFAMethod(name); err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+	}
+	if len(e.AuthMethodTypes) == 0 && len(e.AuthMethodPaths) == 0 {
+		return errors.New("at least one of auth_method_types or auth_method_paths is required")
+	}
+	for _, t := range e.AuthMethodTypes {
+		if _, ok := credentials[t]; !ok {
+			return fmt.Errorf("unknown auth method type %q", t)
+		}
+	}
+	for i, path := range e.AuthMethodPaths {
+		path, err := sanitizeMountPath(strings.TrimPrefix(path, credentialRoutePrefix))
+		if err != nil {
+			return err
+		}
+		e.AuthMethodPaths[i] = path
+	}
+	if e.AuthMethodTypes == nil {
+		e.AuthMethodTypes = []string{}
+	}
+	if e.AuthMethodPaths == nil {
+		e.AuthMethodPaths = []string{}
+	}
+
+	return v.putMFAJSON(mfaEnforcementPrefix+e.Name, e)
+}
+
+// DeleteMFALoginEnforcement deletes a login enforcement
+func (v *Vault) DeleteMFALoginEnforcement(token, name string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mfa/login-enforcement/"+name, policy.DeleteCapability); err != nil {
+		return err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	if _, err := v.loadMFAEnforcement(name); err != nil {
+		return err
+	}
+	return v.barrier.Delete(mfaEnforcementPrefix + name)
+}
+
+// ValidateMFA checks passcodes, keyed by method name, for the entity of
+// a token. Each valid passcode satisfies the paths that require its
+// method for that token for the next minute. Passcodes sent to login
+// paths are left for the login to check.
+func (v *Vault) ValidateMFA(token, path string, passcodes map[string]string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if m, relative := v.routeLocked(path); m != nil && isLoginPath(m, relative) {
+		return nil
+	}
+
+	te, err := v.tokenStore.LookupToken(token)
+	if err != nil {
+		return err
+	}
+
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	now := time.Now()
+	for name, passcode := range passcodes {
+		if err := v.validateMFALocked(name, mfaEntityID(te), passcode, now); err != nil {
+			return err
+		}
+		v.mfa.grantLocked(te.Accessor, name, now)
+	}
+	return nil
+}
+
+// enforceLoginMFALocked checks the passcodes sent with a login against
+// the login enforcements that apply to the auth method mounted at m.
+// Callers must hold v.mu.
+func (v *Vault) enforceLoginMFALocked(m *mount, a *logical.Auth, passcodes map[string]string) error {
+	v.mfa.mu.Lock()
+	defer v.mfa.mu.Unlock()
+
+	enforcements, err := v.loadMFAEnforcements()
+	if err != nil {
+		return err
+	}
+
+	var required []string
+	for _, e := range enforcements {
+		if !slices.Contains(e.AuthMethodTypes, m.entry.Type) && !slices.Contains(e.AuthMethodPaths, m.entry.Path) {
+			continue
+		}
+		for _, name := range e.MFAMethods {
+			if !slices.Contains(required, name) {
+				required = append(required, name)
+			}
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	sort.Strings(required)
+
+	entityID := loginEntityID(m, a)
+	if entityID == "" {
+		return fmt.Errorf("%w: the auth method did not identify who logged in", ErrMFAFailed)
+	}
+
+	var missing []string
+	for _, name := range required {
+		if _, ok := passcodes[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w for MFA methods: %s", ErrMFARequired, strings.Join(missing, ", "))
+	}
+
+	now := time.Now()
+	for _, name := range required {
+		if err := v.validateMFALocked(name, entityID, passcodes[name], now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateMFALocked checks a passcode for an entity. A passcode is
+// accepted once; wrong and reused passcodes count towards the method's
+// max_validation_attempts. Callers must hold v.mfa.mu.
+func (v *Vault) validateMFALocked(name, entityID, passcode string, now time.Time) error {
+	method, err := v.loadMFAMethod(name)
+	if err != nil {
+		return err
+	}
+
+	key := name + "/" + entityID
+	failures := v.mfa.failures[key]
+	if failures != nil && now.Before(failures.lockedUntil) {
+		return fmt.Errorf("%w for MFA method %q; try again later", ErrMFALocked, name)
+	}
+
+	enrollment, err := v.loadMFAEnrollment(name, entityID)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s is not enrolled in MFA method %q", ErrMFAFailed, entityID, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fail := func(reason string) error {
+		if failures == nil {
+			failures = &mfaFailures{}
+			v.mfa.failures[key] = failures
+		}
+		failures.count++
+		if method.MaxValidationAttempts > 0 && failures.count >= method.MaxValidationAttempts {
+			failures.count = 0
+			failures.lockedUntil = now.Add(mfaLockoutDuration)
+		}
+		return fmt.Errorf("%w: %s for MFA method %q", ErrMFAFailed, reason, name)
+	}
+
+	counter, ok := totp.Validate(enrollment.Secret, passcode, now, method.params(), *method.Skew)
+	if !ok {
+		return fail("invalid passcode")
+	}
+	if counter <= enrollment.LastCounter {
+		return fail("passcode already used")
+	}
+
+	enrollment.LastCounter = counter
+	if err := v.putMFAJSON(mfaSecretKey(name, entityID), enrollment); err != nil {
+		return err
+	}
+	delete(v.mfa.failures, key)
+	return nil
+}
+
+// mfaSecretKey returns the storage key of an entity's secret. Entity IDs
+// contain slashes, so they are hashed.
+func mfaSecretKey(name, entityID string) string {
+	hash := sha256.Sum256([]byte(entityID))
+	return mfaSecretPrefix + name + "/" + hex.EncodeToString(hash[:])
+}
+
+func (v *Vault) loadMFAMethod(name string) (*MFAMethod, error) {
+	var method MFAMethod
+	if err := v.getMFAJSON(mfaMethodPrefix+strings.ToLower(name), &method); err != nil {
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, ErrMFAMethodNotFound
+		}
+		return nil, err
+	}
+	return &method, nil
+}
+
+func (v *Vault) loadMFAEnforcement(name string) (*MFALoginEnforcement, error) {
+	var e MFALoginEnforcement
+	if err := v.getMFAJSON(mfaEnforcementPrefix+strings.ToLower(name), &e); err != nil {
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, ErrMFAEnforcementNotFound
+		}
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (v *Vault) loadMFAEnforcements() ([]*MFALoginEnforcement, error) {
+	names, err := v.listMFAKeys(mfaEnforcementPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	enforcements := make([]*MFALoginEnforcement, 0, len(names))
+	for _, name := range names {
+		e, err := v.loadMFAEnforcement(name)
+		if err != nil {
+			return nil, err
+		}
+		enforcements = append(enforcements, e)
+	}
+	return enforcements, nil
+}
+
+// loadMFAEnrollment returns storage.ErrKeyNotFound when the entity is
+// not enrolled
+func (v *Vault) loadMFAEnrollment(name, entityID string) (*mfaEnrollment, error) {
+	var enrollment mfaEnrollment
+	if err := v.getMFAJSON(mfaSecretKey(name, entityID), &enrollment); err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// listMFAKeys returns the sorted names stored directly under a prefix
+func (v *Vault) listMFAKeys(prefix string) ([]string, error) {
+	keys, err := v.barrier.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, strings.TrimPrefix(key, prefix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (v *Vault) getMFAJSON(key string, out interface{}) error {
+	data, err := v.barrier.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (v *Vault) putMFAJSON(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(key, data)
+}
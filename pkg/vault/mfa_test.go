@@ -0,0 +1,43 @@
+package vault
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"vault-clone/pkg/totp"
+)
+
+func TestPathMFABindsRootToken(t *testing.T) {
+	v, root := unsealedVault(t)
+	if err := v.WriteMFAMethod(root, &MFAMethod{Name: "ops_totp"}); err != nil {
+		t.Fatalf("WriteMFAMethod: %v", err)
+	}
+	rules := `{"path": {"sys/seal": {"capabilities": ["sudo"], "mfa_methods": ["ops_totp"]}}}`
+	if err := v.WritePolicy(root, "ops", rules); err != nil {
+		t.Fatalf("WritePolicy: %v", err)
+	}
+
+	if err := v.Seal(root); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("Seal without MFA: %v, want ErrMFARequired", err)
+	}
+
+	secret, err := v.GenerateMFASecret(root, "ops_totp")
+	if err != nil {
+		t.Fatalf("GenerateMFASecret: %v", err)
+	}
+	_, _, key, p, err := totp.ParseURL(secret.URL)
+	if err != nil {
+		t.Fatalf("ParseURL: %v", err)
+	}
+	code, err := totp.Code(key, uint64(time.Now().Unix())/uint64(p.Period/time.Second), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ValidateMFA(root, "sys/seal", map[string]string{"ops_totp": code}); err != nil {
+		t.Fatalf("ValidateMFA: %v", err)
+	}
+	if err := v.Seal(root); err != nil {
+		t.Fatalf("Seal after MFA: %v", err)
+	}
+}
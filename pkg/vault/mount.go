@@ -0,0 +1,699 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/cubbyhole"
+	"vault-clone/pkg/kv"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/storage"
+	"vault-clone/pkg/totp"
+	"vault-clone/pkg/transit"
+)
+
+const (
+	// mountTablePath stores the mount table behind the barrier
+	mountTablePath = "core/mounts"
+	// logicalPrefix is the root of the storage views of mounted engines
+	logicalPrefix = "logical/"
+	// legacySecretPrefix held KV secrets before the mount table existed
+	legacySecretPrefix = "secret/"
+	// legacyKVConfigPath held the KV settings before the mount table existed
+	legacyKVConfigPath = "sys/kv-config/secret"
+)
+
+// ErrMountNotFound is returned when no engine is mounted at a path
+var ErrMountNotFound = errors.New("no secrets engine mounted at path")
+
+// reservedMountPaths cannot be used for secrets engines
+var reservedMountPaths = []string{"sys/", "auth/", cubbyholeMountPath}
+
+// engines are the secrets engine types that can be mounted
+var engines = map[string]logical.Factory{
+	"kv":        kv.Factory,
+	"transit":   transit.Factory,
+	"totp":      totp.Factory,
+	"cubbyhole": cubbyhole.Factory,
+}
+
+// MountConfig holds the tunable settings of a mount. TTLs are encoded as
+// seconds and may be given as a number of seconds or a duration string.
+type MountConfig struct {
+	DefaultLeaseTTL time.Duration `json:"-"`
+	MaxLeaseTTL     time.Duration `json:"-"`
+}
+
+// MountEntry is a single entry of the mount table
+type MountEntry struct {
+	Path        string            `json:"path"`
+	Type        string            `json:"type"`
+	Description string            `json:"description"`
+	UUID        string            `json:"uuid"`
+	Config      MountConfig       `json:"config"`
+	Options     map[string]string `json:"options,omitempty"`
+}
+
+// MountTune lists the settings changed by a tune request; nil fields are
+// left unchanged
+type MountTune struct {
+	Description     *string
+	DefaultLeaseTTL *time.Duration
+	MaxLeaseTTL     *time.Duration
+}
+
+// mountTable is the persisted form of the mount table
+type mountTable struct {
+	Entries []*MountEntry `json:"entries"`
+}
+
+// mount is a mount table entry with its running engine. Auth methods
+// use the same type, with credential set.
+type mount struct {
+	entry      *MountEntry
+	backend    logical.Backend
+	view       *storage.View
+	credential bool
+}
+
+// mountPoint returns the request path prefix the mount is routed at
+func (m *mount) mountPoint() string {
+	if m.credential {
+		return credentialRoutePrefix + m.entry.Path
+	}
+	return m.entry.Path
+}
+
+type mountConfigJSON struct {
+	DefaultLeaseTTL interface{} `json:"default_lease_ttl"`
+	MaxLeaseTTL     interface{} `json:"max_lease_ttl"`
+}
+
+// MarshalJSON encodes TTLs as whole seconds
+func (c MountConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]int64{
+		"default_lease_ttl": int64(c.DefaultLeaseTTL / time.Second),
+		"max_lease_ttl":     int64(c.MaxLeaseTTL / time.Second),
+	})
+}
+
+// UnmarshalJSON accepts TTLs as seconds or duration strings
+func (c *MountConfig) UnmarshalJSON(data []byte) error {
+	var raw mountConfigJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var err error
+	if c.DefaultLeaseTTL, err = logical.ParseTTL(raw.DefaultLeaseTTL); err != nil {
+		return fmt.Errorf("invalid default_lease_ttl: %v", err)
+	}
+	if c.MaxLeaseTTL, err = logical.ParseTTL(raw.MaxLeaseTTL); err != nil {
+		return fmt.Errorf("invalid max_lease_ttl: %v", err)
+	}
+	return nil
+}
+
+// Validate checks that the default TTL does not exceed the maximum
+func (c *MountConfig) Validate() error {
+	if c.DefaultLeaseTTL < 0 || c.MaxLeaseTTL < 0 {
+		return errors.New("lease TTLs cannot be negative")
+	}
+	if c.MaxLeaseTTL > 0 && c.DefaultLeaseTTL > c.MaxLeaseTTL {
+		return errors.New("default_lease_ttl cannot exceed max_lease_ttl")
+	}
+	return nil
+}
+
+// ListMounts returns the mount table sorted by path
+func (v *Vault) ListMounts(token string) ([]*MountEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/mounts", policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	entries := make([]*MountEntry, 0, len(v.mounts))
+	for _, m := range v.mounts {
+		entries = append(entries, m.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// EnableMount mounts a new secrets engine at entry.Path
+func (v *Vault) EnableMount(token string, entry *MountEntry) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(entry.Path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/mounts/"+path, policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	if _, ok := engines[entry.Type]; !ok {
+		return fmt.Errorf("unknown secrets engine type %q", entry.Type)
+	}
+	if entry.Type == cubbyholeMountType {
+		return fmt.Errorf("the cubbyhole engine is built in and only mounted at %q", cubbyholeMountPath)
+	}
+	if err := entry.Config.Validate(); err != nil {
+		return err
+	}
+	if err := v.checkMountPathLocked(path, ""); err != nil {
+		return err
+	}
+
+	uuid, err := crypto.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	added := &MountEntry{
+		Path:        path,
+		Type:        entry.Type,
+		Description: entry.Description,
+		UUID:        uuid,
+		Config:      entry.Config,
+		Options:     entry.Options,
+	}
+
+	m, err := v.newMount(added)
+	if err != nil {
+		return err
+	}
+
+	v.mounts[path] = m
+	if err := v.persistMountsLocked(); err != nil {
+		delete(v.mounts, path)
+		return err
+	}
+	return nil
+}
+
+// DisableMount unmounts a secrets engine, revokes its leases and deletes
+// all of its data
+func (v *Vault) DisableMount(token, path string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/mounts/"+path, policy.DeleteCapability); err != nil {
+		return err
+	}
+
+	if path == cubbyholeMountPath {
+		return fmt.Errorf("cannot unmount %q", path)
+	}
+
+	m, ok := v.mounts[path]
+	if !ok {
+		return ErrMountNotFound
+	}
+
+	// Secrets handed out by the engine stop being valid with it
+	if err := v.revokePrefixLocked(path); err != nil {
+		return err
+	}
+
+	delete(v.mounts, path)
+	if err := v.persistMountsLocked(); err != nil {
+		v.mounts[path] = m
+		return err
+	}
+
+	return m.view.Clear()
+}
+
+// Remount moves a mount to a new path. Data is stored by mount UUID so
+// nothing is copied; only the mount table changes. Leases issued under
+// the old path are revoked.
+func (v *Vault) Remount(token, from, to string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	from, err := sanitizeMountPath(from)
+	if err != nil {
+		return err
+	}
+	to, err = sanitizeMountPath(to)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/remount", policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	if from == cubbyholeMountPath {
+		return fmt.Errorf("cannot move %q", from)
+	}
+
+	m, ok := v.mounts[from]
+	if !ok {
+		return ErrMountNotFound
+	}
+	if err := v.checkMountPathLocked(to, from); err != nil {
+		return err
+	}
+
+	// Leases are tied to the paths they were issued for
+	if err := v.revokePrefixLocked(from); err != nil {
+		return err
+	}
+
+	previous := m.entry
+	moved := *m.entry
+	moved.Path = to
+	m.entry = &moved
+
+	delete(v.mounts, from)
+	v.mounts[to] = m
+	if err := v.persistMountsLocked(); err != nil {
+		delete(v.mounts, to)
+		m.entry = previous
+		v.mounts[from] = m
+		return err
+	}
+	return nil
+}
+
+// ReadMountTune returns the tunable settings of a mount
+func (v *Vault) ReadMountTune(token, path string) (*MountEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := v.authorize(token, "sys/mounts/"+path+"tune", policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	m, ok := v.mounts[path]
+	if !ok {
+		return nil, ErrMountNotFound
+	}
+	return m.entry, nil
+}
+
+// TuneMount changes the description and lease TTLs of a mount
+func (v *Vault) TuneMount(token, path string, tune *MountTune) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	path, err := sanitizeMountPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := v.authorize(token, "sys/mounts/"+path+"tune", policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	m, ok := v.mounts[path]
+	if !ok {
+		return ErrMountNotFound
+	}
+
+	tuned := *m.entry
+	if tune.Description != nil {
+		tuned.Description = *tune.Description
+	}
+	if tune.DefaultLeaseTTL != nil {
+		tuned.Config.DefaultLeaseTTL = *tune.DefaultLeaseTTL
+	}
+	if tune.MaxLeaseTTL != nil {
+		tuned.Config.MaxLeaseTTL = *tune.MaxLeaseTTL
+	}
+	if err := tuned.Config.Validate(); err != nil {
+		return err
+	}
+
+	previous := m.entry
+	m.entry = &tuned
+	if err := v.persistMountsLocked(); err != nil {
+		m.entry = previous
+		return err
+	}
+	return nil
+}
+
+// MountForPath returns the mount serving a path. Any token with some
+// capability on the path, or for KV engines on its data or metadata
+// path, may look it up, so that clients can build engine-specific paths
+// such as "<mount>data/<path>". The lookup
+// does not take a use of a use-limited token.
+func (v *Vault) MountForPath(token, path string) (*MountEntry, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	te, err := v.tokenStore.LookupToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := v.tokenACL(te)
+	if err != nil {
+		return nil, err
+	}
+
+	m, relative := v.routeLocked(path)
+	if m == nil {
+		return nil, ErrMountNotFound
+	}
+
+	// Clients look up KV paths before turning them into the data/ and
+	// metadata/ paths that policies grant
+	paths := []string{path}
+	if m.entry.Type == "kv" {
+		paths = append(paths, m.mountPoint()+"data/"+relative, m.mountPoint()+"metadata/"+relative)
+	}
+	if !slices.ContainsFunc(paths, func(p string) bool {
+		caps := acl.Capabilities(p)
+		return len(caps) != 1 || caps[0] != policy.DenyCapability
+	}) {
+		return nil, ErrPermissionDenied
+	}
+
+	return &MountEntry{Path: m.mountPoint(), Type: m.entry.Type}, nil
+}
+
+// HandleRequest routes a request to the engine mounted at the longest
+// matching prefix of its path. The request path is the full path,
+// including the mount point; capabilities are checked against it.
+// Login paths of auth methods need no token, and a successful login
+// returns the token issued for it as the response data. Requests to the
+// cubbyhole engine are served from the calling token's own cubbyhole.
+func (v *Vault) HandleRequest(token string, req *logical.Request) (*logical.Response, error) {
+	fullPath := req.Path
+	if m, mountPath, ok := v.loginMount(req); ok {
+		return v.handleLogin(fullPath, m, mountPath, req)
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	m, relative := v.routeLocked(fullPath)
+	if m == nil {
+		return nil, ErrMountNotFound
+	}
+
+	req.Path = relative
+	req.MountPoint = m.mountPoint()
+	req.Storage = m.view
+
+	if m.entry.Type == cubbyholeMountType {
+		return v.handleCubbyholeLocked(token, m, req)
+	}
+
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	// Writes to paths that do not exist yet need the create capability
+	if req.Operation == logical.UpdateOperation {
+		if checker, ok := m.backend.(logical.ExistenceChecker); ok {
+			exists, err := checker.Exists(req)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				req.Operation = logical.CreateOperation
+			}
+		}
+	}
+
+	if _, err := v.authorize(token, fullPath, string(req.Operation)); err != nil {
+		return nil, err
+	}
+
+	resp, err := m.backend.HandleRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp != nil && resp.Secret != nil {
+		if err := v.registerLeaseLocked(fullPath, m, resp.Secret); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// routeLocked finds the mount for a path and returns the path relative
+// to it. Paths under "auth/" are routed to auth methods.
+func (v *Vault) routeLocked(path string) (*mount, string) {
+	table := v.mounts
+	if rest, ok := strings.CutPrefix(path, credentialRoutePrefix); ok {
+		table, path = v.auths, rest
+	}
+
+	var best *mount
+	for mountPath, m := range table {
+		// A request for the mount root may omit the trailing slash
+		if !strings.HasPrefix(path, mountPath) && path+"/" != mountPath {
+			continue
+		}
+		if best == nil || len(mountPath) > len(best.entry.Path) {
+			best = m
+		}
+	}
+
+	if best == nil {
+		return nil, ""
+	}
+	return best, strings.TrimPrefix(path, best.entry.Path)
+}
+
+// checkMountPathLocked rejects paths that overlap an existing mount other
+// than ignore
+func (v *Vault) checkMountPathLocked(path, ignore string) error {
+	for _, reserved := range reservedMountPaths {
+		if strings.HasPrefix(path, reserved) {
+			return fmt.Errorf("cannot mount at reserved path %q", reserved)
+		}
+	}
+
+	for existing := range v.mounts {
+		if existing == ignore {
+			continue
+		}
+		if strings.HasPrefix(path, existing) || strings.HasPrefix(existing, path) {
+			return fmt.Errorf("path %q conflicts with existing mount %q", path, existing)
+		}
+	}
+	return nil
+}
+
+// setupMounts loads the mount table and starts every engine. A vault
+// without a mount table gets the default "secret/" KV mount, which takes
+// over any secrets written before mounts existed. The cubbyhole engine
+// is added to tables that lack it.
+func (v *Vault) setupMounts() error {
+	table, err := v.loadMountTable()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	if table == nil {
+		uuid, err := crypto.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		table = &mountTable{Entries: []*MountEntry{{
+			Path:        legacySecretPrefix,
+			Type:        "kv",
+			Description: "key/value secret storage",
+			UUID:        uuid,
+		}}}
+		changed = true
+	}
+
+	if !slices.ContainsFunc(table.Entries, func(e *MountEntry) bool { return e.Path == cubbyholeMountPath }) {
+		uuid, err := crypto.GenerateUUID()
+		if err != nil {
+			return err
+		}
+		table.Entries = append(table.Entries, &MountEntry{
+			Path:        cubbyholeMountPath,
+			Type:        cubbyholeMountType,
+			Description: "per-token private secret storage",
+			UUID:        uuid,
+		})
+		changed = true
+	}
+
+	if changed {
+		data, err := json.Marshal(table)
+		if err != nil {
+			return err
+		}
+		if err := v.barrier.Put(mountTablePath, data); err != nil {
+			return err
+		}
+	}
+
+	mounts := make(map[string]*mount, len(table.Entries))
+	for _, entry := range table.Entries {
+		m, err := v.newMount(entry)
+		if err != nil {
+			return err
+		}
+		mounts[entry.Path] = m
+	}
+
+	if m, ok := mounts[legacySecretPrefix]; ok && m.entry.Type == "kv" {
+		if err := v.migrateLegacySecrets(m.view); err != nil {
+			return err
+		}
+	}
+
+	v.mounts = mounts
+	return nil
+}
+
+// migrateLegacySecrets moves secrets stored directly under "secret/" into
+// the storage view of the default KV mount. Keys are copied before they
+// are deleted, so an interrupted migration resumes on the next unseal.
+func (v *Vault) migrateLegacySecrets(view *storage.View) error {
+	keys, err := v.barrier.List(legacySecretPrefix)
+	if err != nil {
+		return err
+	}
+	keys = append(keys, legacyKVConfigPath)
+
+	for _, key := range keys {
+		data, err := v.barrier.Get(key)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		target := kv.ConfigKey()
+		if key != legacyKVConfigPath {
+			target = kv.DataKey(strings.TrimPrefix(key, legacySecretPrefix))
+		}
+		if err := view.Put(target, data); err != nil {
+			return err
+		}
+		if err := v.barrier.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Vault) newMount(entry *MountEntry) (*mount, error) {
+	factory, ok := engines[entry.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown secrets engine type %q", entry.Type)
+	}
+
+	view := storage.NewView(v.barrier, logicalPrefix+entry.UUID+"/")
+	backend, err := factory(&logical.BackendConfig{
+		MountPoint: entry.Path,
+		Storage:    view,
+		Options:    entry.Options,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &mount{entry: entry, backend: backend, view: view}, nil
+}
+
+func (v *Vault) loadMountTable() (*mountTable, error) {
+	data, err := v.barrier.Get(mountTablePath)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var table mountTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+func (v *Vault) persistMountsLocked() error {
+	table := &mountTable{}
+	for _, m := range v.mounts {
+		table.Entries = append(table.Entries, m.entry)
+	}
+	sort.Slice(table.Entries, func(i, j int) bool { return table.Entries[i].Path < table.Entries[j].Path })
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return v.barrier.Put(mountTablePath, data)
+}
+
+// sanitizeMountPath normalizes a mount path to "a/b/"
+func sanitizeMountPath(path string) (string, error) {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "", errors.New("mount path cannot be empty")
+	}
+	if strings.Contains(path, "//") || strings.ContainsAny(path, "*+") {
+		return "", fmt.Errorf("invalid mount path %q", path)
+	}
+	return path + "/", nil
+}
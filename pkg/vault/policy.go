@@ -0,0 +1,131 @@
+package vault
+
+import (
+	"errors"
+
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/policy"
+)
+
+// ErrPermissionDenied is returned when a token's policies do not grant
+// the capability required for a request
+var ErrPermissionDenied = errors.New("permission denied")
+
+// authorize validates a token and checks that its policies grant the
+// capability on the path, and that the token has recently passed the MFA
+// methods they require there. An authorized request takes one use of a
+// use-limited token. Callers must hold v.mu.
+func (v *Vault) authorize(token, path, capability string) (*auth.Token, error) {
+	te, err := v.tokenStore.LookupToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := v.tokenACL(te)
+	if err != nil {
+		return nil, err
+	}
+
+	if !acl.Allowed(path, capability) {
+		return nil, ErrPermissionDenied
+	}
+
+	if methods := acl.MFAMethods(path); len(methods) > 0 {
+		if err := v.mfa.checkGrants(te.Accessor, methods); err != nil {
+			return nil, err
+		}
+	}
+
+	if te.NumUses > 0 {
+		return v.tokenStore.UseToken(token)
+	}
+	return te, nil
+}
+
+// tokenACL builds the ACL for a token's policies. The root token holds
+// the root policy along with every other policy, so that it is bound by
+// the MFA methods any of them requires on a path.
+func (v *Vault) tokenACL(te *auth.Token) (*policy.ACL, error) {
+	if te.IsRoot {
+		names, err := v.policyStore.List()
+		if err != nil {
+			return nil, err
+		}
+		return v.policyStore.ACL(names)
+	}
+	return v.policyStore.ACL(te.Policies)
+}
+
+// WritePolicy creates or replaces a named policy
+func (v *Vault) WritePolicy(token, name, raw string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	p, err := policy.Parse(name, raw)
+	if err != nil {
+		return err
+	}
+
+	capability := policy.UpdateCapability
+	if _, err := v.policyStore.Get(p.Name); errors.Is(err, policy.ErrPolicyNotFound) {
+		capability = policy.CreateCapability
+	}
+
+	if _, err := v.authorize(token, "sys/policy/"+p.Name, capability); err != nil {
+		return err
+	}
+
+	return v.policyStore.Put(p)
+}
+
+// ReadPolicy returns a named policy
+func (v *Vault) ReadPolicy(token, name string) (*policy.Policy, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/policy/"+name, policy.ReadCapability); err != nil {
+		return nil, err
+	}
+
+	return v.policyStore.Get(name)
+}
+
+// DeletePolicy removes a named policy
+func (v *Vault) DeletePolicy(token, name string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/policy/"+name, policy.DeleteCapability); err != nil {
+		return err
+	}
+
+	return v.policyStore.Delete(name)
+}
+
+// ListPolicies returns the names of all policies
+func (v *Vault) ListPolicies(token string) ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/policy/", policy.ListCapability); err != nil {
+		return nil, err
+	}
+
+	return v.policyStore.List()
+}
@@ -0,0 +1,204 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/shamir"
+)
+
+// RekeyStatus reports the progress of a rekey operation
+type RekeyStatus struct {
+	Started  bool   `json:"started"`
+	Nonce    string `json:"nonce"`
+	T        int    `json:"t"`
+	N        int    `json:"n"`
+	Progress int    `json:"progress"`
+	Required int    `json:"required"`
+}
+
+// RekeyResult is returned for each key share submitted during a rekey.
+// Keys are only populated once, on the submission that completes it.
+type RekeyResult struct {
+	Nonce           string   `json:"nonce"`
+	Complete        bool     `json:"complete"`
+	Progress        int      `json:"progress"`
+	Required        int      `json:"required"`
+	Keys            []string `json:"keys,omitempty"`
+	SecretShares    int      `json:"secret_shares,omitempty"`
+	SecretThreshold int      `json:"secret_threshold,omitempty"`
+}
+
+// RekeyInit starts a rekey operation. A nil config keeps the current
+// share and threshold settings.
+func (v *Vault) RekeyInit(config *SealConfig) (*RekeyStatus, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if v.rekeyConfig != nil {
+		return nil, errors.New("rekey already in progress")
+	}
+
+	if config == nil {
+		current := *v.sealConfig
+		config = &current
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	nonce, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	v.rekeyConfig = config
+	v.rekeyNonce = nonce
+	v.rekeyParts = nil
+
+	return v.rekeyStatusLocked(), nil
+}
+
+// RekeyStatus returns the progress of the current rekey operation
+func (v *Vault) RekeyStatus() *RekeyStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.rekeyStatusLocked()
+}
+
+// RekeyUpdate submits one of the current unseal key shares. When the
+// current threshold is reached a new master key is generated, the
+// keyring is re-encrypted with it and the new key shares are returned.
+func (v *Vault) RekeyUpdate(keyStr, nonce string) (*RekeyResult, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if v.rekeyConfig == nil {
+		return nil, errors.New("no rekey in progress")
+	}
+
+	if nonce != v.rekeyNonce {
+		return nil, errors.New("incorrect rekey nonce")
+	}
+
+	keyPart, err := decodeKeyShare(keyStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var currentKey []byte
+	if v.sealConfig.SecretThreshold == 1 {
+		currentKey = keyPart
+	} else {
+		if err := checkKeyShare(keyPart, v.rekeyParts); err != nil {
+			return nil, err
+		}
+		v.rekeyParts = append(v.rekeyParts, keyPart)
+
+		if len(v.rekeyParts) < v.sealConfig.SecretThreshold {
+			return &RekeyResult{
+				Nonce:    v.rekeyNonce,
+				Progress: len(v.rekeyParts),
+				Required: v.sealConfig.SecretThreshold,
+			}, nil
+		}
+
+		currentKey, err = shamir.Combine(v.rekeyParts)
+		if err != nil {
+			v.rekeyParts = nil
+			return nil, errors.New("invalid unseal key shares")
+		}
+	}
+
+	// A wrong key restarts share collection but keeps the rekey open
+	if err := v.barrier.VerifyMasterKey(currentKey); err != nil {
+		v.rekeyParts = nil
+		return nil, err
+	}
+
+	newKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := splitMasterKey(newKey, v.rekeyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	configJSON, err := json.Marshal(v.rekeyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keyring and the share layout of its new key are stored in one
+	// write, so a failure leaves the old key and layout in place
+	if err := v.barrier.SetMasterKey(newKey, configJSON); err != nil {
+		return nil, err
+	}
+
+	result := &RekeyResult{
+		Nonce:           v.rekeyNonce,
+		Complete:        true,
+		Progress:        v.sealConfig.SecretThreshold,
+		Required:        v.sealConfig.SecretThreshold,
+		Keys:            keys,
+		SecretShares:    v.rekeyConfig.SecretShares,
+		SecretThreshold: v.rekeyConfig.SecretThreshold,
+	}
+
+	v.sealConfig = v.rekeyConfig
+	v.resetRekeyLocked()
+
+	return result, nil
+}
+
+// RekeyCancel aborts the current rekey operation and discards submitted
+// shares. The nonce returned by RekeyInit must be given, so that a stale
+// cancel does not abort a rekey started since.
+func (v *Vault) RekeyCancel(nonce string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.rekeyConfig == nil {
+		return errors.New("no rekey in progress")
+	}
+
+	if nonce != v.rekeyNonce {
+		return errors.New("incorrect rekey nonce")
+	}
+
+	v.resetRekeyLocked()
+	return nil
+}
+
+func (v *Vault) rekeyStatusLocked() *RekeyStatus {
+	status := &RekeyStatus{
+		Started:  v.rekeyConfig != nil,
+		Nonce:    v.rekeyNonce,
+		Progress: len(v.rekeyParts),
+	}
+	if v.sealConfig != nil {
+		status.Required = v.sealConfig.SecretThreshold
+	}
+	if v.rekeyConfig != nil {
+		status.T = v.rekeyConfig.SecretThreshold
+		status.N = v.rekeyConfig.SecretShares
+	}
+	return status
+}
+
+func (v *Vault) resetRekeyLocked() {
+	v.rekeyConfig = nil
+	v.rekeyParts = nil
+	v.rekeyNonce = ""
+}
@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"encoding/json"
+	"testing"
+
+	"vault-clone/pkg/storage"
+)
+
+func unseal(t *testing.T, v *Vault, keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		if _, err := v.Unseal(key); err != nil {
+			t.Fatalf("Unseal: %v", err)
+		}
+	}
+	if v.sealed {
+		t.Fatal("vault still sealed after the threshold of keys")
+	}
+}
+
+func TestRekeySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	v := openVault(t, dir)
+	initResp, err := v.Initialize(&SealConfig{SecretShares: 3, SecretThreshold: 2})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	unseal(t, v, initResp.Keys[:2])
+
+	status, err := v.RekeyInit(&SealConfig{SecretShares: 5, SecretThreshold: 3})
+	if err != nil {
+		t.Fatalf("RekeyInit: %v", err)
+	}
+	var result *RekeyResult
+	for _, key := range initResp.Keys[1:] {
+		if result, err = v.RekeyUpdate(key, status.Nonce); err != nil {
+			t.Fatalf("RekeyUpdate: %v", err)
+		}
+	}
+	if !result.Complete || len(result.Keys) != 5 {
+		t.Fatalf("rekey result = %+v, want 5 new keys", result)
+	}
+	v.storage.(*storage.LogStorage).Close()
+
+	// The new share layout must be read back with the new keyring
+	v = openVault(t, dir)
+	if v.sealConfig.SecretShares != 5 || v.sealConfig.SecretThreshold != 3 {
+		t.Fatalf("seal config = %+v, want 5 shares and a threshold of 3", v.sealConfig)
+	}
+	unseal(t, v, result.Keys[2:])
+}
+
+func TestRekeyCancel(t *testing.T) {
+	v := openVault(t, t.TempDir())
+	initResp, err := v.Initialize(&SealConfig{SecretShares: 3, SecretThreshold: 2})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	unseal(t, v, initResp.Keys[:2])
+
+	status, err := v.RekeyInit(nil)
+	if err != nil {
+		t.Fatalf("RekeyInit: %v", err)
+	}
+	if _, err := v.RekeyUpdate(initResp.Keys[0], status.Nonce); err != nil {
+		t.Fatalf("RekeyUpdate: %v", err)
+	}
+
+	for _, nonce := range []string{"", "wrong"} {
+		if err := v.RekeyCancel(nonce); err == nil {
+			t.Fatalf("RekeyCancel succeeded with nonce %q", nonce)
+		}
+	}
+	if current := v.RekeyStatus(); !current.Started || current.Progress != 1 {
+		t.Fatalf("rekey status after a rejected cancel = %+v, want it in progress", current)
+	}
+
+	if err := v.RekeyCancel(status.Nonce); err != nil {
+		t.Fatalf("RekeyCancel: %v", err)
+	}
+	if current := v.RekeyStatus(); current.Started || current.Progress != 0 {
+		t.Fatalf("rekey status after cancel = %+v, want none", current)
+	}
+	if err := v.RekeyCancel(status.Nonce); err == nil {
+		t.Fatal("RekeyCancel succeeded with no rekey in progress")
+	}
+
+	// The old keys still unseal after a cancelled rekey
+	if err := v.Seal(initResp.RootToken); err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	unseal(t, v, initResp.Keys[1:])
+}
+
+func TestSealConfigBeforeKeyringRecord(t *testing.T) {
+	dir := t.TempDir()
+	v := openVault(t, dir)
+	initResp, err := v.Initialize(&SealConfig{SecretShares: 3, SecretThreshold: 2})
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	// Store the keyring as earlier versions did: the bare ciphertext,
+	// with the seal configuration in a record of its own
+	data, err := v.storage.Get("core/keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		Keyring    string          `json:"keyring"`
+		SealConfig json.RawMessage `json:"seal_config"`
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.storage.Put("core/keyring", []byte(record.Keyring)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.storage.Put(legacySealConfigPath, record.SealConfig); err != nil {
+		t.Fatal(err)
+	}
+	v.storage.(*storage.LogStorage).Close()
+
+	v = openVault(t, dir)
+	if v.sealConfig.SecretShares != 3 || v.sealConfig.SecretThreshold != 2 {
+		t.Fatalf("seal config = %+v, want 3 shares and a threshold of 2", v.sealConfig)
+	}
+	unseal(t, v, initResp.Keys[:2])
+}
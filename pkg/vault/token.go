@@ -0,0 +1,406 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/policy"
+)
+
+// defaultTokenTTL applies to tokens created without a TTL or period
+const defaultTokenTTL = 24 * time.Hour
+
+// TokenRequest describes a token to create
+type TokenRequest struct {
+	TTL      time.Duration
+	Policies []string
+	// Orphan creates a token without a parent, which outlives the token
+	// that created it
+	Orphan    bool
+	Renewable bool
+	// ExplicitMaxTTL caps the lifetime of the token across renewals
+	ExplicitMaxTTL time.Duration
+	// Period creates a periodic token, whose TTL is reset to the period
+	// on every renewal and which never reaches a maximum TTL
+	Period time.Duration
+	// NumUses limits the number of requests the token can make; zero
+	// means unlimited
+	NumUses int
+}
+
+// TokenAuth is returned when a token is created
+type TokenAuth struct {
+	Token    string `json:"token"`
+	Accessor string `json:"accessor"`
+	LeaseID  string `json:"lease_id"`
+	// LeaseDuration is the token TTL in seconds
+	LeaseDuration int64    `json:"lease_duration"`
+	Renewable     bool     `json:"renewable"`
+	Policies      []string `json:"policies"`
+}
+
+// TokenInfo describes a token without revealing it or its relatives
+type TokenInfo struct {
+	Accessor     string    `json:"accessor"`
+	Policies     []string  `json:"policies"`
+	Orphan       bool      `json:"orphan"`
+	CreationTime time.Time `json:"creation_time"`
+	ExpireTime   time.Time `json:"expire_time"`
+	// TTL is the number of seconds left before the token expires
+	TTL       int64 `json:"ttl"`
+	Renewable bool  `json:"renewable"`
+	// ExplicitMaxTTL and Period are in seconds; zero when not set
+	ExplicitMaxTTL int64 `json:"explicit_max_ttl"`
+	Period         int64 `json:"period"`
+	// NumUses is the number of uses left; zero means unlimited
+	NumUses int               `json:"num_uses"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	// EntityID identifies who the token acts for in MFA enrollments
+	EntityID string `json:"entity_id"`
+}
+
+// CreateToken creates a token as a child of the calling token, unless an
+// orphan is requested. Non-root tokens can only hand out policies they
+// hold themselves, plus "default". A token created without policies gets
+// the policies of its creator, or "default" when created by a root
+// token. Periodic tokens default to a TTL of one period, and no token
+// starts with a TTL above its explicit max TTL. Every token is leased so
+// that it is revoked as soon as its TTL runs out, and acts for the same
+// entity as its creator.
+func (v *Vault) CreateToken(token string, req *TokenRequest) (*TokenAuth, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	path := "auth/token/create"
+	if req.Orphan {
+		path = "auth/token/create-orphan"
+	}
+
+	parent, err := v.authorize(token, path, policy.UpdateCapability)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TTL < 0 || req.ExplicitMaxTTL < 0 || req.Period < 0 || req.NumUses < 0 {
+		return nil, errors.New("ttl, explicit_max_ttl, period and num_uses cannot be negative")
+	}
+
+	// A use-limited token is revoked on its last use, which would take
+	// any children with it
+	if parent.NumUses > 0 && !req.Orphan {
+		return nil, errors.New("use-limited tokens cannot create child tokens")
+	}
+
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = defaultTokenTTL
+		if req.Period > 0 {
+			ttl = req.Period
+		}
+	}
+	if req.ExplicitMaxTTL > 0 && ttl > req.ExplicitMaxTTL {
+		ttl = req.ExplicitMaxTTL
+	}
+
+	policies := req.Policies
+	if len(policies) == 0 {
+		policies = []string{"default"}
+		if !parent.IsRoot {
+			policies = parent.Policies
+		}
+	}
+	if !parent.IsRoot {
+		for _, name := range policies {
+			if name != "default" && !slices.Contains(parent.Policies, name) {
+				return nil, fmt.Errorf("%w: cannot create a token with policy %q", ErrPermissionDenied, name)
+			}
+		}
+	}
+
+	newToken, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	leaseID, err := crypto.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &auth.TokenParams{
+		ID:             newToken,
+		TTL:            ttl,
+		Policies:       policies,
+		LeaseID:        tokenLeasePath + "/" + leaseID,
+		Renewable:      req.Renewable,
+		ExplicitMaxTTL: req.ExplicitMaxTTL,
+		Period:         req.Period,
+		NumUses:        req.NumUses,
+		EntityID:       mfaEntityID(parent),
+	}
+	if !req.Orphan {
+		params.Parent = token
+	}
+
+	te, err := v.tokenStore.CreateToken(params)
+	if err != nil {
+		return nil, err
+	}
+
+	le, err := v.registerTokenLeaseLocked(te, tokenLeasePath, systemMaxLeaseTTL)
+	if err != nil {
+		v.tokenStore.RevokeToken(newToken)
+		return nil, err
+	}
+
+	return tokenAuth(newToken, te, le), nil
+}
+
+// RenewToken extends the TTL of another token and its lease, within the
+// limits it was created with
+func (v *Vault) RenewToken(token, target string, increment time.Duration) (*TokenAuth, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/renew", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	return v.renewTokenLocked(target, increment)
+}
+
+// RenewSelf extends the TTL of the calling token. Any valid token may
+// renew itself if it was created renewable.
+func (v *Vault) RenewSelf(token string, increment time.Duration) (*TokenAuth, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.tokenStore.UseToken(token); err != nil {
+		return nil, err
+	}
+	return v.renewTokenLocked(token, increment)
+}
+
+// renewTokenLocked renews a token through its lease. Callers must hold
+// v.mu.
+func (v *Vault) renewTokenLocked(target string, increment time.Duration) (*TokenAuth, error) {
+	if increment < 0 {
+		return nil, errors.New("increment cannot be negative")
+	}
+
+	te, err := v.tokenStore.LookupToken(target)
+	if err != nil {
+		return nil, err
+	}
+	if te.LeaseID == "" {
+		return nil, auth.ErrNotRenewable
+	}
+
+	v.expiration.mu.Lock()
+	defer v.expiration.mu.Unlock()
+
+	le, err := v.loadLease(te.LeaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	te, err = v.renewTokenLeaseLocked(le, increment)
+	if err != nil {
+		return nil, err
+	}
+	return tokenAuth(target, te, le), nil
+}
+
+// LookupToken returns information about another token
+func (v *Vault) LookupToken(token, target string) (*TokenInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/lookup", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	te, err := v.tokenStore.LookupToken(target)
+	if err != nil {
+		return nil, err
+	}
+	return tokenInfo(te), nil
+}
+
+// LookupSelf returns information about the calling token. Any valid
+// token may look itself up.
+func (v *Vault) LookupSelf(token string) (*TokenInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	te, err := v.tokenStore.UseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	return tokenInfo(te), nil
+}
+
+// RevokeToken revokes another token and all of its descendants
+func (v *Vault) RevokeToken(token, target string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/revoke", policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	return v.tokenStore.RevokeToken(target)
+}
+
+// RevokeSelf revokes the calling token and all of its descendants. Any
+// valid token may revoke itself.
+func (v *Vault) RevokeSelf(token string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if err := v.tokenStore.ValidateToken(token); err != nil {
+		return err
+	}
+
+	return v.tokenStore.RevokeToken(token)
+}
+
+// RevokeOrphan revokes a token but keeps its children, which become
+// orphans
+func (v *Vault) RevokeOrphan(token, target string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/revoke-orphan", policy.SudoCapability); err != nil {
+		return err
+	}
+
+	return v.tokenStore.RevokeOrphan(target)
+}
+
+// ListAccessors returns the accessors of all tokens
+func (v *Vault) ListAccessors(token string) ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/accessors", policy.SudoCapability); err != nil {
+		return nil, err
+	}
+
+	accessors, err := v.tokenStore.ListAccessors()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(accessors)
+	return accessors, nil
+}
+
+// LookupAccessor returns information about the token with an accessor
+func (v *Vault) LookupAccessor(token, accessor string) (*TokenInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/lookup-accessor", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	te, err := v.tokenStore.LookupAccessor(accessor)
+	if err != nil {
+		return nil, err
+	}
+	return tokenInfo(te), nil
+}
+
+// RevokeAccessor revokes the token with an accessor and all of its
+// descendants
+func (v *Vault) RevokeAccessor(token, accessor string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "auth/token/revoke-accessor", policy.UpdateCapability); err != nil {
+		return err
+	}
+
+	return v.tokenStore.RevokeAccessor(accessor)
+}
+
+func tokenAuth(token string, te *auth.Token, le *leaseEntry) *TokenAuth {
+	return &TokenAuth{
+		Token:         token,
+		Accessor:      te.Accessor,
+		LeaseID:       le.LeaseID,
+		LeaseDuration: int64(time.Until(le.ExpireTime).Round(time.Second) / time.Second),
+		Renewable:     le.Renewable,
+		Policies:      te.Policies,
+	}
+}
+
+func tokenInfo(te *auth.Token) *TokenInfo {
+	ttl := time.Until(te.ExpiresAt)
+	if ttl < 0 {
+		ttl = 0
+	}
+	return &TokenInfo{
+		Accessor:       te.Accessor,
+		Policies:       te.Policies,
+		Orphan:         te.Parent == "",
+		CreationTime:   te.CreatedAt,
+		ExpireTime:     te.ExpiresAt,
+		TTL:            int64(ttl / time.Second),
+		Renewable:      te.Renewable,
+		ExplicitMaxTTL: int64(te.ExplicitMaxTTL / time.Second),
+		Period:         int64(te.Period / time.Second),
+		NumUses:        te.NumUses,
+		Meta:           te.Meta,
+		EntityID:       mfaEntityID(te),
+	}
+}
@@ -1,67 +1,136 @@
 package vault
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"sync"
-	"time"
 
 	"vault-clone/pkg/auth"
+	"vault-clone/pkg/barrier"
 	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+	"vault-clone/pkg/shamir"
 	"vault-clone/pkg/storage"
 )
 
+// legacyUnsealKeyPath held the unseal key encrypted with itself before the
+// barrier keyring was introduced
+const legacyUnsealKeyPath = "core/unseal-key"
+
+// legacySealConfigPath held the seal configuration before it was stored
+// with the keyring
+const legacySealConfigPath = "core/seal-config"
+
 // Vault represents the main vault instance
 type Vault struct {
-	storage      storage.Storage
-	tokenStore   *auth.TokenStore
-	mu           sync.RWMutex
-	sealed       bool
-	initialized  bool
-	encryptionKey []byte
-	rootToken    string
+	storage     storage.Storage
+	barrier     *barrier.Barrier
+	tokenStore  *auth.TokenStore
+	policyStore *policy.Store
+	mounts      map[string]*mount
+	auths       map[string]*mount
+	audits      map[string]*auditDevice
+	expiration  *expirationManager
+	mfa         *mfaTracker
+	logins      *loginTracker
+	mu          sync.RWMutex
+	sealed      bool
+	initialized bool
+	rootToken   string
+	sealConfig  *SealConfig
+	unsealParts [][]byte
+	unsealNonce string
+	rekeyConfig *SealConfig
+	rekeyParts  [][]byte
+	rekeyNonce  string
 }
 
-// Secret represents a secret stored in the vault
-type Secret struct {
-	Data      map[string]interface{} `json:"data"`
-	Metadata  map[string]interface{} `json:"metadata"`
-	CreatedAt time.Time              `json:"created_at"`
-	UpdatedAt time.Time              `json:"updated_at"`
+// SealConfig describes how the master key is split into unseal key shares
+type SealConfig struct {
+	SecretShares    int `json:"secret_shares"`
+	SecretThreshold int `json:"secret_threshold"`
 }
 
 // InitResponse contains the initialization response
 type InitResponse struct {
-	RootToken     string `json:"root_token"`
-	UnsealKey     string `json:"unseal_key"`
+	RootToken       string   `json:"root_token"`
+	UnsealKey       string   `json:"unseal_key,omitempty"`
+	Keys            []string `json:"keys"`
+	SecretShares    int      `json:"secret_shares"`
+	SecretThreshold int      `json:"secret_threshold"`
+}
+
+// SealStatus reports the seal state and the progress of an unseal attempt
+type SealStatus struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	T           int    `json:"t"`
+	N           int    `json:"n"`
+	Progress    int    `json:"progress"`
+	Nonce       string `json:"nonce"`
 }
 
 // New creates a new vault instance
 func New(storagePath string) (*Vault, error) {
-	store, err := storage.NewFileStorage(storagePath)
+	store, err := storage.NewLogStorage(storagePath)
 	if err != nil {
 		return nil, err
 	}
 
+	b := barrier.New(store)
 	v := &Vault{
-		storage:    store,
-		tokenStore: auth.NewTokenStore(),
-		sealed:     true,
+		storage:     store,
+		barrier:     b,
+		tokenStore:  auth.NewTokenStore(b),
+		policyStore: policy.NewStore(b),
+		mfa:         newMFATracker(),
+		logins:      newLoginTracker(),
+		sealed:      true,
 		initialized: false,
 	}
+	v.tokenStore.OnRevoke(v.destroyCubbyhole)
 
 	// Check if vault is already initialized
 	if err := v.checkInitialized(); err == nil {
 		v.initialized = true
+
+		config, err := v.loadSealConfig()
+		if err != nil {
+			return nil, err
+		}
+		v.sealConfig = config
 	}
 
 	return v, nil
 }
 
-// Initialize initializes the vault and returns the root token and unseal key
-func (v *Vault) Initialize() (*InitResponse, error) {
+// Validate checks that the share and threshold settings are usable
+func (c *SealConfig) Validate() error {
+	if c.SecretShares < 1 {
+		return errors.New("secret_shares must be at least 1")
+	}
+	if c.SecretShares > shamir.MaxShares {
+		return fmt.Errorf("secret_shares cannot exceed %d", shamir.MaxShares)
+	}
+	if c.SecretThreshold < 1 {
+		return errors.New("secret_threshold must be at least 1")
+	}
+	if c.SecretThreshold > c.SecretShares {
+		return errors.New("secret_threshold cannot be greater than secret_shares")
+	}
+	if c.SecretShares > 1 && c.SecretThreshold == 1 {
+		return errors.New("secret_threshold must be at least 2 when using multiple shares")
+	}
+	return nil
+}
+
+// Initialize initializes the vault and returns the root token and unseal
+// key shares. A nil config produces a single unseal key.
+func (v *Vault) Initialize(config *SealConfig) (*InitResponse, error) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -69,6 +138,13 @@ func (v *Vault) Initialize() (*InitResponse, error) {
 		return nil, errors.New("vault is already initialized")
 	}
 
+	if config == nil {
+		config = &SealConfig{SecretShares: 1, SecretThreshold: 1}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	// Generate unseal key (master key)
 	unsealKey, err := crypto.GenerateKey()
 	if err != nil {
@@ -81,13 +157,18 @@ func (v *Vault) Initialize() (*InitResponse, error) {
 		return nil, err
 	}
 
-	// Store the unseal key securely (encrypted with itself for verification)
-	encryptedKey, err := crypto.Encrypt(unsealKey, unsealKey)
+	configJSON, err := json.Marshal(config)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := v.storage.Put("core/unseal-key", []byte(encryptedKey)); err != nil {
+	// Create the data encryption keyring, protected by the master key
+	if err := v.barrier.Initialize(unsealKey, configJSON); err != nil {
+		return nil, err
+	}
+
+	keys, err := splitMasterKey(unsealKey, config)
+	if err != nil {
 		return nil, err
 	}
 
@@ -97,177 +178,291 @@ func (v *Vault) Initialize() (*InitResponse, error) {
 		return nil, err
 	}
 
-	// Create root token in token store
-	v.tokenStore.CreateToken(rootTokenRaw, true, 0) // Root token never expires
+	// Create root token in token store (root token never expires)
+	rootParams := &auth.TokenParams{ID: rootTokenRaw, IsRoot: true, Policies: []string{policy.RootPolicy}}
+	if _, err := v.tokenStore.CreateToken(rootParams); err != nil {
+		return nil, err
+	}
+
+	// The vault stays sealed until the operators submit their key shares
+	v.barrier.Seal()
+	v.tokenStore.ClearCache()
 
 	v.initialized = true
 	v.rootToken = rootTokenRaw
+	v.sealConfig = config
+
+	resp := &InitResponse{
+		RootToken:       rootTokenRaw,
+		Keys:            keys,
+		SecretShares:    config.SecretShares,
+		SecretThreshold: config.SecretThreshold,
+	}
+	if len(keys) == 1 {
+		resp.UnsealKey = keys[0]
+	}
 
-	return &InitResponse{
-		RootToken: rootTokenRaw,
-		UnsealKey: base64.StdEncoding.EncodeToString(unsealKey),
-	}, nil
+	return resp, nil
 }
 
-// Unseal unseals the vault with the unseal key
-func (v *Vault) Unseal(unsealKeyStr string) error {
+// Unseal submits one unseal key share. Shares are collected across calls
+// until the threshold is reached, at which point the master key is
+// reconstructed and the vault is unsealed.
+func (v *Vault) Unseal(unsealKeyStr string) (*SealStatus, error) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
 	if !v.initialized {
-		return errors.New("vault is not initialized")
+		return nil, errors.New("vault is not initialized")
 	}
 
 	if !v.sealed {
-		return errors.New("vault is already unsealed")
+		return nil, errors.New("vault is already unsealed")
 	}
 
-	unsealKey, err := base64.StdEncoding.DecodeString(unsealKeyStr)
+	keyPart, err := decodeKeyShare(unsealKeyStr)
 	if err != nil {
-		return errors.New("invalid unseal key format")
+		return nil, err
 	}
 
-	// Verify unseal key
-	encryptedKeyData, err := v.storage.Get("core/unseal-key")
-	if err != nil {
-		return err
+	var unsealKey []byte
+	if v.sealConfig.SecretThreshold == 1 {
+		unsealKey = keyPart
+	} else {
+		if err := checkKeyShare(keyPart, v.unsealParts); err != nil {
+			return nil, err
+		}
+
+		if v.unsealNonce == "" {
+			nonce, err := crypto.GenerateToken()
+			if err != nil {
+				return nil, err
+			}
+			v.unsealNonce = nonce
+		}
+		v.unsealParts = append(v.unsealParts, keyPart)
+
+		if len(v.unsealParts) < v.sealConfig.SecretThreshold {
+			return v.sealStatusLocked(), nil
+		}
+
+		// Threshold reached: the attempt ends here whether or not it succeeds
+		unsealKey, err = shamir.Combine(v.unsealParts)
+		v.resetUnsealLocked()
+		if err != nil {
+			return nil, errors.New("invalid unseal key shares")
+		}
 	}
 
-	_, err = crypto.Decrypt(string(encryptedKeyData), unsealKey)
-	if err != nil {
-		return errors.New("invalid unseal key")
+	// Decrypt the keyring; vaults created before the barrier existed are
+	// migrated on their first unseal, and until their migration completes
+	if v.legacyMigrationPending() {
+		if err := v.migrateLegacy(unsealKey); err != nil {
+			return nil, err
+		}
+	} else if err := v.barrier.Unseal(unsealKey); err != nil {
+		return nil, err
 	}
 
-	v.encryptionKey = unsealKey
-	v.sealed = false
+	if err := v.setupMounts(); err != nil {
+		v.barrier.Seal()
+		return nil, err
+	}
 
-	// Restore root token to token store after unseal
-	rootTokenHashData, err := v.storage.Get("core/root-token")
-	if err == nil && len(rootTokenHashData) > 0 {
-		// Note: We can't recreate the actual token from its hash
-		// Root token must be provided via storage or environment
-		// For now, we'll rely on users to keep their root token
+	if err := v.setupCredentials(); err != nil {
+		v.mounts = nil
+		v.barrier.Seal()
+		return nil, err
 	}
 
-	return nil
+	if err := v.setupAudit(); err != nil {
+		v.auths = nil
+		v.mounts = nil
+		v.barrier.Seal()
+		return nil, err
+	}
+
+	if err := v.setupExpiration(); err != nil {
+		closeAuditDevices(v.audits)
+		v.audits = nil
+		v.auths = nil
+		v.mounts = nil
+		v.barrier.Seal()
+		return nil, err
+	}
+
+	// Tokens are persisted behind the barrier and load lazily on lookup
+	v.sealed = false
+
+	return v.sealStatusLocked(), nil
 }
 
-// Seal seals the vault
-func (v *Vault) Seal() error {
+// ResetUnseal discards any unseal key shares submitted so far
+func (v *Vault) ResetUnseal() (*SealStatus, error) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
-	if v.sealed {
-		return errors.New("vault is already sealed")
+	if !v.initialized {
+		return nil, errors.New("vault is not initialized")
 	}
 
-	v.encryptionKey = nil
-	v.sealed = true
-
-	return nil
+	v.resetUnsealLocked()
+	return v.sealStatusLocked(), nil
 }
 
-// IsSealed returns whether the vault is sealed
-func (v *Vault) IsSealed() bool {
+// SealStatus returns the seal state and unseal progress
+func (v *Vault) SealStatus() *SealStatus {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
-	return v.sealed
+	return v.sealStatusLocked()
 }
 
-// IsInitialized returns whether the vault is initialized
-func (v *Vault) IsInitialized() bool {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-	return v.initialized
+func (v *Vault) sealStatusLocked() *SealStatus {
+	status := &SealStatus{
+		Initialized: v.initialized,
+		Sealed:      v.sealed,
+		Progress:    len(v.unsealParts),
+		Nonce:       v.unsealNonce,
+	}
+	if v.sealConfig != nil {
+		status.T = v.sealConfig.SecretThreshold
+		status.N = v.sealConfig.SecretShares
+	}
+	return status
 }
 
-// WriteSecret writes a secret to the vault
-func (v *Vault) WriteSecret(token, path string, data map[string]interface{}) error {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-
-	if v.sealed {
-		return errors.New("vault is sealed")
-	}
+func (v *Vault) resetUnsealLocked() {
+	v.unsealParts = nil
+	v.unsealNonce = ""
+}
 
-	if err := v.tokenStore.ValidateToken(token); err != nil {
-		return err
+// splitMasterKey encodes the master key as one key or as Shamir shares
+func splitMasterKey(masterKey []byte, config *SealConfig) ([]string, error) {
+	if config.SecretShares == 1 {
+		return []string{base64.StdEncoding.EncodeToString(masterKey)}, nil
 	}
 
-	secret := &Secret{
-		Data:      data,
-		Metadata:  make(map[string]interface{}),
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	shares, err := shamir.Split(masterKey, config.SecretShares, config.SecretThreshold)
+	if err != nil {
+		return nil, err
 	}
 
-	secretJSON, err := json.Marshal(secret)
-	if err != nil {
-		return err
+	keys := make([]string, len(shares))
+	for i, share := range shares {
+		keys[i] = base64.StdEncoding.EncodeToString(share)
 	}
+	return keys, nil
+}
 
-	// Encrypt the secret
-	encrypted, err := crypto.Encrypt(secretJSON, v.encryptionKey)
+func decodeKeyShare(keyStr string) ([]byte, error) {
+	keyPart, err := base64.StdEncoding.DecodeString(keyStr)
 	if err != nil {
-		return err
+		return nil, errors.New("invalid unseal key format")
 	}
-
-	key := fmt.Sprintf("secret/%s", path)
-	return v.storage.Put(key, []byte(encrypted))
+	return keyPart, nil
 }
 
-// ReadSecret reads a secret from the vault
-func (v *Vault) ReadSecret(token, path string) (*Secret, error) {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
-
-	if v.sealed {
-		return nil, errors.New("vault is sealed")
+// checkKeyShare validates a Shamir share against those already collected
+func checkKeyShare(keyPart []byte, collected [][]byte) error {
+	if len(keyPart) != crypto.KeySize+shamir.ShareOverhead {
+		return errors.New("invalid unseal key share")
 	}
+	for _, existing := range collected {
+		if bytes.Equal(existing, keyPart) {
+			return errors.New("unseal key share already provided")
+		}
+	}
+	return nil
+}
 
-	if err := v.tokenStore.ValidateToken(token); err != nil {
+// loadSealConfig reads the share configuration stored with the keyring.
+// Keyrings stored without one fall back to the separate record earlier
+// versions kept, and to a single key for vaults initialized before key
+// shares were supported.
+func (v *Vault) loadSealConfig() (*SealConfig, error) {
+	data, err := v.barrier.SealConfig()
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
 		return nil, err
 	}
-
-	key := fmt.Sprintf("secret/%s", path)
-	encryptedData, err := v.storage.Get(key)
+	if data == nil {
+		data, err = v.storage.Get(legacySealConfigPath)
+	}
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return &SealConfig{SecretShares: 1, SecretThreshold: 1}, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Decrypt the secret
-	decrypted, err := crypto.Decrypt(string(encryptedData), v.encryptionKey)
-	if err != nil {
+	var config SealConfig
+	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
+	return &config, nil
+}
 
-	var secret Secret
-	if err := json.Unmarshal(decrypted, &secret); err != nil {
-		return nil, err
+// Seal seals the vault. The token needs sudo on sys/seal.
+func (v *Vault) Seal(token string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.sealed {
+		return errors.New("vault is already sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/seal", policy.SudoCapability); err != nil {
+		return err
 	}
 
-	return &secret, nil
+	v.expiration.stop()
+	v.expiration = nil
+	v.barrier.Seal()
+	v.tokenStore.ClearCache()
+	v.policyStore.ClearCache()
+	v.mounts = nil
+	v.auths = nil
+	closeAuditDevices(v.audits)
+	v.audits = nil
+	v.mfa.reset()
+	v.sealed = true
+	v.resetUnsealLocked()
+	v.resetRekeyLocked()
+
+	return nil
+}
+
+// IsSealed returns whether the vault is sealed
+func (v *Vault) IsSealed() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.sealed
+}
+
+// IsInitialized returns whether the vault is initialized
+func (v *Vault) IsInitialized() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.initialized
 }
 
-// DeleteSecret deletes a secret from the vault
-func (v *Vault) DeleteSecret(token, path string) error {
+// RotateKey adds a new data encryption key to the barrier keyring
+func (v *Vault) RotateKey(token string) (*barrier.KeyStatus, error) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
 	if v.sealed {
-		return errors.New("vault is sealed")
+		return nil, errors.New("vault is sealed")
 	}
 
-	if err := v.tokenStore.ValidateToken(token); err != nil {
-		return err
+	if _, err := v.authorize(token, "sys/rotate", policy.SudoCapability); err != nil {
+		return nil, err
 	}
 
-	key := fmt.Sprintf("secret/%s", path)
-	return v.storage.Delete(key)
+	return v.barrier.Rotate()
 }
 
-// ListSecrets lists all secrets with the given prefix
-func (v *Vault) ListSecrets(token, prefix string) ([]string, error) {
+// KeyStatus returns the active barrier key term
+func (v *Vault) KeyStatus(token string) (*barrier.KeyStatus, error) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -275,57 +470,99 @@ func (v *Vault) ListSecrets(token, prefix string) ([]string, error) {
 		return nil, errors.New("vault is sealed")
 	}
 
-	if err := v.tokenStore.ValidateToken(token); err != nil {
+	if _, err := v.authorize(token, "sys/key-status", policy.ReadCapability); err != nil {
 		return nil, err
 	}
 
-	key := fmt.Sprintf("secret/%s", prefix)
-	keys, err := v.storage.List(key)
-	if err != nil {
-		return nil, err
-	}
+	return v.barrier.KeyStatus()
+}
 
-	// Strip the "secret/" prefix from keys
-	var secrets []string
-	for _, k := range keys {
-		if len(k) > 7 {
-			secrets = append(secrets, k[7:])
-		}
+// checkInitialized checks if the vault has been initialized
+func (v *Vault) checkInitialized() error {
+	if v.barrier.Initialized() {
+		return nil
 	}
+	_, err := v.storage.Get(legacyUnsealKeyPath)
+	return err
+}
 
-	return secrets, nil
+// legacyMigrationPending reports whether the vault predates the barrier
+// and has not finished migrating to it
+func (v *Vault) legacyMigrationPending() bool {
+	_, err := v.storage.Get(legacyUnsealKeyPath)
+	return err == nil
 }
 
-// CreateToken creates a new authentication token
-func (v *Vault) CreateToken(rootToken string, ttl time.Duration) (string, error) {
-	v.mu.RLock()
-	defer v.mu.RUnlock()
+// migrateLegacy moves a vault whose secrets were encrypted directly with
+// the unseal key behind the barrier. Every secret is re-encrypted with
+// the new keyring and the self-encrypted unseal key is removed last, so
+// an interrupted migration resumes on the next unseal: entries already
+// re-encrypted no longer open with the unseal key and are skipped.
+func (v *Vault) migrateLegacy(unsealKey []byte) error {
+	encryptedKeyData, err := v.storage.Get(legacyUnsealKeyPath)
+	if err != nil {
+		return err
+	}
 
-	if v.sealed {
-		return "", errors.New("vault is sealed")
+	if _, err := crypto.Decrypt(string(encryptedKeyData), unsealKey); err != nil {
+		return errors.New("invalid unseal key")
 	}
 
-	if err := v.tokenStore.ValidateToken(rootToken); err != nil {
-		return "", err
+	keys, err := v.storage.List("secret/")
+	if err != nil {
+		return err
+	}
+
+	// Decrypt everything before the keyring exists so a failure of the
+	// first attempt leaves the legacy layout untouched
+	plaintexts := make(map[string][]byte, len(keys))
+	var migrated []string
+	for _, key := range keys {
+		data, err := v.storage.Get(key)
+		if err != nil {
+			return err
+		}
+		plaintext, err := crypto.Decrypt(string(data), unsealKey)
+		if err != nil {
+			migrated = append(migrated, key)
+			continue
+		}
+		plaintexts[key] = plaintext
 	}
 
-	if !v.tokenStore.IsRootToken(rootToken) {
-		return "", errors.New("only root token can create new tokens")
+	resuming := v.barrier.Initialized()
+	if len(migrated) > 0 && !resuming {
+		return fmt.Errorf("failed to migrate %s: cannot decrypt with the unseal key", migrated[0])
 	}
 
-	newToken, err := crypto.GenerateToken()
+	if resuming {
+		err = v.barrier.Unseal(unsealKey)
+	} else {
+		err = v.barrier.Initialize(unsealKey, nil)
+	}
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	v.tokenStore.CreateToken(newToken, false, ttl)
-	return newToken, nil
-}
+	for _, key := range migrated {
+		if _, err := v.barrier.Get(key); err != nil {
+			v.barrier.Seal()
+			return fmt.Errorf("failed to migrate %s: %v", key, err)
+		}
+	}
 
-// checkInitialized checks if the vault has been initialized
-func (v *Vault) checkInitialized() error {
-	_, err := v.storage.Get("core/unseal-key")
-	return err
+	for key, plaintext := range plaintexts {
+		if err := v.barrier.Put(key, plaintext); err != nil {
+			v.barrier.Seal()
+			return err
+		}
+	}
+
+	if err := v.storage.Delete(legacyUnsealKeyPath); err != nil {
+		v.barrier.Seal()
+		return err
+	}
+	return nil
 }
 
 // GetRootToken returns the root token (only available immediately after initialization)
@@ -335,8 +572,11 @@ func (v *Vault) GetRootToken() string {
 	return v.rootToken
 }
 
-// AuthenticateRootToken verifies and registers a root token in the token store
-func (v *Vault) AuthenticateRootToken(token string) error {
+// AuthenticateRootToken verifies and registers a root token in the token
+// store. Wrong tokens count as failed logins of the client address, which
+// is empty when unknown. They are not counted against a user: locking
+// one out would let anyone lock the operators out of the root token.
+func (v *Vault) AuthenticateRootToken(token, remoteAddr string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -344,6 +584,10 @@ func (v *Vault) AuthenticateRootToken(token string) error {
 		return errors.New("vault is sealed")
 	}
 
+	if err := v.checkLoginLocked(loginUser{}, remoteAddr); err != nil {
+		return err
+	}
+
 	// Get stored root token hash
 	rootTokenHashData, err := v.storage.Get("core/root-token")
 	if err != nil {
@@ -353,10 +597,14 @@ func (v *Vault) AuthenticateRootToken(token string) error {
 	// Verify the provided token matches the stored hash
 	providedHash := auth.HashToken(token)
 	if providedHash != string(rootTokenHashData) {
-		return errors.New("invalid root token")
+		v.failLoginLocked(loginUser{}, remoteAddr)
+		return logical.InvalidCredentials("invalid root token")
+	}
+	if err := v.succeedLoginLocked(loginUser{}, remoteAddr); err != nil {
+		return err
 	}
 
 	// Add token to token store with no expiration
-	v.tokenStore.CreateToken(token, true, 0)
-	return nil
+	_, err = v.tokenStore.CreateToken(&auth.TokenParams{ID: token, IsRoot: true, Policies: []string{policy.RootPolicy}})
+	return err
 }
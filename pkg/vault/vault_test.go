@@ -0,0 +1,111 @@
+package vault
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"vault-clone/pkg/barrier"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/kv"
+	"vault-clone/pkg/storage"
+)
+
+// legacyVault writes the layout of a vault created before the barrier:
+// the unseal key and every secret encrypted directly with the unseal key
+func legacyVault(t *testing.T, dir string, key []byte, secrets map[string]string) *storage.LogStorage {
+	t.Helper()
+	store, err := storage.NewLogStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedKey, err := crypto.Encrypt(key, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(legacyUnsealKeyPath, []byte(encryptedKey)); err != nil {
+		t.Fatal(err)
+	}
+	for path, value := range secrets {
+		ciphertext, err := crypto.Encrypt([]byte(value), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(legacySecretPrefix+path, []byte(ciphertext)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func openVault(t *testing.T, dir string) *Vault {
+	t.Helper()
+	v, err := New(dir)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { v.storage.(*storage.LogStorage).Close() })
+	return v
+}
+
+func TestUnsealResumesLegacyMigration(t *testing.T) {
+	dir := t.TempDir()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets := map[string]string{"a": `{"a":1}`, "b": `{"b":2}`}
+	store := legacyVault(t, dir, key, secrets)
+
+	// Interrupt a migration after the keyring was written and one secret
+	// was re-encrypted
+	b := barrier.New(store)
+	if err := b.Initialize(key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(legacySecretPrefix+"a", []byte(secrets["a"])); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	v := openVault(t, dir)
+	if _, err := v.Unseal(base64.StdEncoding.EncodeToString(key)); err != nil {
+		t.Fatalf("Unseal: %v", err)
+	}
+
+	if v.legacyMigrationPending() {
+		t.Fatal("legacy unseal key still stored after the migration")
+	}
+	view := v.mounts[legacySecretPrefix].view
+	for path, want := range secrets {
+		got, err := view.Get(kv.DataKey(path))
+		if err != nil {
+			t.Fatalf("secret %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Fatalf("secret %s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestUnsealLegacyWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyVault(t, dir, key, map[string]string{"a": "1"}).Close()
+
+	v := openVault(t, dir)
+
+	wrong, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Unseal(base64.StdEncoding.EncodeToString(wrong)); err == nil {
+		t.Fatal("Unseal succeeded with the wrong key")
+	}
+	if v.barrier.Initialized() {
+		t.Fatal("keyring written for the wrong key")
+	}
+}
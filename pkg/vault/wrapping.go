@@ -0,0 +1,277 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"vault-clone/pkg/auth"
+	"vault-clone/pkg/crypto"
+	"vault-clone/pkg/logical"
+	"vault-clone/pkg/policy"
+)
+
+const (
+	// wrappingLeasePath is the path wrapping tokens are leased under
+	wrappingLeasePath = "sys/wrapping/wrap"
+	// wrappedResponseKey is the cubbyhole entry of a wrapping token that
+	// holds the wrapped response
+	wrappedResponseKey = "response"
+)
+
+// unwrappablePaths are the request paths whose responses cannot be
+// wrapped: they seal the vault, are served while it is sealed, or return
+// unseal keys, so that wrapping could fail once they took effect and
+// lose what they returned. Paths ending in "/" are prefixes. Wrapping
+// tokens are managed with the sys/wrapping endpoints themselves.
+var unwrappablePaths = []string{"sys/init", "sys/unseal", "sys/seal", "sys/rekey/", "sys/wrapping/"}
+
+// ErrInvalidWrappingToken is returned for tokens that are not wrapping
+// tokens, and for wrapping tokens that were already unwrapped or expired
+var ErrInvalidWrappingToken = errors.New("wrapping token is not valid or does not exist")
+
+// WrapInfo describes a wrapping token. The token itself is only
+// returned when the response is wrapped.
+type WrapInfo struct {
+	Token    string `json:"token,omitempty"`
+	Accessor string `json:"accessor"`
+	// TTL is the number of seconds the wrapping token was created with
+	TTL          int64     `json:"ttl"`
+	CreationTime time.Time `json:"creation_time"`
+	CreationPath string    `json:"creation_path"`
+}
+
+// wrappedResponse is the content of a wrapping token's cubbyhole
+type wrappedResponse struct {
+	CreationPath string          `json:"creation_path"`
+	CreationTime time.Time       `json:"creation_time"`
+	TTL          time.Duration   `json:"ttl"`
+	Response     json.RawMessage `json:"response"`
+}
+
+// WrapResponse stores the response to a request to path in the
+// cubbyhole of a new single-use wrapping token that expires after ttl.
+// The request must already have been served; wrapping needs no further
+// authorization.
+func (v *Vault) WrapResponse(path string, ttl time.Duration, response json.RawMessage) (*WrapInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	return v.wrapLocked(&wrappedResponse{
+		CreationPath: strings.Trim(path, "/"),
+		TTL:          ttl,
+		Response:     response,
+	})
+}
+
+// CheckWrap returns an error if the response to a request to path
+// cannot be wrapped, so that the request can be refused before it is
+// served rather than have its response lost
+func (v *Vault) CheckWrap(path string) error {
+	path = strings.Trim(path, "/")
+	for _, unwrappable := range unwrappablePaths {
+		prefix, isPrefix := strings.CutSuffix(unwrappable, "/")
+		if path == prefix || isPrefix && strings.HasPrefix(path, unwrappable) {
+			return logical.InvalidRequest("responses to %s cannot be wrapped", path)
+		}
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return errors.New("vault is sealed")
+	}
+	return nil
+}
+
+// Wrap wraps arbitrary data given by the caller
+func (v *Vault) Wrap(token string, data json.RawMessage, ttl time.Duration) (*WrapInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	if _, err := v.authorize(token, "sys/wrapping/wrap", policy.UpdateCapability); err != nil {
+		return nil, err
+	}
+
+	return v.wrapLocked(&wrappedResponse{
+		CreationPath: wrappingLeasePath,
+		TTL:          ttl,
+		Response:     data,
+	})
+}
+
+// Unwrap returns a wrapped response and revokes its wrapping token, so
+// that it can be unwrapped only once. Holding the wrapping token is
+// enough to unwrap it.
+func (v *Vault) Unwrap(wrappingToken string) (json.RawMessage, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	wrapped, err := v.unwrapLocked(wrappingToken)
+	if err != nil {
+		return nil, err
+	}
+	return wrapped.Response, nil
+}
+
+// LookupWrapping describes a wrapping token without unwrapping it
+func (v *Vault) LookupWrapping(wrappingToken string) (*WrapInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	te, wrapped, err := v.loadWrappedLocked(wrappingToken)
+	if err != nil {
+		return nil, err
+	}
+	return wrapInfo("", te, wrapped), nil
+}
+
+// Rewrap moves a wrapped response to a new wrapping token with the same
+// TTL and creation path and revokes the old one, for example to extend
+// how long a response stays wrapped
+func (v *Vault) Rewrap(wrappingToken string) (*WrapInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.sealed {
+		return nil, errors.New("vault is sealed")
+	}
+
+	wrapped, err := v.unwrapLocked(wrappingToken)
+	if err != nil {
+		return nil, err
+	}
+	return v.wrapLocked(&wrappedResponse{
+		CreationPath: wrapped.CreationPath,
+		TTL:          wrapped.TTL,
+		Response:     wrapped.Response,
+	})
+}
+
+// wrapLocked creates a single-use orphan wrapping token and stores the
+// response in its cubbyhole. The token is leased so that the response
+// is destroyed when it expires. Callers must hold v.mu.
+func (v *Vault) wrapLocked(wrapped *wrappedResponse) (*WrapInfo, error) {
+	if wrapped.TTL <= 0 {
+		return nil, logical.InvalidRequest("wrap ttl must be positive")
+	}
+	if wrapped.TTL > systemMaxLeaseTTL {
+		wrapped.TTL = systemMaxLeaseTTL
+	}
+	if !json.Valid(wrapped.Response) {
+		return nil, logical.InvalidRequest("wrapped data must be JSON")
+	}
+
+	newToken, err := crypto.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	leaseID, err := crypto.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	te, err := v.tokenStore.CreateToken(&auth.TokenParams{
+		ID:       newToken,
+		TTL:      wrapped.TTL,
+		Policies: []string{policy.ResponseWrappingPolicy},
+		LeaseID:  wrappingLeasePath + "/" + leaseID,
+		NumUses:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped.CreationTime = te.CreatedAt.UTC()
+	data, err := json.Marshal(wrapped)
+	if err != nil {
+		v.tokenStore.RevokeToken(newToken)
+		return nil, err
+	}
+	if err := v.cubbyholeView(auth.HashToken(newToken)).Put(wrappedResponseKey, data); err != nil {
+		v.tokenStore.RevokeToken(newToken)
+		return nil, err
+	}
+
+	if _, err := v.registerTokenLeaseLocked(te, wrappingLeasePath, systemMaxLeaseTTL); err != nil {
+		v.tokenStore.RevokeToken(newToken)
+		return nil, err
+	}
+
+	return wrapInfo(newToken, te, wrapped), nil
+}
+
+// unwrapLocked returns the response wrapped by a token and takes the
+// token's only use, which revokes it together with its cubbyhole. Of
+// concurrent unwraps of the same token, only one succeeds. Callers must
+// hold v.mu.
+func (v *Vault) unwrapLocked(wrappingToken string) (*wrappedResponse, error) {
+	_, wrapped, err := v.loadWrappedLocked(wrappingToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := v.tokenStore.UseToken(wrappingToken); err != nil {
+		return nil, ErrInvalidWrappingToken
+	}
+	return wrapped, nil
+}
+
+// loadWrappedLocked returns a wrapping token and the response it wraps.
+// Callers must hold v.mu.
+func (v *Vault) loadWrappedLocked(wrappingToken string) (*auth.Token, *wrappedResponse, error) {
+	if wrappingToken == "" {
+		return nil, nil, ErrMissingToken
+	}
+
+	te, err := v.tokenStore.LookupToken(wrappingToken)
+	if err != nil || !isWrappingToken(te) {
+		return nil, nil, ErrInvalidWrappingToken
+	}
+
+	data, err := v.cubbyholeView(auth.HashToken(wrappingToken)).Get(wrappedResponseKey)
+	if err != nil {
+		return nil, nil, ErrInvalidWrappingToken
+	}
+
+	var wrapped wrappedResponse
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, nil, err
+	}
+	return te, &wrapped, nil
+}
+
+// isWrappingToken reports whether a token was created to wrap a response
+func isWrappingToken(te *auth.Token) bool {
+	return slices.Equal(te.Policies, []string{policy.ResponseWrappingPolicy})
+}
+
+func wrapInfo(token string, te *auth.Token, wrapped *wrappedResponse) *WrapInfo {
+	return &WrapInfo{
+		Token:        token,
+		Accessor:     te.Accessor,
+		TTL:          int64(wrapped.TTL / time.Second),
+		CreationTime: wrapped.CreationTime,
+		CreationPath: wrapped.CreationPath,
+	}
+}
@@ -1,7 +1,7 @@
 import React, { useState } from 'react';
 import { HiEye, HiEyeOff, HiClipboardCopy, HiPencil, HiTrash } from 'react-icons/hi';
 
-const SecretDetail = ({ secret, onEdit, onDelete }) => {
+const SecretDetail = ({ secret, onEdit, onDelete, onSelectVersion, onVersionAction }) => {
   const [showValues, setShowValues] = useState({});
 
   const toggleValue = (key) => {
@@ -15,13 +15,23 @@ const SecretDetail = ({ secret, onEdit, onDelete }) => {
     navigator.clipboard.writeText(text);
   };
 
+  const formatTime = (time) => (time ? new Date(time).toLocaleString() : '-');
+
+  const versionState = (version) => {
+    if (version.destroyed) return 'Destroyed';
+    if (version.deletion_time) return 'Deleted';
+    return 'Active';
+  };
+
+  const versions = [...(secret.history?.versions || [])].reverse();
+
   return (
     <div className="p-8">
       <div className="flex justify-between items-start mb-8 pb-6 border-b border-vault-border-light">
         <div>
           <h2 className="text-xl font-semibold text-vault-text-primary mb-2 break-all">{secret.path}</h2>
           <p className="text-sm text-vault-text-secondary">
-            Version: {secret.version || 1} | Created: {new Date().toLocaleDateString()}
+            Version: {secret.version || 1} | Created: {formatTime(secret.created_at)}
           </p>
         </div>
         <div className="flex gap-2">
@@ -44,6 +54,11 @@ const SecretDetail = ({ secret, onEdit, onDelete }) => {
 
       <div>
         <h3 className="text-xs font-bold uppercase tracking-wider text-vault-text-secondary mb-4">Secret Data</h3>
+        {!secret.data && (
+          <p className="mb-4 text-sm text-vault-text-secondary">
+            This version has been deleted or destroyed.
+          </p>
+        )}
         {Object.entries(secret.data || {}).map(([key, value]) => (
           <div key={key} className="mb-4 p-4 bg-vault-light-bg border border-vault-border-light rounded">
             <div className="flex justify-between items-center mb-2">
@@ -73,6 +88,77 @@ const SecretDetail = ({ secret, onEdit, onDelete }) => {
           </div>
         ))}
       </div>
+
+      {versions.length > 0 && (
+        <div className="mt-8">
+          <h3 className="text-xs font-bold uppercase tracking-wider text-vault-text-secondary mb-4">Version History</h3>
+          <table className="w-full text-sm border border-vault-border-light">
+            <thead className="bg-vault-light-bg text-left text-vault-text-secondary">
+              <tr>
+                <th className="px-3 py-2 font-semibold">Version</th>
+                <th className="px-3 py-2 font-semibold">Created</th>
+                <th className="px-3 py-2 font-semibold">Deleted</th>
+                <th className="px-3 py-2 font-semibold">State</th>
+                <th className="px-3 py-2"></th>
+              </tr>
+            </thead>
+            <tbody>
+              {versions.map((version) => (
+                <tr
+                  key={version.version}
+                  className={`border-t border-vault-border-light ${
+                    version.version === secret.version ? 'bg-blue-50' : ''
+                  }`}
+                >
+                  <td className="px-3 py-2 font-medium text-vault-text-primary">
+                    {version.version}
+                    {version.version === secret.history.current_version && (
+                      <span className="ml-2 text-xs text-vault-text-secondary">(current)</span>
+                    )}
+                  </td>
+                  <td className="px-3 py-2 text-vault-text-secondary">{formatTime(version.created_time)}</td>
+                  <td className="px-3 py-2 text-vault-text-secondary">{formatTime(version.deletion_time)}</td>
+                  <td className="px-3 py-2 text-vault-text-secondary">{versionState(version)}</td>
+                  <td className="px-3 py-2 text-right whitespace-nowrap">
+                    {!version.destroyed && !version.deletion_time && (
+                      <>
+                        <button
+                          onClick={() => onSelectVersion(version.version)}
+                          className="px-2 text-vault-primary hover:underline"
+                        >
+                          View
+                        </button>
+                        <button
+                          onClick={() => onVersionAction('delete', version.version)}
+                          className="px-2 text-vault-primary hover:underline"
+                        >
+                          Delete
+                        </button>
+                      </>
+                    )}
+                    {!version.destroyed && version.deletion_time && (
+                      <button
+                        onClick={() => onVersionAction('undelete', version.version)}
+                        className="px-2 text-vault-primary hover:underline"
+                      >
+                        Undelete
+                      </button>
+                    )}
+                    {!version.destroyed && (
+                      <button
+                        onClick={() => onVersionAction('destroy', version.version)}
+                        className="px-2 text-vault-danger hover:underline"
+                      >
+                        Destroy
+                      </button>
+                    )}
+                  </td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </div>
+      )}
     </div>
   );
 };
@@ -5,14 +5,21 @@ import api from '../services/api';
 const InitPage = ({ onInitialized }) => {
   const [loading, setLoading] = useState(false);
   const [result, setResult] = useState(null);
+  const [secretShares, setSecretShares] = useState(1);
+  const [secretThreshold, setSecretThreshold] = useState(1);
 
   const handleInitialize = async () => {
+    if (secretThreshold > secretShares) {
+      alert('Key threshold cannot be greater than the number of key shares');
+      return;
+    }
+
     setLoading(true);
     try {
-      const data = await api.initialize();
+      const data = await api.initialize(secretShares, secretThreshold);
       setResult(data);
     } catch (error) {
-      alert('Failed to initialize vault: ' + error.message);
+      alert('Failed to initialize vault: ' + (error.response?.data?.error || error.message));
     } finally {
       setLoading(false);
     }
@@ -33,18 +40,52 @@ const InitPage = ({ onInitialized }) => {
         </div>
         <h1 className="text-2xl font-semibold text-white mb-3">Initialize Vault</h1>
         <p className="text-base text-vault-text-muted mb-8 leading-relaxed">
-          Initialize your vault to generate the root token and unseal key.
+          Initialize your vault to generate the root token and unseal key shares.
           These credentials will only be shown once, so make sure to save them securely.
         </p>
 
         {!result ? (
-          <button
-            className="w-full max-w-md mx-auto px-6 py-3 text-base font-medium text-white bg-vault-primary rounded hover:bg-vault-primary-hover transition-colors disabled:opacity-50"
-            onClick={handleInitialize}
-            disabled={loading}
-          >
-            {loading ? 'Initializing...' : 'Initialize Vault'}
-          </button>
+          <div className="max-w-md mx-auto">
+            <div className="grid grid-cols-2 gap-4 mb-6 text-left">
+              <div>
+                <label htmlFor="key-shares" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                  Key Shares
+                </label>
+                <input
+                  id="key-shares"
+                  type="number"
+                  min="1"
+                  max="255"
+                  className="w-full px-4 py-3 text-sm bg-vault-dark-bg border border-vault-border-dark text-white rounded focus:outline-none focus:border-vault-primary focus:ring-2 focus:ring-vault-primary/20 transition-all"
+                  value={secretShares}
+                  onChange={(e) => setSecretShares(parseInt(e.target.value, 10) || 1)}
+                  disabled={loading}
+                />
+              </div>
+              <div>
+                <label htmlFor="key-threshold" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                  Key Threshold
+                </label>
+                <input
+                  id="key-threshold"
+                  type="number"
+                  min="1"
+                  max={secretShares}
+                  className="w-full px-4 py-3 text-sm bg-vault-dark-bg border border-vault-border-dark text-white rounded focus:outline-none focus:border-vault-primary focus:ring-2 focus:ring-vault-primary/20 transition-all"
+                  value={secretThreshold}
+                  onChange={(e) => setSecretThreshold(parseInt(e.target.value, 10) || 1)}
+                  disabled={loading}
+                />
+              </div>
+            </div>
+            <button
+              className="w-full px-6 py-3 text-base font-medium text-white bg-vault-primary rounded hover:bg-vault-primary-hover transition-colors disabled:opacity-50"
+              onClick={handleInitialize}
+              disabled={loading}
+            >
+              {loading ? 'Initializing...' : 'Initialize Vault'}
+            </button>
+          </div>
         ) : (
           <div className="text-left">
             <div className="mb-6 p-4 bg-vault-success/15 border border-vault-success rounded">
@@ -66,18 +107,22 @@ const InitPage = ({ onInitialized }) => {
                 </div>
               </div>
 
-              <div className="p-4 bg-vault-dark-bg border border-vault-border-dark rounded">
-                <label className="block text-sm font-medium text-vault-text-on-dark mb-2">Unseal Key:</label>
-                <div className="flex gap-2">
-                  <code className="flex-1 p-3 bg-black/30 border border-vault-border-dark text-white rounded text-sm break-all">{result.unseal_key}</code>
-                  <button
-                    className="px-4 py-2 text-sm font-medium text-white bg-white/10 border border-vault-border-dark rounded hover:bg-white/15 transition-colors"
-                    onClick={() => navigator.clipboard.writeText(result.unseal_key)}
-                  >
-                    Copy
-                  </button>
+              {result.keys.map((key, index) => (
+                <div key={index} className="p-4 bg-vault-dark-bg border border-vault-border-dark rounded">
+                  <label className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                    {result.keys.length > 1 ? `Unseal Key ${index + 1}:` : 'Unseal Key:'}
+                  </label>
+                  <div className="flex gap-2">
+                    <code className="flex-1 p-3 bg-black/30 border border-vault-border-dark text-white rounded text-sm break-all">{key}</code>
+                    <button
+                      className="px-4 py-2 text-sm font-medium text-white bg-white/10 border border-vault-border-dark rounded hover:bg-white/15 transition-colors"
+                      onClick={() => navigator.clipboard.writeText(key)}
+                    >
+                      Copy
+                    </button>
+                  </div>
                 </div>
-              </div>
+              ))}
             </div>
 
             <button className="w-full px-6 py-3 text-base font-medium text-white bg-vault-primary rounded hover:bg-vault-primary-hover transition-colors" onClick={handleContinue}>
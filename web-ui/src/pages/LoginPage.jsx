@@ -2,25 +2,66 @@ import React, { useState } from 'react';
 import { HiShieldCheck } from 'react-icons/hi';
 import api from '../services/api';
 
+const METHODS = [
+  { id: 'token', label: 'Token' },
+  { id: 'userpass', label: 'Username' },
+];
+
+const inputClass =
+  'w-full px-4 py-3 text-sm bg-vault-dark-bg border border-vault-border-dark text-white rounded focus:outline-none focus:border-vault-primary focus:ring-2 focus:ring-vault-primary/20 transition-all';
+
 const LoginPage = ({ onLogin }) => {
+  const [method, setMethod] = useState('token');
   const [token, setToken] = useState('');
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [mount, setMount] = useState('userpass');
   const [loading, setLoading] = useState(false);
 
-  const handleLogin = async (e) => {
-    e.preventDefault();
+  const errorMessage = (error) => error.response?.data?.error || error.message;
+
+  const loginWithToken = async () => {
     if (!token.trim()) {
       alert('Please enter a token');
       return;
     }
 
-    setLoading(true);
     try {
       api.setToken(token);
-      await api.getStatus();
+      await api.lookupSelf();
       onLogin();
     } catch (error) {
       api.clearToken();
-      alert('Invalid token: ' + error.message);
+      alert('Invalid token: ' + errorMessage(error));
+    }
+  };
+
+  const loginWithUserpass = async () => {
+    if (!username.trim() || !password) {
+      alert('Please enter a username and password');
+      return;
+    }
+
+    try {
+      api.clearToken();
+      const auth = await api.loginUserpass(username.trim(), password, mount.trim() || 'userpass');
+      api.setToken(auth.token);
+      setPassword('');
+      onLogin();
+    } catch (error) {
+      alert('Login failed: ' + errorMessage(error));
+    }
+  };
+
+  const handleLogin = async (e) => {
+    e.preventDefault();
+    setLoading(true);
+    try {
+      if (method === 'token') {
+        await loginWithToken();
+      } else {
+        await loginWithUserpass();
+      }
     } finally {
       setLoading(false);
     }
@@ -34,24 +75,90 @@ const LoginPage = ({ onLogin }) => {
         </div>
         <h1 className="text-2xl font-semibold text-white mb-3">Sign in to Vault</h1>
         <p className="text-base text-vault-text-muted mb-8 leading-relaxed">
-          Enter your authentication token to access the vault.
+          {method === 'token'
+            ? 'Enter your authentication token to access the vault.'
+            : 'Sign in with your username and password.'}
         </p>
 
-        <form onSubmit={handleLogin} className="text-left">
-          <div className="mb-6">
-            <label htmlFor="token" className="block text-sm font-medium text-vault-text-on-dark mb-2">
-              Token
-            </label>
-            <input
-              id="token"
-              type="password"
-              className="w-full px-4 py-3 text-sm bg-vault-dark-bg border border-vault-border-dark text-white rounded focus:outline-none focus:border-vault-primary focus:ring-2 focus:ring-vault-primary/20 transition-all"
-              value={token}
-              onChange={(e) => setToken(e.target.value)}
-              placeholder="Enter your vault token"
+        <div className="flex mb-6 border-b border-vault-border-dark">
+          {METHODS.map((m) => (
+            <button
+              key={m.id}
+              type="button"
+              className={`flex-1 py-2 text-sm font-medium transition-colors ${
+                method === m.id
+                  ? 'text-white border-b-2 border-vault-primary'
+                  : 'text-vault-text-muted hover:text-white'
+              }`}
+              onClick={() => setMethod(m.id)}
               disabled={loading}
-            />
-          </div>
+            >
+              {m.label}
+            </button>
+          ))}
+        </div>
+
+        <form onSubmit={handleLogin} className="text-left">
+          {method === 'token' ? (
+            <div className="mb-6">
+              <label htmlFor="token" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                Token
+              </label>
+              <input
+                id="token"
+                type="password"
+                className={inputClass}
+                value={token}
+                onChange={(e) => setToken(e.target.value)}
+                placeholder="Enter your vault token"
+                disabled={loading}
+              />
+            </div>
+          ) : (
+            <>
+              <div className="mb-4">
+                <label htmlFor="username" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                  Username
+                </label>
+                <input
+                  id="username"
+                  type="text"
+                  autoComplete="username"
+                  className={inputClass}
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  disabled={loading}
+                />
+              </div>
+              <div className="mb-4">
+                <label htmlFor="password" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                  Password
+                </label>
+                <input
+                  id="password"
+                  type="password"
+                  autoComplete="current-password"
+                  className={inputClass}
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  disabled={loading}
+                />
+              </div>
+              <div className="mb-6">
+                <label htmlFor="mount" className="block text-sm font-medium text-vault-text-on-dark mb-2">
+                  Mount path
+                </label>
+                <input
+                  id="mount"
+                  type="text"
+                  className={inputClass}
+                  value={mount}
+                  onChange={(e) => setMount(e.target.value)}
+                  disabled={loading}
+                />
+              </div>
+            </>
+          )}
 
           <button
             type="submit"
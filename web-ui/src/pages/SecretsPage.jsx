@@ -28,16 +28,44 @@ const SecretsPage = () => {
     }
   };
 
-  const handleSelectSecret = async (path) => {
+  const handleSelectSecret = async (path, version) => {
     try {
-      const data = await api.readSecret(path);
-      setSelectedSecret({ path, ...data });
+      const metadata = await api.readSecretMetadata(path);
+      let data = {};
+      try {
+        data = await api.readSecret(path, version);
+      } catch (error) {
+        // Deleted or destroyed versions have no data but still have history
+        if (error.response?.status !== 404) {
+          throw error;
+        }
+      }
+      setSelectedSecret({ path, ...data, history: metadata, version: version || metadata.current_version });
       setShowForm(false);
     } catch (error) {
       alert('Failed to read secret: ' + error.message);
     }
   };
 
+  const handleVersionAction = async (action, version) => {
+    const path = selectedSecret.path;
+    try {
+      if (action === 'delete') {
+        await api.deleteSecretVersions(path, [version]);
+      } else if (action === 'undelete') {
+        await api.undeleteSecretVersions(path, [version]);
+      } else if (action === 'destroy') {
+        if (!window.confirm(`Permanently destroy version ${version} of "${path}"?`)) {
+          return;
+        }
+        await api.destroySecretVersions(path, [version]);
+      }
+      await handleSelectSecret(path, selectedSecret.version);
+    } catch (error) {
+      alert(`Failed to ${action} version: ` + error.message);
+    }
+  };
+
   const handleDeleteSecret = async (path) => {
     if (window.confirm(`Are you sure you want to delete "${path}"?`)) {
       try {
@@ -101,6 +129,8 @@ const SecretsPage = () => {
               secret={selectedSecret}
               onEdit={() => setShowForm(true)}
               onDelete={() => handleDeleteSecret(selectedSecret.path)}
+              onSelectVersion={(version) => handleSelectSecret(selectedSecret.path, version)}
+              onVersionAction={handleVersionAction}
             />
           ) : (
             <div className="flex items-center justify-center h-full text-base text-vault-text-secondary p-8 text-center">
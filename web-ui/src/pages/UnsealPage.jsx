@@ -1,10 +1,24 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import { HiShieldCheck } from 'react-icons/hi';
 import api from '../services/api';
 
 const UnsealPage = ({ onUnsealed }) => {
   const [unsealKey, setUnsealKey] = useState('');
   const [loading, setLoading] = useState(false);
+  const [progress, setProgress] = useState({ t: 1, n: 1, progress: 0, nonce: '' });
+
+  useEffect(() => {
+    loadProgress();
+  }, []);
+
+  const loadProgress = async () => {
+    try {
+      const status = await api.getStatus();
+      setProgress(status);
+    } catch (error) {
+      console.error('Failed to load unseal progress:', error);
+    }
+  };
 
   const handleUnseal = async (e) => {
     e.preventDefault();
@@ -16,18 +30,34 @@ const UnsealPage = ({ onUnsealed }) => {
     setLoading(true);
     try {
       const data = await api.unseal(unsealKey);
+      setUnsealKey('');
       if (!data.sealed) {
         onUnsealed();
       } else {
-        alert('Vault is still sealed. Please check your unseal key.');
+        setProgress(data);
       }
     } catch (error) {
-      alert('Failed to unseal vault: ' + error.message);
+      alert('Failed to unseal vault: ' + (error.response?.data?.error || error.message));
+      loadProgress();
     } finally {
       setLoading(false);
     }
   };
 
+  const handleReset = async () => {
+    setLoading(true);
+    try {
+      const data = await api.resetUnseal();
+      setProgress(data);
+    } catch (error) {
+      alert('Failed to reset unseal progress: ' + error.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const multiKey = progress.t > 1;
+
   return (
     <div className="flex items-center justify-center min-h-screen bg-vault-dark-bg px-8">
       <div className="w-full max-w-lg bg-vault-dark-card p-10 rounded border border-vault-border-dark text-center">
@@ -36,13 +66,33 @@ const UnsealPage = ({ onUnsealed }) => {
         </div>
         <h1 className="text-2xl font-semibold text-white mb-3">Unseal Vault</h1>
         <p className="text-base text-vault-text-muted mb-8 leading-relaxed">
-          The vault is currently sealed. Enter your unseal key to unlock it.
+          {multiKey
+            ? `The vault is currently sealed. ${progress.t} of ${progress.n} unseal key shares are required to unlock it.`
+            : 'The vault is currently sealed. Enter your unseal key to unlock it.'}
         </p>
 
+        {multiKey && (
+          <div className="mb-6 text-left">
+            <div className="flex justify-between text-sm text-vault-text-on-dark mb-2">
+              <span>Unseal progress</span>
+              <span>{progress.progress} / {progress.t}</span>
+            </div>
+            <div className="w-full h-2 bg-vault-dark-bg border border-vault-border-dark rounded overflow-hidden">
+              <div
+                className="h-full bg-vault-primary transition-all"
+                style={{ width: `${(progress.progress / progress.t) * 100}%` }}
+              />
+            </div>
+            {progress.nonce && (
+              <p className="mt-2 text-xs text-vault-text-muted break-all">Nonce: {progress.nonce}</p>
+            )}
+          </div>
+        )}
+
         <form onSubmit={handleUnseal} className="text-left">
           <div className="mb-6">
             <label htmlFor="unseal-key" className="block text-sm font-medium text-vault-text-on-dark mb-2">
-              Unseal Key
+              {multiKey ? 'Unseal Key Share' : 'Unseal Key'}
             </label>
             <input
               id="unseal-key"
@@ -50,7 +100,7 @@ const UnsealPage = ({ onUnsealed }) => {
               className="w-full px-4 py-3 text-sm bg-vault-dark-bg border border-vault-border-dark text-white rounded focus:outline-none focus:border-vault-primary focus:ring-2 focus:ring-vault-primary/20 transition-all"
               value={unsealKey}
               onChange={(e) => setUnsealKey(e.target.value)}
-              placeholder="Enter your unseal key"
+              placeholder={multiKey ? 'Enter an unseal key share' : 'Enter your unseal key'}
               disabled={loading}
             />
           </div>
@@ -60,8 +110,19 @@ const UnsealPage = ({ onUnsealed }) => {
             className="w-full px-6 py-3 text-base font-medium text-white bg-vault-primary rounded hover:bg-vault-primary-hover transition-colors disabled:opacity-50"
             disabled={loading}
           >
-            {loading ? 'Unsealing...' : 'Unseal Vault'}
+            {loading ? 'Unsealing...' : multiKey ? 'Submit Key Share' : 'Unseal Vault'}
           </button>
+
+          {multiKey && progress.progress > 0 && (
+            <button
+              type="button"
+              className="w-full mt-3 px-6 py-3 text-sm font-medium text-white bg-white/10 border border-vault-border-dark rounded hover:bg-white/15 transition-colors disabled:opacity-50"
+              onClick={handleReset}
+              disabled={loading}
+            >
+              Reset Unseal Progress
+            </button>
+          )}
         </form>
       </div>
     </div>
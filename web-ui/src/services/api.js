@@ -32,8 +32,11 @@ class VaultAPI {
     return response.data;
   }
 
-  async initialize() {
-    const response = await this.client.post('/v1/sys/init');
+  async initialize(secretShares = 1, secretThreshold = 1) {
+    const response = await this.client.post('/v1/sys/init', {
+      secret_shares: secretShares,
+      secret_threshold: secretThreshold,
+    });
     return response.data;
   }
 
@@ -42,6 +45,11 @@ class VaultAPI {
     return response.data;
   }
 
+  async resetUnseal() {
+    const response = await this.client.post('/v1/sys/unseal', { reset: true });
+    return response.data;
+  }
+
   async seal() {
     const response = await this.client.post('/v1/sys/seal');
     return response.data;
@@ -49,17 +57,39 @@ class VaultAPI {
 
   // Secret Operations
   async writeSecret(path, data) {
-    const response = await this.client.post(`/v1/secret/${path}`, { data });
+    const response = await this.client.post(`/v1/secret/data/${path}`, { data });
+    return response.data;
+  }
+
+  async readSecret(path, version) {
+    const response = await this.client.get(`/v1/secret/data/${path}`, {
+      params: version ? { version } : {},
+    });
     return response.data;
   }
 
-  async readSecret(path) {
-    const response = await this.client.get(`/v1/secret/${path}`);
+  async readSecretMetadata(path) {
+    const response = await this.client.get(`/v1/secret/metadata/${path}`);
     return response.data;
   }
 
   async deleteSecret(path) {
-    const response = await this.client.delete(`/v1/secret/${path}`);
+    const response = await this.client.delete(`/v1/secret/data/${path}`);
+    return response.data;
+  }
+
+  async deleteSecretVersions(path, versions) {
+    const response = await this.client.post(`/v1/secret/delete/${path}`, { versions });
+    return response.data;
+  }
+
+  async undeleteSecretVersions(path, versions) {
+    const response = await this.client.post(`/v1/secret/undelete/${path}`, { versions });
+    return response.data;
+  }
+
+  async destroySecretVersions(path, versions) {
+    const response = await this.client.post(`/v1/secret/destroy/${path}`, { versions });
     return response.data;
   }
 
@@ -76,6 +106,19 @@ class VaultAPI {
     return response.data;
   }
 
+  async lookupSelf() {
+    const response = await this.client.get('/v1/auth/token/lookup-self');
+    return response.data;
+  }
+
+  async loginUserpass(username, password, mount = 'userpass') {
+    const response = await this.client.post(
+      `/v1/auth/${mount}/login/${encodeURIComponent(username)}`,
+      { password }
+    );
+    return response.data;
+  }
+
   // Token management
   setToken(token) {
     localStorage.setItem('vault_token', token);